@@ -25,6 +25,8 @@ type agentConfig struct {
 	WebsiteID     string   `json:"websiteID"`
 	SourceID      string   `json:"sourceID"`
 	Paths         []string `json:"paths"`
+	// Inputs：带过滤/采样规则的输入文件列表，可与 paths 同时使用。
+	Inputs        []inputConfig `json:"inputs"`
 	PollInterval  string   `json:"pollInterval"`
 	BatchSize     int      `json:"batchSize"`
 	FlushInterval string   `json:"flushInterval"`
@@ -49,6 +51,8 @@ type ingestRequest struct {
 	WebsiteID string   `json:"website_id"`
 	SourceID  string   `json:"source_id"`
 	Lines     []string `json:"lines"`
+	// SampleRates 与 Lines 一一对应；批次内没有采样行时省略。
+	SampleRates []int `json:"sample_rates,omitempty"`
 }
 
 type fileState struct {
@@ -101,10 +105,20 @@ func main() {
 	if sourceID == "" {
 		sourceID = "agent"
 	}
+	inputs, err := buildInputs(cfg)
+	if err != nil {
+		logrus.WithError(err).Error("加载 agent 输入规则失败")
+		os.Exit(1)
+	}
+	for i := range inputs {
+		if inputs[i].fifo || isStreamPath(inputs[i].path) {
+			inputs[i].stream = startStreamInput(inputs[i].path, maxLineBytes, maxPending)
+		}
+	}
 
 	endpoint := strings.TrimRight(cfg.Server, "/") + "/api/ingest/logs"
 	states := make(map[string]*fileState)
-	pending := make([]string, 0, batchSize)
+	pending := make([]agentLine, 0, batchSize)
 	var (
 		nextPushAt    time.Time
 		failures      int
@@ -133,6 +147,7 @@ func main() {
 		"retry_backoff_max":     effectiveBackoffMax.String(),
 		"exit_on_max_backoff":   cfg.ExitOnMaxBackoff,
 		"paths":                 cfg.Paths,
+		"inputs":                len(cfg.Inputs),
 		"website_id":            cfg.WebsiteID,
 		"source_id":             sourceID,
 	}).Info("nginxpulse-agent: config loaded")
@@ -158,26 +173,38 @@ func main() {
 				}
 				continue
 			}
-			for _, path := range cfg.Paths {
+			for _, input := range inputs {
+				path := input.path
 				if len(pending) >= maxPending {
 					break
 				}
-				if strings.HasSuffix(strings.ToLower(path), ".gz") {
-					continue
-				}
-				state := states[path]
-				if state == nil {
-					state = &fileState{}
-					states[path] = state
-				}
-				lines, st, err := readNewLines(path, state, maxLineBytes)
-				if err != nil {
-					logrus.WithError(err).Warnf("读取日志失败: %s", path)
-					continue
+				var (
+					lines []string
+					st    readStats
+				)
+				if input.stream != nil {
+					// stdin/FIFO：取出读取协程已缓冲的行，数量受 pending 剩余容量限制。
+					lines, st = input.stream.drain(maxPending - len(pending))
+				} else {
+					if strings.HasSuffix(strings.ToLower(path), ".gz") {
+						continue
+					}
+					state := states[path]
+					if state == nil {
+						state = &fileState{}
+						states[path] = state
+					}
+					lines, st, err = readNewLines(path, state, maxLineBytes)
+					if err != nil {
+						logrus.WithError(err).Warnf("读取日志失败: %s", path)
+						continue
+					}
 				}
 				if st.lines == 0 {
 					continue
 				}
+				var filtered ruleStats
+				kept := input.rules.filter(lines, &filtered)
 				// 大读取/周期性摘要日志：用于辅助定位 OOM 与积压问题。
 				if st.lines >= batchSize || st.bytes >= 4*1024*1024 || time.Since(lastReadLogged) > 30*time.Second {
 					lastReadLogged = time.Now()
@@ -192,10 +219,12 @@ func main() {
 						"offset_to":     st.to,
 						"offset_delta":  st.to - st.from,
 						"has_partial":   st.hasPartial,
+						"dropped_lines": filtered.dropped,
+						"sampled_out_lines": filtered.sampledOut,
 						"pending_lines": len(pending),
 					}).Info("read new lines")
 				}
-				pending = append(pending, lines...)
+				pending = append(pending, kept...)
 				if len(pending) >= batchSize {
 					// 遵守退避窗口：在 backoff 时间内不进行推送尝试。
 					if !nextPushAt.IsZero() && time.Now().Before(nextPushAt) {
@@ -203,9 +232,9 @@ func main() {
 					}
 					if err := pushLines(requestTimeout, endpoint, cfg.AccessKey, cfg.WebsiteID, sourceID, pending); err != nil {
 						failures++
-						delay := computeBackoff(failures, backoffMin, backoffMax)
-						// 如果此前已达到最大退避，并且等待后依然失败，则按配置可选择直接退出进程。
-						if cfg.ExitOnMaxBackoff && reachedMax && delay >= effectiveBackoffMax {
+						delay, throttled := applyRetryAfter(err, computeBackoff(failures, backoffMin, backoffMax))
+						// 如果此前已达到最大退避，并且等待后依然失败，则按配置可选择直接退出进程（服务端限流除外）。
+						if cfg.ExitOnMaxBackoff && !throttled && reachedMax && delay >= effectiveBackoffMax {
 							logrus.WithError(err).Errorf("日志推送连续失败且退避已达上限 %s，终止 agent 进程", effectiveBackoffMax)
 							os.Exit(1)
 						}
@@ -256,6 +285,10 @@ func main() {
 			}
 		case <-flushTicker.C:
 			if len(pending) == 0 {
+				if streamInputsDone(inputs) {
+					logrus.Info("所有输入均已结束且日志已推送完毕，agent 退出")
+					return
+				}
 				continue
 			}
 			if !nextPushAt.IsZero() && time.Now().Before(nextPushAt) {
@@ -263,8 +296,8 @@ func main() {
 			}
 			if err := pushLines(requestTimeout, endpoint, cfg.AccessKey, cfg.WebsiteID, sourceID, pending); err != nil {
 				failures++
-				delay := computeBackoff(failures, backoffMin, backoffMax)
-				if cfg.ExitOnMaxBackoff && reachedMax && delay >= effectiveBackoffMax {
+				delay, throttled := applyRetryAfter(err, computeBackoff(failures, backoffMin, backoffMax))
+				if cfg.ExitOnMaxBackoff && !throttled && reachedMax && delay >= effectiveBackoffMax {
 					logrus.WithError(err).Errorf("日志推送连续失败且退避已达上限 %s，终止 agent 进程", effectiveBackoffMax)
 					os.Exit(1)
 				}
@@ -300,6 +333,19 @@ func main() {
 	}
 }
 
+// streamInputsDone 仅在所有输入都是已结束的 stdin 时返回 true（例如 `cat access.log | nginxpulse-agent`）。
+func streamInputsDone(inputs []agentInput) bool {
+	if len(inputs) == 0 {
+		return false
+	}
+	for _, input := range inputs {
+		if input.stream == nil || !input.stream.done() {
+			return false
+		}
+	}
+	return true
+}
+
 func loadConfig(path string) (*agentConfig, error) {
 	absPath := path
 	if !filepath.IsAbs(path) {
@@ -321,8 +367,8 @@ func loadConfig(path string) (*agentConfig, error) {
 	if strings.TrimSpace(cfg.WebsiteID) == "" {
 		return nil, errors.New("websiteID 不能为空")
 	}
-	if len(cfg.Paths) == 0 {
-		return nil, errors.New("paths 不能为空")
+	if len(cfg.Paths) == 0 && len(cfg.Inputs) == 0 {
+		return nil, errors.New("paths 与 inputs 不能同时为空")
 	}
 	return cfg, nil
 }
@@ -492,11 +538,23 @@ func readOneLineLimited(reader *bufio.Reader, maxLineBytes int, seed string) (li
 	return buf.String(), false, bytesRead, hasNewline, eof, nil, actualLineBytes
 }
 
-func pushLines(timeout time.Duration, endpoint, accessKey, websiteID, sourceID string, lines []string) error {
+func pushLines(timeout time.Duration, endpoint, accessKey, websiteID, sourceID string, lines []agentLine) error {
 	payload := ingestRequest{
 		WebsiteID: websiteID,
 		SourceID:  sourceID,
-		Lines:     lines,
+		Lines:     make([]string, len(lines)),
+	}
+	sampled := false
+	rates := make([]int, len(lines))
+	for i, line := range lines {
+		payload.Lines[i] = line.text
+		rates[i] = line.sampleRate
+		if line.sampleRate > 1 {
+			sampled = true
+		}
+	}
+	if sampled {
+		payload.SampleRates = rates
 	}
 	body, err := json.Marshal(payload)
 	if err != nil {
@@ -518,12 +576,41 @@ func pushLines(timeout time.Duration, endpoint, accessKey, websiteID, sourceID s
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		// 服务端推送队列已满：按 Retry-After 等待，而不是立即重试
+		retryAfter := time.Duration(0)
+		if secs, err := strconv.Atoi(strings.TrimSpace(resp.Header.Get("Retry-After"))); err == nil && secs > 0 {
+			retryAfter = time.Duration(secs) * time.Second
+		}
+		return &throttledError{retryAfter: retryAfter}
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return fmt.Errorf("http status %d", resp.StatusCode)
 	}
 	return nil
 }
 
+// throttledError 表示服务端返回 429，retryAfter 为服务端建议的等待时间。
+type throttledError struct {
+	retryAfter time.Duration
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("http status %d (retry after %s)", http.StatusTooManyRequests, e.retryAfter)
+}
+
+// applyRetryAfter 在服务端限流时取退避时间与 Retry-After 的较大值，并返回是否为限流错误。
+func applyRetryAfter(err error, delay time.Duration) (time.Duration, bool) {
+	var throttled *throttledError
+	if !errors.As(err, &throttled) {
+		return delay, false
+	}
+	if throttled.retryAfter > delay {
+		delay = throttled.retryAfter
+	}
+	return delay, true
+}
+
 func parseDuration(raw string, fallback time.Duration) time.Duration {
 	raw = strings.TrimSpace(raw)
 	if raw == "" {
@@ -652,8 +739,8 @@ func formatBytes(v int64) string {
 // resetPending 用于释放 pending slice 持有的引用。
 // 这里采用“始终换新 slice”的策略：每次推送成功后都丢弃旧的底层数组，
 // 让 GC 更容易回收历史积压/异常输入导致的内存占用，从而抑制 heap_sys 长期走高。
-func resetPending(pending []string, batchSize, maxPending int) []string {
+func resetPending(pending []agentLine, batchSize, maxPending int) []agentLine {
 	_ = pending
 	_ = maxPending
-	return make([]string, 0, batchSize)
+	return make([]agentLine, 0, batchSize)
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// inputConfig 描述单个输入文件及其边缘侧过滤/采样规则。
+type inputConfig struct {
+	Path string `json:"path"`
+	// Mode：设为 "fifo" 时按命名管道读取，适用于 agent 启动时 nginx 尚未创建管道的场景；留空时按启动时的文件类型自动判断。
+	Mode string `json:"mode"`
+	// Drop：命中任一正则的行直接丢弃，不再推送（例如健康检查、静态资源、内网 IP）。
+	Drop []string `json:"drop"`
+	// Sample：命中规则的行按 1/N 采样推送，服务端按 N 放大聚合计数；按顺序取第一条命中的规则。
+	Sample []sampleRuleConfig `json:"sample"`
+}
+
+type sampleRuleConfig struct {
+	Pattern string `json:"pattern"`
+	Rate    int    `json:"rate"`
+}
+
+// inputModeFIFO 为 inputs[].mode 的取值，表示按命名管道读取。
+const inputModeFIFO = "fifo"
+
+// agentLine 为待推送的一行日志及其采样倍率（未采样为 1）。
+type agentLine struct {
+	text       string
+	sampleRate int
+}
+
+type agentInput struct {
+	path   string
+	fifo   bool // 配置了 mode=fifo
+	rules  *lineRules
+	stream *streamInput
+}
+
+type lineRules struct {
+	drop   []*regexp.Regexp
+	sample []*sampleRule
+}
+
+type sampleRule struct {
+	pattern *regexp.Regexp
+	rate    int
+	seen    uint64
+}
+
+// ruleStats 统计一次读取中被规则过滤掉的行数。
+type ruleStats struct {
+	dropped    int
+	sampledOut int
+}
+
+func buildInputs(cfg *agentConfig) ([]agentInput, error) {
+	inputs := make([]agentInput, 0, len(cfg.Paths)+len(cfg.Inputs))
+	for _, path := range cfg.Paths {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		inputs = append(inputs, agentInput{path: path})
+	}
+	for i, input := range cfg.Inputs {
+		path := strings.TrimSpace(input.Path)
+		if path == "" {
+			return nil, fmt.Errorf("inputs[%d].path 不能为空", i)
+		}
+		mode := strings.ToLower(strings.TrimSpace(input.Mode))
+		if mode != "" && mode != inputModeFIFO {
+			return nil, fmt.Errorf("inputs[%d].mode 无效: %q（仅支持 %q）", i, input.Mode, inputModeFIFO)
+		}
+		rules, err := compileLineRules(input)
+		if err != nil {
+			return nil, fmt.Errorf("inputs[%d]: %w", i, err)
+		}
+		inputs = append(inputs, agentInput{path: path, fifo: mode == inputModeFIFO, rules: rules})
+	}
+	return inputs, nil
+}
+
+func compileLineRules(input inputConfig) (*lineRules, error) {
+	if len(input.Drop) == 0 && len(input.Sample) == 0 {
+		return nil, nil
+	}
+	rules := &lineRules{}
+	for j, pattern := range input.Drop {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("drop[%d] 正则无效: %w", j, err)
+		}
+		rules.drop = append(rules.drop, re)
+	}
+	for j, sample := range input.Sample {
+		if sample.Rate < 1 {
+			return nil, fmt.Errorf("sample[%d].rate 必须大于等于 1", j)
+		}
+		re, err := regexp.Compile(sample.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("sample[%d] 正则无效: %w", j, err)
+		}
+		rules.sample = append(rules.sample, &sampleRule{pattern: re, rate: sample.Rate})
+	}
+	return rules, nil
+}
+
+// apply 返回该行是否需要推送以及对应的采样倍率。
+// 采样使用确定性的计数方式：每条规则命中的第 1、N+1、2N+1... 行被保留。
+func (r *lineRules) apply(line string, stats *ruleStats) (bool, int) {
+	if r == nil {
+		return true, 1
+	}
+	for _, re := range r.drop {
+		if re.MatchString(line) {
+			stats.dropped++
+			return false, 0
+		}
+	}
+	for _, rule := range r.sample {
+		if !rule.pattern.MatchString(line) {
+			continue
+		}
+		keep := rule.seen%uint64(rule.rate) == 0
+		rule.seen++
+		if !keep {
+			stats.sampledOut++
+			return false, 0
+		}
+		return true, rule.rate
+	}
+	return true, 1
+}
+
+func (r *lineRules) filter(lines []string, stats *ruleStats) []agentLine {
+	out := make([]agentLine, 0, len(lines))
+	for _, line := range lines {
+		keep, rate := r.apply(line, stats)
+		if !keep {
+			continue
+		}
+		out = append(out, agentLine{text: line, sampleRate: rate})
+	}
+	return out
+}
@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+func TestLineRulesDropAndSample(t *testing.T) {
+	t.Parallel()
+
+	rules, err := compileLineRules(inputConfig{
+		Drop:   []string{`GET /healthz `},
+		Sample: []sampleRuleConfig{{Pattern: `\.(js|css|png)\b`, Rate: 3}},
+	})
+	if err != nil {
+		t.Fatalf("compileLineRules: %v", err)
+	}
+
+	lines := []string{
+		`1.1.1.1 - - "GET /healthz HTTP/1.1" 200`,
+		`1.1.1.1 - - "GET /app.js HTTP/1.1" 200`,
+		`1.1.1.1 - - "GET /app.css HTTP/1.1" 200`,
+		`1.1.1.1 - - "GET /index.html HTTP/1.1" 200`,
+		`1.1.1.1 - - "GET /logo.png HTTP/1.1" 200`,
+		`1.1.1.1 - - "GET /app.js HTTP/1.1" 200`,
+	}
+	var stats ruleStats
+	got := rules.filter(lines, &stats)
+
+	want := []agentLine{
+		{text: lines[1], sampleRate: 3},
+		{text: lines[3], sampleRate: 1},
+		{text: lines[5], sampleRate: 3},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("filter returned %d lines, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("line %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if stats.dropped != 1 || stats.sampledOut != 2 {
+		t.Fatalf("stats = %+v, want dropped=1 sampledOut=2", stats)
+	}
+}
+
+func TestCompileLineRulesRejectsInvalidRate(t *testing.T) {
+	t.Parallel()
+
+	if _, err := compileLineRules(inputConfig{
+		Sample: []sampleRuleConfig{{Pattern: `.*`, Rate: 0}},
+	}); err == nil {
+		t.Fatal("expected error for rate 0")
+	}
+}
+
+func TestBuildInputsFIFOMode(t *testing.T) {
+	t.Parallel()
+
+	inputs, err := buildInputs(&agentConfig{Inputs: []inputConfig{
+		{Path: "/run/nginx/access.pipe", Mode: "FIFO"},
+		{Path: "/var/log/nginx/access.log"},
+	}})
+	if err != nil {
+		t.Fatalf("buildInputs: %v", err)
+	}
+	if !inputs[0].fifo || inputs[1].fifo {
+		t.Fatalf("fifo = %v/%v, want true/false", inputs[0].fifo, inputs[1].fifo)
+	}
+
+	if _, err := buildInputs(&agentConfig{Inputs: []inputConfig{{Path: "/tmp/x", Mode: "socket"}}}); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+}
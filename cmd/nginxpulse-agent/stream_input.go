@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stdinPath 为约定的标准输入路径，例如 `tail -F access.log | nginxpulse-agent`。
+const stdinPath = "-"
+
+// streamInput 以行缓冲方式持续读取 stdin 或 FIFO（命名管道）。
+// 读取协程把完整的行写入有界 channel，channel 满时阻塞读取，形成与 pending 一致的背压。
+type streamInput struct {
+	path         string
+	maxLineBytes int
+	lines        chan string
+	bytes        atomic.Int64
+	skipped      atomic.Int64
+	finished     atomic.Bool
+}
+
+// isStreamPath 判断输入是否需要按流读取：stdin 或命名管道。
+// 启动时不存在的路径按普通文件读取，尚未创建的 FIFO 需通过 inputs[].mode=fifo 显式指定。
+func isStreamPath(path string) bool {
+	if path == stdinPath {
+		return true
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			logrus.Warnf("输入路径 %s 暂不存在，按普通文件读取；如为尚未创建的 FIFO，请在 inputs 中设置 \"mode\": \"fifo\"", path)
+		}
+		return false
+	}
+	return info.Mode()&os.ModeNamedPipe != 0
+}
+
+func startStreamInput(path string, maxLineBytes, buffer int) *streamInput {
+	if buffer <= 0 {
+		buffer = 1
+	}
+	s := &streamInput{
+		path:         path,
+		maxLineBytes: maxLineBytes,
+		lines:        make(chan string, buffer),
+	}
+	if path == stdinPath {
+		go func() {
+			s.consume(os.Stdin)
+			s.finished.Store(true)
+			close(s.lines)
+			logrus.Info("stdin 输入已结束")
+		}()
+		return s
+	}
+	go s.runFIFO()
+	return s
+}
+
+// runFIFO 循环打开命名管道：写端全部关闭时会读到 EOF，此时重新打开等待下一个写端。
+// 管道尚未创建时每秒重试，连续失败只记录一次。
+func (s *streamInput) runFIFO() {
+	failing := false
+	for {
+		file, err := os.Open(s.path)
+		if err != nil {
+			if !failing {
+				logrus.WithError(err).Warnf("打开 FIFO 失败，等待管道创建: %s", s.path)
+				failing = true
+			}
+			time.Sleep(time.Second)
+			continue
+		}
+		failing = false
+		s.consume(file)
+		file.Close()
+	}
+}
+
+func (s *streamInput) consume(r io.Reader) {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	var lastOverlongLogged time.Time
+	for {
+		line, overlong, bytesRead, _, eof, err, actualLineBytes := readOneLineLimited(reader, s.maxLineBytes, "")
+		if bytesRead > 0 {
+			s.bytes.Add(bytesRead)
+		}
+		if err != nil {
+			logrus.WithError(err).Warnf("读取流式输入失败: %s", s.path)
+			return
+		}
+		if overlong {
+			s.skipped.Add(1)
+			if time.Since(lastOverlongLogged) >= 5*time.Second {
+				lastOverlongLogged = time.Now()
+				logrus.WithFields(logrus.Fields{
+					"path":           s.path,
+					"max_line_bytes": s.maxLineBytes,
+					"line_bytes":     actualLineBytes,
+				}).Warn("skipping overlong log line (exceeds maxLineBytes)")
+			}
+		} else if line != "" {
+			// 流结束时没有换行符的最后一行也视为完整行
+			s.lines <- line
+		}
+		if eof {
+			return
+		}
+	}
+}
+
+// drain 非阻塞地取出最多 limit 行，并返回本次读取的统计信息。
+func (s *streamInput) drain(limit int) ([]string, readStats) {
+	stats := readStats{path: s.path, maxLineBytes: s.maxLineBytes}
+	lines := []string{}
+	for len(lines) < limit {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				limit = len(lines)
+				continue
+			}
+			lines = append(lines, line)
+		default:
+			limit = len(lines)
+		}
+	}
+	stats.lines = len(lines)
+	stats.bytes = s.bytes.Swap(0)
+	stats.skippedLines = int(s.skipped.Swap(0))
+	return lines, stats
+}
+
+// done 表示 stdin 已读到 EOF 且缓冲的行已全部取出；FIFO 会持续重开，永远不会 done。
+func (s *streamInput) done() bool {
+	return s.finished.Load() && len(s.lines) == 0
+}
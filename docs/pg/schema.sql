@@ -28,6 +28,15 @@ CREATE TABLE IF NOT EXISTS "{{website_id}}_dim_ua" (
   UNIQUE (browser, os, device)
 );
 
+-- Applied schema migrations (global, empty website_id for global migrations)
+CREATE TABLE IF NOT EXISTS "schema_migrations" (
+  website_id TEXT NOT NULL,
+  version INT NOT NULL,
+  name TEXT NOT NULL,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY(website_id, version)
+);
+
 -- IP geo cache (global)
 CREATE TABLE IF NOT EXISTS "ip_geo_cache" (
   ip TEXT PRIMARY KEY,
@@ -119,6 +128,42 @@ CREATE TABLE IF NOT EXISTS "{{website_id}}_agg_daily_ip" (
   PRIMARY KEY (day, ip_id)
 );
 
+-- Daily per-dimension aggregates (kind: 1=url, 2=referer, 3=ua, 4=location)
+CREATE TABLE IF NOT EXISTS "{{website_id}}_agg_dim_daily" (
+  kind SMALLINT NOT NULL,
+  day DATE NOT NULL,
+  dim_id BIGINT NOT NULL,
+  pv BIGINT NOT NULL DEFAULT 0,
+  PRIMARY KEY (kind, day, dim_id)
+);
+
+CREATE TABLE IF NOT EXISTS "{{website_id}}_agg_dim_daily_ip" (
+  kind SMALLINT NOT NULL,
+  day DATE NOT NULL,
+  dim_id BIGINT NOT NULL,
+  ip_id BIGINT NOT NULL,
+  PRIMARY KEY (kind, day, dim_id, ip_id)
+);
+
+-- HyperLogLog sketches for approximate UV, keyed like the *_ip tables
+CREATE TABLE IF NOT EXISTS "{{website_id}}_agg_hourly_hll" (
+  bucket BIGINT PRIMARY KEY,
+  sketch BYTEA NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "{{website_id}}_agg_daily_hll" (
+  day DATE PRIMARY KEY,
+  sketch BYTEA NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "{{website_id}}_agg_dim_daily_hll" (
+  kind SMALLINT NOT NULL,
+  day DATE NOT NULL,
+  dim_id BIGINT NOT NULL,
+  sketch BYTEA NOT NULL,
+  PRIMARY KEY (kind, day, dim_id)
+);
+
 -- First seen
 CREATE TABLE IF NOT EXISTS "{{website_id}}_first_seen" (
   ip_id BIGINT PRIMARY KEY,
@@ -1,12 +1,16 @@
 package analytics
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"net/url"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/hll"
 	"github.com/likaia/nginxpulse/internal/sqlutil"
 	"github.com/likaia/nginxpulse/internal/store"
 	"github.com/likaia/nginxpulse/internal/timeutil"
@@ -18,6 +22,8 @@ type ClientStats struct {
 	UV        []int    `json:"uv"`         // 独立访客数
 	PVPercent []int    `json:"pv_percent"` // PV 百分比
 	UVPercent []int    `json:"uv_percent"` // UV 百分比
+	// TruncatedBefore 非空时表示所读数据已不保留该时间之前的部分，排行只覆盖之后的数据
+	TruncatedBefore string `json:"truncatedBefore,omitempty"`
 }
 
 func (s ClientStats) GetType() string {
@@ -79,7 +85,7 @@ func NewLocationStatsManager(userRepoPtr *store.Repository) *ClientStatsManager
 }
 
 // 实现 StatsManager 接口
-func (s *ClientStatsManager) Query(query StatsQuery) (StatsResult, error) {
+func (s *ClientStatsManager) Query(ctx context.Context, query StatsQuery) (StatsResult, error) {
 	result := ClientStats{
 		Key:       make([]string, 0),
 		PV:        make([]int, 0),
@@ -141,13 +147,20 @@ func (s *ClientStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	}
 
 	extraCondition := ""
+	// dim 为统计项所在的维表：原始日志通过 logColumn 关联，按日维度聚合通过 dim_id 关联
+	var dim struct {
+		table     string
+		alias     string
+		logColumn string
+		kind      store.DimAggKind
+	}
 	switch s.statsType {
 	case "url":
-		joinClause = fmt.Sprintf(`JOIN "%s_dim_url" u ON u.id = l.url_id`, query.WebsiteID)
+		dim.table, dim.alias, dim.logColumn, dim.kind = "dim_url", "u", "url_id", store.DimAggURL
 		selectExpr = "u.url"
 		groupExpr = "u.url"
 	case "referer":
-		joinClause = fmt.Sprintf(`JOIN "%s_dim_referer" r ON r.id = l.referer_id`, query.WebsiteID)
+		dim.table, dim.alias, dim.logColumn, dim.kind = "dim_referer", "r", "referer_id", store.DimAggReferer
 	case "referer_ip":
 		joinClause = fmt.Sprintf(
 			`JOIN "%s_dim_referer" r ON r.id = l.referer_id JOIN "%s_dim_ip" ip ON ip.id = l.ip_id`,
@@ -161,19 +174,19 @@ func (s *ClientStatsManager) Query(query StatsQuery) (StatsResult, error) {
 			extraCondition += " AND " + sourceCondition
 		}
 	case "user_browser":
-		joinClause = fmt.Sprintf(`JOIN "%s_dim_ua" ua ON ua.id = l.ua_id`, query.WebsiteID)
+		dim.table, dim.alias, dim.logColumn, dim.kind = "dim_ua", "ua", "ua_id", store.DimAggUA
 		selectExpr = "ua.browser"
 		groupExpr = "ua.browser"
 	case "user_os":
-		joinClause = fmt.Sprintf(`JOIN "%s_dim_ua" ua ON ua.id = l.ua_id`, query.WebsiteID)
+		dim.table, dim.alias, dim.logColumn, dim.kind = "dim_ua", "ua", "ua_id", store.DimAggUA
 		selectExpr = "ua.os"
 		groupExpr = "ua.os"
 	case "user_device":
-		joinClause = fmt.Sprintf(`JOIN "%s_dim_ua" ua ON ua.id = l.ua_id`, query.WebsiteID)
+		dim.table, dim.alias, dim.logColumn, dim.kind = "dim_ua", "ua", "ua_id", store.DimAggUA
 		selectExpr = "ua.device"
 		groupExpr = "ua.device"
 	case "location":
-		joinClause = fmt.Sprintf(`JOIN "%s_dim_location" loc ON loc.id = l.location_id`, query.WebsiteID)
+		dim.table, dim.alias, dim.logColumn, dim.kind = "dim_location", "loc", "location_id", store.DimAggLocation
 		if locationType == "global" {
 			selectExpr = "loc.global"
 			groupExpr = "loc.global"
@@ -186,11 +199,70 @@ func (s *ClientStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		extraCondition = " AND loc.global = '中国'"
 	}
 
-	// 构建、执行查询
-	dbQueryStr := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
+	var (
+		dbQueryStr string
+		args       []interface{}
+		items      []clientStatItem
+	)
+	dayAligned := dim.table != "" && isDayAligned(startTime, endTime)
+	if dim.table != "" && !dayAligned && !rawLogsCover(query.WebsiteID, startTime) {
+		// 原始日志已过期的非整天范围扩展为整天，改读按日维度聚合
+		startTime, endTime = expandToWholeDays(startTime, endTime)
+		dayAligned = true
+	}
+	if dayAligned {
+		result.TruncatedBefore = dailyAggregatesTruncatedBefore(query.WebsiteID, startTime)
+	} else {
+		result.TruncatedBefore = rawLogsTruncatedBefore(query.WebsiteID, startTime)
+	}
+	if dayAligned && uvModeForRange(query.WebsiteID, uvModeFromQuery(query), startTime) == config.UVModeApprox {
+		// 近似口径：PV 取按日维度聚合，UV 合并各维度值的按日草图
+		dimJoin := fmt.Sprintf(`JOIN "%s_%s" %s ON %s.id = a.dim_id`, query.WebsiteID, dim.table, dim.alias, dim.alias)
+		items, err = s.approxDimStats(
+			ctx,
+			query.WebsiteID, selectExpr, groupExpr, dimJoin, extraCondition,
+			dim.kind, dayBucket(startTime), dayBucket(endTime), limit,
+		)
+		if err != nil {
+			return result, fmt.Errorf("查询客户端统计失败: %v", err)
+		}
+	} else if dayAligned {
+		// 整天范围读取按日维度聚合，避免扫描原始日志
+		dimJoin := fmt.Sprintf(`JOIN "%s_%s" %s ON %s.id = a.dim_id`, query.WebsiteID, dim.table, dim.alias, dim.alias)
+		dbQueryStr = sqlutil.ReplacePlaceholders(fmt.Sprintf(`
+        WITH pv AS (
+            SELECT %[1]s AS url, SUM(a.pv) AS pv
+            FROM "%[2]s_agg_dim_daily" a
+            %[4]s
+            WHERE a.kind = ? AND a.day >= ? AND a.day <= ?%[5]s
+            GROUP BY %[3]s
+        ), uv AS (
+            SELECT %[1]s AS url, COUNT(DISTINCT a.ip_id) AS uv
+            FROM "%[2]s_agg_dim_daily_ip" a
+            %[4]s
+            WHERE a.kind = ? AND a.day >= ? AND a.day <= ?%[5]s
+            GROUP BY %[3]s
+        )
+        SELECT pv.url, pv.pv, COALESCE(uv.uv, 0) AS uv
+        FROM pv
+        LEFT JOIN uv ON uv.url = pv.url
+        ORDER BY uv DESC
+        LIMIT ?`,
+			selectExpr, query.WebsiteID, groupExpr, dimJoin, extraCondition))
+		startDay := dayBucket(startTime)
+		endDay := dayBucket(endTime)
+		args = []interface{}{dim.kind, startDay, endDay, dim.kind, startDay, endDay, limit}
+	} else {
+		if dim.table != "" {
+			joinClause = fmt.Sprintf(
+				`JOIN "%s_%s" %s ON %s.id = l.%s`, query.WebsiteID, dim.table, dim.alias, dim.alias, dim.logColumn,
+			)
+		}
+		// 构建、执行查询
+		dbQueryStr = sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         SELECT 
             %[1]s AS url, 
-            COUNT(*) AS pv,
+            SUM(l.sample_rate) AS pv,
             COUNT(DISTINCT l.ip_id) AS uv
         FROM "%[2]s_nginx_logs" l
         %[4]s
@@ -198,32 +270,38 @@ func (s *ClientStatsManager) Query(query StatsQuery) (StatsResult, error) {
         GROUP BY %[3]s
         ORDER BY uv DESC
         LIMIT ?`,
-		selectExpr, query.WebsiteID, groupExpr, joinClause, extraCondition))
-
-	rows, err := s.repo.GetDB().Query(dbQueryStr, startTime.Unix(), endTime.Unix(), limit)
-	if err != nil {
-		return result, fmt.Errorf("查询客户端统计失败: %v", err)
+			selectExpr, query.WebsiteID, groupExpr, joinClause, extraCondition))
+		args = []interface{}{startTime.Unix(), endTime.Unix(), limit}
 	}
-	defer rows.Close()
 
-	totalPV := 0
-	totalUV := 0
+	if dbQueryStr != "" {
+		rows, err := s.repo.ReadDB().QueryContext(ctx, dbQueryStr, args...)
+		if err != nil {
+			return result, fmt.Errorf("查询客户端统计失败: %v", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var item clientStatItem
+			if err := rows.Scan(&item.key, &item.pv, &item.uv); err != nil {
+				return result, fmt.Errorf("解析客户端统计结果失败: %v", err)
+			}
+			items = append(items, item)
+		}
 
-	for rows.Next() {
-		var url string
-		var pv, uv int
-		if err := rows.Scan(&url, &pv, &uv); err != nil {
-			return result, fmt.Errorf("解析客户端统计结果失败: %v", err)
+		if err := rows.Err(); err != nil {
+			return result, fmt.Errorf("遍历客户端统计结果失败: %v", err)
 		}
-		result.Key = append(result.Key, url)
-		result.PV = append(result.PV, pv)
-		result.UV = append(result.UV, uv)
-		totalPV += pv
-		totalUV += uv
 	}
 
-	if err := rows.Err(); err != nil {
-		return result, fmt.Errorf("遍历客户端统计结果失败: %v", err)
+	totalPV := 0
+	totalUV := 0
+	for _, item := range items {
+		result.Key = append(result.Key, item.key)
+		result.PV = append(result.PV, item.pv)
+		result.UV = append(result.UV, item.uv)
+		totalPV += item.pv
+		totalUV += item.uv
 	}
 
 	if totalPV > 0 && totalUV > 0 {
@@ -241,6 +319,113 @@ func (s *ClientStatsManager) Query(query StatsQuery) (StatsResult, error) {
 
 }
 
+type clientStatItem struct {
+	key string
+	pv  int
+	uv  int
+}
+
+// approxDimStats 按维度值合并按日 UV 草图，按近似 UV 排序后取前 limit 项
+func (s *ClientStatsManager) approxDimStats(
+	ctx context.Context,
+	websiteID, selectExpr, groupExpr, dimJoin, extraCondition string,
+	kind store.DimAggKind, startDay, endDay string, limit int,
+) ([]clientStatItem, error) {
+	db := s.repo.ReadDB()
+	pvRows, err := db.QueryContext(ctx, sqlutil.ReplacePlaceholders(fmt.Sprintf(`
+        SELECT %[1]s AS url, SUM(a.pv) AS pv
+        FROM "%[2]s_agg_dim_daily" a
+        %[4]s
+        WHERE a.kind = ? AND a.day >= ? AND a.day <= ?%[5]s
+        GROUP BY %[3]s`,
+		selectExpr, websiteID, groupExpr, dimJoin, extraCondition)), kind, startDay, endDay)
+	if err != nil {
+		return nil, err
+	}
+	defer pvRows.Close()
+	pvByKey := make(map[string]int)
+	for pvRows.Next() {
+		var key string
+		var pv int
+		if err := pvRows.Scan(&key, &pv); err != nil {
+			return nil, err
+		}
+		pvByKey[key] = pv
+	}
+	if err := pvRows.Err(); err != nil {
+		return nil, err
+	}
+
+	sketchRows, err := db.QueryContext(ctx, sqlutil.ReplacePlaceholders(fmt.Sprintf(`
+        SELECT %[1]s AS url, a.sketch
+        FROM "%[2]s_agg_dim_daily_hll" a
+        %[3]s
+        WHERE a.kind = ? AND a.day >= ? AND a.day <= ?%[4]s`,
+		selectExpr, websiteID, dimJoin, extraCondition)), kind, startDay, endDay)
+	if err != nil {
+		return nil, err
+	}
+	defer sketchRows.Close()
+	sketches := make(map[string]*hll.Sketch)
+	for sketchRows.Next() {
+		var key string
+		var data []byte
+		if err := sketchRows.Scan(&key, &data); err != nil {
+			return nil, err
+		}
+		sketch, err := hll.Unmarshal(data)
+		if err != nil {
+			return nil, err
+		}
+		if merged, ok := sketches[key]; ok {
+			merged.Merge(sketch)
+		} else {
+			sketches[key] = sketch
+		}
+	}
+	if err := sketchRows.Err(); err != nil {
+		return nil, err
+	}
+
+	items := make([]clientStatItem, 0, len(pvByKey))
+	for key, pv := range pvByKey {
+		item := clientStatItem{key: key, pv: pv}
+		if sketch, ok := sketches[key]; ok {
+			item.uv = int(sketch.Estimate())
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].uv != items[j].uv {
+			return items[i].uv > items[j].uv
+		}
+		return items[i].key < items[j].key
+	})
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+// isDayAligned 判断时间范围是否由整天组成（起始为 0 点，结束为某天的 23:59:59，与 timeutil.TimePeriod 一致）
+func isDayAligned(start, end time.Time) bool {
+	startOfDay := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+	if !start.Equal(startOfDay) {
+		return false
+	}
+	if end.Hour() == 23 && end.Minute() == 59 && end.Second() == 59 {
+		return end.After(start)
+	}
+	return false
+}
+
+// expandToWholeDays 把时间范围扩展为覆盖首尾两天的整天范围（结束为当天 23:59:59，与 isDayAligned 一致）
+func expandToWholeDays(start, end time.Time) (time.Time, time.Time) {
+	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+	end = time.Date(end.Year(), end.Month(), end.Day(), 23, 59, 59, 0, end.Location())
+	return start, end
+}
+
 func buildInternalRefererCondition(domains []string, refererColumn string) string {
 	conditions := make([]string, 0, len(domains))
 	for _, raw := range domains {
@@ -1,6 +1,7 @@
 package analytics
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
@@ -77,7 +78,7 @@ func NewLogsStatsManager(userRepoPtr *store.Repository) *LogsStatsManager {
 }
 
 // Query 实现 StatsManager 接口
-func (m *LogsStatsManager) Query(query StatsQuery) (StatsResult, error) {
+func (m *LogsStatsManager) Query(ctx context.Context, query StatsQuery) (StatsResult, error) {
 	result := LogsStats{}
 	const botDeviceLabel = "蜘蛛"
 	result.IPParsing = ingest.IsIPParsing()
@@ -443,7 +444,7 @@ func (m *LogsStatsManager) Query(query StatsQuery) (StatsResult, error) {
 
 	// 执行查询
 	queryStr := sqlutil.ReplacePlaceholders(queryBuilder.String())
-	rows, err := m.repo.GetDB().Query(queryStr, args...)
+	rows, err := m.repo.ReadDB().QueryContext(ctx, queryStr, args...)
 	if err != nil {
 		return result, fmt.Errorf("查询日志失败: %v", err)
 	}
@@ -583,7 +584,7 @@ func (m *LogsStatsManager) Query(query StatsQuery) (StatsResult, error) {
 
 	var total int
 	countQueryStr := sqlutil.ReplacePlaceholders(countQuery.String())
-	err = m.repo.GetDB().QueryRow(countQueryStr, countArgs...).Scan(&total)
+	err = m.repo.ReadDB().QueryRowContext(ctx, countQueryStr, countArgs...).Scan(&total)
 	if err != nil {
 		return result, fmt.Errorf("获取日志总数失败: %v", err)
 	}
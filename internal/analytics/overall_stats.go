@@ -1,12 +1,14 @@
 package analytics
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"math"
 	"sort"
 	"time"
 
+	"github.com/likaia/nginxpulse/internal/config"
 	"github.com/likaia/nginxpulse/internal/sqlutil"
 	"github.com/likaia/nginxpulse/internal/store"
 	"github.com/likaia/nginxpulse/internal/timeutil"
@@ -27,6 +29,8 @@ type OverallStats struct {
 	Compare                   OverallCompare `json:"compare"`                   // 对比数据
 	StatusCodeHits            StatusCodeHits `json:"statusCodeHits"`            // HTTP 状态码命中次数
 	StatusCodeHitsPrevious    StatusCodeHits `json:"statusCodeHitsPrevious"`    // 上一期状态码命中次数
+	// TruncatedBefore 非空时表示日聚合已不保留该时间之前的数据，统计只覆盖之后的部分
+	TruncatedBefore string `json:"truncatedBefore,omitempty"`
 }
 
 type OverallSnapshot struct {
@@ -66,7 +70,7 @@ func NewOverallStatsManager(userRepoPtr *store.Repository) *OverallStatsManager
 }
 
 // 实现 StatsManager 接口
-func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
+func (s *OverallStatsManager) Query(ctx context.Context, query StatsQuery) (StatsResult, error) {
 
 	result := OverallStats{
 		PV:                        0,
@@ -107,12 +111,14 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		}
 	}
 
-	err = s.statsByTimeRangeForWebsite(query.WebsiteID, startTime, endTime, &result)
+	result.TruncatedBefore = dailyAggregatesTruncatedBefore(query.WebsiteID, startTime)
+	uvMode := uvModeFromQuery(query)
+	err = s.statsByTimeRangeForWebsite(ctx, query.WebsiteID, startTime, endTime, uvMode, &result)
 	if err != nil {
 		return result, fmt.Errorf("获取总体统计失败: %v", err)
 	}
 
-	statusHits, err := s.statusCodeHitsByTimeRangeForWebsite(query.WebsiteID, startTime, endTime)
+	statusHits, err := s.statusCodeHitsByTimeRangeForWebsite(ctx, query.WebsiteID, startTime, endTime)
 	if err != nil {
 		logrus.WithError(err).Warn("获取状态码统计失败")
 	} else {
@@ -120,7 +126,7 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	}
 
 	if !prevStart.IsZero() && !prevEnd.IsZero() {
-		prevStatusHits, err := s.statusCodeHitsByTimeRangeForWebsite(query.WebsiteID, prevStart, prevEnd)
+		prevStatusHits, err := s.statusCodeHitsByTimeRangeForWebsite(ctx, query.WebsiteID, prevStart, prevEnd)
 		if err != nil {
 			logrus.WithError(err).Warn("获取上一期状态码统计失败")
 		} else {
@@ -128,7 +134,7 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		}
 	}
 
-	metrics, err := collectSessionMetrics(s.repo, query.WebsiteID, startTime, endTime)
+	metrics, err := collectSessionMetrics(ctx, s.repo, query.WebsiteID, startTime, endTime)
 	if err != nil {
 		logrus.WithError(err).Warn("获取会话统计失败")
 	} else {
@@ -136,14 +142,14 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		result.EntryPages = buildEntryStats(metrics.EntryCounts, entryLimit)
 	}
 
-	activeCount, err := s.activeVisitorCount(query.WebsiteID)
+	activeCount, err := s.activeVisitorCount(ctx, query.WebsiteID)
 	if err != nil {
 		logrus.WithError(err).Warn("获取活跃访客失败")
 	} else {
 		result.ActiveVisitorCount = activeCount
 	}
 
-	newCount, returningCount, err := s.newReturningCounts(query.WebsiteID, startTime, endTime)
+	newCount, returningCount, err := s.newReturningCounts(ctx, query.WebsiteID, startTime, endTime)
 	if err != nil {
 		logrus.WithError(err).Warn("获取新老访客失败")
 	} else {
@@ -152,7 +158,7 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	}
 
 	if !prevStart.IsZero() && !prevEnd.IsZero() {
-		prevNew, prevReturning, err := s.newReturningCounts(query.WebsiteID, prevStart, prevEnd)
+		prevNew, prevReturning, err := s.newReturningCounts(ctx, query.WebsiteID, prevStart, prevEnd)
 		if err != nil {
 			logrus.WithError(err).Warn("获取上期新老访客失败")
 		} else {
@@ -163,7 +169,8 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 
 	currentSnapshot := snapshotFromOverall(result)
 	prevSnapshot, prevSameSnapshot, forecastSnapshot := s.buildCompareSnapshots(
-		query.WebsiteID, timeRange, startTime, endTime, currentSnapshot,
+		ctx,
+		query.WebsiteID, timeRange, startTime, endTime, uvMode, currentSnapshot,
 	)
 	result.Compare = OverallCompare{
 		Previous: prevSnapshot,
@@ -176,7 +183,8 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 
 // StatsByTimePoints 直接使用 db.Query() 方法查询数据库获取指定时间点的统计数据
 func (s *OverallStatsManager) statsByTimeRangeForWebsite(
-	websiteID string, startTime, endTime time.Time, overall *OverallStats) error {
+	ctx context.Context,
+	websiteID string, startTime, endTime time.Time, uvMode string, overall *OverallStats) error {
 
 	// 初始化结果
 	overall.PV = 0
@@ -185,6 +193,7 @@ func (s *OverallStatsManager) statsByTimeRangeForWebsite(
 
 	startDay := dayBucket(startTime)
 	endDay := dayBucket(endTime)
+	uvMode = uvModeForRange(websiteID, uvMode, startTime)
 
 	aggQuery := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         SELECT 
@@ -196,13 +205,24 @@ func (s *OverallStatsManager) statsByTimeRangeForWebsite(
 
 	var pv int64
 	var traffic int64
-	row := s.repo.GetDB().QueryRow(aggQuery, startDay, endDay)
+	row := s.repo.ReadDB().QueryRowContext(ctx, aggQuery, startDay, endDay)
 	if err := row.Scan(&pv, &traffic); err != nil {
 		return fmt.Errorf("查询总体统计数据失败: %v", err)
 	}
 	overall.PV = int(pv)
 	overall.Traffic = traffic
 
+	if uvMode == config.UVModeApprox {
+		uv, err := mergedSketchEstimate(ctx, s.repo.ReadDB(), sqlutil.ReplacePlaceholders(fmt.Sprintf(
+			`SELECT sketch FROM "%s_agg_daily_hll" WHERE day >= ? AND day <= ?`, websiteID,
+		)), startDay, endDay)
+		if err != nil {
+			return fmt.Errorf("查询总体统计UV失败: %v", err)
+		}
+		overall.UV = uv
+		return nil
+	}
+
 	uvQuery := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         SELECT COUNT(DISTINCT ip_id) as uv
         FROM "%s_agg_daily_ip"
@@ -210,7 +230,7 @@ func (s *OverallStatsManager) statsByTimeRangeForWebsite(
 		websiteID))
 
 	var uv int64
-	row = s.repo.GetDB().QueryRow(uvQuery, startDay, endDay)
+	row = s.repo.ReadDB().QueryRowContext(ctx, uvQuery, startDay, endDay)
 	if err := row.Scan(&uv); err != nil {
 		return fmt.Errorf("查询总体统计UV失败: %v", err)
 	}
@@ -220,6 +240,7 @@ func (s *OverallStatsManager) statsByTimeRangeForWebsite(
 }
 
 func (s *OverallStatsManager) statusCodeHitsByTimeRangeForWebsite(
+	ctx context.Context,
 	websiteID string, startTime, endTime time.Time) (StatusCodeHits, error) {
 
 	result := StatusCodeHits{}
@@ -237,7 +258,7 @@ func (s *OverallStatsManager) statusCodeHitsByTimeRangeForWebsite(
         WHERE day >= ? AND day <= ?`,
 		websiteID))
 
-	row := s.repo.GetDB().QueryRow(query, startDay, endDay)
+	row := s.repo.ReadDB().QueryRowContext(ctx, query, startDay, endDay)
 	if err := row.Scan(&result.S2xx, &result.S3xx, &result.S4xx, &result.S5xx, &result.Other); err != nil {
 		return result, fmt.Errorf("查询状态码统计失败: %v", err)
 	}
@@ -253,36 +274,38 @@ type sessionMetrics struct {
 const sessionGapSeconds = int64(1800)
 
 func collectSessionMetrics(
+	ctx context.Context,
 	repo *store.Repository,
 	websiteID string,
 	startTime, endTime time.Time,
 ) (sessionMetrics, error) {
 	sessionAggTable := fmt.Sprintf("%s_agg_session_daily", websiteID)
 	entryAggTable := fmt.Sprintf("%s_agg_entry_daily", websiteID)
-	hasSessionAgg, err := tableExists(repo.GetDB(), sessionAggTable)
+	hasSessionAgg, err := tableExists(ctx, repo.ReadDB(), sessionAggTable)
 	if err != nil {
 		return sessionMetrics{EntryCounts: make(map[string]int)}, err
 	}
-	hasEntryAgg, err := tableExists(repo.GetDB(), entryAggTable)
+	hasEntryAgg, err := tableExists(ctx, repo.ReadDB(), entryAggTable)
 	if err != nil {
 		return sessionMetrics{EntryCounts: make(map[string]int)}, err
 	}
 	if hasSessionAgg && hasEntryAgg {
-		return collectSessionMetricsFromAggregates(repo.GetDB(), websiteID, startTime, endTime)
+		return collectSessionMetricsFromAggregates(ctx, repo.ReadDB(), websiteID, startTime, endTime)
 	}
 
 	sessionTable := fmt.Sprintf("%s_sessions", websiteID)
-	exists, err := tableExists(repo.GetDB(), sessionTable)
+	exists, err := tableExists(ctx, repo.ReadDB(), sessionTable)
 	if err != nil {
 		return sessionMetrics{EntryCounts: make(map[string]int)}, err
 	}
 	if !exists {
-		return collectSessionMetricsFromLogs(repo, websiteID, startTime, endTime)
+		return collectSessionMetricsFromLogs(ctx, repo, websiteID, startTime, endTime)
 	}
-	return collectSessionMetricsFromSessions(repo.GetDB(), websiteID, startTime, endTime)
+	return collectSessionMetricsFromSessions(ctx, repo.ReadDB(), websiteID, startTime, endTime)
 }
 
 func collectSessionMetricsFromAggregates(
+	ctx context.Context,
 	db *sql.DB,
 	websiteID string,
 	startTime, endTime time.Time,
@@ -298,7 +321,8 @@ func collectSessionMetricsFromAggregates(
 	entryTable := fmt.Sprintf("%s_agg_entry_daily", websiteID)
 	urlTable := fmt.Sprintf("%s_dim_url", websiteID)
 
-	row := db.QueryRow(
+	row := db.QueryRowContext(
+		ctx,
 		sqlutil.ReplacePlaceholders(fmt.Sprintf(
 			`SELECT COALESCE(SUM(sessions), 0) FROM "%s" WHERE day >= ? AND day <= ?`, sessionTable,
 		)),
@@ -308,7 +332,8 @@ func collectSessionMetricsFromAggregates(
 		return metrics, err
 	}
 
-	rows, err := db.Query(
+	rows, err := db.QueryContext(
+		ctx,
 		sqlutil.ReplacePlaceholders(fmt.Sprintf(
 			`SELECT u.url, SUM(e.count)
          FROM "%s" e
@@ -343,6 +368,7 @@ func collectSessionMetricsFromAggregates(
 }
 
 func collectSessionMetricsFromSessions(
+	ctx context.Context,
 	db *sql.DB,
 	websiteID string,
 	startTime, endTime time.Time,
@@ -354,7 +380,8 @@ func collectSessionMetricsFromSessions(
 	sessionTable := fmt.Sprintf("%s_sessions", websiteID)
 	urlTable := fmt.Sprintf("%s_dim_url", websiteID)
 
-	row := db.QueryRow(
+	row := db.QueryRowContext(
+		ctx,
 		sqlutil.ReplacePlaceholders(fmt.Sprintf(
 			`SELECT COUNT(*) FROM "%s" WHERE start_ts >= ? AND start_ts < ?`, sessionTable,
 		)),
@@ -364,7 +391,8 @@ func collectSessionMetricsFromSessions(
 		return metrics, err
 	}
 
-	rows, err := db.Query(
+	rows, err := db.QueryContext(
+		ctx,
 		sqlutil.ReplacePlaceholders(fmt.Sprintf(
 			`SELECT u.url, COUNT(*)
          FROM "%s" s
@@ -399,6 +427,7 @@ func collectSessionMetricsFromSessions(
 }
 
 func collectSessionMetricsFromLogs(
+	ctx context.Context,
 	repo *store.Repository,
 	websiteID string,
 	startTime, endTime time.Time,
@@ -415,7 +444,7 @@ func collectSessionMetricsFromLogs(
         ORDER BY l.ip_id, l.ua_id, l.timestamp`,
 		websiteID, websiteID))
 
-	rows, err := repo.GetDB().Query(query, startTime.Unix(), endTime.Unix())
+	rows, err := repo.ReadDB().QueryContext(ctx, query, startTime.Unix(), endTime.Unix())
 	if err != nil {
 		return metrics, err
 	}
@@ -457,15 +486,8 @@ func collectSessionMetricsFromLogs(
 	return metrics, nil
 }
 
-func tableExists(db *sql.DB, tableName string) (bool, error) {
-	row := db.QueryRow(sqlutil.ReplacePlaceholders(
-		`SELECT 1
-         FROM pg_class c
-         JOIN pg_namespace n ON n.oid = c.relnamespace
-         WHERE n.nspname = 'public'
-           AND c.relkind IN ('r', 'p')
-           AND c.relname = ?`,
-	), tableName)
+func tableExists(ctx context.Context, db *sql.DB, tableName string) (bool, error) {
+	row := db.QueryRowContext(ctx, sqlutil.TableExistsQuery(), tableName)
 	var exists int
 	if err := row.Scan(&exists); err != nil {
 		if err == sql.ErrNoRows {
@@ -524,7 +546,7 @@ func buildEntryStats(counts map[string]int, limit int) ClientStats {
 	return result
 }
 
-func (s *OverallStatsManager) activeVisitorCount(websiteID string) (int, error) {
+func (s *OverallStatsManager) activeVisitorCount(ctx context.Context, websiteID string) (int, error) {
 	now := time.Now()
 	start := now.Add(-15 * time.Minute)
 
@@ -534,7 +556,7 @@ func (s *OverallStatsManager) activeVisitorCount(websiteID string) (int, error)
         WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ?`,
 		websiteID))
 
-	row := s.repo.GetDB().QueryRow(query, start.Unix(), now.Unix())
+	row := s.repo.ReadDB().QueryRowContext(ctx, query, start.Unix(), now.Unix())
 	var count int
 	if err := row.Scan(&count); err != nil {
 		return 0, err
@@ -543,11 +565,29 @@ func (s *OverallStatsManager) activeVisitorCount(websiteID string) (int, error)
 }
 
 func (s *OverallStatsManager) newReturningCounts(
+	ctx context.Context,
 	websiteID string, startTime, endTime time.Time,
 ) (int, int, error) {
 	startDay := dayBucket(startTime)
 	endDay := dayBucket(endTime)
 
+	if uvModeForRange(websiteID, config.UVModeExact, startTime) == config.UVModeApprox {
+		// IP 集合已不覆盖该范围：新访客按首次访问时间计数，老访客为草图 UV 减去新访客
+		uv, err := mergedSketchEstimate(ctx, s.repo.ReadDB(), sqlutil.ReplacePlaceholders(fmt.Sprintf(
+			`SELECT sketch FROM "%s_agg_daily_hll" WHERE day >= ? AND day <= ?`, websiteID,
+		)), startDay, endDay)
+		if err != nil {
+			return 0, 0, err
+		}
+		var newCount int
+		if err := s.repo.ReadDB().QueryRowContext(ctx, sqlutil.ReplacePlaceholders(fmt.Sprintf(
+			`SELECT COUNT(*) FROM "%s_first_seen" WHERE first_ts >= ? AND first_ts < ?`, websiteID,
+		)), startTime.Unix(), endTime.Unix()).Scan(&newCount); err != nil {
+			return 0, 0, err
+		}
+		return newCount, max(uv-newCount, 0), nil
+	}
+
 	query := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         WITH active_ips AS (
             SELECT DISTINCT ip_id
@@ -561,7 +601,8 @@ func (s *OverallStatsManager) newReturningCounts(
         LEFT JOIN "%s_first_seen" fs ON fs.ip_id = a.ip_id`,
 		websiteID, websiteID))
 
-	row := s.repo.GetDB().QueryRow(
+	row := s.repo.ReadDB().QueryRowContext(
+		ctx,
 		query,
 		startDay, endDay,
 		startTime.Unix(), endTime.Unix(),
@@ -605,6 +646,14 @@ func previousTimeRange(timeRange string) (time.Time, time.Time) {
 		end := time.Date(now.Year(), now.Month(), now.Day()-30, 23, 59, 59, 0, now.Location())
 		start := time.Date(now.Year(), now.Month(), now.Day()-59, 0, 0, 0, 0, now.Location())
 		return start, end
+	case "last90days":
+		end := time.Date(now.Year(), now.Month(), now.Day()-90, 23, 59, 59, 0, now.Location())
+		start := time.Date(now.Year(), now.Month(), now.Day()-179, 0, 0, 0, 0, now.Location())
+		return start, end
+	case "last365days":
+		end := time.Date(now.Year(), now.Month(), now.Day()-365, 23, 59, 59, 0, now.Location())
+		start := time.Date(now.Year(), now.Month(), now.Day()-729, 0, 0, 0, 0, now.Location())
+		return start, end
 	case "week":
 		start, end, _ := timeutil.TimePeriod("week")
 		return start.AddDate(0, 0, -7), end.AddDate(0, 0, -7)
@@ -630,9 +679,11 @@ func snapshotFromOverall(overall OverallStats) OverallSnapshot {
 }
 
 func (s *OverallStatsManager) buildCompareSnapshots(
+	ctx context.Context,
 	websiteID string,
 	timeRange string,
 	startTime, endTime time.Time,
+	uvMode string,
 	current OverallSnapshot,
 ) (OverallSnapshot, OverallSnapshot, OverallSnapshot) {
 	prevStart, prevEnd := previousTimeRange(timeRange)
@@ -640,7 +691,7 @@ func (s *OverallStatsManager) buildCompareSnapshots(
 		return OverallSnapshot{}, current, current
 	}
 
-	prevSnapshot, err := s.snapshotForRange(websiteID, prevStart, prevEnd)
+	prevSnapshot, err := s.snapshotForRange(ctx, websiteID, prevStart, prevEnd, uvMode)
 	if err != nil {
 		logrus.WithError(err).Warn("获取上一期统计失败")
 	}
@@ -667,7 +718,7 @@ func (s *OverallStatsManager) buildCompareSnapshots(
 	}
 
 	progressForecast := scaleSnapshot(current, progress)
-	forecast := s.forecastSnapshot(websiteID, startTime, endTime, currentEnd, progressForecast)
+	forecast := s.forecastSnapshot(ctx, websiteID, startTime, endTime, currentEnd, uvMode, progressForecast)
 
 	prevSameEnd := prevStart.Add(elapsed)
 	if prevSameEnd.After(prevEnd) {
@@ -675,7 +726,7 @@ func (s *OverallStatsManager) buildCompareSnapshots(
 	}
 	prevSameSnapshot := prevSnapshot
 	if prevSameEnd.After(prevStart) {
-		prevSameSnapshot, err = s.snapshotForRange(websiteID, prevStart, prevSameEnd)
+		prevSameSnapshot, err = s.snapshotForRange(ctx, websiteID, prevStart, prevSameEnd, uvMode)
 		if err != nil {
 			logrus.WithError(err).Warn("获取上一期同期失败")
 			prevSameSnapshot = prevSnapshot
@@ -702,14 +753,15 @@ func scaleSnapshot(current OverallSnapshot, progress float64) OverallSnapshot {
 }
 
 func (s *OverallStatsManager) snapshotForRange(
-	websiteID string, startTime, endTime time.Time,
+	ctx context.Context,
+	websiteID string, startTime, endTime time.Time, uvMode string,
 ) (OverallSnapshot, error) {
 	overall := OverallStats{}
-	if err := s.statsByTimeRangeForWebsite(websiteID, startTime, endTime, &overall); err != nil {
+	if err := s.statsByTimeRangeForWebsite(ctx, websiteID, startTime, endTime, uvMode, &overall); err != nil {
 		return OverallSnapshot{}, err
 	}
 
-	metrics, err := collectSessionMetrics(s.repo, websiteID, startTime, endTime)
+	metrics, err := collectSessionMetrics(ctx, s.repo, websiteID, startTime, endTime)
 	if err != nil {
 		return OverallSnapshot{}, err
 	}
@@ -724,8 +776,10 @@ func (s *OverallStatsManager) snapshotForRange(
 }
 
 func (s *OverallStatsManager) forecastSnapshot(
+	ctx context.Context,
 	websiteID string,
 	startTime, endTime, currentEnd time.Time,
+	uvMode string,
 	progressForecast OverallSnapshot,
 ) OverallSnapshot {
 	if currentEnd.Before(startTime) {
@@ -757,7 +811,7 @@ func (s *OverallStatsManager) forecastSnapshot(
 		windowStart = startTime
 	}
 
-	windowSnapshot, err := s.snapshotForRange(websiteID, windowStart, currentEnd)
+	windowSnapshot, err := s.snapshotForRange(ctx, websiteID, windowStart, currentEnd, uvMode)
 	if err != nil {
 		logrus.WithError(err).Warn("获取预测窗口数据失败")
 		return progressForecast
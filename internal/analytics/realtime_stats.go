@@ -1,6 +1,7 @@
 package analytics
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strings"
@@ -43,7 +44,7 @@ func NewRealtimeStatsManager(userRepoPtr *store.Repository) *RealtimeStatsManage
 	}
 }
 
-func (m *RealtimeStatsManager) Query(query StatsQuery) (StatsResult, error) {
+func (m *RealtimeStatsManager) Query(ctx context.Context, query StatsQuery) (StatsResult, error) {
 	result := RealtimeStats{
 		WindowMinutes: 30,
 	}
@@ -62,39 +63,40 @@ func (m *RealtimeStatsManager) Query(query StatsQuery) (StatsResult, error) {
 
 	tableName := fmt.Sprintf("%s_nginx_logs", query.WebsiteID)
 
-	activeCount, err := m.activeVisitorCount(tableName, startTime, endTime)
+	activeCount, err := m.activeVisitorCount(ctx, tableName, startTime, endTime)
 	if err != nil {
 		return result, err
 	}
 	result.ActiveCount = activeCount
 
-	series, err := m.activeSeries(tableName, startTime, endTime, window)
+	series, err := m.activeSeries(ctx, tableName, startTime, endTime, window)
 	if err != nil {
 		return result, err
 	}
 	result.ActiveSeries = series
 
-	result.DeviceBreakdown = m.deviceBreakdown(tableName, startTime, endTime)
+	result.DeviceBreakdown = m.deviceBreakdown(ctx, tableName, startTime, endTime)
 
 	refererExpr := buildRealtimeRefererExpr(query.WebsiteID, "r.referer")
 	refererJoin := fmt.Sprintf(`JOIN "%s_dim_referer" r ON r.id = l.referer_id`, query.WebsiteID)
-	referers, _ := m.queryTopItems(tableName, refererJoin, refererExpr, refererExpr, startTime, endTime, 10, true)
+	referers, _ := m.queryTopItems(ctx, tableName, refererJoin, refererExpr, refererExpr, startTime, endTime, 10, true)
 	result.Referers = referers
 
 	urlJoin := fmt.Sprintf(`JOIN "%s_dim_url" u ON u.id = l.url_id`, query.WebsiteID)
-	pages, _ := m.queryTopItems(tableName, urlJoin, "u.url", "u.url", startTime, endTime, 10, false)
+	pages, _ := m.queryTopItems(ctx, tableName, urlJoin, "u.url", "u.url", startTime, endTime, 10, false)
 	result.Pages = pages
 
-	entryCounts, _ := m.entryPages(tableName, startTime, endTime)
+	entryCounts, _ := m.entryPages(ctx, tableName, startTime, endTime)
 	result.EntryPages = entryCounts
 
 	uaJoin := fmt.Sprintf(`JOIN "%s_dim_ua" ua ON ua.id = l.ua_id`, query.WebsiteID)
-	browsers, _ := m.queryTopItems(tableName, uaJoin, "ua.browser", "ua.browser", startTime, endTime, 10, true)
+	browsers, _ := m.queryTopItems(ctx, tableName, uaJoin, "ua.browser", "ua.browser", startTime, endTime, 10, true)
 	result.Browsers = browsers
 
 	locationExpr := "CASE WHEN position('·' in loc.domestic) > 0 THEN substring(loc.domestic from position('·' in loc.domestic) + 1) ELSE loc.domestic END"
 	locationJoin := fmt.Sprintf(`JOIN "%s_dim_location" loc ON loc.id = l.location_id`, query.WebsiteID)
 	locations, _ := m.queryTopItems(
+		ctx,
 		tableName,
 		locationJoin,
 		locationExpr,
@@ -109,14 +111,14 @@ func (m *RealtimeStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	return result, nil
 }
 
-func (m *RealtimeStatsManager) activeVisitorCount(tableName string, startTime, endTime time.Time) (int, error) {
+func (m *RealtimeStatsManager) activeVisitorCount(ctx context.Context, tableName string, startTime, endTime time.Time) (int, error) {
 	query := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         SELECT COUNT(DISTINCT ip_id)
         FROM "%s"
         WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ?`,
 		tableName))
 
-	row := m.repo.GetDB().QueryRow(query, startTime.Unix(), endTime.Unix())
+	row := m.repo.ReadDB().QueryRowContext(ctx, query, startTime.Unix(), endTime.Unix())
 	var count int
 	if err := row.Scan(&count); err != nil {
 		return 0, err
@@ -124,7 +126,7 @@ func (m *RealtimeStatsManager) activeVisitorCount(tableName string, startTime, e
 	return count, nil
 }
 
-func (m *RealtimeStatsManager) activeSeries(tableName string, startTime, endTime time.Time, window int) ([]int, error) {
+func (m *RealtimeStatsManager) activeSeries(ctx context.Context, tableName string, startTime, endTime time.Time, window int) ([]int, error) {
 	query := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         SELECT (timestamp / 60) as bucket, COUNT(DISTINCT ip_id) as uv
         FROM "%s"
@@ -132,7 +134,7 @@ func (m *RealtimeStatsManager) activeSeries(tableName string, startTime, endTime
         GROUP BY bucket`,
 		tableName))
 
-	rows, err := m.repo.GetDB().Query(query, startTime.Unix(), endTime.Unix())
+	rows, err := m.repo.ReadDB().QueryContext(ctx, query, startTime.Unix(), endTime.Unix())
 	if err != nil {
 		return nil, err
 	}
@@ -159,7 +161,7 @@ func (m *RealtimeStatsManager) activeSeries(tableName string, startTime, endTime
 	return series, nil
 }
 
-func (m *RealtimeStatsManager) deviceBreakdown(tableName string, startTime, endTime time.Time) []RealtimeItem {
+func (m *RealtimeStatsManager) deviceBreakdown(ctx context.Context, tableName string, startTime, endTime time.Time) []RealtimeItem {
 	query := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         SELECT ua.device, COUNT(DISTINCT l.ip_id) as uv
         FROM "%s" l
@@ -168,7 +170,7 @@ func (m *RealtimeStatsManager) deviceBreakdown(tableName string, startTime, endT
         GROUP BY ua.device`,
 		tableName, strings.TrimSuffix(tableName, "_nginx_logs")))
 
-	rows, err := m.repo.GetDB().Query(query, startTime.Unix(), endTime.Unix())
+	rows, err := m.repo.ReadDB().QueryContext(ctx, query, startTime.Unix(), endTime.Unix())
 	if err != nil {
 		return []RealtimeItem{}
 	}
@@ -206,6 +208,7 @@ func (m *RealtimeStatsManager) deviceBreakdown(tableName string, startTime, endT
 }
 
 func (m *RealtimeStatsManager) queryTopItems(
+	ctx context.Context,
 	tableName string,
 	joinClause string,
 	selectExpr string,
@@ -217,7 +220,8 @@ func (m *RealtimeStatsManager) queryTopItems(
 	if limit <= 0 {
 		limit = 10
 	}
-	countExpr := "COUNT(*)"
+	// PV 按边缘采样倍率还原，与按日维度聚合口径一致
+	countExpr := "SUM(l.sample_rate)"
 	if distinctIP {
 		countExpr = "COUNT(DISTINCT l.ip_id)"
 	}
@@ -232,7 +236,7 @@ func (m *RealtimeStatsManager) queryTopItems(
         LIMIT ?`,
 		selectExpr, countExpr, tableName, joinClause, groupExpr))
 
-	rows, err := m.repo.GetDB().Query(query, startTime.Unix(), endTime.Unix(), limit)
+	rows, err := m.repo.ReadDB().QueryContext(ctx, query, startTime.Unix(), endTime.Unix(), limit)
 	if err != nil {
 		return nil, err
 	}
@@ -273,6 +277,7 @@ func (m *RealtimeStatsManager) queryTopItems(
 }
 
 func (m *RealtimeStatsManager) entryPages(
+	ctx context.Context,
 	tableName string,
 	startTime, endTime time.Time,
 ) ([]RealtimeItem, error) {
@@ -284,7 +289,7 @@ func (m *RealtimeStatsManager) entryPages(
         ORDER BY l.ip_id, l.ua_id, l.timestamp`,
 		tableName, strings.TrimSuffix(tableName, "_nginx_logs")))
 
-	rows, err := m.repo.GetDB().Query(query, startTime.Unix(), endTime.Unix())
+	rows, err := m.repo.ReadDB().QueryContext(ctx, query, startTime.Unix(), endTime.Unix())
 	if err != nil {
 		return nil, err
 	}
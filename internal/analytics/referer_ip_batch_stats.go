@@ -1,6 +1,7 @@
 package analytics
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/likaia/nginxpulse/internal/sqlutil"
@@ -22,6 +23,8 @@ type RefererIPBatchStats struct {
 	Search   RefererIPGroupStats `json:"search"`
 	Direct   RefererIPGroupStats `json:"direct"`
 	External RefererIPGroupStats `json:"external"`
+	// TruncatedBefore 非空时表示原始日志已不保留该时间之前的数据，排行只覆盖之后的部分
+	TruncatedBefore string `json:"truncatedBefore,omitempty"`
 }
 
 func (s RefererIPBatchStats) GetType() string {
@@ -36,7 +39,7 @@ func NewRefererIPBatchStatsManager(repo *store.Repository) *RefererIPBatchStatsM
 	return &RefererIPBatchStatsManager{repo: repo}
 }
 
-func (m *RefererIPBatchStatsManager) Query(query StatsQuery) (StatsResult, error) {
+func (m *RefererIPBatchStatsManager) Query(ctx context.Context, query StatsQuery) (StatsResult, error) {
 	result := RefererIPBatchStats{}
 	timeRange := query.ExtraParam["timeRange"].(string)
 	limit, _ := query.ExtraParam["limit"].(int)
@@ -48,20 +51,21 @@ func (m *RefererIPBatchStatsManager) Query(query StatsQuery) (StatsResult, error
 	if err != nil {
 		return result, err
 	}
+	result.TruncatedBefore = rawLogsTruncatedBefore(query.WebsiteID, startTime)
 
-	all, err := m.queryGroup(query.WebsiteID, startTime.Unix(), endTime.Unix(), limit, "all")
+	all, err := m.queryGroup(ctx, query.WebsiteID, startTime.Unix(), endTime.Unix(), limit, "all")
 	if err != nil {
 		return result, err
 	}
-	search, err := m.queryGroup(query.WebsiteID, startTime.Unix(), endTime.Unix(), limit, "search")
+	search, err := m.queryGroup(ctx, query.WebsiteID, startTime.Unix(), endTime.Unix(), limit, "search")
 	if err != nil {
 		return result, err
 	}
-	direct, err := m.queryGroup(query.WebsiteID, startTime.Unix(), endTime.Unix(), limit, "direct")
+	direct, err := m.queryGroup(ctx, query.WebsiteID, startTime.Unix(), endTime.Unix(), limit, "direct")
 	if err != nil {
 		return result, err
 	}
-	external, err := m.queryGroup(query.WebsiteID, startTime.Unix(), endTime.Unix(), limit, "external")
+	external, err := m.queryGroup(ctx, query.WebsiteID, startTime.Unix(), endTime.Unix(), limit, "external")
 	if err != nil {
 		return result, err
 	}
@@ -74,6 +78,7 @@ func (m *RefererIPBatchStatsManager) Query(query StatsQuery) (StatsResult, error
 }
 
 func (m *RefererIPBatchStatsManager) queryGroup(
+	ctx context.Context,
 	websiteID string,
 	startUnix int64,
 	endUnix int64,
@@ -101,7 +106,7 @@ func (m *RefererIPBatchStatsManager) queryGroup(
         WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%[2]s`,
 		websiteID, extraCondition))
 
-	if err := m.repo.GetDB().QueryRow(totalQuery, startUnix, endUnix).Scan(&result.TotalUV); err != nil {
+	if err := m.repo.ReadDB().QueryRowContext(ctx, totalQuery, startUnix, endUnix).Scan(&result.TotalUV); err != nil {
 		return result, fmt.Errorf("查询来源IP总量失败: %v", err)
 	}
 
@@ -149,7 +154,7 @@ func (m *RefererIPBatchStatsManager) queryGroup(
         ORDER BY t.uv DESC, t.ip ASC`,
 		websiteID, extraCondition))
 
-	rows, err := m.repo.GetDB().Query(querySQL, startUnix, endUnix, limit)
+	rows, err := m.repo.ReadDB().QueryContext(ctx, querySQL, startUnix, endUnix, limit)
 	if err != nil {
 		return result, fmt.Errorf("查询来源IP排行失败: %v", err)
 	}
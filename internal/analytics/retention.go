@@ -0,0 +1,59 @@
+package analytics
+
+import (
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+)
+
+// 各层数据按站点的保留策略分别过期：原始日志（含会话明细）最短，其次是小时聚合与日聚合。
+// 查询范围早于所读数据层的保留期时，有更粗的层级可用就改读更粗的层级；没有时在结果的 truncatedBefore
+// 中返回实际覆盖的起点，而不是静默返回偏小的数字。
+
+// truncatedTimeLayout 为 truncatedBefore 的时间格式
+const truncatedTimeLayout = "2006-01-02 15:04:05"
+
+func rawLogsCutoff(websiteID string) time.Time {
+	return time.Now().AddDate(0, 0, -config.GetRetentionPolicyForWebsite(websiteID).RawDays)
+}
+
+func hourlyAggregatesCutoff(websiteID string) time.Time {
+	return time.Now().AddDate(0, 0, -config.GetRetentionPolicyForWebsite(websiteID).HourlyDays)
+}
+
+func dailyAggregatesCutoff(websiteID string) time.Time {
+	return time.Now().AddDate(0, 0, -config.GetRetentionPolicyForWebsite(websiteID).DailyDays)
+}
+
+// rawLogsCover 判断站点的原始日志与会话明细是否仍保留 start 之后的数据
+func rawLogsCover(websiteID string, start time.Time) bool {
+	return start.Unix() >= rawLogsCutoff(websiteID).Unix()
+}
+
+// hourlyAggregatesCover 判断站点的小时聚合是否仍保留 start 所在的小时
+func hourlyAggregatesCover(websiteID string, start time.Time) bool {
+	return hourBucket(start) >= hourBucket(hourlyAggregatesCutoff(websiteID))
+}
+
+// dailyAggregatesCover 判断站点的日聚合是否仍保留 start 所在的日期
+func dailyAggregatesCover(websiteID string, start time.Time) bool {
+	return dayBucket(start) >= dayBucket(dailyAggregatesCutoff(websiteID))
+}
+
+// rawLogsTruncatedBefore 在原始日志已不覆盖 start 时返回最早仍保留的时间，否则返回空字符串
+func rawLogsTruncatedBefore(websiteID string, start time.Time) string {
+	if rawLogsCover(websiteID, start) {
+		return ""
+	}
+	return rawLogsCutoff(websiteID).Format(truncatedTimeLayout)
+}
+
+// dailyAggregatesTruncatedBefore 在日聚合已不覆盖 start 时返回最早仍保留的日期零点，否则返回空字符串
+func dailyAggregatesTruncatedBefore(websiteID string, start time.Time) string {
+	if dailyAggregatesCover(websiteID, start) {
+		return ""
+	}
+	cutoff := dailyAggregatesCutoff(websiteID).In(time.Local)
+	day := time.Date(cutoff.Year(), cutoff.Month(), cutoff.Day(), 0, 0, 0, 0, time.Local)
+	return day.Format(truncatedTimeLayout)
+}
@@ -1,6 +1,7 @@
 package analytics
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strings"
@@ -37,6 +38,8 @@ type SessionsStats struct {
 		PageSize int `json:"pageSize"`
 		Pages    int `json:"pages"`
 	} `json:"pagination"`
+	// TruncatedBefore 非空时表示原始日志已不保留该时间之前的数据，列表只包含之后的会话
+	TruncatedBefore string `json:"truncatedBefore,omitempty"`
 }
 
 // GetType 实现 StatsResult 接口
@@ -57,7 +60,7 @@ func NewSessionsStatsManager(userRepoPtr *store.Repository) *SessionsStatsManage
 }
 
 // Query 实现 StatsManager 接口
-func (m *SessionsStatsManager) Query(query StatsQuery) (StatsResult, error) {
+func (m *SessionsStatsManager) Query(ctx context.Context, query StatsQuery) (StatsResult, error) {
 	result := SessionsStats{}
 
 	page := 1
@@ -131,10 +134,14 @@ func (m *SessionsStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		}
 		conditions = append(conditions, "timestamp >= ? AND timestamp < ?")
 		args = append(args, startTime.Unix(), endTime.Unix())
+		result.TruncatedBefore = rawLogsTruncatedBefore(query.WebsiteID, startTime)
 	}
 	if timeStart > 0 {
 		conditions = append(conditions, "timestamp >= ?")
 		args = append(args, timeStart)
+		if result.TruncatedBefore == "" {
+			result.TruncatedBefore = rawLogsTruncatedBefore(query.WebsiteID, time.Unix(timeStart, 0))
+		}
 	}
 	if timeEnd > 0 {
 		conditions = append(conditions, "timestamp <= ?")
@@ -165,7 +172,7 @@ func (m *SessionsStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	queryBuilder.WriteString(" ORDER BY l.ip_id, l.ua_id, l.timestamp")
 
 	queryStr := sqlutil.ReplacePlaceholders(queryBuilder.String())
-	rows, err := m.repo.GetDB().Query(queryStr, args...)
+	rows, err := m.repo.ReadDB().QueryContext(ctx, queryStr, args...)
 	if err != nil {
 		return result, fmt.Errorf("查询会话日志失败: %v", err)
 	}
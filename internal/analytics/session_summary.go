@@ -1,6 +1,7 @@
 package analytics
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/likaia/nginxpulse/internal/sqlutil"
@@ -13,6 +14,8 @@ type SessionSummary struct {
 	BounceCount        int     `json:"bounceCount"`
 	BounceRate         float64 `json:"bounceRate"`
 	AvgDurationSeconds int64   `json:"avgDurationSeconds"`
+	// TruncatedBefore 非空时表示原始日志已不保留该时间之前的数据，摘要只覆盖之后的会话
+	TruncatedBefore string `json:"truncatedBefore,omitempty"`
 }
 
 func (s SessionSummary) GetType() string {
@@ -29,7 +32,7 @@ func NewSessionSummaryStatsManager(userRepoPtr *store.Repository) *SessionSummar
 	}
 }
 
-func (m *SessionSummaryStatsManager) Query(query StatsQuery) (StatsResult, error) {
+func (m *SessionSummaryStatsManager) Query(ctx context.Context, query StatsQuery) (StatsResult, error) {
 	result := SessionSummary{}
 
 	timeRange, ok := query.ExtraParam["timeRange"].(string)
@@ -41,9 +44,12 @@ func (m *SessionSummaryStatsManager) Query(query StatsQuery) (StatsResult, error
 	if err != nil {
 		return result, fmt.Errorf("解析时间范围失败: %v", err)
 	}
+	// 跳出率与时长只能由原始日志计算，没有更粗的聚合可用
+	result.TruncatedBefore = rawLogsTruncatedBefore(query.WebsiteID, startTime)
 
 	tableName := fmt.Sprintf("%s_nginx_logs", query.WebsiteID)
-	rows, err := m.repo.GetDB().Query(
+	rows, err := m.repo.ReadDB().QueryContext(
+		ctx,
 		sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         SELECT timestamp, ip_id, ua_id
         FROM "%s"
@@ -1,6 +1,7 @@
 package analytics
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strconv"
@@ -25,7 +26,7 @@ type StatsQuery struct {
 
 // StatsManager 统计管理器接口
 type StatsManager interface {
-	Query(query StatsQuery) (StatsResult, error)
+	Query(ctx context.Context, query StatsQuery) (StatsResult, error)
 }
 
 // StatsFactory 统计工厂，管理所有统计管理器
@@ -35,8 +36,14 @@ type StatsFactory struct {
 	cache       *StatsCache
 	mu          sync.RWMutex
 	cacheExpiry time.Duration
+
+	clearMu    sync.Mutex
+	clearTimer *time.Timer
 }
 
+// cacheClearDebounce 为推送写入后合并缓存失效的时间窗口
+const cacheClearDebounce = 2 * time.Second
+
 // NewStatsFactory 创建新的统计工厂
 func NewStatsFactory(repo *store.Repository) *StatsFactory {
 	cfg := config.ReadConfig()
@@ -93,17 +100,35 @@ func (f *StatsFactory) ClearCache() {
 	f.cache.Clear()
 }
 
+// ClearCacheDebounced 在 cacheClearDebounce 后清空统计缓存，窗口内的多次调用只会清空一次。
+// 用于高频推送写入场景，避免每个批次都让缓存失效。
+func (f *StatsFactory) ClearCacheDebounced() {
+	f.clearMu.Lock()
+	defer f.clearMu.Unlock()
+	if f.clearTimer != nil {
+		return
+	}
+	f.clearTimer = time.AfterFunc(cacheClearDebounce, func() {
+		f.clearMu.Lock()
+		f.clearTimer = nil
+		f.clearMu.Unlock()
+		f.cache.Clear()
+	})
+}
+
 func (f *StatsFactory) Repo() *store.Repository {
 	return f.repo
 }
 
-// QueryStats 通过指定类型的管理器查询统计数据
-func (f *StatsFactory) QueryStats(managerType string, query StatsQuery) (StatsResult, error) {
+// QueryStats 通过指定类型的管理器查询统计数据；ctx 取消（如请求断开）或超过 database.statementTimeout 时查询随之取消
+func (f *StatsFactory) QueryStats(ctx context.Context, managerType string, query StatsQuery) (StatsResult, error) {
 	// 获取对应的管理器
 	manager, exists := f.GetManager(managerType)
 	if !exists {
 		return nil, fmt.Errorf("未找到统计管理器: %s", managerType)
 	}
+	ctx, cancel := f.repo.WithStatementTimeout(ctx)
+	defer cancel()
 
 	if f.shouldCache(managerType) {
 		// 构建缓存键
@@ -115,7 +140,7 @@ func (f *StatsFactory) QueryStats(managerType string, query StatsQuery) (StatsRe
 		}
 
 		// 执行查询
-		result, err := manager.Query(query)
+		result, err := manager.Query(ctx, query)
 		if err != nil {
 			return nil, err
 		}
@@ -127,7 +152,7 @@ func (f *StatsFactory) QueryStats(managerType string, query StatsQuery) (StatsRe
 	}
 
 	// 执行查询
-	result, err := manager.Query(query)
+	result, err := manager.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -387,6 +412,20 @@ func (f *StatsFactory) BuildQueryFromRequest(
 			query.ExtraParam["entryLimit"] = value
 		}
 	}
+	switch statsType {
+	case "timeseries", "overall", "url", "referer", "browser", "os", "device", "location":
+		// UV 口径：未指定时取 system.uvMode，解析后的值始终写入参数以区分缓存
+		uvMode := config.NormalizeUVMode(config.ReadConfig().System.UVMode)
+		if raw, ok := params["uvMode"]; ok && raw != "" {
+			switch strings.ToLower(raw) {
+			case config.UVModeExact, config.UVModeApprox:
+				uvMode = strings.ToLower(raw)
+			default:
+				return query, fmt.Errorf("uvMode 参数无效")
+			}
+		}
+		query.ExtraParam["uvMode"] = uvMode
+	}
 	if statsType == "realtime" {
 		if windowRaw, ok := params["window"]; ok && windowRaw != "" {
 			value, err := strconv.Atoi(windowRaw)
@@ -1,9 +1,11 @@
 package analytics
 
 import (
+	"context"
 	"fmt"
 	"time"
 
+	"github.com/likaia/nginxpulse/internal/config"
 	"github.com/likaia/nginxpulse/internal/sqlutil"
 	"github.com/likaia/nginxpulse/internal/store"
 	"github.com/likaia/nginxpulse/internal/timeutil"
@@ -15,10 +17,13 @@ type StatPoint struct {
 }
 
 type TimeSeriesStats struct {
+	ViewType  string   `json:"viewType"` // 实际使用的粒度，小时聚合已过期时会退回 daily
 	Labels    []string `json:"labels"`
 	Visitors  []int    `json:"visitors"`
 	Pageviews []int    `json:"pageviews"`
 	PvMinusUv []int    `json:"pvMinusUv"` // PV - UV
+	// TruncatedBefore 非空时表示日聚合已不保留该时间之前的数据，之前的时间点为 0
+	TruncatedBefore string `json:"truncatedBefore,omitempty"`
 }
 
 // TimeSeriesStats 实现 StatsResult 接口
@@ -38,22 +43,34 @@ func NewTimeSeriesStatsManager(userRepoPtr *store.Repository) *TimeSeriesStatsMa
 }
 
 // 实现 StatsManager 接口
-func (s *TimeSeriesStatsManager) Query(query StatsQuery) (StatsResult, error) {
+func (s *TimeSeriesStatsManager) Query(ctx context.Context, query StatsQuery) (StatsResult, error) {
 	timeRange := query.ExtraParam["timeRange"].(string)
 	viewType := query.ExtraParam["viewType"].(string)
 	timePoints, labels := timeutil.TimePointsAndLabels(timeRange, viewType)
+	if isHourlyView(timePoints) && !hourlyAggregatesCover(query.WebsiteID, timePoints[0]) {
+		viewType = "daily"
+		timePoints, labels = coarsenToDaily(timePoints)
+	}
 	result := TimeSeriesStats{
+		ViewType:  viewType,
 		Labels:    labels,
 		Visitors:  make([]int, len(timePoints)),
 		Pageviews: make([]int, len(timePoints)),
 		PvMinusUv: make([]int, len(timePoints)),
 	}
+	if len(timePoints) > 0 {
+		result.TruncatedBefore = dailyAggregatesTruncatedBefore(query.WebsiteID, timePoints[0])
+	}
 
-	statPoints, err := s.statsByTimePointsForWebsite(query.WebsiteID, timePoints, viewType)
+	statPoints, err := s.statsByTimePointsForWebsite(ctx, query.WebsiteID, timePoints, viewType, uvModeFromQuery(query))
 	if err != nil {
 		return result, fmt.Errorf("获取图表数据失败: %v", err)
 	}
 	for i, point := range statPoints {
+		// 近似 UV 存在误差，不应超过 PV
+		if point.UV > point.PV {
+			point.UV = point.PV
+		}
 		result.Pageviews[i] = point.PV
 		result.Visitors[i] = point.UV
 		result.PvMinusUv[i] = point.PV - point.UV
@@ -64,7 +81,8 @@ func (s *TimeSeriesStatsManager) Query(query StatsQuery) (StatsResult, error) {
 
 // statsByTimePointsForWebsite 根据多个时间点批量查询统计数据
 func (s *TimeSeriesStatsManager) statsByTimePointsForWebsite(
-	websiteID string, timePoints []time.Time, viewType string) ([]StatPoint, error) {
+	ctx context.Context,
+	websiteID string, timePoints []time.Time, viewType, uvMode string) ([]StatPoint, error) {
 
 	timePointsSize := len(timePoints)
 	results := make([]StatPoint, timePointsSize)
@@ -73,15 +91,17 @@ func (s *TimeSeriesStatsManager) statsByTimePointsForWebsite(
 		return results, nil
 	}
 
+	uvMode = uvModeForRange(websiteID, uvMode, timePoints[0])
 	if viewType == "hourly" {
-		return s.statsByHourlyBuckets(websiteID, timePoints, results)
+		return s.statsByHourlyBuckets(ctx, websiteID, timePoints, uvMode, results)
 	}
 
-	return s.statsByDailyBuckets(websiteID, timePoints, results)
+	return s.statsByDailyBuckets(ctx, websiteID, timePoints, uvMode, results)
 }
 
 func (s *TimeSeriesStatsManager) statsByHourlyBuckets(
-	websiteID string, timePoints []time.Time, results []StatPoint) ([]StatPoint, error) {
+	ctx context.Context,
+	websiteID string, timePoints []time.Time, uvMode string, results []StatPoint) ([]StatPoint, error) {
 
 	bucketIndex := make(map[int64]int, len(timePoints))
 	startBucket := hourBucket(timePoints[0])
@@ -92,7 +112,7 @@ func (s *TimeSeriesStatsManager) statsByHourlyBuckets(
 		bucketIndex[bucket] = i
 	}
 
-	rows, err := s.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+	rows, err := s.repo.ReadDB().QueryContext(ctx, sqlutil.ReplacePlaceholders(fmt.Sprintf(
 		`SELECT bucket, pv FROM "%s_agg_hourly" WHERE bucket >= ? AND bucket <= ?`,
 		websiteID,
 	)), startBucket, endBucket)
@@ -114,18 +134,20 @@ func (s *TimeSeriesStatsManager) statsByHourlyBuckets(
 		return results, err
 	}
 
-	uvRows, err := s.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(
-		`SELECT bucket, COUNT(*) FROM "%s_agg_hourly_ip" WHERE bucket >= ? AND bucket <= ? GROUP BY bucket`,
-		websiteID,
-	)), startBucket, endBucket)
+	approx := uvMode == config.UVModeApprox
+	uvQuery := `SELECT bucket, COUNT(*) FROM "%s_agg_hourly_ip" WHERE bucket >= ? AND bucket <= ? GROUP BY bucket`
+	if approx {
+		uvQuery = `SELECT bucket, sketch FROM "%s_agg_hourly_hll" WHERE bucket >= ? AND bucket <= ?`
+	}
+	uvRows, err := s.repo.ReadDB().QueryContext(ctx, sqlutil.ReplacePlaceholders(fmt.Sprintf(uvQuery, websiteID)), startBucket, endBucket)
 	if err != nil {
 		return results, err
 	}
 	defer uvRows.Close()
 	for uvRows.Next() {
 		var bucket int64
-		var uv int
-		if err := uvRows.Scan(&bucket, &uv); err != nil {
+		uv, err := scanUV(uvRows, approx, &bucket)
+		if err != nil {
 			return results, err
 		}
 		if idx, ok := bucketIndex[bucket]; ok {
@@ -140,7 +162,8 @@ func (s *TimeSeriesStatsManager) statsByHourlyBuckets(
 }
 
 func (s *TimeSeriesStatsManager) statsByDailyBuckets(
-	websiteID string, timePoints []time.Time, results []StatPoint) ([]StatPoint, error) {
+	ctx context.Context,
+	websiteID string, timePoints []time.Time, uvMode string, results []StatPoint) ([]StatPoint, error) {
 
 	dayIndex := make(map[string]int, len(timePoints))
 	startDay := dayBucket(timePoints[0])
@@ -151,7 +174,7 @@ func (s *TimeSeriesStatsManager) statsByDailyBuckets(
 		dayIndex[day] = i
 	}
 
-	rows, err := s.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+	rows, err := s.repo.ReadDB().QueryContext(ctx, sqlutil.ReplacePlaceholders(fmt.Sprintf(
 		`SELECT day, pv FROM "%s_agg_daily" WHERE day >= ? AND day <= ?`,
 		websiteID,
 	)), startDay, endDay)
@@ -174,18 +197,20 @@ func (s *TimeSeriesStatsManager) statsByDailyBuckets(
 		return results, err
 	}
 
-	uvRows, err := s.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(
-		`SELECT day, COUNT(*) FROM "%s_agg_daily_ip" WHERE day >= ? AND day <= ? GROUP BY day`,
-		websiteID,
-	)), startDay, endDay)
+	approx := uvMode == config.UVModeApprox
+	uvQuery := `SELECT day, COUNT(*) FROM "%s_agg_daily_ip" WHERE day >= ? AND day <= ? GROUP BY day`
+	if approx {
+		uvQuery = `SELECT day, sketch FROM "%s_agg_daily_hll" WHERE day >= ? AND day <= ?`
+	}
+	uvRows, err := s.repo.ReadDB().QueryContext(ctx, sqlutil.ReplacePlaceholders(fmt.Sprintf(uvQuery, websiteID)), startDay, endDay)
 	if err != nil {
 		return results, err
 	}
 	defer uvRows.Close()
 	for uvRows.Next() {
 		var day time.Time
-		var uv int
-		if err := uvRows.Scan(&day, &uv); err != nil {
+		uv, err := scanUV(uvRows, approx, &day)
+		if err != nil {
 			return results, err
 		}
 		dayKey := day.Format("2006-01-02")
@@ -200,6 +225,29 @@ func (s *TimeSeriesStatsManager) statsByDailyBuckets(
 	return results, nil
 }
 
+// isHourlyView 判断时间点是否为逐小时（单日范围无论 viewType 都按小时展示）
+func isHourlyView(timePoints []time.Time) bool {
+	return len(timePoints) > 1 && timePoints[1].Sub(timePoints[0]) == time.Hour
+}
+
+// coarsenToDaily 把逐小时的时间点合并为逐日时间点，用于小时聚合已过期的时间范围
+func coarsenToDaily(timePoints []time.Time) ([]time.Time, []string) {
+	days := make([]time.Time, 0, len(timePoints)/24+1)
+	labels := make([]string, 0, cap(days))
+	seen := make(map[string]struct{})
+	for _, point := range timePoints {
+		key := dayBucket(point)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		day := time.Date(point.Year(), point.Month(), point.Day(), 0, 0, 0, 0, point.Location())
+		days = append(days, day)
+		labels = append(labels, timeutil.FormatDateWithWeekday(day, false))
+	}
+	return days, labels
+}
+
 func hourBucket(ts time.Time) int64 {
 	local := ts.In(time.Local)
 	start := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), 0, 0, 0, local.Location())
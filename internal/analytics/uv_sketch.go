@@ -0,0 +1,74 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/hll"
+)
+
+// uvModeFromQuery 返回查询使用的 UV 口径（exact / approx）
+func uvModeFromQuery(query StatsQuery) string {
+	mode, _ := query.ExtraParam["uvMode"].(string)
+	return config.NormalizeUVMode(mode)
+}
+
+// uvModeForRange 返回从 start 开始的范围实际使用的 UV 口径：精确口径所需的 IP 集合已不覆盖 start 时
+// （approx 口径下 IP 集合只与原始日志保留同样久），改用草图
+func uvModeForRange(websiteID, uvMode string, start time.Time) string {
+	if uvMode != config.UVModeExact {
+		return uvMode
+	}
+	cutoff := time.Now().AddDate(0, 0, -config.GetRetentionPolicyForWebsite(websiteID).UVExactDays)
+	if hourBucket(start) < hourBucket(cutoff) {
+		return config.UVModeApprox
+	}
+	return uvMode
+}
+
+// scanUV 扫描 (key, uv) 行：精确口径下第二列为计数，近似口径下为草图
+func scanUV(rows *sql.Rows, approx bool, key interface{}) (int, error) {
+	if !approx {
+		var uv int
+		err := rows.Scan(key, &uv)
+		return uv, err
+	}
+	var data []byte
+	if err := rows.Scan(key, &data); err != nil {
+		return 0, err
+	}
+	sketch, err := hll.Unmarshal(data)
+	if err != nil {
+		return 0, err
+	}
+	return int(sketch.Estimate()), nil
+}
+
+// mergedSketchEstimate 合并查询返回的全部草图（单列 sketch）并估算 UV
+func mergedSketchEstimate(ctx context.Context, db *sql.DB, query string, args ...interface{}) (int, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	merged := hll.New()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return 0, err
+		}
+		sketch, err := hll.Unmarshal(data)
+		if err != nil {
+			return 0, fmt.Errorf("解析 UV 草图失败: %w", err)
+		}
+		merged.Merge(sketch)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return int(merged.Estimate()), nil
+}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/likaia/nginxpulse/internal/analytics"
 	"github.com/likaia/nginxpulse/internal/cli"
+	"github.com/likaia/nginxpulse/internal/cluster"
 	"github.com/likaia/nginxpulse/internal/config"
 	"github.com/likaia/nginxpulse/internal/enrich"
 	"github.com/likaia/nginxpulse/internal/ingest"
@@ -25,6 +26,9 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// parserShutdownTimeout 为关闭时等待日志解析停止的最长时间
+const parserShutdownTimeout = 30 * time.Second
+
 // Run wires the application dependencies and blocks until shutdown.
 func Run() error {
 	if cli.ProcessCliCommands() {
@@ -64,7 +68,10 @@ func Run() error {
 	}
 	defer repository.Close()
 
+	leader := cluster.NewLeader(repository, cfg.System.LeaderElection)
+	leader.Start(ctx)
 	logParser := ingest.NewLogParser(repository)
+	logParser.SetLeader(leader)
 	statsFactory := analytics.NewStatsFactory(repository)
 
 	serverHandle, err := server.StartHTTPServer(statsFactory, logParser, cfg.Server.Port)
@@ -73,16 +80,26 @@ func Run() error {
 	}
 	printStartupNotice(cfg)
 
+	if cfg.System.AutoMigrate {
+		// 服务开始监听后再执行回填迁移，避免长时间回填导致启动超时；执行完成前对应站点暂停写入
+		go repository.RunPendingMigrations()
+	}
+
 	interval := config.ParseInterval(cfg.System.TaskInterval, 5*time.Minute)
-	go worker.InitialScan(logParser, interval)
+	go worker.InitialScan(ctx, logParser, interval)
 
 	if cfg.System.DemoMode {
 		go worker.RunDemoGenerator(ctx, repository, time.Minute)
 	}
 
 	go worker.RunScheduler(ctx, logParser, interval)
+	go watchReloadSignal(ctx, logParser, statsFactory)
 
-	return waitForShutdown(cancel, serverHandle)
+	err = waitForShutdown(cancel, serverHandle)
+	// 先等扫描在批次边界停止并保存扫描状态，再释放主节点租约，避免其他实例接管后重复扫描
+	logParser.Close(parserShutdownTimeout)
+	leader.Release()
+	return err
 }
 
 func printStartupNotice(cfg *config.Config) {
@@ -143,11 +160,12 @@ func formatAccessAddr(addr string) string {
 }
 
 func resolveConfigPath() string {
-	if _, err := os.Stat(config.ConfigFile); err == nil {
-		if abs, err := filepath.Abs(config.ConfigFile); err == nil {
+	path := config.ResolveConfigFile()
+	if _, err := os.Stat(path); err == nil {
+		if abs, err := filepath.Abs(path); err == nil {
 			return abs
 		}
-		return config.ConfigFile
+		return path
 	}
 	if config.HasEnvConfigSource() {
 		return "CONFIG_JSON/WEBSITES (env)"
@@ -178,6 +196,46 @@ func initRepository() (*store.Repository, error) {
 	return repository, nil
 }
 
+// watchReloadSignal 收到 SIGHUP 时热加载配置，加载失败时继续使用当前配置
+func watchReloadSignal(ctx context.Context, logParser *ingest.LogParser, statsFactory *analytics.StatsFactory) {
+	reloadSignal := make(chan os.Signal, 1)
+	signal.Notify(reloadSignal, syscall.SIGHUP)
+	defer signal.Stop(reloadSignal)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reloadSignal:
+			logrus.Info("收到 SIGHUP，重新加载配置 ......")
+			previous := config.ReadConfig()
+			if _, err := logParser.ReloadConfig(); err != nil {
+				logrus.WithError(err).Error("重新加载配置失败，继续使用当前配置")
+				continue
+			}
+			statsFactory.ClearCache()
+			recordReloadedConfig(statsFactory.Repo(), previous, config.ReadConfig())
+		}
+	}
+}
+
+// recordReloadedConfig 把手动修改配置文件后加载的内容记入配置历史，内容没有变化时不记录
+func recordReloadedConfig(repository *store.Repository, previous, current *config.Config) {
+	changes, err := config.DiffConfigs(previous, current)
+	if err != nil || len(changes) == 0 {
+		return
+	}
+	if err := repository.RecordConfigBaseline(previous); err != nil {
+		logrus.WithError(err).Warn("记录配置基线失败")
+	}
+	if _, _, err := repository.RecordConfig(current, store.ConfigVersion{
+		Source: store.ConfigSourceReload,
+		Actor:  "SIGHUP",
+	}); err != nil {
+		logrus.WithError(err).Warn("记录配置历史失败")
+	}
+}
+
 func waitForShutdown(cancel context.CancelFunc, serverHandle *http.Server) error {
 	shutdownSignal := make(chan os.Signal, 1)
 	signal.Notify(shutdownSignal, os.Interrupt, syscall.SIGTERM)
@@ -0,0 +1,346 @@
+// Package archive 在保留期清理前把原始日志按站点/天导出到本地目录或 S3 兼容存储，
+// 并支持把一段归档重新导入数据库以便排查。
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	manifestName    = "manifest.json"
+	importBatchSize = 1000
+)
+
+// Manifest 为站点的归档清单，与归档文件一同存放在 <站点ID>/manifest.json
+type Manifest struct {
+	WebsiteID string `json:"website_id"`
+	// ArchivedUntil 之前（Unix 秒）的日志均已归档，下次只导出之后的部分
+	ArchivedUntil int64   `json:"archived_until"`
+	Files         []Entry `json:"files"`
+}
+
+// Entry 为一个归档文件，覆盖 [From, To) 内的日志；保留期截止时间落在某天中间时，同一天会分多个文件
+type Entry struct {
+	Day       string    `json:"day"`
+	Key       string    `json:"key"`
+	From      int64     `json:"from"`
+	To        int64     `json:"to"`
+	Rows      int64     `json:"rows"`
+	Bytes     int64     `json:"bytes"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ImportResult 为重新导入归档的统计
+type ImportResult struct {
+	Files int   `json:"files"`
+	Rows  int64 `json:"rows"`
+}
+
+type Archiver struct {
+	repo    *store.Repository
+	storage Storage
+}
+
+// Enabled 返回是否在保留期清理前自动归档
+func Enabled() bool {
+	cfg := config.ReadConfig().Archive
+	return cfg != nil && cfg.Enabled
+}
+
+// New 按配置打开归档存储；未启用自动归档时仍可用于列出和导入已有归档
+func New(repo *store.Repository) (*Archiver, error) {
+	cfg := config.ReadConfig().Archive
+	if cfg == nil {
+		return nil, errors.New("未配置归档（archive）")
+	}
+	storage, err := newStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Archiver{repo: repo, storage: storage}, nil
+}
+
+// ArchiveBefore 导出站点 timestamp < cutoff 且尚未归档的日志，每天一个文件，全部写入后再更新清单。
+// 中途失败时清单不变，下次会以相同的文件名重新导出并覆盖。
+func (a *Archiver) ArchiveBefore(websiteID string, cutoff time.Time) error {
+	ctx := context.Background()
+	manifest, err := a.Manifest(websiteID)
+	if err != nil {
+		return err
+	}
+	from, to := manifest.ArchivedUntil, cutoff.Unix()
+	if to <= from {
+		return nil
+	}
+
+	var writer *dayWriter
+	defer func() {
+		if writer != nil {
+			writer.discard()
+		}
+	}()
+	flush := func() error {
+		entry, err := writer.finish()
+		if err != nil {
+			return err
+		}
+		if err := a.storage.Put(ctx, entry.Key, writer.file); err != nil {
+			return fmt.Errorf("上传归档 %s 失败: %w", entry.Key, err)
+		}
+		writer.discard()
+		writer = nil
+		manifest.addEntry(entry)
+		logrus.WithField("website", websiteID).Infof("已归档 %s 的 %d 条日志: %s", entry.Day, entry.Rows, entry.Key)
+		return nil
+	}
+
+	err = a.repo.ExportLogs(websiteID, from, to, func(log store.NginxLogRecord) error {
+		day := log.Timestamp.Format("2006-01-02")
+		if writer != nil && writer.entry.Day != day {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if writer == nil {
+			dayStart := time.Date(log.Timestamp.Year(), log.Timestamp.Month(), log.Timestamp.Day(), 0, 0, 0, 0, log.Timestamp.Location())
+			entry := Entry{
+				Day:  day,
+				From: max(from, dayStart.Unix()),
+				To:   min(to, dayStart.AddDate(0, 0, 1).Unix()),
+			}
+			entry.Key = fmt.Sprintf("%s/%s/logs-%d.ndjson.gz", websiteID, day, entry.From)
+			var err error
+			if writer, err = newDayWriter(entry); err != nil {
+				return err
+			}
+		}
+		return writer.write(log)
+	})
+	if err != nil {
+		return err
+	}
+	if writer != nil {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+
+	manifest.ArchivedUntil = to
+	return a.saveManifest(ctx, manifest)
+}
+
+// Manifest 读取站点的归档清单，尚未归档时返回空清单
+func (a *Archiver) Manifest(websiteID string) (*Manifest, error) {
+	manifest := &Manifest{WebsiteID: websiteID, Files: make([]Entry, 0)}
+	reader, err := a.storage.Open(context.Background(), websiteID+"/"+manifestName)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	if err := json.NewDecoder(reader).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("解析归档清单失败: %w", err)
+	}
+	return manifest, nil
+}
+
+func (a *Archiver) saveManifest(ctx context.Context, manifest *Manifest) error {
+	file, err := os.CreateTemp("", "nginxpulse-manifest-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return err
+	}
+	return a.storage.Put(ctx, manifest.WebsiteID+"/"+manifestName, file)
+}
+
+// addEntry 追加归档文件，同名文件（上次失败后重新导出）直接替换
+func (m *Manifest) addEntry(entry Entry) {
+	for i := range m.Files {
+		if m.Files[i].Key == entry.Key {
+			m.Files[i] = entry
+			return
+		}
+	}
+	m.Files = append(m.Files, entry)
+}
+
+// Import 把 [from, to) 内的归档日志重新写回数据库（不更新聚合）。
+// 数据库中该范围仍有日志时拒绝执行，避免重复导入。
+func (a *Archiver) Import(websiteID string, from, to time.Time) (ImportResult, error) {
+	var result ImportResult
+	if _, ok := config.GetWebsiteByID(websiteID); !ok {
+		return result, fmt.Errorf("配置中没有 ID 为 %s 的站点", websiteID)
+	}
+	manifest, err := a.Manifest(websiteID)
+	if err != nil {
+		return result, err
+	}
+
+	fromTs, toTs := from.Unix(), to.Unix()
+	var entries []Entry
+	rangeFrom, rangeTo := toTs, fromTs
+	for _, entry := range manifest.Files {
+		if entry.To <= fromTs || entry.From >= toTs {
+			continue
+		}
+		entries = append(entries, entry)
+		rangeFrom = min(rangeFrom, max(entry.From, fromTs))
+		rangeTo = max(rangeTo, min(entry.To, toTs))
+	}
+	if len(entries) == 0 {
+		return result, fmt.Errorf("站点 %s 在该时间范围内没有归档", websiteID)
+	}
+	exists, err := a.repo.HasLogsBetween(websiteID, rangeFrom, rangeTo)
+	if err != nil {
+		return result, err
+	}
+	if exists {
+		return result, errors.New("该时间范围内数据库中仍有日志，请缩小范围或等待保留期清理后再导入")
+	}
+
+	for _, entry := range entries {
+		rows, err := a.importEntry(websiteID, entry, fromTs, toTs)
+		result.Rows += rows
+		if err != nil {
+			return result, fmt.Errorf("导入归档 %s 失败: %w", entry.Key, err)
+		}
+		result.Files++
+	}
+	return result, nil
+}
+
+// importEntry 先把归档下载到临时文件并校验 sha256，再逐批写回数据库
+func (a *Archiver) importEntry(websiteID string, entry Entry, fromTs, toTs int64) (int64, error) {
+	reader, err := a.storage.Open(context.Background(), entry.Key)
+	if err != nil {
+		return 0, err
+	}
+	file, err := os.CreateTemp("", "nginxpulse-import-*")
+	if err != nil {
+		reader.Close()
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	digest := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, digest), reader)
+	reader.Close()
+	if err != nil {
+		return 0, err
+	}
+	if sum := hex.EncodeToString(digest.Sum(nil)); entry.SHA256 != "" && sum != entry.SHA256 {
+		return 0, fmt.Errorf("校验和不一致（清单 %s，文件 %s）", entry.SHA256, sum)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	gz, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+
+	var imported int64
+	batch := make([]store.NginxLogRecord, 0, importBatchSize)
+	decoder := json.NewDecoder(gz)
+	for {
+		var log store.NginxLogRecord
+		if err := decoder.Decode(&log); err == io.EOF {
+			break
+		} else if err != nil {
+			return imported, err
+		}
+		if ts := log.Timestamp.Unix(); ts < fromTs || ts >= toTs {
+			continue
+		}
+		batch = append(batch, log)
+		if len(batch) == importBatchSize {
+			if err := a.repo.InsertArchivedLogs(websiteID, batch); err != nil {
+				return imported, err
+			}
+			imported += int64(len(batch))
+			batch = batch[:0]
+		}
+	}
+	if err := a.repo.InsertArchivedLogs(websiteID, batch); err != nil {
+		return imported, err
+	}
+	return imported + int64(len(batch)), nil
+}
+
+// dayWriter 把一天的日志写成 gzip 压缩的 NDJSON 临时文件，同时计算压缩后内容的 sha256
+type dayWriter struct {
+	entry   Entry
+	file    *os.File
+	gz      *gzip.Writer
+	encoder *json.Encoder
+	digest  hash.Hash
+}
+
+func newDayWriter(entry Entry) (*dayWriter, error) {
+	file, err := os.CreateTemp("", "nginxpulse-archive-*")
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(file, digest))
+	return &dayWriter{
+		entry:   entry,
+		file:    file,
+		gz:      gz,
+		encoder: json.NewEncoder(gz),
+		digest:  digest,
+	}, nil
+}
+
+func (w *dayWriter) write(log store.NginxLogRecord) error {
+	log.ID = 0
+	w.entry.Rows++
+	return w.encoder.Encode(log)
+}
+
+func (w *dayWriter) finish() (Entry, error) {
+	if err := w.gz.Close(); err != nil {
+		return Entry{}, err
+	}
+	info, err := w.file.Stat()
+	if err != nil {
+		return Entry{}, err
+	}
+	w.entry.Bytes = info.Size()
+	w.entry.SHA256 = hex.EncodeToString(w.digest.Sum(nil))
+	w.entry.CreatedAt = time.Now()
+	return w.entry, nil
+}
+
+func (w *dayWriter) discard() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
@@ -0,0 +1,122 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest/source"
+)
+
+// Storage 为归档文件的存放位置，key 以 "/" 分隔；读取不存在的 key 时返回的错误满足 os.ErrNotExist
+type Storage interface {
+	Put(ctx context.Context, key string, file *os.File) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+func newStorage(cfg *config.ArchiveConfig) (Storage, error) {
+	if cfg.S3 != nil {
+		client, err := source.NewS3Client(
+			strings.TrimSpace(cfg.S3.Endpoint),
+			strings.TrimSpace(cfg.S3.Region),
+			strings.TrimSpace(cfg.S3.AccessKey),
+			strings.TrimSpace(cfg.S3.SecretKey),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("创建归档 S3 客户端失败: %w", err)
+		}
+		return &s3Storage{
+			client: client,
+			bucket: strings.TrimSpace(cfg.S3.Bucket),
+			prefix: strings.Trim(strings.TrimSpace(cfg.S3.Prefix), "/"),
+		}, nil
+	}
+
+	dir := strings.TrimSpace(cfg.Dir)
+	if dir == "" {
+		dir = filepath.Join(config.DataDir, "archive")
+	}
+	return &localStorage{dir: dir}, nil
+}
+
+type localStorage struct {
+	dir string
+}
+
+// Put 先写入同目录的临时文件再重命名，避免留下写了一半的归档
+func (s *localStorage) Put(_ context.Context, key string, file *os.File) error {
+	target := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".archive-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, file); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+func (s *localStorage) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.dir, filepath.FromSlash(key)))
+}
+
+type s3Storage struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func (s *s3Storage) objectKey(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return path.Join(s.prefix, key)
+}
+
+func (s *s3Storage) Put(ctx context.Context, key string, file *os.File) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+		Body:   file,
+	})
+	return err
+}
+
+func (s *s3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+		}
+		return nil, err
+	}
+	return resp.Body, nil
+}
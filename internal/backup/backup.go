@@ -0,0 +1,382 @@
+// Package backup 把一个或全部站点的数据库数据与扫描状态导出为可移植的 tar.gz 备份，
+// 并恢复到其他实例（可同时把站点改为新的 ID）。
+//
+// 备份内容：
+//
+//	sites/<站点ID>/<表名>.ndjson   站点表，每行一个 JSON 数组，列定义见 backup.json
+//	global/<表名>.ndjson          IP 归属地缓存与系统通知
+//	scan_state.json               各站点的日志扫描状态
+//	backup.json                   清单（最后写入，包含各表行数）
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/store"
+)
+
+const (
+	formatVersion = 1
+	manifestName  = "backup.json"
+	scanStateName = "scan_state.json"
+)
+
+// Manifest 为备份清单
+type Manifest struct {
+	FormatVersion int                 `json:"format_version"`
+	SchemaVersion int                 `json:"schema_version"`
+	Driver        string              `json:"driver"`
+	CreatedAt     time.Time           `json:"created_at"`
+	Websites      []string            `json:"websites"`
+	Tables        []store.BackupTable `json:"tables"`
+}
+
+// Result 为恢复备份的统计，Websites 为备份中的站点 ID 到恢复后站点 ID 的映射
+type Result struct {
+	Websites map[string]string `json:"websites"`
+	store.RestoreResult
+}
+
+// Create 导出站点数据到 w；websiteIDs 为空时导出配置中的全部站点及全部 IP 归属地缓存与系统通知。
+// scanStates 为各站点的扫描状态原文，只写入所选站点的部分。
+func Create(
+	repo *store.Repository,
+	w io.Writer,
+	websiteIDs []string,
+	scanStates map[string]json.RawMessage,
+) (*Manifest, error) {
+	full := len(websiteIDs) == 0
+	if full {
+		websiteIDs = config.GetAllWebsiteIDs()
+	}
+	for _, websiteID := range websiteIDs {
+		if _, ok := config.GetWebsiteByID(websiteID); !ok {
+			return nil, fmt.Errorf("配置中没有 ID 为 %s 的站点", websiteID)
+		}
+	}
+	if len(websiteIDs) == 0 {
+		return nil, errors.New("没有可备份的站点")
+	}
+
+	manifest := &Manifest{
+		FormatVersion: formatVersion,
+		SchemaVersion: store.SchemaVersion(),
+		Driver:        config.ReadConfig().Database.Driver,
+		CreatedAt:     time.Now(),
+		Websites:      websiteIDs,
+		Tables:        make([]store.BackupTable, 0),
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := repo.DumpTables(websiteIDs, full, func(table store.BackupTable, rows *store.BackupRows) error {
+		file, err := os.CreateTemp("", "nginxpulse-backup-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		buffered := bufio.NewWriter(file)
+		encoder := json.NewEncoder(buffered)
+		for rows.Next() {
+			if err := encoder.Encode(rows.Values()); err != nil {
+				return err
+			}
+			table.Rows++
+		}
+		if err := buffered.Flush(); err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, tableFileName(table), file); err != nil {
+			return err
+		}
+		manifest.Tables = append(manifest.Tables, table)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]json.RawMessage)
+	for _, websiteID := range websiteIDs {
+		if state, ok := scanStates[websiteID]; ok {
+			states[websiteID] = state
+		}
+	}
+	if err := writeTarJSON(tw, scanStateName, states); err != nil {
+		return nil, err
+	}
+	if err := writeTarJSON(tw, manifestName, manifest); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Restore 把备份恢复到当前数据库。idMap 把备份中的站点 ID 映射为配置中的新 ID，未列出的站点沿用原 ID；
+// 目标站点必须已在配置中且没有数据。每个站点在一个事务内恢复，返回的扫描状态已按新 ID 归类，由调用方写入数据库；
+// 中途失败时也会返回已提交站点的扫描状态，调用方应照常保存。
+func Restore(
+	repo *store.Repository,
+	r io.Reader,
+	idMap map[string]string,
+) (*Result, map[string]json.RawMessage, error) {
+	dir, err := os.MkdirTemp("", "nginxpulse-restore-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := extract(r, dir); err != nil {
+		return nil, nil, fmt.Errorf("解压备份失败: %w", err)
+	}
+	var manifest Manifest
+	if err := readJSONFile(filepath.Join(dir, manifestName), &manifest); err != nil {
+		return nil, nil, fmt.Errorf("读取备份清单失败: %w", err)
+	}
+	if manifest.FormatVersion != formatVersion {
+		return nil, nil, fmt.Errorf("不支持的备份格式版本 %d", manifest.FormatVersion)
+	}
+	if manifest.SchemaVersion > store.SchemaVersion() {
+		return nil, nil, fmt.Errorf("备份来自更新的版本（结构版本 %d，当前为 %d），请先升级 nginxpulse",
+			manifest.SchemaVersion, store.SchemaVersion())
+	}
+
+	mapping, err := resolveMapping(manifest.Websites, idMap)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 扫描状态先于数据读取：后续站点或全局数据恢复失败时，已提交站点的扫描状态仍需返回给调用方保存，
+	// 否则这些站点的日志会从头重新解析
+	var backupStates map[string]json.RawMessage
+	if err := readJSONFile(filepath.Join(dir, scanStateName), &backupStates); err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("读取扫描状态失败: %w", err)
+	}
+
+	open := func(table store.BackupTable) (store.BackupRowReader, error) {
+		return openTableFile(filepath.Join(dir, filepath.FromSlash(tableFileName(table))))
+	}
+	result := &Result{Websites: mapping}
+	states := make(map[string]json.RawMessage)
+	for _, websiteID := range manifest.Websites {
+		var tables []store.BackupTable
+		for _, table := range manifest.Tables {
+			if table.WebsiteID == websiteID {
+				tables = append(tables, table)
+			}
+		}
+		restored, err := repo.RestoreWebsite(mapping[websiteID], tables, open)
+		if err != nil {
+			return result, states, fmt.Errorf("恢复站点 %s 失败: %w", websiteID, err)
+		}
+		result.Tables += restored.Tables
+		result.Rows += restored.Rows
+		if state, ok := backupStates[websiteID]; ok {
+			states[mapping[websiteID]] = state
+		}
+	}
+
+	var globals []store.BackupTable
+	for _, table := range manifest.Tables {
+		if table.WebsiteID == "" {
+			globals = append(globals, table)
+		}
+	}
+	restored, err := repo.RestoreGlobal(globals, open, mapping)
+	if err != nil {
+		return result, states, fmt.Errorf("恢复全局数据失败: %w", err)
+	}
+	result.Tables += restored.Tables
+	result.Rows += restored.Rows
+	result.IPGeoCache = restored.IPGeoCache
+	result.Notifications = restored.Notifications
+	return result, states, nil
+}
+
+// ParseIDMap 解析 "旧ID:新ID,旧ID:新ID" 形式的站点 ID 映射
+func ParseIDMap(value string) (map[string]string, error) {
+	idMap := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		oldID, newID, ok := strings.Cut(item, ":")
+		oldID = strings.TrimSpace(oldID)
+		newID = strings.TrimSpace(newID)
+		if !ok || oldID == "" || newID == "" {
+			return nil, fmt.Errorf("站点 ID 映射格式应为 旧ID:新ID: %s", item)
+		}
+		if _, exists := idMap[oldID]; exists {
+			return nil, fmt.Errorf("站点 %s 的映射重复", oldID)
+		}
+		idMap[oldID] = newID
+	}
+	return idMap, nil
+}
+
+// resolveMapping 补全备份中每个站点的目标 ID，并检查映射是否有效
+func resolveMapping(websiteIDs []string, idMap map[string]string) (map[string]string, error) {
+	mapping := make(map[string]string, len(websiteIDs))
+	for _, websiteID := range websiteIDs {
+		mapping[websiteID] = websiteID
+	}
+	for oldID, newID := range idMap {
+		if _, ok := mapping[oldID]; !ok {
+			return nil, fmt.Errorf("备份中没有站点 %s", oldID)
+		}
+		mapping[oldID] = newID
+	}
+	targets := make(map[string]string, len(mapping))
+	for oldID, newID := range mapping {
+		if other, ok := targets[newID]; ok {
+			return nil, fmt.Errorf("站点 %s 与 %s 不能恢复到同一个站点 %s", other, oldID, newID)
+		}
+		targets[newID] = oldID
+		if _, ok := config.GetWebsiteByID(newID); !ok {
+			return nil, fmt.Errorf("配置中没有 ID 为 %s 的站点，请先添加站点或通过映射恢复到其他站点", newID)
+		}
+	}
+	return mapping, nil
+}
+
+func tableFileName(table store.BackupTable) string {
+	if table.WebsiteID == "" {
+		return path.Join("global", table.Name+".ndjson")
+	}
+	return path.Join("sites", table.WebsiteID, table.Name+".ndjson")
+}
+
+func writeTarFile(tw *tar.Writer, name string, file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    info.Size(),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+func writeTarJSON(tw *tar.Writer, name string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// extract 把备份解压到 dir，只接受普通文件，并拒绝指向目录之外的路径
+func extract(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("备份中包含非法路径 %s", header.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		file, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, tr)
+		closeErr := file.Close()
+		if err != nil {
+			return err
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+	}
+}
+
+func readJSONFile(name string, value interface{}) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+type tableFile struct {
+	file    *os.File
+	decoder *json.Decoder
+}
+
+func openTableFile(name string) (*tableFile, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	decoder.UseNumber()
+	return &tableFile{file: file, decoder: decoder}, nil
+}
+
+func (t *tableFile) Next() ([]interface{}, error) {
+	var row []interface{}
+	if err := t.decoder.Decode(&row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+func (t *tableFile) Close() error {
+	return t.file.Close()
+}
@@ -1,15 +1,22 @@
 package cli
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/likaia/nginxpulse/internal/archive"
+	"github.com/likaia/nginxpulse/internal/backup"
 	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest"
+	"github.com/likaia/nginxpulse/internal/store"
 	"github.com/likaia/nginxpulse/internal/version"
 )
 
@@ -18,6 +25,17 @@ func ProcessCliCommands() bool {
 	// 命令行参数
 	cleanApp := flag.Bool("clean", false, "清理nginxpulse服务、释放端口和删除数据")
 	showVer := flag.Bool("v", false, "显示版本信息")
+	renameSite := flag.String("rename-site", "", "迁移站点数据到新的站点 ID，格式: 旧ID:新ID（需先停止服务）")
+	listOrphanSites := flag.Bool("list-orphan-sites", false, "列出已从配置中移除、但数据库中仍有数据的站点")
+	purgeSite := flag.String("purge-site", "", "清除已从配置中移除的站点的全部数据，参数为站点 ID（需先停止服务）")
+	migrate := flag.Bool("migrate", false, "执行全部待执行的结构迁移（含已有日志的回填，需先停止服务）")
+	migrateDryRun := flag.Bool("migrate-dry-run", false, "列出待执行的结构迁移，不做任何修改")
+	archiveList := flag.String("archive-list", "", "列出站点的日志归档文件，参数为站点 ID")
+	archiveImport := flag.String("archive-import", "", "把归档日志重新导入数据库，格式: 站点ID:开始日期:结束日期（日期为 2006-01-02，含首尾）")
+	backupFile := flag.String("backup", "", "导出站点数据的完整备份到指定文件（.tar.gz，需先停止服务）")
+	backupSites := flag.String("backup-sites", "", "只备份指定站点，多个 ID 以逗号分隔（默认全部站点）")
+	restoreFile := flag.String("restore", "", "从备份文件恢复站点数据（目标站点需已在配置中且没有数据，需先停止服务）")
+	restoreMap := flag.String("restore-map", "", "恢复时改用新的站点 ID，格式: 旧ID:新ID,旧ID:新ID")
 	flag.Parse()
 
 	// 显示版本信息
@@ -47,6 +65,87 @@ func ProcessCliCommands() bool {
 		return true
 	}
 
+	// 预览待执行的结构迁移
+	if *migrateDryRun {
+		if err := listPendingMigrations(); err != nil {
+			fmt.Fprintf(os.Stderr, "读取待执行迁移失败: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+	}
+
+	// 执行结构迁移
+	if *migrate {
+		if err := runMigrations(); err != nil {
+			fmt.Fprintf(os.Stderr, "结构迁移失败: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+	}
+
+	// 列出日志归档
+	if *archiveList != "" {
+		if err := listArchives(strings.TrimSpace(*archiveList)); err != nil {
+			fmt.Fprintf(os.Stderr, "读取日志归档失败: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+	}
+
+	// 导入日志归档
+	if *archiveImport != "" {
+		if err := importArchive(*archiveImport); err != nil {
+			fmt.Fprintf(os.Stderr, "导入日志归档失败: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+	}
+
+	// 备份站点数据
+	if *backupFile != "" {
+		if err := backupWebsites(*backupFile, *backupSites); err != nil {
+			fmt.Fprintf(os.Stderr, "备份站点数据失败: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+	}
+
+	// 恢复站点数据
+	if *restoreFile != "" {
+		if err := restoreWebsites(*restoreFile, *restoreMap); err != nil {
+			fmt.Fprintf(os.Stderr, "恢复站点数据失败: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+	}
+
+	// 迁移站点数据
+	if *renameSite != "" {
+		if err := renameWebsite(*renameSite); err != nil {
+			fmt.Fprintf(os.Stderr, "迁移站点数据失败: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+	}
+
+	// 列出残留站点
+	if *listOrphanSites {
+		if err := listOrphanWebsites(); err != nil {
+			fmt.Fprintf(os.Stderr, "读取残留站点失败: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+	}
+
+	// 清除站点数据
+	if *purgeSite != "" {
+		if err := purgeWebsite(strings.TrimSpace(*purgeSite)); err != nil {
+			fmt.Fprintf(os.Stderr, "清除站点数据失败: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+	}
+
 	// 不需要退出，继续运行
 	return false
 }
@@ -58,7 +157,7 @@ func showVersion() {
 }
 
 func initConfig() bool {
-	if _, err := os.Stat(config.ConfigFile); err == nil {
+	if config.ConfigSourceType() == config.ConfigSourceFile {
 		return false
 	}
 
@@ -109,16 +208,316 @@ func validateConfig() bool {
 	}
 	fmt.Fprintln(os.Stderr, "配置文件错误:")
 	for _, item := range result.Errors {
-		if item.Field == "" {
+		location := item.Field
+		if item.File != "" {
+			location = strings.TrimSpace(item.File + " " + item.Field)
+		}
+		if location == "" {
 			fmt.Fprintf(os.Stderr, " - %s\n", item.Message)
 		} else {
-			fmt.Fprintf(os.Stderr, " - %s: %s\n", item.Field, item.Message)
+			fmt.Fprintf(os.Stderr, " - %s: %s\n", location, item.Message)
 		}
 	}
 	fmt.Fprintln(os.Stderr, "请修正配置问题后重新启动服务")
 	return true
 }
 
+// listPendingMigrations 打印全局与各站点待执行的结构迁移
+func listPendingMigrations() error {
+	if config.NeedsSetup() {
+		return fmt.Errorf("尚未完成初始化配置")
+	}
+	repository, err := store.NewRepository()
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	pending, err := repository.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		fmt.Println("没有待执行的结构迁移")
+		return nil
+	}
+	for _, item := range pending {
+		note := ""
+		if item.Backfill {
+			note = "\t需要回填已有日志"
+		}
+		fmt.Printf("%s%s\n", item, note)
+	}
+	return nil
+}
+
+// runMigrations 执行全部待执行的结构迁移，包括启动时不会自动执行的回填
+func runMigrations() error {
+	if config.NeedsSetup() {
+		return fmt.Errorf("尚未完成初始化配置")
+	}
+	repository, err := store.NewRepository()
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	applied, err := repository.Migrate(true)
+	for _, item := range applied {
+		fmt.Printf("已执行: %s\n", item)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println("没有待执行的结构迁移")
+	}
+	return nil
+}
+
+// listArchives 打印站点归档清单中的文件
+func listArchives(websiteID string) error {
+	if config.NeedsSetup() {
+		return fmt.Errorf("尚未完成初始化配置")
+	}
+	repository, err := store.NewRepository()
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	archiver, err := archive.New(repository)
+	if err != nil {
+		return err
+	}
+	manifest, err := archiver.Manifest(websiteID)
+	if err != nil {
+		return err
+	}
+	if len(manifest.Files) == 0 {
+		fmt.Printf("站点 %s 没有归档\n", websiteID)
+		return nil
+	}
+	for _, entry := range manifest.Files {
+		fmt.Printf("%s\t%d 条\t%d 字节\t%s\n", entry.Day, entry.Rows, entry.Bytes, entry.Key)
+	}
+	fmt.Printf("已归档至: %s\n", time.Unix(manifest.ArchivedUntil, 0).Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+// importArchive 把站点在日期范围内的归档日志重新写回数据库
+func importArchive(value string) error {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return fmt.Errorf("参数格式应为 站点ID:开始日期:结束日期")
+	}
+	websiteID := strings.TrimSpace(parts[0])
+	from, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(parts[1]), time.Local)
+	if err != nil {
+		return fmt.Errorf("开始日期格式无效: %w", err)
+	}
+	to, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(parts[2]), time.Local)
+	if err != nil {
+		return fmt.Errorf("结束日期格式无效: %w", err)
+	}
+	if to.Before(from) {
+		return fmt.Errorf("结束日期不能早于开始日期")
+	}
+	if config.NeedsSetup() {
+		return fmt.Errorf("尚未完成初始化配置")
+	}
+
+	repository, err := store.NewRepository()
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	archiver, err := archive.New(repository)
+	if err != nil {
+		return err
+	}
+	result, err := archiver.Import(websiteID, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已从 %d 个归档文件导入 %d 条日志，这些日志会在下次保留期清理时再次删除\n", result.Files, result.Rows)
+	return nil
+}
+
+// backupWebsites 把站点数据导出到备份文件，写入失败时删除不完整的文件
+func backupWebsites(filePath, sites string) (err error) {
+	if config.NeedsSetup() {
+		return fmt.Errorf("尚未完成初始化配置")
+	}
+	var websiteIDs []string
+	for _, id := range strings.Split(sites, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			websiteIDs = append(websiteIDs, id)
+		}
+	}
+
+	repository, err := store.NewRepository()
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(filePath)
+		}
+	}()
+
+	manifest, err := ingest.BackupData(repository, file, websiteIDs)
+	if err != nil {
+		return err
+	}
+	var rows int64
+	for _, table := range manifest.Tables {
+		rows += table.Rows
+	}
+	fmt.Printf("已备份 %d 个站点: %d 张表, %d 行 -> %s\n", len(manifest.Websites), len(manifest.Tables), rows, filePath)
+	return nil
+}
+
+// restoreWebsites 从备份文件恢复站点数据与扫描状态
+func restoreWebsites(filePath, mapping string) error {
+	idMap, err := backup.ParseIDMap(mapping)
+	if err != nil {
+		return err
+	}
+	if config.NeedsSetup() {
+		return fmt.Errorf("尚未完成初始化配置")
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	repository, err := store.NewRepository()
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	result, err := ingest.RestoreData(repository, file, idMap)
+	if err != nil {
+		return err
+	}
+	oldIDs := make([]string, 0, len(result.Websites))
+	for oldID := range result.Websites {
+		oldIDs = append(oldIDs, oldID)
+	}
+	sort.Strings(oldIDs)
+	for _, oldID := range oldIDs {
+		fmt.Printf("站点 %s -> %s\n", oldID, result.Websites[oldID])
+	}
+	fmt.Printf("已恢复 %d 张表, %d 行（IP 归属地缓存 %d 条, 系统通知 %d 条）\n",
+		result.Tables, result.Rows, result.IPGeoCache, result.Notifications)
+	return nil
+}
+
+// renameWebsite 把旧站点 ID 的数据表与扫描状态迁移到配置中的新站点 ID
+func renameWebsite(value string) error {
+	oldID, newID, ok := strings.Cut(value, ":")
+	oldID = strings.TrimSpace(oldID)
+	newID = strings.TrimSpace(newID)
+	if !ok || oldID == "" || newID == "" {
+		return fmt.Errorf("参数格式应为 旧ID:新ID")
+	}
+	if config.NeedsSetup() {
+		return fmt.Errorf("尚未完成初始化配置")
+	}
+	if _, exists := config.GetWebsiteByID(newID); !exists {
+		return fmt.Errorf("配置中没有 ID 为 %s 的站点，请先在站点配置中设置 id", newID)
+	}
+	if website, exists := config.GetWebsiteByID(oldID); exists {
+		return fmt.Errorf("站点 %s 仍在使用 ID %s，迁移会使其丢失数据", website.Name, oldID)
+	}
+
+	repository, err := store.NewRepository()
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	if err := ingest.RenameWebsiteData(repository, oldID, newID); err != nil {
+		return err
+	}
+	fmt.Printf("站点数据已从 %s 迁移到 %s\n", oldID, newID)
+	return nil
+}
+
+// listOrphanWebsites 打印数据库中仍有数据、但已不在配置中的站点
+func listOrphanWebsites() error {
+	if config.NeedsSetup() {
+		return fmt.Errorf("尚未完成初始化配置")
+	}
+	repository, err := store.NewRepository()
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	orphans, err := repository.ListOrphanWebsites()
+	if err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		fmt.Println("没有残留的站点数据")
+		return nil
+	}
+	for _, orphan := range orphans {
+		lastLog := "-"
+		if orphan.LastLogAt != nil {
+			lastLog = orphan.LastLogAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%s\t%d 张表\t最后日志: %s\n", orphan.ID, len(orphan.Tables), lastLog)
+	}
+	return nil
+}
+
+// purgeWebsite 在终端确认后清除站点的数据表、扫描状态、IP 归属地失败记录与系统通知
+func purgeWebsite(websiteID string) error {
+	if config.NeedsSetup() {
+		return fmt.Errorf("尚未完成初始化配置")
+	}
+	if website, exists := config.GetWebsiteByID(websiteID); exists {
+		return fmt.Errorf("站点 %s 仍在使用 ID %s，请先从配置中移除", website.Name, websiteID)
+	}
+
+	fmt.Printf("将永久删除站点 %s 的全部数据，请再次输入站点 ID 确认: ", websiteID)
+	reader := bufio.NewReader(os.Stdin)
+	answer, _ := reader.ReadString('\n')
+	if strings.TrimSpace(answer) != websiteID {
+		return fmt.Errorf("输入的站点 ID 不一致，已取消")
+	}
+
+	repository, err := store.NewRepository()
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	result, err := ingest.PurgeWebsiteData(repository, websiteID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已清除站点 %s: %d 张表, %d 条 IP 归属地失败记录, %d 条待解析 IP, %d 条系统通知\n",
+		websiteID, result.Tables, result.IPGeoFailures, result.IPGeoPending, result.Notifications)
+	return nil
+}
+
 // cleanService 清理 nginxpulse 服务、释放端口和删除数据
 func cleanService() {
 	fmt.Println("开始清理nginxpulse服务...")
@@ -0,0 +1,235 @@
+// Package cluster 负责多个实例共用同一个数据库时的选主：通过数据库中的租约选出一个实例
+// 执行定时扫描、历史回填与 IP 归属地解析，所有实例都提供查询与推送接口。
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	leaseName = "scheduler"
+	// leaseTTL 为租约有效期，主节点异常退出后其他实例最迟在该时间后接管
+	leaseTTL = 30 * time.Second
+	// renewInterval 为续约间隔，有效期内可容忍两次续约失败
+	renewInterval = 10 * time.Second
+	// safetyMargin 为本地判断租约到期时预留的余量，避免本地时钟与数据库不一致时两个实例同时认为自己是主节点
+	safetyMargin = 5 * time.Second
+)
+
+// Leader 维护当前实例的主节点身份。未开启选主时（单实例部署）总是视为主节点；
+// nil 的 *Leader 同样视为主节点，便于命令行与测试直接使用 LogParser。
+type Leader struct {
+	repo    *store.Repository
+	id      string
+	enabled bool
+
+	mu      sync.RWMutex
+	leading bool
+	until   time.Time // 本地认为租约有效的截止时间
+	holder  string    // 最近一次看到的主节点 ID
+	stopped bool
+	lost    chan struct{} // 当前任期失去主节点身份时关闭，未处于任期时为 nil
+}
+
+// NewLeader 创建选主器；enabled 为 false 时不访问数据库，IsLeader 恒为 true
+func NewLeader(repo *store.Repository, enabled bool) *Leader {
+	return &Leader{
+		repo:    repo,
+		id:      instanceID(),
+		enabled: enabled,
+	}
+}
+
+// instanceID 以主机名与进程号标识实例（Kubernetes 中主机名即 Pod 名称）
+func instanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "nginxpulse"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// Enabled 返回是否开启了选主
+func (l *Leader) Enabled() bool {
+	return l != nil && l.enabled
+}
+
+// ID 返回当前实例的 ID
+func (l *Leader) ID() string {
+	if l == nil {
+		return ""
+	}
+	return l.id
+}
+
+// IsLeader 返回当前实例是否为主节点
+func (l *Leader) IsLeader() bool {
+	if !l.Enabled() {
+		return true
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.leading && time.Now().Before(l.until)
+}
+
+// Holder 返回当前主节点的 ID，未知时返回空字符串
+func (l *Leader) Holder() string {
+	if !l.Enabled() {
+		return l.ID()
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.holder
+}
+
+// Start 先同步竞选一次（保证启动后的初始扫描能判断身份），再在后台定期续约或竞选，直到 ctx 取消
+func (l *Leader) Start(ctx context.Context) {
+	if !l.Enabled() {
+		return
+	}
+	logrus.Infof("已开启多实例选主，当前实例 ID: %s", l.id)
+	l.tryAcquire()
+	go func() {
+		ticker := time.NewTicker(renewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				l.tryAcquire()
+			}
+		}
+	}()
+}
+
+func (l *Leader) tryAcquire() {
+	l.mu.RLock()
+	stopped := l.stopped
+	l.mu.RUnlock()
+	if stopped {
+		return
+	}
+	start := time.Now()
+	acquired, err := l.repo.AcquireLease(leaseName, l.id, leaseTTL)
+	if err != nil {
+		// 续约失败时保留已有身份，直到本地截止时间到期
+		logrus.WithError(err).Warn("续约主节点租约失败")
+		return
+	}
+
+	holder := l.id
+	if !acquired {
+		if current, err := l.repo.LeaseHolder(leaseName); err == nil {
+			holder = current
+		} else {
+			holder = ""
+		}
+	}
+
+	l.mu.Lock()
+	if l.stopped {
+		l.mu.Unlock()
+		return
+	}
+	wasLeading := l.leading && start.Before(l.until)
+	l.leading = acquired
+	l.holder = holder
+	if acquired {
+		l.until = start.Add(leaseTTL - safetyMargin)
+		if !wasLeading {
+			l.endTermLocked()
+			l.lost = make(chan struct{})
+		}
+	} else {
+		l.endTermLocked()
+	}
+	l.mu.Unlock()
+
+	switch {
+	case acquired && !wasLeading:
+		logrus.Infof("当前实例 %s 成为主节点，开始执行定时任务", l.id)
+	case !acquired && wasLeading:
+		logrus.Warnf("当前实例 %s 失去主节点身份（当前主节点: %s），停止执行定时任务", l.id, holder)
+	}
+}
+
+// endTermLocked 结束当前任期，取消由 Context 派生的上下文
+func (l *Leader) endTermLocked() {
+	if l.lost != nil {
+		close(l.lost)
+		l.lost = nil
+	}
+}
+
+// Context 派生一个在当前实例失去主节点身份时取消的上下文：续约发现租约被其他实例持有、
+// 本地租约到期未能续约或释放租约时都会取消。未开启选主时只随 parent 取消；调用时已不是主节点则直接返回已取消的上下文。
+func (l *Leader) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	if !l.Enabled() {
+		return ctx, cancel
+	}
+	l.mu.RLock()
+	lost := l.lost
+	until := l.until
+	leading := l.leading && time.Now().Before(until)
+	l.mu.RUnlock()
+	if !leading || lost == nil {
+		cancel()
+		return ctx, cancel
+	}
+
+	go func() {
+		timer := time.NewTimer(time.Until(until))
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-lost:
+				cancel()
+				return
+			case <-timer.C:
+				// 续约成功会推后截止时间，否则说明本地租约已到期
+				l.mu.RLock()
+				until = l.until
+				l.mu.RUnlock()
+				if !time.Now().Before(until) {
+					logrus.Warnf("当前实例 %s 的主节点租约已到期，停止正在执行的定时任务", l.id)
+					cancel()
+					return
+				}
+				timer.Reset(time.Until(until))
+			}
+		}
+	}()
+	return ctx, cancel
+}
+
+// Release 在服务关闭时主动释放租约，让其他实例无需等待租约过期即可接管
+func (l *Leader) Release() {
+	if !l.Enabled() {
+		return
+	}
+	l.mu.Lock()
+	leading := l.leading
+	l.leading = false
+	l.stopped = true
+	l.endTermLocked()
+	l.mu.Unlock()
+	if !leading {
+		return
+	}
+	if err := l.repo.ReleaseLease(leaseName, l.id); err != nil {
+		logrus.WithError(err).Warn("释放主节点租约失败")
+		return
+	}
+	logrus.Infof("当前实例 %s 已释放主节点租约", l.id)
+}
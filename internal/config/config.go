@@ -5,14 +5,21 @@ import (
 	"encoding/hex"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+// snapshot 为一次加载得到的配置与站点映射，热加载时整体替换，读取方不会看到新旧混合的状态
+type snapshot struct {
+	cfg      *Config
+	websites map[string]WebsiteConfig
+}
+
 var (
-	globalConfig *Config
-	websiteIDMap sync.Map
+	current atomic.Pointer[snapshot]
+	loadMu  sync.Mutex
 )
 
 const (
@@ -27,9 +34,16 @@ type Config struct {
 	Database DatabaseConfig  `json:"database"`
 	Websites []WebsiteConfig `json:"websites"`
 	PVFilter PVFilterConfig  `json:"pvFilter"`
+	Archive  *ArchiveConfig  `json:"archive,omitempty"`
+
+	// configFile/websiteFiles 记录配置来源，用于按文件报告校验错误：
+	// configFile 为主配置文件（来自环境变量时为空），websiteFiles 与 Websites 一一对应，来自 sites.d 的站点为其文件路径
+	configFile   string
+	websiteFiles []string
 }
 
 type WebsiteConfig struct {
+	ID         string           `json:"id,omitempty"` // 显式站点 ID，留空时由名称生成
 	Name       string           `json:"name"`
 	LogPath    string           `json:"logPath"`
 	Domains    []string         `json:"domains,omitempty"`
@@ -39,6 +53,35 @@ type WebsiteConfig struct {
 	TimeLayout string           `json:"timeLayout,omitempty"`
 	Sources    []SourceConfig   `json:"sources,omitempty"`
 	Whitelist  *WhitelistConfig `json:"whitelist,omitempty"`
+	// RetentionDays/PVFilter 覆盖全局的日志保留天数与 PV 过滤规则（未配置时沿用全局）
+	RetentionDays int             `json:"retentionDays,omitempty"`
+	PVFilter      *PVFilterConfig `json:"pvFilter,omitempty"`
+}
+
+// EffectiveID 返回站点 ID：优先使用显式配置的 id，否则由站点名称生成（改名会得到新的 ID）
+func (w WebsiteConfig) EffectiveID() string {
+	if id := strings.TrimSpace(w.ID); id != "" {
+		return id
+	}
+	return generateID(w.Name)
+}
+
+// EffectivePVFilter 返回站点生效的 PV 过滤规则：站点 pvFilter 中显式配置的字段覆盖全局同名字段
+func (w WebsiteConfig) EffectivePVFilter(global PVFilterConfig) PVFilterConfig {
+	if w.PVFilter == nil {
+		return global
+	}
+	effective := global
+	if w.PVFilter.StatusCodeInclude != nil {
+		effective.StatusCodeInclude = w.PVFilter.StatusCodeInclude
+	}
+	if w.PVFilter.ExcludePatterns != nil {
+		effective.ExcludePatterns = w.PVFilter.ExcludePatterns
+	}
+	if w.PVFilter.ExcludeIPs != nil {
+		effective.ExcludeIPs = w.PVFilter.ExcludeIPs
+	}
+	return effective
 }
 
 type SourceConfig struct {
@@ -105,18 +148,116 @@ type SystemConfig struct {
 	Language          string   `json:"language"`
 	WebBasePath       string   `json:"webBasePath,omitempty"`
 	MobilePWAEnabled  bool     `json:"mobilePwaEnabled"`
+	// IngestQueueSize/IngestWorkers 控制推送接口每个站点的排队上限与并发写入数（0 表示默认值）
+	IngestQueueSize int `json:"ingestQueueSize,omitempty"`
+	IngestWorkers   int `json:"ingestWorkers,omitempty"`
+	// LogImportMaxMB 为上传导入日志时单次请求的大小上限（MB，0 表示默认 1024）
+	LogImportMaxMB int `json:"logImportMaxMB,omitempty"`
+	// LogPartitionInterval 日志表分区粒度：daily（默认）或 monthly，仅 PostgreSQL 生效
+	LogPartitionInterval string `json:"logPartitionInterval,omitempty"`
+	// LogInsertMode 日志写入方式：insert（默认，多行 INSERT）或 copy（COPY 到临时表后集合合并，仅 PostgreSQL）
+	LogInsertMode string `json:"logInsertMode,omitempty"`
+	// HourlyRetentionDays/DailyRetentionDays 为小时聚合与日聚合（含会话聚合）的保留天数，0 表示沿用上一级
+	HourlyRetentionDays int `json:"hourlyRetentionDays,omitempty"`
+	DailyRetentionDays  int `json:"dailyRetentionDays,omitempty"`
+	// UVMode 为 UV 统计的默认口径：exact（默认，精确去重）或 approx（HyperLogLog 近似），可按请求覆盖
+	UVMode string `json:"uvMode,omitempty"`
+	// AutoMigrate 为 true 时，服务开始监听后在后台自动执行需要回填已有日志的结构迁移；默认需通过 -migrate 显式执行
+	AutoMigrate bool `json:"autoMigrate,omitempty"`
+	// LeaderElection 为 true 时多个实例共用同一个 PostgreSQL，通过数据库租约选出一个实例执行定时扫描、
+	// 回填与 IP 归属地解析，其余实例只提供查询与推送接口
+	LeaderElection bool `json:"leaderElection,omitempty"`
+}
+
+const (
+	LogPartitionDaily   = "daily"
+	LogPartitionMonthly = "monthly"
+
+	LogInsertModeInsert = "insert"
+	LogInsertModeCopy   = "copy"
+
+	UVModeExact  = "exact"
+	UVModeApprox = "approx"
+)
+
+// NormalizeUVMode 返回合法的 UV 口径，空值或未知值视为 exact
+func NormalizeUVMode(mode string) string {
+	if strings.EqualFold(strings.TrimSpace(mode), UVModeApprox) {
+		return UVModeApprox
+	}
+	return UVModeExact
 }
 
+// RetentionPolicy 为分层保留策略（天）：原始日志 <= 小时聚合 <= 日聚合
+type RetentionPolicy struct {
+	RawDays    int
+	HourlyDays int
+	DailyDays  int
+	// UVExactDays 为精确 UV 所需的 IP 集合（agg_*_ip）的保留天数：exact 口径下与对应聚合相同；
+	// approx 口径下只与原始日志相同，更早范围的 UV 以草图为准
+	UVExactDays int
+}
+
+// GetRetentionPolicyForWebsite 返回站点归一化后的分层保留策略：站点 retentionDays 覆盖全局的原始日志保留天数，
+// 未配置的聚合层级沿用上一级的天数
+func GetRetentionPolicyForWebsite(websiteID string) RetentionPolicy {
+	system := ReadConfig().System
+	rawDays := system.LogRetentionDays
+	if website, ok := GetWebsiteByID(websiteID); ok && website.RetentionDays > 0 {
+		rawDays = website.RetentionDays
+	}
+	return newRetentionPolicy(rawDays, system)
+}
+
+func newRetentionPolicy(rawDays int, system SystemConfig) RetentionPolicy {
+	policy := RetentionPolicy{RawDays: rawDays}
+	if policy.RawDays <= 0 {
+		policy.RawDays = 30
+	}
+	policy.HourlyDays = max(system.HourlyRetentionDays, policy.RawDays)
+	policy.DailyDays = max(system.DailyRetentionDays, policy.HourlyDays)
+	policy.UVExactDays = policy.DailyDays
+	if NormalizeUVMode(system.UVMode) == UVModeApprox {
+		policy.UVExactDays = policy.RawDays
+	}
+	return policy
+}
+
+// ArchiveConfig 冷存储归档：保留期清理删除原始日志前，按站点/天导出为 gzip 压缩的 NDJSON 文件
+type ArchiveConfig struct {
+	Enabled bool `json:"enabled"`
+	// Format 归档格式，目前仅支持 ndjson（gzip 压缩）
+	Format string `json:"format,omitempty"`
+	// Dir 为本地归档目录（默认数据目录下的 archive）；配置 s3 时改为写入对象存储
+	Dir string           `json:"dir,omitempty"`
+	S3  *ArchiveS3Config `json:"s3,omitempty"`
+}
+
+type ArchiveS3Config struct {
+	Endpoint  string `json:"endpoint,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix,omitempty"`
+	AccessKey string `json:"accessKey,omitempty"`
+	SecretKey string `json:"secretKey,omitempty"`
+}
+
+const ArchiveFormatNDJSON = "ndjson"
+
 type ServerConfig struct {
 	Port string `json:"Port"`
 }
 
 type DatabaseConfig struct {
-	Driver          string `json:"driver"`
-	DSN             string `json:"dsn"`
-	MaxOpenConns    int    `json:"maxOpenConns"`
-	MaxIdleConns    int    `json:"maxIdleConns"`
-	ConnMaxLifetime string `json:"connMaxLifetime"`
+	Driver string `json:"driver"`
+	DSN    string `json:"dsn"`
+	// ReadDSN 为只读副本（PostgreSQL 流复制备库）的 DSN 列表，统计、日志查询与导出使用副本，写入仍走主库
+	ReadDSN         []string `json:"readDSN,omitempty"`
+	MaxOpenConns    int      `json:"maxOpenConns"`
+	MaxIdleConns    int      `json:"maxIdleConns"`
+	ConnMaxLifetime string   `json:"connMaxLifetime"`
+	// StatementTimeout 为看板统计与日志查询的超时时间（duration），留空表示不限制；请求断开时查询总会被取消
+	StatementTimeout string `json:"statementTimeout,omitempty"`
 }
 
 type PVFilterConfig struct {
@@ -132,41 +273,53 @@ func ReadRawConfig() (*Config, error) {
 
 // ReadConfig 读取配置文件并返回配置，同时初始化 ID 映射
 func ReadConfig() *Config {
-	if globalConfig != nil {
-		return globalConfig
+	if snap := current.Load(); snap != nil {
+		return snap.cfg
+	}
+
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	if snap := current.Load(); snap != nil {
+		return snap.cfg
 	}
 
 	cfg, err := loadConfig()
 	if err != nil {
 		panic(err)
 	}
+	current.Store(newSnapshot(cfg))
+	return cfg
+}
 
-	// 初始化 ID 映射
+func newSnapshot(cfg *Config) *snapshot {
+	websites := make(map[string]WebsiteConfig, len(cfg.Websites))
 	for _, website := range cfg.Websites {
-		id := generateID(website.Name)
-		websiteIDMap.Store(id, website)
+		websites[website.EffectiveID()] = website
 	}
+	return &snapshot{cfg: cfg, websites: websites}
+}
 
-	globalConfig = cfg
-	return globalConfig
+// loadSnapshot 返回当前配置快照，尚未调用 ReadConfig 时站点映射为空
+func loadSnapshot() *snapshot {
+	if snap := current.Load(); snap != nil {
+		return snap
+	}
+	return &snapshot{}
 }
 
 // GetWebsiteByID 根据 ID 获取对应的 WebsiteConfig
 func GetWebsiteByID(id string) (WebsiteConfig, bool) {
-	value, ok := websiteIDMap.Load(id)
-	if ok {
-		return value.(WebsiteConfig), true
-	}
-	return WebsiteConfig{}, false
+	website, ok := loadSnapshot().websites[id]
+	return website, ok
 }
 
 // GetAllWebsiteIDs 获取所有网站的 ID 列表
 func GetAllWebsiteIDs() []string {
-	var ids []string
-	websiteIDMap.Range(func(key, value interface{}) bool {
-		ids = append(ids, key.(string))
-		return true
-	})
+	websites := loadSnapshot().websites
+	ids := make([]string, 0, len(websites))
+	for id := range websites {
+		ids = append(ids, id)
+	}
 	return ids
 }
 
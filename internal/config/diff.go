@@ -0,0 +1,124 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// ConfigChange 为两份配置之间的一处差异。Path 中的站点与数据源以 ID 标识（如 websites[blog].logRegex），
+// 不受顺序调整影响；Old/New 为 nil 表示该字段在对应一侧不存在。
+type ConfigChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+// DiffConfigs 按字段对比两份配置，结果按 Path 排序
+func DiffConfigs(from, to *Config) ([]ConfigChange, error) {
+	left, err := flattenConfig(from)
+	if err != nil {
+		return nil, err
+	}
+	right, err := flattenConfig(to)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(left)+len(right))
+	for path := range left {
+		paths = append(paths, path)
+	}
+	for path := range right {
+		if _, ok := left[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	changes := make([]ConfigChange, 0)
+	for _, path := range paths {
+		oldValue, newValue := left[path], right[path]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		changes = append(changes, ConfigChange{Path: path, Old: oldValue, New: newValue})
+	}
+	return changes, nil
+}
+
+// flattenConfig 把配置展开为 路径 -> 值；站点 ID 先补齐为 EffectiveID，保证由名称生成 ID 的站点也能按 ID 对齐
+func flattenConfig(cfg *Config) (map[string]interface{}, error) {
+	flat := make(map[string]interface{})
+	if cfg == nil {
+		return flat, nil
+	}
+	normalized := *cfg
+	normalized.Websites = make([]WebsiteConfig, len(cfg.Websites))
+	for i, website := range cfg.Websites {
+		website.ID = website.EffectiveID()
+		normalized.Websites[i] = website
+	}
+
+	payload, err := json.Marshal(&normalized)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, err
+	}
+	flattenValue("", raw, flat)
+	return flat, nil
+}
+
+func flattenValue(path string, value interface{}, flat map[string]interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			next := key
+			if path != "" {
+				next = path + "." + key
+			}
+			flattenValue(next, item, flat)
+		}
+	case []interface{}:
+		ids, ok := elementIDs(v)
+		if !ok {
+			// 普通数组（如 excludePatterns）整体作为一个值比较
+			flat[path] = v
+			return
+		}
+		for i, item := range v {
+			flattenValue(fmt.Sprintf("%s[%s]", path, ids[i]), item, flat)
+		}
+	default:
+		flat[path] = v
+	}
+}
+
+// elementIDs 在数组元素都是带唯一非空 id 的对象时返回各元素的 id（站点与数据源）
+func elementIDs(items []interface{}) ([]string, bool) {
+	if len(items) == 0 {
+		return nil, false
+	}
+	ids := make([]string, len(items))
+	seen := make(map[string]struct{}, len(items))
+	for i, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		id, _ := obj["id"].(string)
+		if id == "" {
+			return nil, false
+		}
+		if _, dup := seen[id]; dup {
+			return nil, false
+		}
+		seen[id] = struct{}{}
+		ids[i] = id
+	}
+	return ids, true
+}
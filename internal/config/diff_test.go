@@ -0,0 +1,60 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffConfigsMatchesWebsitesByID(t *testing.T) {
+	from := &Config{
+		Websites: []WebsiteConfig{
+			{ID: "blog", Name: "Blog", LogPath: "/var/log/blog.log", LogRegex: `^(?P<ip>\S+)`},
+			{Name: "Shop", LogPath: "/var/log/shop.log"},
+		},
+		PVFilter: PVFilterConfig{ExcludePatterns: []string{"favicon.ico$"}},
+	}
+	to := &Config{
+		Websites: []WebsiteConfig{
+			// 调整顺序不应产生差异
+			{Name: "Shop", LogPath: "/var/log/shop.log"},
+			{ID: "blog", Name: "Blog", LogPath: "/var/log/blog.log", LogRegex: `^(?P<ip>\S+) -`},
+		},
+		PVFilter: PVFilterConfig{ExcludePatterns: []string{"favicon.ico$", "robots.txt$"}},
+	}
+
+	changes, err := DiffConfigs(from, to)
+	if err != nil {
+		t.Fatalf("DiffConfigs: %v", err)
+	}
+	want := []ConfigChange{
+		{Path: "pvFilter.excludePatterns", Old: []interface{}{"favicon.ico$"}, New: []interface{}{"favicon.ico$", "robots.txt$"}},
+		{Path: "websites[blog].logRegex", Old: `^(?P<ip>\S+)`, New: `^(?P<ip>\S+) -`},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("changes = %#v, want %#v", changes, want)
+	}
+}
+
+func TestDiffConfigsAddedWebsite(t *testing.T) {
+	from := &Config{Websites: []WebsiteConfig{{ID: "blog", Name: "Blog", LogPath: "/a"}}}
+	to := &Config{Websites: []WebsiteConfig{
+		{ID: "blog", Name: "Blog", LogPath: "/a"},
+		{ID: "shop", Name: "Shop", LogPath: "/b"},
+	}}
+
+	changes, err := DiffConfigs(from, to)
+	if err != nil {
+		t.Fatalf("DiffConfigs: %v", err)
+	}
+	paths := make([]string, 0, len(changes))
+	for _, change := range changes {
+		if change.Old != nil {
+			t.Errorf("%s: old = %v, want nil", change.Path, change.Old)
+		}
+		paths = append(paths, change.Path)
+	}
+	want := []string{"websites[shop].id", "websites[shop].logPath", "websites[shop].name"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("paths = %v, want %v", paths, want)
+	}
+}
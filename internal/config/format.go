@@ -0,0 +1,170 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
+
+// configFileCandidates 为主配置文件的候选路径，按顺序使用第一个存在的文件
+var configFileCandidates = []string{
+	ConfigFile,
+	"./configs/nginxpulse_config.yaml",
+	"./configs/nginxpulse_config.yml",
+	"./configs/nginxpulse_config.toml",
+}
+
+// ResolveConfigFile 返回实际使用的主配置文件路径，都不存在时返回默认的 JSON 路径
+func ResolveConfigFile() string {
+	for _, path := range configFileCandidates {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ConfigFile
+}
+
+// configFormat 根据扩展名判断配置格式，不支持的扩展名返回空字符串
+func configFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return formatJSON
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".toml":
+		return formatTOML
+	default:
+		return ""
+	}
+}
+
+// decodeConfigData 按文件格式解析配置。YAML 与 TOML 先转换为 JSON 再解码，
+// 因此三种格式使用相同的字段名（即 json tag）。
+func decodeConfigData(path string, data []byte, out interface{}) error {
+	var raw interface{}
+	switch configFormat(path) {
+	case formatYAML:
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+	case formatTOML:
+		var table map[string]interface{}
+		if err := toml.Unmarshal(data, &table); err != nil {
+			return err
+		}
+		raw = table
+	default:
+		return json.Unmarshal(data, out)
+	}
+	if raw == nil {
+		return nil
+	}
+	converted, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(converted, out)
+}
+
+// encodeConfigData 按文件格式序列化配置，字段顺序与 JSON 一致
+func encodeConfigData(path string, value interface{}) ([]byte, error) {
+	payload, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	switch configFormat(path) {
+	case formatYAML:
+		// JSON 是合法的 YAML：解析为节点树可保留字段顺序，清除引号与流式风格后输出块格式
+		var node yaml.Node
+		if err := yaml.Unmarshal(payload, &node); err != nil {
+			return nil, err
+		}
+		resetYAMLStyle(&node)
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&node); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case formatTOML:
+		decoder := json.NewDecoder(bytes.NewReader(payload))
+		decoder.UseNumber()
+		var raw map[string]interface{}
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, err
+		}
+		return toml.Marshal(tomlValue(raw))
+	default:
+		return payload, nil
+	}
+}
+
+func resetYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetYAMLStyle(child)
+	}
+}
+
+// tomlValue 把 JSON 解码结果转换为 TOML 可表示的值：去掉 null，数字还原为整数或浮点数
+func tomlValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if item == nil {
+				continue
+			}
+			out[key] = tomlValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			if item == nil {
+				continue
+			}
+			out = append(out, tomlValue(item))
+		}
+		return out
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	default:
+		return v
+	}
+}
+
+// readConfigFile 读取并解析一个配置文件，错误信息带上文件路径
+func readConfigFile(path string, out interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := decodeConfigData(path, data, out); err != nil {
+		return fmt.Errorf("解析 %s 失败: %w", path, err)
+	}
+	return nil
+}
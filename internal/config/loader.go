@@ -16,6 +16,8 @@ const (
 	envTaskInterval      = "TASK_INTERVAL"
 	envHTTPSourceTimeout = "HTTP_SOURCE_TIMEOUT"
 	envLogRetentionDays  = "LOG_RETENTION_DAYS"
+	envHourlyRetention   = "HOURLY_RETENTION_DAYS"
+	envDailyRetention    = "DAILY_RETENTION_DAYS"
 	envLogParseBatchSize = "LOG_PARSE_BATCH_SIZE"
 	envServerPort        = "SERVER_PORT"
 	envPVStatusCodes     = "PV_STATUS_CODES"
@@ -28,11 +30,29 @@ const (
 	envMobilePWAEnabled  = "MOBILE_PWA_ENABLED"
 	envIPGeoCacheLimit   = "IP_GEO_CACHE_LIMIT"
 	envIPGeoAPIURL       = "IP_GEO_API_URL"
+	envIngestQueueSize   = "INGEST_QUEUE_SIZE"
+	envIngestWorkers     = "INGEST_WORKERS"
+	envLogImportMaxMB    = "LOG_IMPORT_MAX_MB"
+	envLogPartition      = "LOG_PARTITION_INTERVAL"
+	envUVMode            = "UV_MODE"
+	envLogInsertMode     = "LOG_INSERT_MODE"
+	envAutoMigrate       = "AUTO_MIGRATE"
+	envLeaderElection    = "LEADER_ELECTION"
+	envArchiveEnabled    = "ARCHIVE_ENABLED"
+	envArchiveDir        = "ARCHIVE_DIR"
+	envArchiveS3Endpoint = "ARCHIVE_S3_ENDPOINT"
+	envArchiveS3Region   = "ARCHIVE_S3_REGION"
+	envArchiveS3Bucket   = "ARCHIVE_S3_BUCKET"
+	envArchiveS3Prefix   = "ARCHIVE_S3_PREFIX"
+	envArchiveS3Access   = "ARCHIVE_S3_ACCESS_KEY"
+	envArchiveS3Secret   = "ARCHIVE_S3_SECRET_KEY"
 	envDBDriver          = "DB_DRIVER"
 	envDBDSN             = "DB_DSN"
+	envDBReadDSN         = "DB_READ_DSN"
 	envDBMaxOpenConns    = "DB_MAX_OPEN_CONNS"
 	envDBMaxIdleConns    = "DB_MAX_IDLE_CONNS"
 	envDBConnMaxLifetime = "DB_CONN_MAX_LIFETIME"
+	envDBStmtTimeout     = "DB_STATEMENT_TIMEOUT"
 )
 
 var (
@@ -97,32 +117,41 @@ func loadConfig() (*Config, error) {
 	cfg := DefaultConfig()
 	cfgPtr := &cfg
 	loaded := false
+	var siteFiles []siteFile
 
 	if ForceEmptyConfigEnabled() {
 		loaded = false
-	} else if raw, key := getEnvValue(envConfigJSON); raw != "" {
-		if err := json.Unmarshal([]byte(raw), cfgPtr); err != nil {
-			return nil, fmt.Errorf("解析 %s 失败: %w", key, err)
-		}
-		loaded = true
 	} else {
-		bytes, err := os.ReadFile(ConfigFile)
-		if err == nil {
-			if err := json.Unmarshal(bytes, cfgPtr); err != nil {
-				return nil, err
+		if raw, key := getEnvValue(envConfigJSON); raw != "" {
+			if err := json.Unmarshal([]byte(raw), cfgPtr); err != nil {
+				return nil, fmt.Errorf("解析 %s 失败: %w", key, err)
 			}
 			loaded = true
-		} else if !os.IsNotExist(err) {
+		} else {
+			path := ResolveConfigFile()
+			err := readConfigFile(path, cfgPtr)
+			if err == nil {
+				cfgPtr.configFile = path
+				loaded = true
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			} else if !HasEnvConfigSource() {
+				// 配置不存在且未注入环境变量，进入初始化模式
+				loaded = false
+			}
+		}
+
+		files, err := loadSiteFiles()
+		if err != nil {
 			return nil, err
-		} else if !HasEnvConfigSource() {
-			// 配置不存在且未注入环境变量，进入初始化模式
-			loaded = false
 		}
+		siteFiles = files
 	}
 
 	if err := applyEnvOverrides(cfgPtr); err != nil {
 		return nil, err
 	}
+	appendSiteFiles(cfgPtr, siteFiles)
 	applyDefaults(cfgPtr)
 
 	if !loaded && len(cfgPtr.Websites) == 0 && !NeedsSetup() {
@@ -175,6 +204,26 @@ func applyEnvOverrides(cfg *Config) error {
 		}
 		cfg.System.LogRetentionDays = parsed
 	}
+	if raw, key := getEnvValue(envHourlyRetention); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("解析 %s 失败: %w", key, err)
+		}
+		if parsed < 0 {
+			return fmt.Errorf("%s 不能小于0", key)
+		}
+		cfg.System.HourlyRetentionDays = parsed
+	}
+	if raw, key := getEnvValue(envDailyRetention); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("解析 %s 失败: %w", key, err)
+		}
+		if parsed < 0 {
+			return fmt.Errorf("%s 不能小于0", key)
+		}
+		cfg.System.DailyRetentionDays = parsed
+	}
 	if raw, key := getEnvValue(envLogParseBatchSize); raw != "" {
 		parsed, err := strconv.Atoi(raw)
 		if err != nil {
@@ -195,6 +244,45 @@ func applyEnvOverrides(cfg *Config) error {
 		}
 		cfg.System.IPGeoCacheLimit = parsed
 	}
+	if raw, key := getEnvValue(envIngestQueueSize); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("解析 %s 失败: %w", key, err)
+		}
+		if parsed <= 0 {
+			return fmt.Errorf("%s 必须大于0", key)
+		}
+		cfg.System.IngestQueueSize = parsed
+	}
+	if raw, key := getEnvValue(envIngestWorkers); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("解析 %s 失败: %w", key, err)
+		}
+		if parsed <= 0 {
+			return fmt.Errorf("%s 必须大于0", key)
+		}
+		cfg.System.IngestWorkers = parsed
+	}
+	if raw, key := getEnvValue(envLogImportMaxMB); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("解析 %s 失败: %w", key, err)
+		}
+		if parsed <= 0 {
+			return fmt.Errorf("%s 必须大于0", key)
+		}
+		cfg.System.LogImportMaxMB = parsed
+	}
+	if raw, _ := getEnvValue(envLogPartition); raw != "" {
+		cfg.System.LogPartitionInterval = strings.ToLower(strings.TrimSpace(raw))
+	}
+	if raw, _ := getEnvValue(envLogInsertMode); raw != "" {
+		cfg.System.LogInsertMode = strings.ToLower(strings.TrimSpace(raw))
+	}
+	if raw, _ := getEnvValue(envUVMode); raw != "" {
+		cfg.System.UVMode = strings.ToLower(strings.TrimSpace(raw))
+	}
 	if raw, _ := getEnvValue(envIPGeoAPIURL); raw != "" {
 		cfg.System.IPGeoAPIURL = strings.TrimSpace(raw)
 	}
@@ -228,6 +316,20 @@ func applyEnvOverrides(cfg *Config) error {
 		}
 		cfg.System.MobilePWAEnabled = parsed
 	}
+	if raw, key := getEnvValue(envAutoMigrate); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("解析 %s 失败: %w", key, err)
+		}
+		cfg.System.AutoMigrate = parsed
+	}
+	if raw, key := getEnvValue(envLeaderElection); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("解析 %s 失败: %w", key, err)
+		}
+		cfg.System.LeaderElection = parsed
+	}
 
 	if raw, _ := getEnvValue(envServerPort); raw != "" {
 		if !strings.Contains(raw, ":") {
@@ -242,6 +344,13 @@ func applyEnvOverrides(cfg *Config) error {
 	if raw, _ := getEnvValue(envDBDSN); raw != "" {
 		cfg.Database.DSN = raw
 	}
+	if raw, key := getEnvValue(envDBReadDSN); raw != "" {
+		parsed, err := parseStringSliceFlexible(raw)
+		if err != nil {
+			return fmt.Errorf("解析 %s 失败: %w", key, err)
+		}
+		cfg.Database.ReadDSN = parsed
+	}
 	if raw, key := getEnvValue(envDBMaxOpenConns); raw != "" {
 		parsed, err := strconv.Atoi(raw)
 		if err != nil {
@@ -268,6 +377,16 @@ func applyEnvOverrides(cfg *Config) error {
 		}
 		cfg.Database.ConnMaxLifetime = raw
 	}
+	if raw, key := getEnvValue(envDBStmtTimeout); raw != "" {
+		if _, err := time.ParseDuration(raw); err != nil {
+			return fmt.Errorf("解析 %s 失败: %w", key, err)
+		}
+		cfg.Database.StatementTimeout = raw
+	}
+
+	if err := applyArchiveEnvOverrides(cfg); err != nil {
+		return err
+	}
 
 	if raw, key := getEnvValue(envPVStatusCodes); raw != "" {
 		values, err := parseIntSlice(raw)
@@ -433,3 +552,48 @@ func getEnvValue(keys ...string) (string, string) {
 	}
 	return "", ""
 }
+
+func applyArchiveEnvOverrides(cfg *Config) error {
+	archive := func() *ArchiveConfig {
+		if cfg.Archive == nil {
+			cfg.Archive = &ArchiveConfig{}
+		}
+		return cfg.Archive
+	}
+	archiveS3 := func() *ArchiveS3Config {
+		if archive().S3 == nil {
+			cfg.Archive.S3 = &ArchiveS3Config{}
+		}
+		return cfg.Archive.S3
+	}
+
+	if raw, key := getEnvValue(envArchiveEnabled); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("解析 %s 失败: %w", key, err)
+		}
+		archive().Enabled = parsed
+	}
+	if raw, _ := getEnvValue(envArchiveDir); raw != "" {
+		archive().Dir = strings.TrimSpace(raw)
+	}
+	if raw, _ := getEnvValue(envArchiveS3Bucket); raw != "" {
+		archiveS3().Bucket = strings.TrimSpace(raw)
+	}
+	if raw, _ := getEnvValue(envArchiveS3Endpoint); raw != "" {
+		archiveS3().Endpoint = strings.TrimSpace(raw)
+	}
+	if raw, _ := getEnvValue(envArchiveS3Region); raw != "" {
+		archiveS3().Region = strings.TrimSpace(raw)
+	}
+	if raw, _ := getEnvValue(envArchiveS3Prefix); raw != "" {
+		archiveS3().Prefix = strings.TrimSpace(raw)
+	}
+	if raw, _ := getEnvValue(envArchiveS3Access); raw != "" {
+		archiveS3().AccessKey = strings.TrimSpace(raw)
+	}
+	if raw, _ := getEnvValue(envArchiveS3Secret); raw != "" {
+		archiveS3().SecretKey = strings.TrimSpace(raw)
+	}
+	return nil
+}
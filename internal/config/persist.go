@@ -1,31 +1,40 @@
 package config
 
 import (
-	"encoding/json"
 	"os"
 	"path/filepath"
 )
 
 // WriteConfigFile writes the config to disk with an atomic rename.
+// The main config file keeps its current format (json/yaml/toml); websites defined in
+// sites.d are left to their own files and must be unchanged in cfg (see ErrManagedWebsite).
 func WriteConfigFile(cfg *Config) error {
 	if cfg == nil {
 		return nil
 	}
 
-	payload, err := json.MarshalIndent(cfg, "", "  ")
+	websites, err := splitManagedWebsites(cfg)
 	if err != nil {
 		return err
 	}
+	main := *cfg
+	main.Websites = websites
 
-	dir := filepath.Dir(ConfigFile)
+	path := ResolveConfigFile()
+	payload, err := encodeConfigData(path, &main)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
 
-	tmpPath := ConfigFile + ".tmp"
+	tmpPath := path + ".tmp"
 	if err := os.WriteFile(tmpPath, payload, 0644); err != nil {
 		return err
 	}
 
-	return os.Rename(tmpPath, ConfigFile)
+	return os.Rename(tmpPath, path)
 }
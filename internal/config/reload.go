@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// changed 在每次热加载成功后关闭并替换，由 loadMu 保护
+var changed = make(chan struct{})
+
+// ReloadResult 描述一次热加载的结果
+type ReloadResult struct {
+	Added   []string `json:"added"`   // 新增的站点 ID
+	Removed []string `json:"removed"` // 移除的站点 ID
+	// RestartFields 为已写入但需要重启服务才会生效的配置项
+	RestartFields []string `json:"restart_fields"`
+}
+
+// RestartRequired 返回是否有配置项需要重启才能生效
+func (r ReloadResult) RestartRequired() bool {
+	return len(r.RestartFields) > 0
+}
+
+// Changed 返回在下一次热加载成功后关闭的 channel，用于依赖配置的后台任务（如定时任务间隔）感知变更
+func Changed() <-chan struct{} {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	return changed
+}
+
+// ReloadConfig 重新读取配置（配置文件或环境变量）并校验，通过后原子替换当前配置与站点映射。
+// prepare 在替换前以新配置调用（如为新增站点建表），返回错误时放弃本次加载，当前配置保持不变。
+func ReloadConfig(prepare func(next *Config, result ReloadResult) error) (ReloadResult, error) {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	result := ReloadResult{}
+	next, err := loadConfig()
+	if err != nil {
+		return result, fmt.Errorf("读取配置失败: %w", err)
+	}
+	validation := ValidateConfig(next, ValidateOptions{})
+	if len(validation.Errors) > 0 {
+		messages := make([]string, 0, len(validation.Errors))
+		for _, fieldErr := range validation.Errors {
+			messages = append(messages, fieldErr.Field+": "+fieldErr.Message)
+		}
+		return result, fmt.Errorf("配置校验失败: %s", strings.Join(messages, "; "))
+	}
+
+	prev := loadSnapshot()
+	snap := newSnapshot(next)
+	for id := range snap.websites {
+		if _, ok := prev.websites[id]; !ok {
+			result.Added = append(result.Added, id)
+		}
+	}
+	for id := range prev.websites {
+		if _, ok := snap.websites[id]; !ok {
+			result.Removed = append(result.Removed, id)
+		}
+	}
+	if prev.cfg != nil {
+		result.RestartFields = restartFields(prev.cfg, next)
+	}
+
+	if prepare != nil {
+		if err := prepare(next, result); err != nil {
+			return result, err
+		}
+	}
+
+	current.Store(snap)
+	close(changed)
+	changed = make(chan struct{})
+	return result, nil
+}
+
+// restartFields 列出新旧配置中取值不同、且只在启动时读取的配置项
+func restartFields(prev, next *Config) []string {
+	fields := make([]string, 0)
+	check := func(name string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			fields = append(fields, name)
+		}
+	}
+	check("server.Port", prev.Server.Port, next.Server.Port)
+	check("database", prev.Database, next.Database)
+	check("system.logDestination", prev.System.LogDestination, next.System.LogDestination)
+	check("system.webBasePath", prev.System.WebBasePath, next.System.WebBasePath)
+	check("system.demoMode", prev.System.DemoMode, next.System.DemoMode)
+	check("system.leaderElection", prev.System.LeaderElection, next.System.LeaderElection)
+	check("system.ipGeoCacheLimit", prev.System.IPGeoCacheLimit, next.System.IPGeoCacheLimit)
+	check("system.ingestQueueSize", prev.System.IngestQueueSize, next.System.IngestQueueSize)
+	check("system.ingestWorkers", prev.System.IngestWorkers, next.System.IngestWorkers)
+	return fields
+}
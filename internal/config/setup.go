@@ -28,7 +28,7 @@ func ConfigSourceType() ConfigSource {
 	if HasEnvConfigSource() {
 		return ConfigSourceEnv
 	}
-	if _, err := os.Stat(ConfigFile); err == nil {
+	if _, err := os.Stat(ResolveConfigFile()); err == nil || hasSiteFiles() {
 		return ConfigSourceFile
 	}
 	return ConfigSourceNone
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SitesDir 下每个文件定义一个站点（json/yaml/yml/toml），按文件名顺序追加在主配置的 websites 之后
+const SitesDir = "./configs/sites.d"
+
+// ErrManagedWebsite 表示保存的配置修改了由 sites.d 文件定义的站点
+var ErrManagedWebsite = errors.New("站点由 sites.d 文件管理")
+
+type siteFile struct {
+	path    string
+	website WebsiteConfig
+}
+
+// loadSiteFiles 按文件名顺序读取 sites.d 下的站点文件，目录不存在时返回空列表；
+// 隐藏文件与不支持的扩展名（如 .bak）会被忽略
+func loadSiteFiles() ([]siteFile, error) {
+	entries, err := os.ReadDir(SitesDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]siteFile, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || configFormat(name) == "" {
+			continue
+		}
+		path := filepath.Join(SitesDir, name)
+		var website WebsiteConfig
+		if err := readConfigFile(path, &website); err != nil {
+			return nil, err
+		}
+		files = append(files, siteFile{path: path, website: website})
+	}
+	return files, nil
+}
+
+// hasSiteFiles 返回 sites.d 下是否有站点文件
+func hasSiteFiles() bool {
+	files, err := loadSiteFiles()
+	return err != nil || len(files) > 0
+}
+
+// appendSiteFiles 把 sites.d 中的站点追加到配置中，并记录每个站点的来源文件
+func appendSiteFiles(cfg *Config, files []siteFile) {
+	cfg.websiteFiles = make([]string, len(cfg.Websites), len(cfg.Websites)+len(files))
+	for _, file := range files {
+		cfg.Websites = append(cfg.Websites, file.website)
+		cfg.websiteFiles = append(cfg.websiteFiles, file.path)
+	}
+}
+
+// WebsiteFile 返回第 i 个站点的来源文件，不是来自 sites.d 时返回空字符串
+func (c *Config) WebsiteFile(i int) string {
+	if i < 0 || i >= len(c.websiteFiles) {
+		return ""
+	}
+	return c.websiteFiles[i]
+}
+
+// ManagedWebsites 返回由 sites.d 文件定义的站点 ID 与对应文件
+func (c *Config) ManagedWebsites() map[string]string {
+	managed := make(map[string]string)
+	for i, website := range c.Websites {
+		if file := c.WebsiteFile(i); file != "" {
+			managed[website.EffectiveID()] = file
+		}
+	}
+	return managed
+}
+
+// splitManagedWebsites 从待保存的配置中去掉 sites.d 定义的站点，这些站点只能通过修改对应文件变更；
+// 待保存的站点与文件内容不一致时返回 ErrManagedWebsite
+func splitManagedWebsites(cfg *Config) ([]WebsiteConfig, error) {
+	files, err := loadSiteFiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return cfg.Websites, nil
+	}
+	managed := make(map[string]siteFile, len(files))
+	for _, file := range files {
+		managed[file.website.EffectiveID()] = file
+	}
+
+	websites := make([]WebsiteConfig, 0, len(cfg.Websites))
+	for _, website := range cfg.Websites {
+		id := website.EffectiveID()
+		file, ok := managed[id]
+		if !ok {
+			websites = append(websites, website)
+			continue
+		}
+		if !sameWebsite(website, file.website) {
+			return nil, fmt.Errorf("%w: 站点 %s 定义在 %s 中，请直接修改该文件", ErrManagedWebsite, id, file.path)
+		}
+	}
+	return websites, nil
+}
+
+// sameWebsite 以序列化结果比较站点配置，忽略 nil 与空集合等不影响含义的差异
+func sameWebsite(a, b WebsiteConfig) bool {
+	left, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	right, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(left) == string(right)
+}
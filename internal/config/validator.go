@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -14,6 +15,8 @@ import (
 type FieldError struct {
 	Field   string `json:"field"`
 	Message string `json:"message"`
+	// File 为出错字段所在的配置文件（sites.d 中的站点文件或主配置文件），配置来自页面或环境变量时为空
+	File string `json:"file,omitempty"`
 }
 
 type ValidateOptions struct {
@@ -26,6 +29,32 @@ type ValidationResult struct {
 	Warnings []FieldError `json:"warnings"`
 }
 
+// websiteIDPattern 站点 ID 会作为表名与索引名前缀，限制为小写字母与数字
+var websiteIDPattern = regexp.MustCompile(`^[a-z0-9]{1,32}$`)
+
+// reservedWebsiteIDs 为全局表、索引（idx_*）与 SQLite 内部表使用的名称前缀，不能作为站点 ID
+var reservedWebsiteIDs = map[string]bool{
+	"ip":     true, // ip_geo_cache / ip_geo_pending / ip_geo_api_failures
+	"system": true, // system_notifications
+	"config": true, // config_history
+	"scan":   true, // scan_state
+	"schema": true, // schema_migrations
+	"leader": true, // leader_lease
+	"idx":    true,
+	"sqlite": true,
+}
+
+// CheckWebsiteID 检查显式配置的站点 ID 能否用作表名前缀
+func CheckWebsiteID(id string) error {
+	if !websiteIDPattern.MatchString(id) {
+		return fmt.Errorf("站点 ID 只能包含小写字母和数字，长度不超过 32")
+	}
+	if reservedWebsiteIDs[id] {
+		return fmt.Errorf("站点 ID %s 为系统保留名称，请换一个 ID", id)
+	}
+	return nil
+}
+
 func ValidateConfig(cfg *Config, opts ValidateOptions) ValidationResult {
 	result := ValidationResult{}
 	addError := func(field, msg string) {
@@ -44,11 +73,38 @@ func ValidateConfig(cfg *Config, opts ValidateOptions) ValidationResult {
 		addError("websites", "至少需要配置一个站点")
 	}
 
+	siteIDs := map[string]int{}
 	for i, site := range cfg.Websites {
 		sitePrefix := fmt.Sprintf("websites[%d]", i)
 		if strings.TrimSpace(site.Name) == "" {
 			addError(sitePrefix+".name", "站点名称不能为空")
 		}
+		id := strings.TrimSpace(site.ID)
+		var idErr error
+		if id != "" {
+			idErr = CheckWebsiteID(id)
+		}
+		if idErr != nil {
+			addError(sitePrefix+".id", idErr.Error())
+		} else if strings.TrimSpace(site.Name) != "" || id != "" {
+			// 名称生成的 ID 只有 4 位十六进制，不同名称也可能冲突
+			siteID := site.EffectiveID()
+			if other, ok := siteIDs[siteID]; ok {
+				conflict := fmt.Sprintf("websites[%d]", other)
+				if file := cfg.WebsiteFile(other); file != "" {
+					conflict = file
+				}
+				addError(sitePrefix+".id", fmt.Sprintf("站点 ID %s 与 %s 冲突，请为其中一个站点配置不同的 id", siteID, conflict))
+			} else {
+				siteIDs[siteID] = i
+			}
+		}
+		if site.RetentionDays < 0 {
+			addError(sitePrefix+".retentionDays", "retentionDays 不能小于 0")
+		}
+		if site.PVFilter != nil {
+			validatePVFilter(sitePrefix+".pvFilter", *site.PVFilter, true, addError)
+		}
 
 		if len(site.Sources) == 0 {
 			if strings.TrimSpace(site.LogPath) == "" {
@@ -147,23 +203,90 @@ func ValidateConfig(cfg *Config, opts ValidateOptions) ValidationResult {
 		}
 	}
 
-	if strings.TrimSpace(cfg.Database.Driver) == "" {
+	driver := strings.TrimSpace(cfg.Database.Driver)
+	if driver == "" {
 		addError("database.driver", "数据库驱动不能为空")
-	} else if strings.TrimSpace(cfg.Database.Driver) != "postgres" {
-		addError("database.driver", "仅支持 postgres 驱动")
+	} else if driver != "postgres" && driver != "sqlite" {
+		addError("database.driver", "仅支持 postgres 或 sqlite 驱动")
 	}
-	if strings.TrimSpace(cfg.Database.DSN) == "" {
+	// sqlite 未填写 DSN 时使用数据目录下的默认文件
+	if driver != "sqlite" && strings.TrimSpace(cfg.Database.DSN) == "" {
 		addError("database.dsn", "数据库 DSN 不能为空")
 	}
+	if len(cfg.Database.ReadDSN) > 0 && driver == "sqlite" {
+		addError("database.readDSN", "readDSN（只读副本）仅支持 PostgreSQL")
+	}
+	for _, dsn := range cfg.Database.ReadDSN {
+		if strings.TrimSpace(dsn) == "" {
+			addError("database.readDSN", "readDSN 不能包含空的 DSN")
+			break
+		}
+	}
+	if raw := strings.TrimSpace(cfg.Database.StatementTimeout); raw != "" {
+		if timeout, err := time.ParseDuration(raw); err != nil || timeout < 0 {
+			addError("database.statementTimeout", "statementTimeout 格式不正确，应为 duration，例如 30s")
+		}
+	}
 	if cfg.System.LogRetentionDays <= 0 {
 		addError("system.logRetentionDays", "logRetentionDays 必须大于 0")
 	}
+	if cfg.System.HourlyRetentionDays < 0 {
+		addError("system.hourlyRetentionDays", "hourlyRetentionDays 不能小于 0")
+	} else if cfg.System.HourlyRetentionDays > 0 && cfg.System.HourlyRetentionDays < cfg.System.LogRetentionDays {
+		addError("system.hourlyRetentionDays", "hourlyRetentionDays 不能小于 logRetentionDays")
+	}
+	if cfg.System.DailyRetentionDays < 0 {
+		addError("system.dailyRetentionDays", "dailyRetentionDays 不能小于 0")
+	} else if cfg.System.DailyRetentionDays > 0 &&
+		cfg.System.DailyRetentionDays < max(cfg.System.HourlyRetentionDays, cfg.System.LogRetentionDays) {
+		addError("system.dailyRetentionDays", "dailyRetentionDays 不能小于 hourlyRetentionDays 与 logRetentionDays")
+	}
+	switch strings.TrimSpace(cfg.System.LogPartitionInterval) {
+	case "", LogPartitionDaily, LogPartitionMonthly:
+	default:
+		addError("system.logPartitionInterval", "logPartitionInterval 仅支持 daily 或 monthly")
+	}
+	switch strings.TrimSpace(cfg.System.LogInsertMode) {
+	case "", LogInsertModeInsert:
+	case LogInsertModeCopy:
+		if driver == "sqlite" {
+			addError("system.logInsertMode", "logInsertMode=copy 仅支持 PostgreSQL")
+		}
+	default:
+		addError("system.logInsertMode", "logInsertMode 仅支持 insert 或 copy")
+	}
+	if cfg.System.LeaderElection && driver == "sqlite" {
+		addError("system.leaderElection", "leaderElection（多实例选主）仅支持 PostgreSQL")
+	}
+	switch strings.TrimSpace(cfg.System.UVMode) {
+	case "", UVModeExact, UVModeApprox:
+	default:
+		addError("system.uvMode", "uvMode 仅支持 exact 或 approx")
+	}
+	if cfg.Archive != nil && cfg.Archive.Enabled {
+		switch strings.TrimSpace(cfg.Archive.Format) {
+		case "", ArchiveFormatNDJSON:
+		default:
+			addError("archive.format", "archive.format 目前仅支持 ndjson")
+		}
+		if s3 := cfg.Archive.S3; s3 != nil {
+			if strings.TrimSpace(s3.Bucket) == "" {
+				addError("archive.s3.bucket", "archive.s3.bucket 不能为空")
+			}
+			if (strings.TrimSpace(s3.AccessKey) == "") != (strings.TrimSpace(s3.SecretKey) == "") {
+				addError("archive.s3.accessKey", "archive.s3 accessKey/secretKey 需同时配置")
+			}
+		}
+	}
 	if cfg.System.ParseBatchSize <= 0 {
 		addError("system.parseBatchSize", "parseBatchSize 必须大于 0")
 	}
 	if cfg.System.IPGeoCacheLimit <= 0 {
 		addError("system.ipGeoCacheLimit", "ipGeoCacheLimit 必须大于 0")
 	}
+	if cfg.System.LogImportMaxMB < 0 {
+		addError("system.logImportMaxMB", "logImportMaxMB 不能小于 0")
+	}
 	if strings.TrimSpace(cfg.System.HTTPSourceTimeout) != "" {
 		timeout, err := time.ParseDuration(strings.TrimSpace(cfg.System.HTTPSourceTimeout))
 		if err != nil {
@@ -200,10 +323,49 @@ func ValidateConfig(cfg *Config, opts ValidateOptions) ValidationResult {
 	if len(cfg.PVFilter.ExcludePatterns) == 0 {
 		addError("pvFilter.excludePatterns", "excludePatterns 不能为空")
 	}
+	validatePVFilter("pvFilter", cfg.PVFilter, false, addError)
 
+	attachErrorFiles(cfg, result.Errors)
+	attachErrorFiles(cfg, result.Warnings)
 	return result
 }
 
+var websiteFieldPattern = regexp.MustCompile(`^websites\[(\d+)\]`)
+
+// attachErrorFiles 为错误标注所在文件：sites.d 中的站点标注其站点文件，其余标注主配置文件
+func attachErrorFiles(cfg *Config, errs []FieldError) {
+	for i := range errs {
+		errs[i].File = cfg.configFile
+		match := websiteFieldPattern.FindStringSubmatch(errs[i].Field)
+		if match == nil {
+			continue
+		}
+		index, _ := strconv.Atoi(match[1])
+		if file := cfg.WebsiteFile(index); file != "" {
+			errs[i].File = file
+		}
+	}
+}
+
+// validatePVFilter 校验状态码与排除正则；站点级覆盖中未配置的字段（nil）沿用全局，不做校验
+func validatePVFilter(prefix string, filter PVFilterConfig, override bool, addError func(field, msg string)) {
+	if override && filter.StatusCodeInclude != nil && len(filter.StatusCodeInclude) == 0 {
+		addError(prefix+".statusCodeInclude", "statusCodeInclude 不能为空")
+	}
+	for _, code := range filter.StatusCodeInclude {
+		if code < 100 || code > 599 {
+			addError(prefix+".statusCodeInclude", fmt.Sprintf("状态码无效: %d", code))
+			break
+		}
+	}
+	for _, pattern := range filter.ExcludePatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			addError(prefix+".excludePatterns", fmt.Sprintf("排除规则不是合法的正则表达式: %s", pattern))
+			break
+		}
+	}
+}
+
 func validateWhitelistIP(value string) error {
 	trimmed := strings.TrimSpace(value)
 	if trimmed == "" {
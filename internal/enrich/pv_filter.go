@@ -4,48 +4,86 @@ import (
 	"net"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/likaia/nginxpulse/internal/config"
 )
 
-var (
-	// 全局过滤规则编译后的正则表达式
+// PVFilter 为编译后的 PV 过滤规则
+type PVFilter struct {
 	excludePatterns []*regexp.Regexp
 	excludeIPs      map[string]bool
 	statusCodes     map[int]bool
 	excludePrivate  bool
+}
+
+var (
+	// 全局过滤规则，以及配置了 pvFilter 覆盖的站点各自的过滤规则
+	pvFilterMu       sync.RWMutex
+	defaultPVFilter  = &PVFilter{}
+	websitePVFilters = map[string]*PVFilter{}
 )
 
-// InitPVFilters 初始化PV过滤规则
-func InitPVFilters() {
-	cfg := config.ReadConfig()
+// NewPVFilter 根据配置编译 PV 过滤规则
+func NewPVFilter(cfg config.PVFilterConfig) *PVFilter {
+	filter := &PVFilter{}
 
 	// 初始化状态码过滤
-	statusCodes = make(map[int]bool)
-	for _, code := range cfg.PVFilter.StatusCodeInclude {
-		statusCodes[code] = true
+	filter.statusCodes = make(map[int]bool)
+	for _, code := range cfg.StatusCodeInclude {
+		filter.statusCodes[code] = true
 	}
 
 	// 初始化正则表达式过滤
-	excludePatterns = make([]*regexp.Regexp, len(cfg.PVFilter.ExcludePatterns))
-	for i, pattern := range cfg.PVFilter.ExcludePatterns {
-		excludePatterns[i] = regexp.MustCompile(pattern)
+	filter.excludePatterns = make([]*regexp.Regexp, len(cfg.ExcludePatterns))
+	for i, pattern := range cfg.ExcludePatterns {
+		filter.excludePatterns[i] = regexp.MustCompile(pattern)
 	}
 
 	// 初始化IP过滤
-	excludeIPs = make(map[string]bool)
-	for _, ip := range cfg.PVFilter.ExcludeIPs {
+	filter.excludeIPs = make(map[string]bool)
+	for _, ip := range cfg.ExcludeIPs {
 		normalized := normalizeIP(ip)
 		if normalized == "" {
 			continue
 		}
-		excludeIPs[normalized] = true
+		filter.excludeIPs[normalized] = true
+	}
+
+	filter.excludePrivate = true
+	if cfg.ExcludeIPs != nil && len(cfg.ExcludeIPs) == 0 {
+		filter.excludePrivate = false
+	}
+	return filter
+}
+
+// InitPVFilters 初始化PV过滤规则
+func InitPVFilters() {
+	cfg := config.ReadConfig()
+
+	filters := make(map[string]*PVFilter)
+	for _, id := range config.GetAllWebsiteIDs() {
+		website, ok := config.GetWebsiteByID(id)
+		if !ok || website.PVFilter == nil {
+			continue
+		}
+		filters[id] = NewPVFilter(website.EffectivePVFilter(cfg.PVFilter))
 	}
 
-	excludePrivate = true
-	if cfg.PVFilter.ExcludeIPs != nil && len(cfg.PVFilter.ExcludeIPs) == 0 {
-		excludePrivate = false
+	pvFilterMu.Lock()
+	defaultPVFilter = NewPVFilter(cfg.PVFilter)
+	websitePVFilters = filters
+	pvFilterMu.Unlock()
+}
+
+// PVFilterForWebsite 返回站点生效的 PV 过滤规则，未配置覆盖时返回全局规则
+func PVFilterForWebsite(websiteID string) *PVFilter {
+	pvFilterMu.RLock()
+	defer pvFilterMu.RUnlock()
+	if filter, ok := websitePVFilters[websiteID]; ok {
+		return filter
 	}
+	return defaultPVFilter
 }
 
 // normalizeIP extracts a usable IP string from log tokens
@@ -98,27 +136,37 @@ func normalizeIP(raw string) string {
 	return candidate
 }
 
-// ShouldCountAsPageView 判断是否符合 PV 过滤条件
+// ShouldCountAsPageView 按全局规则判断是否符合 PV 过滤条件
 func ShouldCountAsPageView(statusCode int, path string, ip string) int {
+	return PVFilterForWebsite("").ShouldCountAsPageView(statusCode, path, ip)
+}
+
+// ShouldCountAsPageViewForWebsite 按站点生效的规则判断是否符合 PV 过滤条件
+func ShouldCountAsPageViewForWebsite(websiteID string, statusCode int, path string, ip string) int {
+	return PVFilterForWebsite(websiteID).ShouldCountAsPageView(statusCode, path, ip)
+}
+
+// ShouldCountAsPageView 判断是否符合 PV 过滤条件
+func (f *PVFilter) ShouldCountAsPageView(statusCode int, path string, ip string) int {
 	// 检查状态码
-	if !statusCodes[statusCode] {
+	if !f.statusCodes[statusCode] {
 		return 0
 	}
 
 	normalizedIP := normalizeIP(ip)
 
 	// 过滤内网/保留地址
-	if excludePrivate && isPrivateIP(net.ParseIP(normalizedIP)) {
+	if f.excludePrivate && isPrivateIP(net.ParseIP(normalizedIP)) {
 		return 0
 	}
 
 	// 检查排除 IP 列表
-	if normalizedIP != "" && excludeIPs[normalizedIP] {
+	if normalizedIP != "" && f.excludeIPs[normalizedIP] {
 		return 0
 	}
 
-	// 检查是否匹配全局排除模式
-	for _, pattern := range excludePatterns {
+	// 检查是否匹配排除模式
+	for _, pattern := range f.excludePatterns {
 		if pattern.MatchString(path) {
 			return 0
 		}
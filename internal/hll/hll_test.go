@@ -0,0 +1,65 @@
+package hll
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimateAccuracy(t *testing.T) {
+	t.Parallel()
+
+	for _, n := range []int{0, 1, 10, 1000, 5000, 100000} {
+		sketch := New()
+		for i := 0; i < n; i++ {
+			sketch.AddID(int64(i + 1))
+			sketch.AddID(int64(i + 1))
+		}
+		got := float64(sketch.Estimate())
+		if n == 0 {
+			if got != 0 {
+				t.Fatalf("empty sketch estimate = %v", got)
+			}
+			continue
+		}
+		if diff := math.Abs(got-float64(n)) / float64(n); diff > 0.05 {
+			t.Fatalf("n=%d estimate=%v error=%.3f", n, got, diff)
+		}
+	}
+}
+
+func TestMergeAndRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	left, right, all := New(), New(), New()
+	for i := int64(1); i <= 3000; i++ {
+		all.AddID(i)
+		if i <= 2000 {
+			left.AddID(i)
+		}
+		if i > 1000 {
+			right.AddID(i)
+		}
+	}
+	left.Merge(right)
+	if left.Estimate() != all.Estimate() {
+		t.Fatalf("merged estimate %d, want %d", left.Estimate(), all.Estimate())
+	}
+
+	for _, sketch := range []*Sketch{New(), right, all} {
+		data, err := sketch.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Estimate() != sketch.Estimate() {
+			t.Fatalf("round trip estimate %d, want %d", decoded.Estimate(), sketch.Estimate())
+		}
+	}
+
+	if _, err := Unmarshal([]byte{formatSparse, 0xff, 0xff, 1}); err == nil {
+		t.Fatal("expected error for out of range index")
+	}
+}
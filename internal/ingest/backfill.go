@@ -3,6 +3,7 @@ package ingest
 import (
 	"bufio"
 	"compress/gzip"
+	"context"
 	"errors"
 	"io"
 	"os"
@@ -56,7 +57,8 @@ func (b *backfillBudget) consume(bytes int64) {
 	}
 }
 
-func (p *LogParser) BackfillHistory(maxDuration time.Duration, maxBytes int64) BackfillResult {
+// BackfillHistory 在预算内回填最近窗口之前的历史日志；ctx 取消时提交已解析的部分后停止，下次继续
+func (p *LogParser) BackfillHistory(ctx context.Context, maxDuration time.Duration, maxBytes int64) BackfillResult {
 	result := BackfillResult{}
 	if p.demoMode {
 		return result
@@ -73,13 +75,8 @@ func (p *LogParser) BackfillHistory(maxDuration time.Duration, maxBytes int64) B
 	websiteIDs := config.GetAllWebsiteIDs()
 
 	for _, websiteID := range websiteIDs {
-		state, ok := p.states[websiteID]
-		if !ok || state.Files == nil {
-			continue
-		}
-
-		for filePath, fileState := range state.Files {
-			if budget.exhausted() {
+		for filePath, fileState := range p.fileStates(websiteID) {
+			if budget.exhausted() || ctx.Err() != nil {
 				break
 			}
 			if fileState.BackfillDone {
@@ -87,8 +84,11 @@ func (p *LogParser) BackfillHistory(maxDuration time.Duration, maxBytes int64) B
 			}
 
 			if isGzipFile(filePath) {
-				processed, entries, err := p.backfillGzipFile(websiteID, filePath, &fileState, budget)
-				if err != nil {
+				processed, entries, err := p.backfillGzipFile(ctx, websiteID, filePath, &fileState, budget)
+				if err != nil && ctx.Err() != nil {
+					result.ProcessedBytes += processed
+					result.ProcessedEntries += entries
+				} else if err != nil {
 					logrus.Warnf("回填 gzip 日志文件 %s 失败: %v", filePath, err)
 					p.notifyFileIO(websiteID, filePath, "回填 gzip 日志文件", err)
 				} else {
@@ -100,7 +100,7 @@ func (p *LogParser) BackfillHistory(maxDuration time.Duration, maxBytes int64) B
 				continue
 			}
 
-			processed, entries, err := p.backfillPlainFile(websiteID, filePath, &fileState, budget)
+			processed, entries, err := p.backfillPlainFile(ctx, websiteID, filePath, &fileState, budget)
 			if err != nil {
 				if !errors.Is(err, io.EOF) {
 					logrus.Warnf("回填日志文件 %s 失败: %v", filePath, err)
@@ -116,7 +116,7 @@ func (p *LogParser) BackfillHistory(maxDuration time.Duration, maxBytes int64) B
 		}
 
 		p.refreshWebsiteRanges(websiteID)
-		if budget.exhausted() {
+		if budget.exhausted() || ctx.Err() != nil {
 			break
 		}
 	}
@@ -126,6 +126,7 @@ func (p *LogParser) BackfillHistory(maxDuration time.Duration, maxBytes int64) B
 }
 
 func (p *LogParser) backfillPlainFile(
+	ctx context.Context,
 	websiteID, filePath string,
 	state *FileState,
 	budget *backfillBudget,
@@ -151,42 +152,58 @@ func (p *LogParser) backfillPlainFile(
 	}
 	window := parseWindow{maxTs: cutoffTs}
 
-	batch := make([]store.NginxLogRecord, 0, p.parseBatchSize)
-	processBatch := func() {
+	var (
+		bytesRead      int64
+		committedBytes int64
+		entryCount     int
+		minTs          int64
+		maxTs          int64
+	)
+
+	// 回填位置与日志批次在同一个事务内提交；写入失败时停在最后提交的批次之后，下次从该位置继续
+	batchSize := p.batchSize()
+	batch := make([]store.NginxLogRecord, 0, batchSize)
+	processBatch := func() error {
 		if len(batch) == 0 {
-			return
+			committedBytes = bytesRead
+			return nil
 		}
+		if !p.IsLeader() {
+			return ErrNotLeader
+		}
+		checkpoint := *state
+		checkpoint.BackfillOffset += bytesRead
+		p.updateParsedRange(&checkpoint, minTs, maxTs)
 		// 先标记 location 为“待解析”，再在成功落库后写入 ip_geo_pending（避免竞态导致“待解析”长期不变）
 		p.markBatchIPGeoPending(batch)
-		if err := p.repo.BatchInsertLogsForWebsite(websiteID, batch); err != nil {
+		if err := p.repo.BatchInsertLogsWithScanState(websiteID, batch, []store.ScanStateRow{
+			fileStateRow(websiteID, filePath, checkpoint),
+		}); err != nil {
 			logrus.Errorf("批量插入网站 %s 的日志记录失败: %v", websiteID, err)
 			p.notifyDatabaseWrite(websiteID, "回填写入日志批次", err)
-		} else {
-			p.enqueueBatchIPGeo(batch)
+			return err
 		}
+		p.enqueueBatchIPGeo(batch)
+		entryCount += len(batch)
+		committedBytes = bytesRead
 		batch = batch[:0]
+		return nil
 	}
 
-	var (
-		bytesRead  int64
-		entryCount int
-		minTs      int64
-		maxTs      int64
-	)
-
 	for {
-		if budget.exhausted() {
+		if budget.exhausted() || ctx.Err() != nil {
 			break
 		}
 		line, err := bufReader.ReadString('\n')
 		if len(line) == 0 && err != nil {
-			if err == io.EOF {
+			if writeErr := processBatch(); writeErr != nil {
+				err = writeErr
+			} else if err == io.EOF {
 				state.BackfillDone = true
 			}
-			processBatch()
-			state.BackfillOffset += bytesRead
+			state.BackfillOffset += committedBytes
 			p.updateParsedRange(state, minTs, maxTs)
-			return bytesRead, entryCount, err
+			return committedBytes, entryCount, err
 		}
 		bytesRead += int64(len(line))
 		budget.consume(int64(len(line)))
@@ -220,10 +237,12 @@ func (p *LogParser) backfillPlainFile(
 		if ts > maxTs {
 			maxTs = ts
 		}
-		entryCount++
 
-		if len(batch) >= p.parseBatchSize {
-			processBatch()
+		if len(batch) >= batchSize {
+			if writeErr := processBatch(); writeErr != nil {
+				state.BackfillOffset += committedBytes
+				return committedBytes, entryCount, writeErr
+			}
 		}
 
 		if err != nil {
@@ -234,16 +253,20 @@ func (p *LogParser) backfillPlainFile(
 		}
 	}
 
-	processBatch()
-	state.BackfillOffset += bytesRead
+	if writeErr := processBatch(); writeErr != nil {
+		state.BackfillOffset += committedBytes
+		return committedBytes, entryCount, writeErr
+	}
+	state.BackfillOffset += committedBytes
 	if state.BackfillOffset >= state.BackfillEnd {
 		state.BackfillDone = true
 	}
 	p.updateParsedRange(state, minTs, maxTs)
-	return bytesRead, entryCount, nil
+	return committedBytes, entryCount, nil
 }
 
 func (p *LogParser) backfillGzipFile(
+	ctx context.Context,
 	websiteID, filePath string,
 	state *FileState,
 	budget *backfillBudget,
@@ -278,14 +301,29 @@ func (p *LogParser) backfillGzipFile(
 	}
 	window := parseWindow{maxTs: cutoffTs}
 
+	// gzip 无法按位置读取，BackfillOffset 记录解压后已写入的字节数，中断后跳过这部分继续回填
+	skipped := state.BackfillOffset
+	if skipped > 0 {
+		if err := skipReaderBytes(gzReader, skipped); err != nil {
+			return 0, 0, err
+		}
+	}
+	checkpoint := func(bytes, minTs, maxTs int64) []store.ScanStateRow {
+		partial := *state
+		partial.BackfillOffset = skipped + bytes
+		p.updateParsedRange(&partial, minTs, maxTs)
+		return []store.ScanStateRow{fileStateRow(websiteID, filePath, partial)}
+	}
+
 	parserResult := EmptyParserResult("", "")
-	entriesCount, bytesRead, minTs, maxTs := p.parseLogLines(gzReader, websiteID, "", &parserResult, window)
+	entriesCount, bytesRead, minTs, maxTs, err := p.parseLogLines(ctx, gzReader, websiteID, "", &parserResult, window, checkpoint)
 	budget.consume(bytesRead)
-	state.BackfillDone = true
+	state.BackfillOffset = skipped + bytesRead
+	state.BackfillDone = err == nil
 	p.updateParsedRange(state, minTs, maxTs)
 	if maxTs > state.LastTimestamp {
 		state.LastTimestamp = maxTs
 	}
 
-	return bytesRead, entriesCount, nil
+	return bytesRead, entriesCount, err
 }
@@ -0,0 +1,48 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/enrich"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// ReloadConfig 热加载配置：新配置校验通过并为新增站点建表后才原子替换，
+// 随后重建由站点配置生成的行解析器、白名单匹配器与 PV 过滤规则。正在进行的扫描与回填不会中断，
+// 从下一个文件或批次开始使用新配置；已移除站点的数据保留在数据库中，不再扫描。
+func (p *LogParser) ReloadConfig() (config.ReloadResult, error) {
+	result, err := config.ReloadConfig(func(next *config.Config, result config.ReloadResult) error {
+		// 新增站点已有日志（如重新加入的站点）时回填迁移不在此执行，站点暂停写入，见 store.Repository.MigrationPending
+		err := p.repo.InitWebsites(result.Added, false)
+		if errors.Is(err, store.ErrMigrationRequired) {
+			logrus.Warn(err.Error())
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("初始化新增站点数据表失败: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	matchers := buildWhitelistMatchers()
+	p.cacheMu.Lock()
+	p.lineParsers = make(map[string]*logLineParser)
+	p.whitelistMatchers = matchers
+	p.cacheMu.Unlock()
+	enrich.InitPVFilters()
+	if config.ReadConfig().System.AutoMigrate {
+		go p.repo.RunPendingMigrations()
+	}
+
+	logrus.Infof("配置已重新加载：新增站点 %v，移除站点 %v", result.Added, result.Removed)
+	if result.RestartRequired() {
+		logrus.Warnf("以下配置项需要重启服务才能生效: %v", result.RestartFields)
+	}
+	return result, nil
+}
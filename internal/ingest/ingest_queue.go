@@ -0,0 +1,158 @@
+package ingest
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultIngestQueueSize = 64
+	defaultIngestWorkers   = 2
+)
+
+// ErrIngestQueueFull 表示站点的推送队列已满，调用方应稍后重试。
+var ErrIngestQueueFull = errors.New("ingest queue full")
+
+// IngestResult 为一次推送批次的处理结果。
+type IngestResult struct {
+	Accepted int
+	Deduped  int
+	Err      error
+}
+
+type ingestTask struct {
+	websiteID   string
+	sourceID    string
+	lines       []string
+	sampleRates []int
+	done        chan IngestResult
+}
+
+// IngestQueue 为推送日志提供按站点隔离的有界队列：每个站点固定数量的 worker 串行消费，
+// 队列满时直接拒绝，避免突发推送堆积大量 goroutine 与数据库事务。
+type IngestQueue struct {
+	parser  *LogParser
+	size    int
+	workers int
+
+	mu     sync.Mutex
+	queues map[string]chan ingestTask
+}
+
+// NewIngestQueue 创建推送队列，队列长度与 worker 数取自 system 配置。
+func NewIngestQueue(parser *LogParser) *IngestQueue {
+	cfg := config.ReadConfig()
+	size := cfg.System.IngestQueueSize
+	if size <= 0 {
+		size = defaultIngestQueueSize
+	}
+	workers := cfg.System.IngestWorkers
+	if workers <= 0 {
+		workers = defaultIngestWorkers
+	}
+	q := &IngestQueue{
+		parser:  parser,
+		size:    size,
+		workers: workers,
+		queues:  make(map[string]chan ingestTask),
+	}
+	go q.watchConfig()
+	return q
+}
+
+// Submit 将批次放入站点队列，返回的 channel 会在处理完成后收到结果。
+// 队列已满时返回 ErrIngestQueueFull，不会阻塞。
+func (q *IngestQueue) Submit(websiteID, sourceID string, lines []string, sampleRates []int) (<-chan IngestResult, error) {
+	task := ingestTask{
+		websiteID:   websiteID,
+		sourceID:    sourceID,
+		lines:       lines,
+		sampleRates: sampleRates,
+		done:        make(chan IngestResult, 1),
+	}
+	// 持锁投递，避免与 removeQueue 关闭 channel 并发
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.queueForLocked(websiteID) <- task:
+		return task.done, nil
+	default:
+		return nil, ErrIngestQueueFull
+	}
+}
+
+// SubmitWait 与 Submit 相同，但队列已满时按 interval 重试，直到投递成功或 stop 返回 true。
+// 用于后台导入等可以等待的场景，仍与推送共用站点队列与 worker。
+func (q *IngestQueue) SubmitWait(
+	websiteID, sourceID string,
+	lines []string,
+	interval time.Duration,
+	stop func() bool,
+) (<-chan IngestResult, error) {
+	for {
+		done, err := q.Submit(websiteID, sourceID, lines, nil)
+		if !errors.Is(err, ErrIngestQueueFull) {
+			return done, err
+		}
+		if stop != nil && stop() {
+			return nil, err
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (q *IngestQueue) queueForLocked(websiteID string) chan ingestTask {
+	queue, ok := q.queues[websiteID]
+	if ok {
+		return queue
+	}
+	queue = make(chan ingestTask, q.size)
+	q.queues[websiteID] = queue
+	for i := 0; i < q.workers; i++ {
+		go q.work(queue)
+	}
+	logrus.WithFields(logrus.Fields{
+		"website": websiteID,
+		"size":    q.size,
+		"workers": q.workers,
+	}).Info("推送队列已创建")
+	return queue
+}
+
+func (q *IngestQueue) work(queue chan ingestTask) {
+	for task := range queue {
+		accepted, deduped, err := q.parser.IngestSampledLines(task.websiteID, task.sourceID, task.lines, task.sampleRates)
+		task.done <- IngestResult{Accepted: accepted, Deduped: deduped, Err: err}
+	}
+}
+
+// watchConfig 在配置热加载后停止已移除站点的队列与 worker。
+func (q *IngestQueue) watchConfig() {
+	changed := config.Changed()
+	for {
+		<-changed
+		changed = config.Changed()
+		q.mu.Lock()
+		for websiteID := range q.queues {
+			if _, ok := config.GetWebsiteByID(websiteID); !ok {
+				q.removeQueueLocked(websiteID)
+			}
+		}
+		q.mu.Unlock()
+	}
+}
+
+// removeQueueLocked 关闭站点队列，worker 处理完已排队的批次后退出。
+func (q *IngestQueue) removeQueueLocked(websiteID string) {
+	queue, ok := q.queues[websiteID]
+	if !ok {
+		return
+	}
+	delete(q.queues, websiteID)
+	close(queue)
+	logrus.WithField("website", websiteID).Info("站点已移除，推送队列已关闭")
+}
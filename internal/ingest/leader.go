@@ -0,0 +1,60 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/likaia/nginxpulse/internal/cluster"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotLeader 表示开启多实例选主时，当前实例不是主节点，不能执行会改写扫描状态或批量改写数据的操作
+var ErrNotLeader = errors.New("当前实例不是主节点，请稍后重试或在主节点上执行")
+
+// SetLeader 设置选主器；未设置时视为单实例部署，总是主节点
+func (p *LogParser) SetLeader(leader *cluster.Leader) {
+	p.leader = leader
+}
+
+// IsLeader 返回当前实例是否负责执行定时扫描、回填与 IP 归属地解析
+func (p *LogParser) IsLeader() bool {
+	return p.leader.IsLeader()
+}
+
+// LeaderContext 派生一个在当前实例失去主节点身份时取消的上下文，定时扫描与回填据此在批次边界停止
+func (p *LogParser) LeaderContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return p.leader.Context(parent)
+}
+
+// RequireLeader 在当前实例不是主节点时返回 ErrNotLeader（附带已知的主节点 ID）
+func (p *LogParser) RequireLeader() error {
+	if p.leader.IsLeader() {
+		return nil
+	}
+	if holder := p.leader.Holder(); holder != "" {
+		return fmt.Errorf("%w（主节点: %s）", ErrNotLeader, holder)
+	}
+	return ErrNotLeader
+}
+
+// syncStateFromDB 在开启选主时由主节点在每轮扫描前调用：先写入本地未保存的变化，再从数据库重新读取扫描状态，
+// 以接管之前的主节点与其他实例（接收推送日志）写入的进度。写入失败时保留内存中的状态，避免丢失本地进度。
+func (p *LogParser) syncStateFromDB() {
+	if !p.leader.Enabled() {
+		return
+	}
+	p.updateState()
+	p.stateMu.Lock()
+	pending := len(p.dirtyStates) > 0
+	p.stateMu.Unlock()
+	if pending {
+		return
+	}
+	rows, err := p.repo.LoadScanState()
+	if err != nil {
+		logrus.WithError(err).Warn("重新读取扫描状态失败，沿用内存中的状态")
+		return
+	}
+	p.applyScanStates(rows)
+}
@@ -1,8 +1,9 @@
 package ingest
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -10,6 +11,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/likaia/nginxpulse/internal/archive"
+	"github.com/likaia/nginxpulse/internal/cluster"
 	"github.com/likaia/nginxpulse/internal/config"
 	"github.com/likaia/nginxpulse/internal/enrich"
 	"github.com/likaia/nginxpulse/internal/ingest/dedup"
@@ -40,6 +43,8 @@ const (
 	parseTypeCaddyJSON = "caddy_json"
 )
 
+const scanStateFileName = "nginx_scan_state.json"
+
 const (
 	recentLogWindowDays   = 7
 	recentScanChunkSize   = 256 * 1024
@@ -143,55 +148,45 @@ type logLineParser struct {
 	timeLayout string
 	source     string
 	parseType  string
+	websiteID  string // 用于站点级的保留天数与 PV 过滤规则
 }
 
 type LogParser struct {
 	repo              *store.Repository
-	statePath         string
 	states            map[string]LogScanState // 各网站的扫描状态，以网站ID为键
+	dirtyStates       map[scanStateKey]struct{}
+	stateMu           sync.Mutex // 保护 states 与 dirtyStates，扫描协程与推送日志的队列 worker 会并发更新扫描状态
 	demoMode          bool
-	retentionDays     int
-	parseBatchSize    int
 	ipGeoCacheLimit   int
 	lineParsers       map[string]*logLineParser // key: websiteID or websiteID:sourceID
 	dedup             *dedup.Cache
 	whitelistMatchers map[string]*enrich.WhitelistMatcher
+	cacheMu           sync.Mutex      // 保护 lineParsers 与 whitelistMatchers，配置热加载时整体替换
+	leader            *cluster.Leader // 为 nil 时视为单实例部署
+	// ctx 为解析器的生命周期，Close 时取消，用于不属于某次请求的后台解析（如重新解析）
+	ctx  context.Context
+	stop context.CancelFunc
 }
 
 // NewLogParser 创建新的日志解析器
 func NewLogParser(userRepoPtr *store.Repository) *LogParser {
-	statePath := filepath.Join(config.DataDir, "nginx_scan_state.json")
 	cfg := config.ReadConfig()
-	retentionDays := cfg.System.LogRetentionDays
-	if retentionDays <= 0 {
-		retentionDays = 30
-	}
-	parseBatchSize := cfg.System.ParseBatchSize
-	if parseBatchSize <= 0 {
-		parseBatchSize = defaultParseBatchSize
-	}
 	ipGeoCacheLimit := cfg.System.IPGeoCacheLimit
 	if ipGeoCacheLimit <= 0 {
 		ipGeoCacheLimit = 1000000
 	}
+	ctx, stop := context.WithCancel(context.Background())
 	parser := &LogParser{
+		ctx:               ctx,
+		stop:              stop,
 		repo:              userRepoPtr,
-		statePath:         statePath,
 		states:            make(map[string]LogScanState),
+		dirtyStates:       make(map[scanStateKey]struct{}),
 		demoMode:          cfg.System.DemoMode,
-		retentionDays:     retentionDays,
-		parseBatchSize:    parseBatchSize,
 		ipGeoCacheLimit:   ipGeoCacheLimit,
 		lineParsers:       make(map[string]*logLineParser),
 		dedup:             dedup.NewCache(100000, 10*time.Minute),
-		whitelistMatchers: make(map[string]*enrich.WhitelistMatcher),
-	}
-	for _, websiteID := range config.GetAllWebsiteIDs() {
-		if site, ok := config.GetWebsiteByID(websiteID); ok {
-			if matcher := enrich.NewWhitelistMatcher(site.Whitelist); matcher != nil {
-				parser.whitelistMatchers[websiteID] = matcher
-			}
-		}
+		whitelistMatchers: buildWhitelistMatchers(),
 	}
 	parser.loadState()
 	parser.resetStateIfEmptyDB()
@@ -199,67 +194,102 @@ func NewLogParser(userRepoPtr *store.Repository) *LogParser {
 	return parser
 }
 
-// loadState 加载上次扫描状态
+// Close 在服务关闭时调用：取消后台解析，等待正在进行的扫描在批次边界停止（最多 timeout），
+// 然后写入内存中尚未保存的扫描状态。返回后解析锁保持占用，不会再开始新的解析。
+func (p *LogParser) Close(timeout time.Duration) {
+	p.stop()
+	deadline := time.Now().Add(timeout)
+	for !startBackfillParsing() {
+		if time.Now().After(deadline) {
+			logrus.Warnf("等待日志解析停止超时（%s），跳过保存扫描状态，下次启动从最后提交的批次继续", timeout)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	p.updateState()
+}
+
+// retentionDaysFor 返回站点的原始日志保留天数（站点 retentionDays 优先于全局配置），
+// 每次读取当前配置，与日志清理使用同一口径，热加载后立即生效
+func (p *LogParser) retentionDaysFor(websiteID string) int {
+	return config.GetRetentionPolicyForWebsite(websiteID).RawDays
+}
+
+// batchSize 返回当前配置的每批写入条数，热加载后从下一次读取文件开始生效
+func (p *LogParser) batchSize() int {
+	if size := config.ReadConfig().System.ParseBatchSize; size > 0 {
+		return size
+	}
+	return defaultParseBatchSize
+}
+
+// loadState 从数据库加载上次扫描状态，首次启动新版本时先导入旧版的状态文件
 func (p *LogParser) loadState() {
-	data, err := os.ReadFile(p.statePath)
-	if os.IsNotExist(err) {
-		// 状态文件不存在，创建空状态映射
-		p.states = make(map[string]LogScanState)
-		return
+	if err := migrateScanStateFile(p.repo); err != nil {
+		statePath := filepath.Join(config.DataDir, scanStateFileName)
+		logrus.Errorf("导入扫描状态文件失败: %v", err)
+		p.notifyFileIO("", statePath, "导入扫描状态文件", err)
 	}
 
+	rows, err := p.repo.LoadScanState()
 	if err != nil {
-		logrus.Errorf("无法读取扫描状态文件: %v", err)
-		p.notifyFileIO("", p.statePath, "读取扫描状态文件", err)
-		p.states = make(map[string]LogScanState)
+		logrus.Errorf("读取扫描状态失败: %v", err)
+		p.notifyDatabaseWrite("", "读取扫描状态", err)
 		return
 	}
+	p.applyScanStates(rows)
+}
 
-	if err := json.Unmarshal(data, &p.states); err != nil {
-		logrus.Errorf("解析扫描状态失败: %v", err)
-		p.notifyFileIO("", p.statePath, "解析扫描状态文件", err)
-		p.states = make(map[string]LogScanState)
+// applyScanStates 用数据库中的记录替换内存中的扫描状态
+func (p *LogParser) applyScanStates(rows []store.ScanStateRow) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.states = make(map[string]LogScanState)
+	for websiteID, state := range buildScanStates(rows) {
+		p.setWebsiteStateLocked(websiteID, state)
 	}
+	p.dirtyStates = make(map[scanStateKey]struct{})
+}
 
-	for websiteID, state := range p.states {
-		if state.Files == nil {
-			state.Files = make(map[string]FileState)
-		}
-		normalizedFiles := make(map[string]FileState, len(state.Files))
-		for path, fileState := range state.Files {
-			normalizedFiles[normalizeLogPath(path)] = fileState
-		}
-		state.Files = normalizedFiles
-		if state.Targets == nil {
-			state.Targets = make(map[string]TargetState)
-		}
-		if state.ParsedHourBuckets == nil {
-			state.ParsedHourBuckets = make(map[int64]bool)
-		}
-		p.states[websiteID] = state
-		p.refreshWebsiteRanges(websiteID)
+// setWebsiteState 补齐从数据库或备份读入的扫描状态（空集合、日志路径规范化）后设为站点的当前状态
+func (p *LogParser) setWebsiteState(websiteID string, state LogScanState) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.setWebsiteStateLocked(websiteID, state)
+}
+
+func (p *LogParser) setWebsiteStateLocked(websiteID string, state LogScanState) {
+	if state.Files == nil {
+		state.Files = make(map[string]FileState)
+	}
+	normalizedFiles := make(map[string]FileState, len(state.Files))
+	for path, fileState := range state.Files {
+		normalizedFiles[normalizeLogPath(path)] = fileState
+	}
+	state.Files = normalizedFiles
+	if state.Targets == nil {
+		state.Targets = make(map[string]TargetState)
+	}
+	if state.ParsedHourBuckets == nil {
+		state.ParsedHourBuckets = make(map[int64]bool)
 	}
+	p.states[websiteID] = state
+	p.refreshWebsiteRangesLocked(websiteID)
 }
 
-// updateState 更新并保存状态
+// updateState 把内存中有变化的扫描状态写入数据库，失败时保留标记，下次再写
 func (p *LogParser) updateState() {
-	data, err := json.Marshal(p.states)
-	if err != nil {
-		logrus.Errorf("保存扫描状态失败: %v", err)
-		p.notifyFileIO("", p.statePath, "序列化扫描状态文件", err)
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	if len(p.dirtyStates) == 0 {
 		return
 	}
-
-	tmpPath := p.statePath + ".tmp"
-	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+	if err := p.repo.SaveScanState(p.dirtyStateRows()); err != nil {
 		logrus.Errorf("保存扫描状态失败: %v", err)
-		p.notifyFileIO("", p.statePath, "写入扫描状态文件", err)
+		p.notifyDatabaseWrite("", "保存扫描状态", err)
 		return
 	}
-	if err := os.Rename(tmpPath, p.statePath); err != nil {
-		logrus.Errorf("保存扫描状态失败: %v", err)
-		p.notifyFileIO("", p.statePath, "保存扫描状态文件", err)
-	}
+	p.dirtyStates = make(map[scanStateKey]struct{})
 }
 
 func (p *LogParser) resetStateIfEmptyDB() {
@@ -273,6 +303,7 @@ func (p *LogParser) resetStateIfEmptyDB() {
 	}
 
 	hasState := false
+	p.stateMu.Lock()
 	for _, id := range websiteIDs {
 		state, ok := p.states[id]
 		if !ok {
@@ -284,6 +315,7 @@ func (p *LogParser) resetStateIfEmptyDB() {
 			break
 		}
 	}
+	p.stateMu.Unlock()
 	if !hasState {
 		return
 	}
@@ -303,6 +335,7 @@ func (p *LogParser) resetStateIfEmptyDB() {
 	p.ResetScanState("")
 }
 
+// ensureWebsiteState 返回站点状态并补齐空集合，调用方需持有 stateMu
 func (p *LogParser) ensureWebsiteState(websiteID string) LogScanState {
 	state, ok := p.states[websiteID]
 	if !ok {
@@ -324,6 +357,8 @@ func (p *LogParser) ensureWebsiteState(websiteID string) LogScanState {
 }
 
 func (p *LogParser) hasUnparsedWebsite(websiteIDs []string) bool {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 	for _, id := range websiteIDs {
 		state, ok := p.states[id]
 		if !ok || !state.InitialParsed {
@@ -334,15 +369,20 @@ func (p *LogParser) hasUnparsedWebsite(websiteIDs []string) bool {
 }
 
 func (p *LogParser) markInitialParsed(websiteID string) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 	state := p.ensureWebsiteState(websiteID)
 	if state.InitialParsed {
 		return
 	}
 	state.InitialParsed = true
 	p.states[websiteID] = state
+	p.markStateDirty(websiteID, scanStateKindSite, "")
 }
 
 func (p *LogParser) getFileState(websiteID, filePath string) (FileState, bool) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 	state, ok := p.states[websiteID]
 	if !ok || state.Files == nil {
 		return FileState{}, false
@@ -351,25 +391,48 @@ func (p *LogParser) getFileState(websiteID, filePath string) (FileState, bool) {
 	return fileState, ok
 }
 
+// fileStates 返回站点文件状态的副本，供遍历期间仍会更新状态的调用方使用
+func (p *LogParser) fileStates(websiteID string) map[string]FileState {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	state, ok := p.states[websiteID]
+	if !ok || state.Files == nil {
+		return nil
+	}
+	files := make(map[string]FileState, len(state.Files))
+	for path, fileState := range state.Files {
+		files[path] = fileState
+	}
+	return files
+}
+
 func (p *LogParser) setFileState(websiteID, filePath string, fileState FileState) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 	state := p.ensureWebsiteState(websiteID)
 	state.Files[normalizeLogPath(filePath)] = fileState
 	p.states[websiteID] = state
+	p.markStateDirty(websiteID, scanStateKindFile, normalizeLogPath(filePath))
 }
 
 func (p *LogParser) deleteFileState(websiteID, filePath string) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 	state, ok := p.states[websiteID]
 	if !ok || state.Files == nil {
 		return
 	}
 	delete(state.Files, normalizeLogPath(filePath))
 	p.states[websiteID] = state
+	p.markStateDirty(websiteID, scanStateKindFile, normalizeLogPath(filePath))
 }
 
 func (p *LogParser) recordParsedHourBuckets(websiteID string, buckets map[int64]struct{}) {
 	if len(buckets) == 0 {
 		return
 	}
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 	state := p.ensureWebsiteState(websiteID)
 	if state.ParsedHourBuckets == nil {
 		state.ParsedHourBuckets = make(map[int64]bool)
@@ -378,9 +441,12 @@ func (p *LogParser) recordParsedHourBuckets(websiteID string, buckets map[int64]
 		state.ParsedHourBuckets[bucket] = true
 	}
 	p.states[websiteID] = state
+	p.markStateDirty(websiteID, scanStateKindSite, "")
 }
 
 func (p *LogParser) getTargetState(websiteID, targetKey string) (TargetState, bool) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 	state, ok := p.states[websiteID]
 	if !ok || state.Targets == nil {
 		return TargetState{}, false
@@ -390,21 +456,33 @@ func (p *LogParser) getTargetState(websiteID, targetKey string) (TargetState, bo
 }
 
 func (p *LogParser) setTargetState(websiteID, targetKey string, targetState TargetState) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 	state := p.ensureWebsiteState(websiteID)
 	state.Targets[targetKey] = targetState
 	p.states[websiteID] = state
+	p.markStateDirty(websiteID, scanStateKindTarget, targetKey)
 }
 
 func (p *LogParser) deleteTargetState(websiteID, targetKey string) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 	state, ok := p.states[websiteID]
 	if !ok || state.Targets == nil {
 		return
 	}
 	delete(state.Targets, targetKey)
 	p.states[websiteID] = state
+	p.markStateDirty(websiteID, scanStateKindTarget, targetKey)
 }
 
 func (p *LogParser) refreshWebsiteRanges(websiteID string) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.refreshWebsiteRangesLocked(websiteID)
+}
+
+func (p *LogParser) refreshWebsiteRangesLocked(websiteID string) {
 	state, ok := p.states[websiteID]
 	if !ok || (state.Files == nil && state.Targets == nil) {
 		return
@@ -500,6 +578,7 @@ func (p *LogParser) refreshWebsiteRanges(websiteID string) {
 	state.RecentCutoffTs = recentCutoff
 	state.BackfillPending = backfillPending
 	p.states[websiteID] = state
+	p.markStateDirty(websiteID, scanStateKindSite, "")
 
 	UpdateWebsiteParseStatus(websiteID, WebsiteParseStatus{
 		LogMinTs:               logMin,
@@ -552,7 +631,17 @@ func (p *LogParser) CleanOldLogs() error {
 		return nil
 	}
 
-	err := p.repo.CleanOldLogs()
+	// 启用归档时先导出即将过期的原始日志，归档失败的站点本轮不删除
+	var archiver store.LogArchiver
+	if archive.Enabled() {
+		a, err := archive.New(p.repo)
+		if err != nil {
+			return fmt.Errorf("初始化日志归档失败: %w", err)
+		}
+		archiver = a.ArchiveBefore
+	}
+
+	err := p.repo.CleanOldLogs(archiver)
 	if err != nil {
 		return err
 	}
@@ -562,8 +651,8 @@ func (p *LogParser) CleanOldLogs() error {
 	return nil
 }
 
-// ScanNginxLogs 增量扫描Nginx日志文件
-func (p *LogParser) ScanNginxLogs() []ParserResult {
+// ScanNginxLogs 增量扫描Nginx日志文件；ctx 取消时提交已解析的批次后停止，下次从记录的位置继续
+func (p *LogParser) ScanNginxLogs(ctx context.Context) []ParserResult {
 	if p.demoMode {
 		return []ParserResult{}
 	}
@@ -577,11 +666,12 @@ func (p *LogParser) ScanNginxLogs() []ParserResult {
 	}
 	defer finishIPParsing()
 
-	return p.scanNginxLogsInternal(websiteIDs)
+	p.syncStateFromDB()
+	return p.scanNginxLogsInternal(ctx, websiteIDs)
 }
 
 // ScanNginxLogsForWebsite 扫描指定网站的日志文件
-func (p *LogParser) ScanNginxLogsForWebsite(websiteID string) []ParserResult {
+func (p *LogParser) ScanNginxLogsForWebsite(ctx context.Context, websiteID string) []ParserResult {
 	if p.demoMode {
 		return []ParserResult{}
 	}
@@ -594,23 +684,38 @@ func (p *LogParser) ScanNginxLogsForWebsite(websiteID string) []ParserResult {
 	}
 	defer finishIPParsing()
 
-	return p.scanNginxLogsInternal([]string{websiteID})
+	p.syncStateFromDB()
+	return p.scanNginxLogsInternal(ctx, []string{websiteID})
 }
 
 // ResetScanState 重置日志扫描状态
 func (p *LogParser) ResetScanState(websiteID string) {
+	p.stateMu.Lock()
 	if websiteID == "" {
 		p.states = make(map[string]LogScanState)
+		p.dirtyStates = make(map[scanStateKey]struct{})
 		ResetWebsiteParseStatus("")
 	} else {
 		delete(p.states, websiteID)
+		for key := range p.dirtyStates {
+			if key.websiteID == websiteID {
+				delete(p.dirtyStates, key)
+			}
+		}
 		ResetWebsiteParseStatus(websiteID)
 	}
-	p.updateState()
+	p.stateMu.Unlock()
+	if err := p.repo.ReplaceScanState(websiteID, nil); err != nil {
+		logrus.Errorf("清除扫描状态失败: %v", err)
+		p.notifyDatabaseWrite(websiteID, "清除扫描状态", err)
+	}
 }
 
 // TriggerReparse 清空指定网站的日志并触发重新解析
 func (p *LogParser) TriggerReparse(websiteID string) error {
+	if err := p.RequireLeader(); err != nil {
+		return err
+	}
 	if p.demoMode {
 		var err error
 		if websiteID == "" {
@@ -628,6 +733,7 @@ func (p *LogParser) TriggerReparse(websiteID string) error {
 	if !startIPParsingWithStage(parseStageReparse) {
 		return ErrParsingInProgress
 	}
+	p.syncStateFromDB()
 
 	var ids []string
 	if websiteID == "" {
@@ -651,24 +757,57 @@ func (p *LogParser) TriggerReparse(websiteID string) error {
 
 	go func() {
 		defer finishIPParsing()
-		p.scanNginxLogsInternal(ids)
+		p.scanNginxLogsInternal(p.ctx, ids)
 	}()
 
 	return nil
 }
 
-func (p *LogParser) scanNginxLogsInternal(websiteIDs []string) []ParserResult {
+// ReclassifyPageviews 按站点当前的 PV 过滤规则重新判定已入库日志，无需重新读取日志文件。
+// 执行期间占用解析锁，定时扫描会跳过，避免与重建聚合的过程交错写入。
+func (p *LogParser) ReclassifyPageviews(
+	websiteID string,
+	start, end time.Time,
+	progress func(store.ReclassifyProgress) bool,
+) (store.ReclassifyProgress, error) {
+	if err := p.RequireLeader(); err != nil {
+		return store.ReclassifyProgress{}, err
+	}
+	if !startIPParsingWithStage(parseStageReclassify) {
+		return store.ReclassifyProgress{}, ErrParsingInProgress
+	}
+	defer finishIPParsing()
+
+	filter := enrich.PVFilterForWebsite(websiteID)
+	return p.repo.ReclassifyPageviews(websiteID, start, end, filter.ShouldCountAsPageView, progress)
+}
+
+func (p *LogParser) scanNginxLogsInternal(ctx context.Context, websiteIDs []string) []ParserResult {
 	setParsingTotalBytes(p.calculateTotalBytesToScan(websiteIDs))
 	parserResults := make([]ParserResult, len(websiteIDs))
 
 	for i, id := range websiteIDs {
+		if ctx.Err() != nil {
+			logrus.Info("服务关闭，停止扫描日志，已提交的进度下次继续")
+			break
+		}
 		startTime := time.Now()
 
 		website, _ := config.GetWebsiteByID(id)
 		parserResult := EmptyParserResult(website.Name, id)
+		// 回填迁移执行完成前不扫描该站点，扫描进度保留，完成后从原位置继续
+		if pending, err := p.repo.RefreshMigrationPending(id); pending {
+			if err != nil {
+				logrus.WithError(err).Warnf("读取网站 %s 的迁移记录失败", id)
+			}
+			parserResult.Success = false
+			parserResult.Error = fmt.Errorf("站点 %s: %w", id, store.ErrMigrationPending)
+			parserResults[i] = parserResult
+			continue
+		}
 		p.markInitialParsed(id)
 		if len(website.Sources) > 0 {
-			p.scanSources(id, website, &parserResult)
+			p.scanSources(ctx, id, website, &parserResult)
 		} else {
 			if _, err := p.getLineParser(id); err != nil {
 				parserResult.Success = false
@@ -693,11 +832,11 @@ func (p *LogParser) scanNginxLogsInternal(websiteIDs []string) []ParserResult {
 					p.notifyLogParsing(id, logPath, "日志路径未匹配到文件", errors.New(errstr))
 				} else {
 					for _, matchPath := range matches {
-						p.scanSingleFile(id, matchPath, &parserResult)
+						p.scanSingleFile(ctx, id, matchPath, &parserResult)
 					}
 				}
 			} else {
-				p.scanSingleFile(id, logPath, &parserResult)
+				p.scanSingleFile(ctx, id, logPath, &parserResult)
 			}
 		}
 
@@ -20,7 +20,7 @@ func TestIISDefaultParserParsesW3CLine(t *testing.T) {
 	line := now.Format(defaultIISTimeLayout) +
 		" 10.0.0.10 GET /index.html a=1&b=2 443 - 203.0.113.8 Mozilla/5.0+(Windows+NT+10.0;+Win64;+x64) https://example.com/ 200 0 0 36"
 
-	p := &LogParser{retentionDays: 30}
+	p := &LogParser{}
 	record, err := p.parseRegexLogLine(parser, line)
 	if err != nil {
 		t.Fatalf("parseRegexLogLine error: %v", err)
@@ -53,7 +53,7 @@ func TestIISDefaultParserSkipsDashQuery(t *testing.T) {
 	line := now.Format(defaultIISTimeLayout) +
 		" 10.0.0.10 GET /health - 443 - 203.0.113.9 curl/8.0.1 - 204 0 0 1"
 
-	p := &LogParser{retentionDays: 30}
+	p := &LogParser{}
 	record, err := p.parseRegexLogLine(parser, line)
 	if err != nil {
 		t.Fatalf("parseRegexLogLine error: %v", err)
@@ -31,7 +31,10 @@ func (p *LogParser) getLineParserForSource(websiteID, sourceID string) (*logLine
 	if sourceID != "" {
 		key = websiteID + ":" + sourceID
 	}
-	if parser, ok := p.lineParsers[key]; ok {
+	p.cacheMu.Lock()
+	parser, ok := p.lineParsers[key]
+	p.cacheMu.Unlock()
+	if ok {
 		return parser, nil
 	}
 
@@ -54,8 +57,11 @@ func (p *LogParser) getLineParserForSource(websiteID, sourceID string) (*logLine
 	if err != nil {
 		return nil, err
 	}
+	parser.websiteID = websiteID
 
+	p.cacheMu.Lock()
 	p.lineParsers[key] = parser
+	p.cacheMu.Unlock()
 	return parser, nil
 }
 
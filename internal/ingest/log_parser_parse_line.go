@@ -127,7 +127,7 @@ func (p *LogParser) parseRegexLogLine(parser *logLineParser, line string) (*stor
 	referPath := extractField(matches, parser.indexMap, refererAliases)
 
 	userAgent := extractField(matches, parser.indexMap, userAgentAliases)
-	return p.buildLogRecord(ip, method, urlValue, referPath, userAgent, statusCode, bytesSent, timestamp)
+	return p.buildLogRecord(parser.websiteID, ip, method, urlValue, referPath, userAgent, statusCode, bytesSent, timestamp)
 }
 
 func (p *LogParser) parseCaddyJSONLine(line string, parser *logLineParser) (*store.NginxLogRecord, error) {
@@ -167,11 +167,11 @@ func (p *LogParser) parseCaddyJSONLine(line string, parser *logLineParser) (*sto
 		return nil, err
 	}
 
-	return p.buildLogRecord(ip, method, urlValue, referPath, userAgent, statusCode, bytesSent, timestamp)
+	return p.buildLogRecord(parser.websiteID, ip, method, urlValue, referPath, userAgent, statusCode, bytesSent, timestamp)
 }
 
 func (p *LogParser) buildLogRecord(
-	ip, method, urlValue, referer, userAgent string,
+	websiteID, ip, method, urlValue, referer, userAgent string,
 	statusCode, bytesSent int, timestamp time.Time) (*store.NginxLogRecord, error) {
 
 	ip = normalizeIP(ip)
@@ -182,7 +182,7 @@ func (p *LogParser) buildLogRecord(
 		return nil, errors.New("日志缺少状态码")
 	}
 
-	cutoffTime := time.Now().AddDate(0, 0, -p.retentionDays)
+	cutoffTime := time.Now().AddDate(0, 0, -p.retentionDaysFor(websiteID))
 	if timestamp.Before(cutoffTime) {
 		return nil, errors.New("日志超过保留天数")
 	}
@@ -203,7 +203,7 @@ func (p *LogParser) buildLogRecord(
 		userAgent = "-"
 	}
 
-	pageviewFlag := enrich.ShouldCountAsPageView(statusCode, decodedPath, ip)
+	pageviewFlag := enrich.ShouldCountAsPageViewForWebsite(websiteID, statusCode, decodedPath, ip)
 	browser, os, device := enrich.ParseUserAgent(userAgent)
 
 	return &store.NginxLogRecord{
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/sha1"
 	"errors"
 	"fmt"
@@ -18,7 +19,7 @@ import (
 )
 
 func (p *LogParser) scanSingleFile(
-	websiteID string, logPath string, parserResult *ParserResult) {
+	ctx context.Context, websiteID string, logPath string, parserResult *ParserResult) {
 	file, err := os.Open(logPath)
 	if err != nil {
 		logrus.Errorf("无法打开日志文件 %s: %v", logPath, err)
@@ -64,14 +65,26 @@ func (p *LogParser) scanSingleFile(
 			if fileInfo.ModTime().After(cutoff) || fileInfo.ModTime().Equal(cutoff) {
 				if _, err := file.Seek(0, 0); err == nil {
 					if gzReader, err := gzip.NewReader(file); err == nil {
-						entriesCount, _, minTs, maxTs := p.parseLogLines(
-							gzReader, websiteID, "", parserResult, parseWindow{minTs: cutoffTs},
+						// 中途记录的状态 LastSize 仍为 0，中断后按增量扫描从 LastOffset 续读
+						checkpoint := func(bytes, minTs, maxTs int64) []store.ScanStateRow {
+							state := fileState
+							state.LastOffset = bytes
+							p.updateParsedRange(&state, minTs, maxTs)
+							return []store.ScanStateRow{fileStateRow(websiteID, logPath, state)}
+						}
+						entriesCount, bytesRead, minTs, maxTs, err := p.parseLogLines(
+							ctx, gzReader, websiteID, "", parserResult, parseWindow{minTs: cutoffTs}, checkpoint,
 						)
 						gzReader.Close()
 						p.updateParsedRange(&fileState, minTs, maxTs)
 						if maxTs > fileState.LastTimestamp {
 							fileState.LastTimestamp = maxTs
 						}
+						if err != nil {
+							fileState.LastOffset = bytesRead
+							p.setFileState(websiteID, logPath, fileState)
+							return
+						}
 						if entriesCount > 0 {
 							logrus.Infof("网站 %s 的 gzip 日志文件 %s 扫描完成，解析了 %d 条记录",
 								websiteID, logPath, entriesCount)
@@ -118,13 +131,20 @@ func (p *LogParser) scanSingleFile(
 				logrus.Errorf("无法设置文件读取位置 %s: %v", logPath, err)
 				p.notifyFileIO(websiteID, logPath, "设置文件读取位置", err)
 			} else {
-				entriesCount, _, minTs, maxTs := p.parseLogLines(
-					file, websiteID, "", parserResult, parseWindow{minTs: cutoffTs},
+				checkpoint := func(bytes, minTs, maxTs int64) []store.ScanStateRow {
+					state := fileState
+					state.LastOffset = recentOffset + bytes
+					p.updateParsedRange(&state, minTs, maxTs)
+					return []store.ScanStateRow{fileStateRow(websiteID, logPath, state)}
+				}
+				entriesCount, bytesRead, minTs, maxTs, err := p.parseLogLines(
+					ctx, file, websiteID, "", parserResult, parseWindow{minTs: cutoffTs}, checkpoint,
 				)
 				p.updateParsedRange(&fileState, minTs, maxTs)
 				if maxTs > fileState.LastTimestamp {
 					fileState.LastTimestamp = maxTs
 				}
+				fileState.LastOffset = plainFileOffset(currentSize, recentOffset+bytesRead, err)
 				if entriesCount > 0 {
 					logrus.Infof("网站 %s 的日志文件 %s 扫描完成，解析了 %d 条记录",
 						websiteID, logPath, entriesCount)
@@ -189,21 +209,37 @@ func (p *LogParser) scanSingleFile(
 		reader = file
 	}
 
-	entriesCount, bytesRead, minTs, maxTs := p.parseLogLines(reader, websiteID, "", parserResult, parseWindow{})
+	// gzip 文件的回填会解析整个文件中早于最近窗口的部分，回填完成前只解析窗口内的日志
+	window := parseWindow{}
+	if isGzip && !fileState.BackfillDone {
+		window.minTs = fileState.RecentCutoffTs
+	}
+	// 中途记录的状态保留上次的 LastSize，gzip 文件中断后不会被当作已读完
+	checkpoint := func(bytes, minTs, maxTs int64) []store.ScanStateRow {
+		state := fileState
+		state.LastOffset = startOffset + bytes
+		p.updateParsedRange(&state, minTs, maxTs)
+		return []store.ScanStateRow{fileStateRow(websiteID, logPath, state)}
+	}
+	entriesCount, bytesRead, minTs, maxTs, err := p.parseLogLines(ctx, reader, websiteID, "", parserResult, window, checkpoint)
 	if closer != nil {
 		closer.Close()
 	}
 
-	if isGzip {
-		fileState.LastOffset = startOffset + bytesRead
-	} else {
-		fileState.LastOffset = currentSize
-	}
-	fileState.LastSize = currentSize
 	p.updateParsedRange(&fileState, minTs, maxTs)
 	if maxTs > fileState.LastTimestamp {
 		fileState.LastTimestamp = maxTs
 	}
+	switch {
+	case err != nil:
+		fileState.LastOffset = startOffset + bytesRead
+	case isGzip:
+		fileState.LastOffset = startOffset + bytesRead
+		fileState.LastSize = currentSize
+	default:
+		fileState.LastOffset = plainFileOffset(currentSize, startOffset+bytesRead, nil)
+		fileState.LastSize = currentSize
+	}
 
 	p.setFileState(websiteID, logPath, fileState)
 
@@ -213,10 +249,21 @@ func (p *LogParser) scanSingleFile(
 	}
 }
 
+// plainFileOffset 返回普通日志文件读完后应记录的位置：写入失败时停在最后提交的批次之后；
+// 否则取扫描开始时的文件大小与实际读到的位置中较大者（读取期间追加的内容已经解析，读取出错的剩余部分跳过）
+func plainFileOffset(currentSize, readOffset int64, err error) int64 {
+	if err != nil || readOffset > currentSize {
+		return readOffset
+	}
+	return currentSize
+}
+
 // determineStartOffset 确定扫描起始位置
 func (p *LogParser) determineStartOffset(
 	websiteID string, filePath string, currentSize int64) int64 {
 
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 	state, ok := p.states[websiteID]
 	if !ok { // 网站没有扫描记录，创建新状态
 		p.states[websiteID] = LogScanState{
@@ -415,56 +462,104 @@ func (p *LogParser) findRecentOffset(
 	return 0, lastTs, nil
 }
 
-// parseLogLines 解析日志行并返回解析的记录数
+// parseLogLines 解析日志行并逐批写入数据库，返回写入的记录数、已处理的字节数与写入日志的时间范围。
+// checkpoint 不为空时，其返回的扫描状态与每批日志在同一个事务内提交；写入失败时停止读取并返回错误，
+// 此时已处理的字节数停在最后一个成功提交的批次之后，调用方据此记录读取位置，下次从该位置继续。
+// ctx 取消时提交已解析的部分后停止，返回 ctx 的错误，调用方同样按已处理的字节数记录位置。
 func (p *LogParser) parseLogLines(
-	reader io.Reader, websiteID, sourceID string, parserResult *ParserResult, window parseWindow) (int, int64, int64, int64) {
+	ctx context.Context,
+	reader io.Reader,
+	websiteID, sourceID string,
+	parserResult *ParserResult,
+	window parseWindow,
+	checkpoint scanCheckpoint,
+) (int, int64, int64, int64, error) {
+	// 按实际消耗的字节（含换行符与 \r）计数，保证记录的位置可以精确续读
+	var consumed int64
 	scanner := bufio.NewScanner(reader)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		consumed += int64(advance)
+		return advance, token, err
+	})
+
 	entriesCount := 0
-	var minTs int64
-	var maxTs int64
+	var committedBytes int64
+	var minTs, maxTs int64
+	var batchMinTs, batchMaxTs int64
 	parsedBuckets := make(map[int64]struct{})
+	batchBuckets := make(map[int64]struct{})
 	var whitelistHits map[string]*whitelistHit
 	var batchWhitelistHits map[string]*whitelistHit
 
 	// 批量插入相关
-	batch := make([]store.NginxLogRecord, 0, p.parseBatchSize)
+	batchSize := p.batchSize()
+	batch := make([]store.NginxLogRecord, 0, batchSize)
 
 	// 处理一批数据
-	processBatch := func() {
+	processBatch := func() error {
 		if len(batch) == 0 {
-			return
+			committedBytes = consumed
+			return nil
+		}
+		// 开启选主时，失去主节点身份后不再提交，避免与新的主节点重复写入同一段日志
+		if !p.IsLeader() {
+			return ErrNotLeader
+		}
+
+		newMinTs, newMaxTs := mergeTsRange(minTs, maxTs, batchMinTs, batchMaxTs)
+		var states []store.ScanStateRow
+		if checkpoint != nil {
+			states = checkpoint(consumed, newMinTs, newMaxTs)
 		}
 
 		// 先把本批次 location 标记为“待解析”，确保日志落库后前端可见；
 		// 再在日志成功落库后写入 ip_geo_pending，避免“先入队、后落库”导致回填命中空 ip_id 后把队列误删。
 		p.markBatchIPGeoPending(batch)
-		if err := p.repo.BatchInsertLogsForWebsite(websiteID, batch); err != nil {
+		if err := p.repo.BatchInsertLogsWithScanState(websiteID, batch, states); err != nil {
 			logrus.Errorf("批量插入网站 %s 的日志记录失败: %v", websiteID, err)
 			p.notifyDatabaseWrite(websiteID, "写入日志批次", err)
-		} else {
-			p.enqueueBatchIPGeo(batch)
-			whitelistHits = mergeWhitelistHits(whitelistHits, batchWhitelistHits)
+			return err
 		}
+		p.enqueueBatchIPGeo(batch)
+		whitelistHits = mergeWhitelistHits(whitelistHits, batchWhitelistHits)
+
+		entriesCount += len(batch)
+		parserResult.TotalEntries += len(batch) // 累加到总结果中，而非赋值
+		minTs, maxTs = newMinTs, newMaxTs
+		for bucket := range batchBuckets {
+			parsedBuckets[bucket] = struct{}{}
+		}
+		committedBytes = consumed
 
 		batch = batch[:0] // 清空批次但保留容量
+		batchMinTs, batchMaxTs = 0, 0
+		batchBuckets = make(map[int64]struct{})
 		batchWhitelistHits = nil
+		return nil
 	}
 
 	// 逐行处理
 	const progressChunk = int64(64 * 1024)
 	var pendingBytes int64
-	var totalBytes int64
-	for scanner.Scan() {
-		line := scanner.Text()
-		lineBytes := int64(len(line) + 1)
-		pendingBytes += lineBytes
-		totalBytes += lineBytes
+	var reported int64
+	var writeErr error
+	var canceled error
+	for {
+		if canceled = ctx.Err(); canceled != nil {
+			break
+		}
+		if !scanner.Scan() {
+			break
+		}
+		pendingBytes += consumed - reported
+		reported = consumed
 		if pendingBytes >= progressChunk {
 			addParsingProgress(pendingBytes)
 			pendingBytes = 0
 		}
 
-		entry, err := p.parseLogLine(websiteID, sourceID, line)
+		entry, err := p.parseLogLine(websiteID, sourceID, scanner.Text())
 		if err != nil {
 			continue
 		}
@@ -472,45 +567,61 @@ func (p *LogParser) parseLogLines(
 		if !window.allows(ts) {
 			continue
 		}
-		if matcher := p.whitelistMatchers[websiteID]; matcher != nil && matcher.Enabled() {
+		if matcher := p.whitelistMatcher(websiteID); matcher != nil && matcher.Enabled() {
 			if match, ok := matcher.Match(entry.IP); ok {
 				batchWhitelistHits = p.recordWhitelistHit(websiteID, *entry, match, batchWhitelistHits)
 			}
 		}
 		batch = append(batch, *entry)
-		bucket := (ts / 3600) * 3600
-		parsedBuckets[bucket] = struct{}{}
-		if minTs == 0 || ts < minTs {
-			minTs = ts
-		}
-		if ts > maxTs {
-			maxTs = ts
-		}
-		entriesCount++
-		parserResult.TotalEntries++ // 累加到总结果中，而非赋值
+		batchBuckets[(ts/3600)*3600] = struct{}{}
+		batchMinTs, batchMaxTs = mergeTsRange(batchMinTs, batchMaxTs, ts, ts)
 
-		if len(batch) >= p.parseBatchSize {
-			processBatch()
+		if len(batch) >= batchSize {
+			if writeErr = processBatch(); writeErr != nil {
+				break
+			}
 		}
 	}
 
-	processBatch() // 处理剩余的记录
+	if writeErr == nil {
+		writeErr = processBatch() // 处理剩余的记录
+	}
+	if writeErr == nil {
+		writeErr = canceled
+	}
 	if pendingBytes > 0 {
 		addParsingProgress(pendingBytes)
 	}
 
-	if err := scanner.Err(); err != nil {
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
 		logrus.Errorf("扫描网站 %s 的文件时出错: %v", websiteID, err)
 		p.notifyLogParsing(websiteID, "", "扫描日志文件", err)
 	}
 	p.flushWhitelistHits(whitelistHits)
 
 	p.recordParsedHourBuckets(websiteID, parsedBuckets)
-	return entriesCount, totalBytes, minTs, maxTs // 返回当前文件的日志条数
+	return entriesCount, committedBytes, minTs, maxTs, writeErr
+}
+
+// mergeTsRange 合并两个时间范围，0 表示没有数据
+func mergeTsRange(minTs, maxTs, otherMinTs, otherMaxTs int64) (int64, int64) {
+	if otherMinTs > 0 && (minTs == 0 || otherMinTs < minTs) {
+		minTs = otherMinTs
+	}
+	if otherMaxTs > maxTs {
+		maxTs = otherMaxTs
+	}
+	return minTs, maxTs
 }
 
 // IngestLines parses and inserts streamed log lines for a website/source.
 func (p *LogParser) IngestLines(websiteID, sourceID string, lines []string) (int, int, error) {
+	return p.IngestSampledLines(websiteID, sourceID, lines, nil)
+}
+
+// IngestSampledLines is IngestLines for lines sampled at the edge: sampleRates[i]
+// is the 1/N rate line i was kept at (missing or <= 1 means unsampled).
+func (p *LogParser) IngestSampledLines(websiteID, sourceID string, lines []string, sampleRates []int) (int, int, error) {
 	if websiteID == "" {
 		return 0, 0, errors.New("websiteID 不能为空")
 	}
@@ -521,7 +632,8 @@ func (p *LogParser) IngestLines(websiteID, sourceID string, lines []string) (int
 		return 0, 0, err
 	}
 
-	batch := make([]store.NginxLogRecord, 0, p.parseBatchSize)
+	batchSize := p.batchSize()
+	batch := make([]store.NginxLogRecord, 0, batchSize)
 	accepted := 0
 	deduped := 0
 	var minTs int64
@@ -547,17 +659,20 @@ func (p *LogParser) IngestLines(websiteID, sourceID string, lines []string) (int
 		return nil
 	}
 
-	for _, line := range lines {
+	for i, line := range lines {
 		entry, err := p.parseLogLine(websiteID, sourceID, line)
 		if err != nil {
 			continue
 		}
+		if i < len(sampleRates) && sampleRates[i] > 1 {
+			entry.SampleRate = sampleRates[i]
+		}
 		key := buildDedupKey(websiteID, sourceID, line)
 		if p.dedup != nil && p.dedup.Seen(key) {
 			deduped++
 			continue
 		}
-		if matcher := p.whitelistMatchers[websiteID]; matcher != nil && matcher.Enabled() {
+		if matcher := p.whitelistMatcher(websiteID); matcher != nil && matcher.Enabled() {
 			if match, ok := matcher.Match(entry.IP); ok {
 				batchWhitelistHits = p.recordWhitelistHit(websiteID, *entry, match, batchWhitelistHits)
 			}
@@ -574,7 +689,7 @@ func (p *LogParser) IngestLines(websiteID, sourceID string, lines []string) (int
 			maxTs = ts
 		}
 
-		if len(batch) >= p.parseBatchSize {
+		if len(batch) >= batchSize {
 			if err := processBatch(); err != nil {
 				return accepted, deduped, err
 			}
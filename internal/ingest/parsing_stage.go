@@ -5,10 +5,14 @@ import "sync"
 type parseStage string
 
 const (
-	parseStageNone     parseStage = ""
-	parseStageInitial  parseStage = "initial"
-	parseStagePeriodic parseStage = "periodic"
-	parseStageReparse  parseStage = "reparse"
+	parseStageNone       parseStage = ""
+	parseStageInitial    parseStage = "initial"
+	parseStagePeriodic   parseStage = "periodic"
+	parseStageReparse    parseStage = "reparse"
+	parseStageReclassify parseStage = "reclassify"
+	parseStagePurge      parseStage = "purge"
+	parseStageBackup     parseStage = "backup"
+	parseStageRestore    parseStage = "restore"
 )
 
 var (
@@ -0,0 +1,223 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// 扫描状态按记录存放在数据库 scan_state 表：每个站点一条汇总记录，每个日志文件、远端目标各一条。
+// 读取位置随日志批次在同一个事务内提交（见 parseLogLines 的 checkpoint），
+// 其余变化（时间范围、已解析小时等）先在内存中标记，由 updateState 统一写入。
+const (
+	scanStateKindSite   = "site"
+	scanStateKindFile   = "file"
+	scanStateKindTarget = "target"
+)
+
+type scanStateKey struct {
+	websiteID string
+	kind      string
+	key       string
+}
+
+// scanCheckpoint 返回本次读取已处理 bytes 字节、解析时间范围为 [minTs, maxTs] 时应记录的扫描状态，
+// 与对应的日志批次一同提交
+type scanCheckpoint func(bytes, minTs, maxTs int64) []store.ScanStateRow
+
+// marshalScanState 序列化扫描状态；状态只包含数字、字符串与布尔值，不会失败
+func marshalScanState(value interface{}) json.RawMessage {
+	data, _ := json.Marshal(value)
+	return data
+}
+
+func fileStateRow(websiteID, filePath string, state FileState) store.ScanStateRow {
+	return store.ScanStateRow{
+		WebsiteID: websiteID,
+		Kind:      scanStateKindFile,
+		Key:       normalizeLogPath(filePath),
+		State:     marshalScanState(state),
+	}
+}
+
+func targetStateRow(websiteID, targetKey string, state TargetState) store.ScanStateRow {
+	return store.ScanStateRow{
+		WebsiteID: websiteID,
+		Kind:      scanStateKindTarget,
+		Key:       targetKey,
+		State:     marshalScanState(state),
+	}
+}
+
+func siteStateRow(websiteID string, state LogScanState) store.ScanStateRow {
+	state.Files = nil
+	state.Targets = nil
+	return store.ScanStateRow{
+		WebsiteID: websiteID,
+		Kind:      scanStateKindSite,
+		State:     marshalScanState(state),
+	}
+}
+
+// scanStateRows 把站点的扫描状态拆分为汇总、逐文件与逐目标的记录
+func scanStateRows(websiteID string, state LogScanState) []store.ScanStateRow {
+	rows := make([]store.ScanStateRow, 0, 1+len(state.Files)+len(state.Targets))
+	rows = append(rows, siteStateRow(websiteID, state))
+	for filePath, fileState := range state.Files {
+		rows = append(rows, fileStateRow(websiteID, filePath, fileState))
+	}
+	for targetKey, targetState := range state.Targets {
+		rows = append(rows, targetStateRow(websiteID, targetKey, targetState))
+	}
+	return rows
+}
+
+// buildScanStates 把数据库中的记录组装为各站点的扫描状态，无法解析的记录跳过（对应文件会重新扫描）
+func buildScanStates(rows []store.ScanStateRow) map[string]LogScanState {
+	states := make(map[string]LogScanState)
+	get := func(websiteID string) LogScanState {
+		state, ok := states[websiteID]
+		if !ok {
+			state = LogScanState{
+				Files:   make(map[string]FileState),
+				Targets: make(map[string]TargetState),
+			}
+		}
+		return state
+	}
+
+	for _, row := range rows {
+		state := get(row.WebsiteID)
+		var err error
+		switch row.Kind {
+		case scanStateKindSite:
+			var site LogScanState
+			if err = json.Unmarshal(row.State, &site); err == nil {
+				site.Files = state.Files
+				site.Targets = state.Targets
+				state = site
+			}
+		case scanStateKindFile:
+			var fileState FileState
+			if err = json.Unmarshal(row.State, &fileState); err == nil {
+				state.Files[row.Key] = fileState
+			}
+		case scanStateKindTarget:
+			var targetState TargetState
+			if err = json.Unmarshal(row.State, &targetState); err == nil {
+				state.Targets[row.Key] = targetState
+			}
+		default:
+			err = fmt.Errorf("未知的记录类型 %s", row.Kind)
+		}
+		if err != nil {
+			logrus.Warnf("忽略网站 %s 无法解析的扫描状态 %s/%s: %v", row.WebsiteID, row.Kind, row.Key, err)
+			continue
+		}
+		states[row.WebsiteID] = state
+	}
+	return states
+}
+
+// loadScanStates 从数据库读取各站点的扫描状态（服务停止时由命令行使用，会先导入旧版状态文件）
+func loadScanStates(repo *store.Repository) (map[string]LogScanState, error) {
+	if err := migrateScanStateFile(repo); err != nil {
+		return nil, err
+	}
+	rows, err := repo.LoadScanState()
+	if err != nil {
+		return nil, fmt.Errorf("读取扫描状态失败: %w", err)
+	}
+	return buildScanStates(rows), nil
+}
+
+// migrateScanStateFile 把旧版本保存在 nginx_scan_state.json 中的扫描状态导入数据库，完成后把文件改名为 .bak。
+// 数据库中已有扫描状态时不再导入（说明之前已导入过，只是改名失败）。
+func migrateScanStateFile(repo *store.Repository) error {
+	if err := repo.EnsureScanStateTable(); err != nil {
+		return err
+	}
+	statePath := filepath.Join(config.DataDir, scanStateFileName)
+	data, err := os.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取扫描状态文件失败: %w", err)
+	}
+
+	existing, err := repo.LoadScanState()
+	if err != nil {
+		return err
+	}
+	if len(existing) == 0 && len(data) > 0 {
+		var states map[string]LogScanState
+		if err := json.Unmarshal(data, &states); err != nil {
+			return fmt.Errorf("解析扫描状态文件失败: %w", err)
+		}
+		var rows []store.ScanStateRow
+		for websiteID, state := range states {
+			rows = append(rows, scanStateRows(websiteID, state)...)
+		}
+		if err := repo.ReplaceScanState("", rows); err != nil {
+			return fmt.Errorf("导入扫描状态失败: %w", err)
+		}
+		logrus.Infof("已把扫描状态文件 %s 导入数据库（%d 个站点）", statePath, len(states))
+	}
+	return os.Rename(statePath, statePath+".bak")
+}
+
+// markStateDirty 标记需要由 updateState 写入数据库的记录，调用方需持有 stateMu
+func (p *LogParser) markStateDirty(websiteID, kind, key string) {
+	p.dirtyStates[scanStateKey{websiteID: websiteID, kind: kind, key: key}] = struct{}{}
+}
+
+// dirtyStateRows 按内存中的当前状态生成待写入的记录，已删除的文件/目标生成删除记录；调用方需持有 stateMu
+func (p *LogParser) dirtyStateRows() []store.ScanStateRow {
+	keys := make([]scanStateKey, 0, len(p.dirtyStates))
+	for key := range p.dirtyStates {
+		keys = append(keys, key)
+	}
+	// 固定写入顺序，避免与批次事务交错时的锁顺序不一致
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].websiteID != keys[j].websiteID {
+			return keys[i].websiteID < keys[j].websiteID
+		}
+		if keys[i].kind != keys[j].kind {
+			return keys[i].kind < keys[j].kind
+		}
+		return keys[i].key < keys[j].key
+	})
+
+	// 开启选主时站点汇总记录只由主节点写入，其他实例接收推送日志时只写入对应目标的记录
+	leader := p.leader.IsLeader()
+	rows := make([]store.ScanStateRow, 0, len(keys))
+	for _, key := range keys {
+		if key.kind == scanStateKindSite && !leader {
+			continue
+		}
+		row := store.ScanStateRow{WebsiteID: key.websiteID, Kind: key.kind, Key: key.key}
+		state, ok := p.states[key.websiteID]
+		switch {
+		case !ok:
+		case key.kind == scanStateKindSite:
+			row = siteStateRow(key.websiteID, state)
+		case key.kind == scanStateKindFile:
+			if fileState, exists := state.Files[key.key]; exists {
+				row = fileStateRow(key.websiteID, key.key, fileState)
+			}
+		case key.kind == scanStateKindTarget:
+			if targetState, exists := state.Targets[key.key]; exists {
+				row = targetStateRow(key.websiteID, key.key, targetState)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
@@ -0,0 +1,107 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/likaia/nginxpulse/internal/backup"
+	"github.com/likaia/nginxpulse/internal/store"
+)
+
+// Backup 导出站点数据与扫描状态（服务运行时使用），导出期间暂停日志解析以保证扫描状态与数据库一致。
+// 开启选主时只能在主节点上执行，其他实例无法暂停主节点的解析。
+func (p *LogParser) Backup(w io.Writer, websiteIDs []string) (*backup.Manifest, error) {
+	if err := p.RequireLeader(); err != nil {
+		return nil, err
+	}
+	if !startIPParsingWithStage(parseStageBackup) {
+		return nil, ErrParsingInProgress
+	}
+	defer finishIPParsing()
+
+	p.syncStateFromDB()
+	p.stateMu.Lock()
+	states := make(map[string]json.RawMessage, len(p.states))
+	for websiteID, state := range p.states {
+		data, err := json.Marshal(state)
+		if err != nil {
+			p.stateMu.Unlock()
+			return nil, err
+		}
+		states[websiteID] = data
+	}
+	p.stateMu.Unlock()
+	return backup.Create(p.repo, w, websiteIDs, states)
+}
+
+// Restore 恢复备份到当前实例（服务运行时使用），并把备份中的扫描状态设为对应站点的当前状态
+func (p *LogParser) Restore(r io.Reader, idMap map[string]string) (*backup.Result, error) {
+	if err := p.RequireLeader(); err != nil {
+		return nil, err
+	}
+	if !startIPParsingWithStage(parseStageRestore) {
+		return nil, ErrParsingInProgress
+	}
+	defer finishIPParsing()
+
+	// 恢复中途失败时，已提交站点的扫描状态也要保存，否则这些站点的日志会从头重新解析
+	result, states, restoreErr := backup.Restore(p.repo, r, idMap)
+	restored, err := saveRestoredScanStates(p.repo, states)
+	for websiteID, state := range restored {
+		p.setWebsiteState(websiteID, state)
+		ResetWebsiteParseStatus(websiteID)
+	}
+	if len(restored) > 0 {
+		p.updateState()
+	}
+	if restoreErr != nil {
+		return result, restoreErr
+	}
+	return result, err
+}
+
+// BackupData 导出站点数据与数据库中的扫描状态（服务停止时由命令行使用）
+func BackupData(repo *store.Repository, w io.Writer, websiteIDs []string) (*backup.Manifest, error) {
+	scanStates, err := loadScanStates(repo)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]json.RawMessage, len(scanStates))
+	for websiteID, state := range scanStates {
+		states[websiteID] = marshalScanState(state)
+	}
+	return backup.Create(repo, w, websiteIDs, states)
+}
+
+// RestoreData 恢复备份并把扫描状态写入数据库（服务停止时由命令行使用）
+func RestoreData(repo *store.Repository, r io.Reader, idMap map[string]string) (*backup.Result, error) {
+	if err := migrateScanStateFile(repo); err != nil {
+		return nil, err
+	}
+	result, states, restoreErr := backup.Restore(repo, r, idMap)
+	_, err := saveRestoredScanStates(repo, states)
+	if restoreErr != nil {
+		return result, restoreErr
+	}
+	return result, err
+}
+
+// saveRestoredScanStates 用备份中的扫描状态替换各站点在数据库中的记录，返回已写入的状态
+func saveRestoredScanStates(
+	repo *store.Repository,
+	states map[string]json.RawMessage,
+) (map[string]LogScanState, error) {
+	restored := make(map[string]LogScanState, len(states))
+	for websiteID, data := range states {
+		var state LogScanState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return restored, fmt.Errorf("数据已恢复，但解析站点 %s 的扫描状态失败: %w", websiteID, err)
+		}
+		if err := repo.ReplaceScanState(websiteID, scanStateRows(websiteID, state)); err != nil {
+			return restored, fmt.Errorf("数据已恢复，但保存站点 %s 的扫描状态失败: %w", websiteID, err)
+		}
+		restored[websiteID] = state
+	}
+	return restored, nil
+}
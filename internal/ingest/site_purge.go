@@ -0,0 +1,32 @@
+package ingest
+
+import (
+	"github.com/likaia/nginxpulse/internal/store"
+)
+
+// PurgeWebsite 清除已从配置中移除的站点的数据库数据与扫描状态（服务运行时使用）
+func (p *LogParser) PurgeWebsite(websiteID string) (store.PurgeResult, error) {
+	if err := p.RequireLeader(); err != nil {
+		return store.PurgeResult{}, err
+	}
+	if !startIPParsingWithStage(parseStagePurge) {
+		return store.PurgeResult{}, ErrParsingInProgress
+	}
+	defer finishIPParsing()
+
+	result, err := p.repo.PurgeWebsite(websiteID)
+	if err != nil {
+		return result, err
+	}
+	p.ResetScanState(websiteID)
+	return result, nil
+}
+
+// PurgeWebsiteData 清除站点的数据库数据与扫描状态（服务停止时由命令行使用）
+func PurgeWebsiteData(repo *store.Repository, websiteID string) (store.PurgeResult, error) {
+	// 先导入旧版本的状态文件，避免已清除站点的扫描状态在下次启动时被导入
+	if err := migrateScanStateFile(repo); err != nil {
+		return store.PurgeResult{}, err
+	}
+	return repo.PurgeWebsite(websiteID)
+}
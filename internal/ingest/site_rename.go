@@ -0,0 +1,15 @@
+package ingest
+
+import (
+	"github.com/likaia/nginxpulse/internal/store"
+)
+
+// RenameWebsiteData 把站点数据从 oldID 迁移到 newID：数据库表与扫描状态在同一个事务内迁移。
+// 需在服务停止时执行，否则运行中的解析任务仍会写入旧 ID。
+func RenameWebsiteData(repo *store.Repository, oldID, newID string) error {
+	// 旧版本的扫描状态仍在状态文件中时先导入，使其随数据库一起迁移
+	if err := migrateScanStateFile(repo); err != nil {
+		return err
+	}
+	return repo.RenameWebsite(oldID, newID)
+}
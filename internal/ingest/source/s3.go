@@ -32,6 +32,32 @@ func NewS3Source(websiteID, id, endpoint, region, bucket, prefix, pattern, acces
 		region = "us-east-1"
 	}
 
+	client, err := NewS3Client(endpoint, region, accessKey, secretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Source{
+		websiteID:   websiteID,
+		id:          id,
+		endpoint:    endpoint,
+		region:      region,
+		bucket:      bucket,
+		prefix:      prefix,
+		pattern:     pattern,
+		accessKey:   accessKey,
+		secretKey:   secretKey,
+		compression: compression,
+		client:      client,
+	}, nil
+}
+
+// NewS3Client 创建 S3 客户端；配置 endpoint 时按 S3 兼容存储处理（固定地址、path-style 访问）
+func NewS3Client(endpoint, region, accessKey, secretKey string) (*s3.Client, error) {
+	if region == "" {
+		region = "us-east-1"
+	}
+
 	cfgOptions := []func(*config.LoadOptions) error{
 		config.WithRegion(region),
 	}
@@ -59,25 +85,11 @@ func NewS3Source(websiteID, id, endpoint, region, bucket, prefix, pattern, acces
 		return nil, err
 	}
 
-	client := s3.NewFromConfig(cfg, func(options *s3.Options) {
+	return s3.NewFromConfig(cfg, func(options *s3.Options) {
 		if endpoint != "" {
 			options.UsePathStyle = true
 		}
-	})
-
-	return &S3Source{
-		websiteID:   websiteID,
-		id:          id,
-		endpoint:    endpoint,
-		region:      region,
-		bucket:      bucket,
-		prefix:      prefix,
-		pattern:     pattern,
-		accessKey:   accessKey,
-		secretKey:   secretKey,
-		compression: compression,
-		client:      client,
-	}, nil
+	}), nil
 }
 
 func (s *S3Source) ID() string {
@@ -9,12 +9,20 @@ import (
 
 	"github.com/likaia/nginxpulse/internal/config"
 	"github.com/likaia/nginxpulse/internal/ingest/source"
+	"github.com/likaia/nginxpulse/internal/store"
 	"github.com/sirupsen/logrus"
 )
 
-func (p *LogParser) scanSources(websiteID string, website config.WebsiteConfig, parserResult *ParserResult) {
-	ctx := context.Background()
+func (p *LogParser) scanSources(
+	ctx context.Context,
+	websiteID string,
+	website config.WebsiteConfig,
+	parserResult *ParserResult,
+) {
 	for _, srcCfg := range website.Sources {
+		if ctx.Err() != nil {
+			return
+		}
 		if _, err := p.getLineParserForSource(websiteID, srcCfg.ID); err != nil {
 			parserResult.Success = false
 			parserResult.Error = err
@@ -43,6 +51,10 @@ func (p *LogParser) scanSources(websiteID string, website config.WebsiteConfig,
 		}
 		for _, target := range targets {
 			if err := p.scanTarget(ctx, websiteID, src, target, parserResult); err != nil {
+				if ctx.Err() != nil {
+					// 服务关闭，已提交的进度已记录，下次继续
+					return
+				}
 				parserResult.Success = false
 				parserResult.Error = err
 			}
@@ -81,7 +93,7 @@ func (p *LogParser) scanTarget(
 		if meta.ETag != "" && state.LastETag != "" && meta.ETag != state.LastETag && meta.Size <= state.LastSize {
 			reset = true
 		}
-		if state.LastOffset > 0 && meta.Size > 0 && state.LastOffset > meta.Size {
+		if !meta.Compressed && state.LastOffset > 0 && meta.Size > 0 && state.LastOffset > meta.Size {
 			reset = true
 		}
 	}
@@ -90,12 +102,17 @@ func (p *LogParser) scanTarget(
 		ok = false
 	}
 
+	// 压缩目标每次整体重读；同一版本读到一半中断时（BackfillDone 为 false），解压后跳过已写入的 LastOffset 字节
 	needsFullScan := meta.Compressed
+	var resumeBytes int64
 	if needsFullScan && ok {
 		sameETag := meta.ETag != "" && meta.ETag == state.LastETag
 		sameMod := meta.ETag == "" && meta.Size == state.LastSize && meta.ModTime.Unix() == state.LastModTime
 		if meta.Size == state.LastSize && (sameETag || sameMod) {
-			return nil
+			if state.BackfillDone {
+				return nil
+			}
+			resumeBytes = state.LastOffset
 		}
 	}
 
@@ -142,17 +159,52 @@ func (p *LogParser) scanTarget(
 		bytesRead    int64
 		minTs        int64
 		maxTs        int64
+		parseErr     error
 	)
 
+	// 中途记录的状态：普通目标保留上次的大小与版本，压缩目标记录当前版本并以 BackfillDone=false 标记未读完
+	partialState := func(bytes, minTs, maxTs int64) TargetState {
+		partial := state
+		if needsFullScan {
+			partial.LastOffset = resumeBytes + bytes
+			partial.LastSize = meta.Size
+			partial.LastETag = meta.ETag
+			partial.LastModTime = meta.ModTime.Unix()
+			partial.BackfillDone = false
+		} else {
+			partial.LastOffset = startOffset + bytes
+		}
+		updateTargetParsedRange(&partial, minTs, maxTs)
+		return partial
+	}
+	checkpoint := func(bytes, minTs, maxTs int64) []store.ScanStateRow {
+		return []store.ScanStateRow{targetStateRow(websiteID, targetKey, partialState(bytes, minTs, maxTs))}
+	}
+
 	if needsFullScan {
 		gzReader, err := gzip.NewReader(reader)
 		if err != nil {
 			return err
 		}
-		entriesCount, bytesRead, minTs, maxTs = p.parseLogLines(gzReader, websiteID, target.SourceID, parserResult, window)
+		if resumeBytes > 0 {
+			if err := skipReaderBytes(gzReader, resumeBytes); err != nil {
+				gzReader.Close()
+				return err
+			}
+		}
+		entriesCount, bytesRead, minTs, maxTs, parseErr = p.parseLogLines(
+			ctx, gzReader, websiteID, target.SourceID, parserResult, window, checkpoint,
+		)
 		gzReader.Close()
 	} else {
-		entriesCount, bytesRead, minTs, maxTs = p.parseLogLines(reader, websiteID, target.SourceID, parserResult, window)
+		entriesCount, bytesRead, minTs, maxTs, parseErr = p.parseLogLines(
+			ctx, reader, websiteID, target.SourceID, parserResult, window, checkpoint,
+		)
+	}
+
+	if parseErr != nil {
+		p.setTargetState(websiteID, targetKey, partialState(bytesRead, minTs, maxTs))
+		return parseErr
 	}
 
 	updateTargetParsedRange(&state, minTs, maxTs)
@@ -19,6 +19,25 @@ type whitelistHit struct {
 	fingerprint string
 }
 
+// buildWhitelistMatchers 按当前配置为各站点生成白名单匹配器
+func buildWhitelistMatchers() map[string]*enrich.WhitelistMatcher {
+	matchers := make(map[string]*enrich.WhitelistMatcher)
+	for _, websiteID := range config.GetAllWebsiteIDs() {
+		if site, ok := config.GetWebsiteByID(websiteID); ok {
+			if matcher := enrich.NewWhitelistMatcher(site.Whitelist); matcher != nil {
+				matchers[websiteID] = matcher
+			}
+		}
+	}
+	return matchers
+}
+
+func (p *LogParser) whitelistMatcher(websiteID string) *enrich.WhitelistMatcher {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+	return p.whitelistMatchers[websiteID]
+}
+
 func (p *LogParser) recordWhitelistHit(
 	websiteID string,
 	log store.NginxLogRecord,
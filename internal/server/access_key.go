@@ -3,6 +3,7 @@ package server
 import (
 	"net/http"
 	"strings"
+	"sync/atomic"
 
 	"github.com/gin-gonic/gin"
 	"github.com/likaia/nginxpulse/internal/config"
@@ -10,8 +11,19 @@ import (
 
 const accessKeyHeader = "X-NginxPulse-Key"
 
-func accessKeyMiddleware() gin.HandlerFunc {
+// accessKeySet 为由某个配置快照生成的密钥集合，配置热加载后在下一次请求时重建
+type accessKeySet struct {
+	cfg  *config.Config
+	keys map[string]struct{}
+}
+
+var currentAccessKeys atomic.Pointer[accessKeySet]
+
+func loadAccessKeys() map[string]struct{} {
 	cfg := config.ReadConfig()
+	if set := currentAccessKeys.Load(); set != nil && set.cfg == cfg {
+		return set.keys
+	}
 	keys := make(map[string]struct{})
 	for _, key := range cfg.System.AccessKeys {
 		key = strings.TrimSpace(key)
@@ -20,14 +32,17 @@ func accessKeyMiddleware() gin.HandlerFunc {
 		}
 		keys[key] = struct{}{}
 	}
+	currentAccessKeys.Store(&accessKeySet{cfg: cfg, keys: keys})
+	return keys
+}
 
-	if len(keys) == 0 {
-		return func(c *gin.Context) {
+func accessKeyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys := loadAccessKeys()
+		if len(keys) == 0 {
 			c.Next()
+			return
 		}
-	}
-
-	return func(c *gin.Context) {
 		if !strings.HasPrefix(c.Request.URL.Path, "/api/") {
 			c.Next()
 			return
@@ -0,0 +1,201 @@
+package sqlutil
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Dialect identifies the SQL flavour of the configured database backend.
+type Dialect string
+
+const (
+	DialectPostgres Dialect = "postgres"
+	DialectSQLite   Dialect = "sqlite"
+)
+
+var currentDialect = DialectPostgres
+
+// SetDialect selects the dialect used by the helpers in this package.
+// It is called once when the repository opens the database.
+func SetDialect(dialect Dialect) {
+	if dialect == "" {
+		dialect = DialectPostgres
+	}
+	currentDialect = dialect
+}
+
+// CurrentDialect returns the dialect of the configured backend.
+func CurrentDialect() Dialect {
+	return currentDialect
+}
+
+// IsSQLite reports whether the configured backend is the embedded SQLite database.
+func IsSQLite() bool {
+	return currentDialect == DialectSQLite
+}
+
+// SupportsPartitions reports whether log tables can be declared as range partitioned tables.
+func SupportsPartitions() bool {
+	return currentDialect == DialectPostgres
+}
+
+// SupportsAdvisoryLocks reports whether pg_advisory_xact_lock is available.
+// SQLite serialises writers on the database file, so callers can skip the lock statements.
+func SupportsAdvisoryLocks() bool {
+	return currentDialect == DialectPostgres
+}
+
+// PartitionByRange returns the partition clause appended to CREATE TABLE, or "" when unsupported.
+func PartitionByRange(column string) string {
+	if !SupportsPartitions() {
+		return ""
+	}
+	return " PARTITION BY RANGE (" + column + ")"
+}
+
+// TableExistsQuery returns a query taking the table name and yielding a row if the table exists.
+func TableExistsQuery() string {
+	if IsSQLite() {
+		return `SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?`
+	}
+	return ReplacePlaceholders(
+		`SELECT 1
+         FROM pg_class c
+         JOIN pg_namespace n ON n.oid = c.relnamespace
+         WHERE n.nspname = 'public'
+           AND c.relkind IN ('r', 'p')
+           AND c.relname = ?`,
+	)
+}
+
+// ColumnExistsQuery returns a query taking (table, column) and yielding a row if the column exists.
+func ColumnExistsQuery() string {
+	if IsSQLite() {
+		return `SELECT 1 FROM pragma_table_info(?) WHERE name = ? LIMIT 1`
+	}
+	return ReplacePlaceholders(
+		`SELECT 1
+         FROM information_schema.columns
+         WHERE table_schema = 'public' AND table_name = ? AND column_name = ?
+         LIMIT 1`,
+	)
+}
+
+// LogTablesQuery returns a query listing all "<website>_nginx_logs" tables (partitions excluded).
+func LogTablesQuery() string {
+	if IsSQLite() {
+		return `SELECT name
+        FROM sqlite_master
+        WHERE type = 'table'
+          AND name LIKE '%\_nginx_logs' ESCAPE '\'`
+	}
+	return `SELECT c.relname
+        FROM pg_class c
+        JOIN pg_namespace n ON n.oid = c.relnamespace
+        WHERE n.nspname = 'public'
+          AND c.relkind IN ('r', 'p')
+          AND c.relispartition = false
+          AND c.relname LIKE '%\_nginx_logs' ESCAPE '\'`
+}
+
+// WebsiteRelationsQuery returns a query listing (name, kind) of the given tables, their partitions
+// (recursively) and the indexes and sequences owned by them. kind is one of "table", "index" or
+// "sequence". It takes the tableCount table names as arguments.
+func WebsiteRelationsQuery(tableCount int) string {
+	if IsSQLite() {
+		names := strings.TrimSuffix(strings.Repeat("?, ", tableCount), ", ")
+		return `SELECT name, type
+        FROM sqlite_master
+        WHERE type IN ('table', 'index')
+          AND sql IS NOT NULL
+          AND tbl_name IN (` + names + `)
+        ORDER BY name`
+	}
+	placeholders := make([]string, tableCount)
+	for i := range placeholders {
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+	}
+	names := strings.Join(placeholders, ", ")
+	return `WITH RECURSIVE site_tables AS (
+             SELECT c.oid
+             FROM pg_class c
+             JOIN pg_namespace n ON n.oid = c.relnamespace
+             WHERE n.nspname = 'public'
+               AND c.relkind IN ('r', 'p')
+               AND c.relname IN (` + names + `)
+             UNION
+             SELECT i.inhrelid
+             FROM pg_inherits i
+             JOIN site_tables t ON t.oid = i.inhparent
+         )
+         SELECT c.relname, 'table'
+         FROM pg_class c
+         JOIN site_tables t ON t.oid = c.oid
+         UNION
+         SELECT c.relname, 'index'
+         FROM pg_index x
+         JOIN site_tables t ON t.oid = x.indrelid
+         JOIN pg_class c ON c.oid = x.indexrelid
+         UNION
+         SELECT c.relname, 'sequence'
+         FROM pg_depend d
+         JOIN site_tables t ON t.oid = d.refobjid
+         JOIN pg_class c ON c.oid = d.objid
+         WHERE d.classid = 'pg_class'::regclass
+           AND c.relkind = 'S'
+         ORDER BY 1`
+}
+
+// JSONTextField returns an expression extracting a top-level key of a JSON column as text.
+// SQLite stores the encoded bytes as a BLOB, so it is cast back to TEXT before json_extract.
+func JSONTextField(column, key string) string {
+	if IsSQLite() {
+		return "json_extract(CAST(" + column + " AS TEXT), '$." + key + "')"
+	}
+	return column + "->>'" + key + "'"
+}
+
+var sqliteRewrites = []struct {
+	pattern *regexp.Regexp
+	replace string
+}{
+	{regexp.MustCompile(`(?i)\bBIGSERIAL\s+PRIMARY\s+KEY\b`), "INTEGER PRIMARY KEY AUTOINCREMENT"},
+	{regexp.MustCompile(`(?i)\bTIMESTAMPTZ\b`), "TIMESTAMP"},
+	{regexp.MustCompile(`(?i)\bJSONB\b`), "TEXT"},
+	{regexp.MustCompile(`(?i)\bBYTEA\b`), "BLOB"},
+	// SQLite serialises writers, so row locks are unnecessary (and unsupported).
+	{regexp.MustCompile(`(?i)\s+FOR\s+UPDATE\b`), ""},
+	{regexp.MustCompile(`(?i)\bNOW\(\)`), "CURRENT_TIMESTAMP"},
+	{regexp.MustCompile(`(?i)\bILIKE\b`), "LIKE"},
+	{regexp.MustCompile(`(?i)\bdate\(to_timestamp\(([\w."]+)\)\)`), "date($1, 'unixepoch', 'localtime')"},
+	{regexp.MustCompile(`(?i)\bADD\s+COLUMN\s+IF\s+NOT\s+EXISTS\b`), "ADD COLUMN"},
+	// SQL-standard position('x' in col) / substring(col from a [for b]) only exist as functions in SQLite.
+	{regexp.MustCompile(`(?i)\bposition\(('[^']*') in ([\w."]+)\)`), "instr($2, $1)"},
+	{regexp.MustCompile(`(?i)\bsubstring\(([\w."]+) from ((?:[^()]|\([^()]*\))+?) for ((?:[^()]|\([^()]*\))+?)\)`), "substr($1, $2, $3)"},
+	{regexp.MustCompile(`(?i)\bsubstring\(([\w."]+) from ((?:[^()]|\([^()]*\))+?)\)`), "substr($1, $2)"},
+}
+
+// sqliteKeywords is a cheap pre-check so that the common queries skip the regexp pass.
+var sqliteKeywords = []string{"BIGSERIAL", "TIMESTAMPTZ", "JSONB", "BYTEA", "FOR UPDATE", "NOW()", "ILIKE", "TO_TIMESTAMP", "IF NOT EXISTS", "POSITION(", "SUBSTRING("}
+
+// TranslateSQLite rewrites the PostgreSQL-specific constructs used by the repository
+// into their SQLite equivalents. Everything else (ON CONFLICT upserts, RETURNING,
+// window functions, $N placeholders) is understood by SQLite as is.
+func TranslateSQLite(query string) string {
+	upper := strings.ToUpper(query)
+	matched := false
+	for _, keyword := range sqliteKeywords {
+		if strings.Contains(upper, keyword) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return query
+	}
+	for _, rewrite := range sqliteRewrites {
+		query = rewrite.pattern.ReplaceAllString(query, rewrite.replace)
+	}
+	return query
+}
@@ -0,0 +1,59 @@
+package sqlutil
+
+import "testing"
+
+func TestTranslateSQLite(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "untouched",
+			query: `SELECT id FROM "t" WHERE ip = $1`,
+			want:  `SELECT id FROM "t" WHERE ip = $1`,
+		},
+		{
+			name:  "serial and timestamptz",
+			query: `CREATE TABLE t (id BIGSERIAL PRIMARY KEY, created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(), metadata JSONB)`,
+			want:  `CREATE TABLE t (id INTEGER PRIMARY KEY AUTOINCREMENT, created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, metadata TEXT)`,
+		},
+		{
+			name:  "day bucket",
+			query: `SELECT date(to_timestamp(start_ts)) AS day FROM s`,
+			want:  `SELECT date(start_ts, 'unixepoch', 'localtime') AS day FROM s`,
+		},
+		{
+			name:  "ilike",
+			query: `WHERE f.ip ILIKE ?`,
+			want:  `WHERE f.ip LIKE ?`,
+		},
+		{
+			name:  "substring prefix",
+			query: `CASE WHEN position('·' in loc.domestic) > 0 THEN substring(loc.domestic from 1 for position('·' in loc.domestic) - 1) ELSE loc.domestic END`,
+			want:  `CASE WHEN instr(loc.domestic, '·') > 0 THEN substr(loc.domestic, 1, instr(loc.domestic, '·') - 1) ELSE loc.domestic END`,
+		},
+		{
+			name:  "substring suffix",
+			query: `substring(loc.domestic from position('·' in loc.domestic) + 1)`,
+			want:  `substr(loc.domestic, instr(loc.domestic, '·') + 1)`,
+		},
+		{
+			name:  "row lock and bytea",
+			query: `CREATE TABLE h (day DATE PRIMARY KEY, sketch BYTEA); SELECT sketch FROM "h" WHERE day = $1 FOR UPDATE`,
+			want:  `CREATE TABLE h (day DATE PRIMARY KEY, sketch BLOB); SELECT sketch FROM "h" WHERE day = $1`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := TranslateSQLite(tt.query); got != tt.want {
+				t.Fatalf("TranslateSQLite() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
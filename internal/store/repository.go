@@ -1,10 +1,13 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
@@ -30,6 +33,8 @@ type NginxLogRecord struct {
 	UserDevice       string    `json:"user_device"`
 	DomesticLocation string    `json:"domestic_location"`
 	GlobalLocation   string    `json:"global_location"`
+	// SampleRate 为 agent 边缘采样倍率（1/N 采样时为 N），写入聚合时按该倍率放大计数。
+	SampleRate int `json:"sample_rate,omitempty"`
 }
 
 type IPGeoAPIFailure struct {
@@ -97,6 +102,9 @@ func sanitizeLogRecord(log NginxLogRecord) NginxLogRecord {
 	log.UserDevice = sanitizeAndTruncate(log.UserDevice, maxUABytes)
 	log.DomesticLocation = sanitizeUTF8(log.DomesticLocation)
 	log.GlobalLocation = sanitizeUTF8(log.GlobalLocation)
+	if log.SampleRate < 1 {
+		log.SampleRate = 1
+	}
 	return log
 }
 
@@ -107,18 +115,59 @@ type IPGeoCacheEntry struct {
 }
 
 type Repository struct {
-	db *sql.DB
+	db       *sql.DB
+	replicas *replicaPool // 未配置 database.readDSN 时为 nil
+	// statementTimeout 为统计查询的超时时间，0 表示只受请求上下文限制
+	statementTimeout time.Duration
+
+	partitionMu    sync.Mutex
+	partitionCache map[string]*logPartitionSet
+
+	// migrationPending 为有尚未执行的回填迁移的站点，见 MigrationPending
+	migrationMu      sync.RWMutex
+	migrationPending map[string]bool
+	migrationRunMu   sync.Mutex // 串行执行 RunPendingMigrations
 }
 
 func NewRepository() (*Repository, error) {
 	cfg := config.ReadConfig()
-	db, err := openPostgres(cfg.Database)
+	var (
+		db  *sql.DB
+		err error
+	)
+	switch strings.TrimSpace(cfg.Database.Driver) {
+	case "sqlite":
+		sqlutil.SetDialect(sqlutil.DialectSQLite)
+		db, err = openSQLite(cfg.Database)
+	default:
+		sqlutil.SetDialect(sqlutil.DialectPostgres)
+		db, err = openPostgres(cfg.Database)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	var replicas *replicaPool
+	if strings.TrimSpace(cfg.Database.Driver) != "sqlite" && len(cfg.Database.ReadDSN) > 0 {
+		if replicas, err = openReplicaPool(cfg.Database); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	var statementTimeout time.Duration
+	if raw := strings.TrimSpace(cfg.Database.StatementTimeout); raw != "" {
+		if statementTimeout, err = time.ParseDuration(raw); err != nil {
+			logrus.WithError(err).Warn("无效的统计查询超时配置，已忽略")
+			statementTimeout = 0
+		}
+	}
+
 	return &Repository{
-		db: db,
+		db:               db,
+		replicas:         replicas,
+		statementTimeout: statementTimeout,
+		partitionCache:   make(map[string]*logPartitionSet),
 	}, nil
 }
 
@@ -127,7 +176,7 @@ func openPostgres(cfg config.DatabaseConfig) (*sql.DB, error) {
 		cfg.Driver = "postgres"
 	}
 	if cfg.Driver != "postgres" {
-		return nil, fmt.Errorf("仅支持 postgres 驱动，当前为: %s", cfg.Driver)
+		return nil, fmt.Errorf("仅支持 postgres 或 sqlite 驱动，当前为: %s", cfg.Driver)
 	}
 	if strings.TrimSpace(cfg.DSN) == "" {
 		return nil, fmt.Errorf("数据库 DSN 不能为空")
@@ -138,6 +187,17 @@ func openPostgres(cfg config.DatabaseConfig) (*sql.DB, error) {
 		return nil, fmt.Errorf("解析数据库 DSN 失败: %w", err)
 	}
 
+	db := openPostgresPool(pgConfig, cfg)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// openPostgresPool 按连接池配置打开连接（不立即建立连接），主库与只读副本共用
+func openPostgresPool(pgConfig *pgx.ConnConfig, cfg config.DatabaseConfig) *sql.DB {
 	db := stdlib.OpenDB(*pgConfig)
 	if cfg.MaxOpenConns > 0 {
 		db.SetMaxOpenConns(cfg.MaxOpenConns)
@@ -152,23 +212,46 @@ func openPostgres(cfg config.DatabaseConfig) (*sql.DB, error) {
 			logrus.WithError(err).Warn("无效的数据库连接最大生命周期配置，已忽略")
 		}
 	}
+	return db
+}
 
-	if err := db.Ping(); err != nil {
-		db.Close()
-		return nil, err
+// 初始化数据库：执行待执行的结构迁移。需要回填已有日志的迁移不在启动时执行（避免长时间回填导致启动超时），
+// 对应站点暂停写入，由 system.autoMigrate 在服务开始监听后执行（RunPendingMigrations），或通过 nginxpulse -migrate 执行。
+// 多个实例共用同一个数据库时，迁移按实例依次执行。
+func (r *Repository) Init() error {
+	unlock, err := r.lockMigrations()
+	if err != nil {
+		return err
 	}
-
-	return db, nil
+	defer unlock()
+	_, err = r.Migrate(false)
+	if errors.Is(err, ErrMigrationRequired) {
+		logrus.Warn(err.Error())
+		return nil
+	}
+	return err
 }
 
-// 初始化数据库
-func (r *Repository) Init() error {
-	return r.createTables()
+// InitWebsites 在配置热加载时为新增站点执行结构迁移（建表），此时新配置尚未生效
+func (r *Repository) InitWebsites(websiteIDs []string, allowBackfill bool) error {
+	if len(websiteIDs) == 0 {
+		return nil
+	}
+	unlock, err := r.lockMigrations()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	_, err = r.MigrateWebsites(websiteIDs, allowBackfill)
+	return err
 }
 
 // 关闭数据库连接
 func (r *Repository) Close() error {
 	logrus.Info("关闭数据库")
+	if r.replicas != nil {
+		r.replicas.close()
+	}
 	if r.db != nil {
 		return r.db.Close()
 	}
@@ -180,6 +263,25 @@ func (r *Repository) GetDB() *sql.DB {
 	return r.db
 }
 
+// ReadDB 返回统计查询使用的连接：配置了只读副本时轮询选择健康的副本，全部不可用时回退到主库
+func (r *Repository) ReadDB() *sql.DB {
+	if r.replicas != nil {
+		if db := r.replicas.pick(); db != nil {
+			return db
+		}
+	}
+	return r.db
+}
+
+// WithStatementTimeout 为统计查询附加 database.statementTimeout 超时；上下文取消或超时后，
+// 正在执行的查询会在数据库端一并取消
+func (r *Repository) WithStatementTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.statementTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.statementTimeout)
+}
+
 func (r *Repository) GetIPGeoCache(ips []string) (map[string]IPGeoCacheEntry, error) {
 	results := make(map[string]IPGeoCacheEntry)
 	if len(ips) == 0 {
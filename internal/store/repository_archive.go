@@ -0,0 +1,152 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+)
+
+// LogArchiver 在保留期清理删除站点原始日志之前调用，需把 timestamp < cutoff 的日志全部归档；
+// 返回错误时本轮不删除该站点的原始日志。
+type LogArchiver func(websiteID string, cutoff time.Time) error
+
+// ExportLogs 按时间顺序遍历 [from, to)（Unix 秒）内的原始日志，维度值已还原为原文
+func (r *Repository) ExportLogs(websiteID string, from, to int64, fn func(NginxLogRecord) error) error {
+	rows, err := r.db.Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`SELECT l.id, ip.ip, l.pageview_flag, l.timestamp, l.method, url.url,
+                l.status_code, l.bytes_sent, ref.referer, ua.browser, ua.os, ua.device,
+                loc.domestic, loc.global, l.sample_rate
+         FROM "%[1]s_nginx_logs" l
+         JOIN "%[1]s_dim_ip" ip ON ip.id = l.ip_id
+         JOIN "%[1]s_dim_url" url ON url.id = l.url_id
+         JOIN "%[1]s_dim_referer" ref ON ref.id = l.referer_id
+         JOIN "%[1]s_dim_ua" ua ON ua.id = l.ua_id
+         JOIN "%[1]s_dim_location" loc ON loc.id = l.location_id
+         WHERE l.timestamp >= ? AND l.timestamp < ?
+         ORDER BY l.timestamp, l.id`,
+		websiteID,
+	)), from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			log NginxLogRecord
+			ts  int64
+		)
+		if err := rows.Scan(
+			&log.ID, &log.IP, &log.PageviewFlag, &ts, &log.Method, &log.Url,
+			&log.Status, &log.BytesSent, &log.Referer, &log.UserBrowser, &log.UserOs, &log.UserDevice,
+			&log.DomesticLocation, &log.GlobalLocation, &log.SampleRate,
+		); err != nil {
+			return err
+		}
+		log.Timestamp = time.Unix(ts, 0)
+		if err := fn(log); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// HasLogsBetween 判断站点在 [from, to)（Unix 秒）内是否还有原始日志
+func (r *Repository) HasLogsBetween(websiteID string, from, to int64) (bool, error) {
+	var value int
+	err := r.db.QueryRow(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`SELECT 1 FROM "%s_nginx_logs" WHERE timestamp >= ? AND timestamp < ? LIMIT 1`,
+		websiteID,
+	)), from, to).Scan(&value)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// InsertArchivedLogs 把归档中的日志重新写回原始日志表，只补齐维表，不更新聚合、首次访问与会话：
+// 这些数据的保留期更长，重新累加会重复计数。导入的日志仍早于保留期，会在下次清理时再次删除。
+func (r *Repository) InsertArchivedLogs(websiteID string, logs []NginxLogRecord) (err error) {
+	if len(logs) == 0 {
+		return nil
+	}
+	if pending, _ := r.RefreshMigrationPending(websiteID); pending {
+		return fmt.Errorf("站点 %s: %w", websiteID, ErrMigrationPending)
+	}
+	if err := r.ensureLogPartitionsForLogs(websiteID, logs); err != nil {
+		return err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	dims, err := prepareDimStatements(tx, websiteID)
+	if err != nil {
+		return err
+	}
+	defer dims.Close()
+
+	cache := newDimCaches()
+	logRows := make([]logInsertRow, 0, len(logs))
+	for _, log := range logs {
+		log = sanitizeLogRecord(log)
+
+		ipID, err := getOrCreateDimID(cache.ip, dims.insertIP, dims.selectIP, log.IP, log.IP)
+		if err != nil {
+			return err
+		}
+		urlID, err := getOrCreateDimID(cache.url, dims.insertURL, dims.selectURL, log.Url, log.Url)
+		if err != nil {
+			return err
+		}
+		refererID, err := getOrCreateDimID(
+			cache.referer, dims.insertReferer, dims.selectReferer, log.Referer, log.Referer,
+		)
+		if err != nil {
+			return err
+		}
+		uaID, err := getOrCreateDimID(
+			cache.ua, dims.insertUA, dims.selectUA, uaCacheKey(log.UserBrowser, log.UserOs, log.UserDevice),
+			log.UserBrowser, log.UserOs, log.UserDevice,
+		)
+		if err != nil {
+			return err
+		}
+		locationID, err := getOrCreateDimID(
+			cache.location, dims.insertLocation, dims.selectLocation,
+			locationCacheKey(log.DomesticLocation, log.GlobalLocation),
+			log.DomesticLocation, log.GlobalLocation,
+		)
+		if err != nil {
+			return err
+		}
+
+		logRows = append(logRows, logInsertRow{
+			ipID:         ipID,
+			pageviewFlag: log.PageviewFlag,
+			timestamp:    log.Timestamp.Unix(),
+			method:       log.Method,
+			urlID:        urlID,
+			statusCode:   log.Status,
+			bytesSent:    log.BytesSent,
+			refererID:    refererID,
+			uaID:         uaID,
+			locationID:   locationID,
+			sampleRate:   log.SampleRate,
+		})
+	}
+
+	if err := bulkInsertLogRows(tx, fmt.Sprintf("%s_nginx_logs", websiteID), logRows); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
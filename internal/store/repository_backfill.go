@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/likaia/nginxpulse/internal/config"
 	"github.com/likaia/nginxpulse/internal/sqlutil"
 	"github.com/sirupsen/logrus"
 )
@@ -127,13 +128,13 @@ func (r *Repository) backfillAggregates(websiteID string) error {
 		`INSERT INTO "%s" (bucket, pv, traffic, s2xx, s3xx, s4xx, s5xx, other)
          SELECT
              (timestamp / 3600) * 3600 AS bucket,
-             SUM(CASE WHEN pageview_flag = 1 THEN 1 ELSE 0 END) AS pv,
-             SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent ELSE 0 END) AS traffic,
-             SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN 1 ELSE 0 END) AS s2xx,
-             SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN 1 ELSE 0 END) AS s3xx,
-             SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN 1 ELSE 0 END) AS s4xx,
-             SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN 1 ELSE 0 END) AS s5xx,
-             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN 1 ELSE 0 END) AS other
+             SUM(CASE WHEN pageview_flag = 1 THEN sample_rate ELSE 0 END) AS pv,
+             SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent * sample_rate ELSE 0 END) AS traffic,
+             SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN sample_rate ELSE 0 END) AS s2xx,
+             SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN sample_rate ELSE 0 END) AS s3xx,
+             SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN sample_rate ELSE 0 END) AS s4xx,
+             SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN sample_rate ELSE 0 END) AS s5xx,
+             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN sample_rate ELSE 0 END) AS other
          FROM "%s"
          GROUP BY bucket`, aggHourly, logTable,
 	)); err != nil {
@@ -157,13 +158,13 @@ func (r *Repository) backfillAggregates(websiteID string) error {
 		`INSERT INTO "%s" (day, pv, traffic, s2xx, s3xx, s4xx, s5xx, other)
          SELECT
              date(to_timestamp(timestamp)) AS day,
-             SUM(CASE WHEN pageview_flag = 1 THEN 1 ELSE 0 END) AS pv,
-             SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent ELSE 0 END) AS traffic,
-             SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN 1 ELSE 0 END) AS s2xx,
-             SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN 1 ELSE 0 END) AS s3xx,
-             SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN 1 ELSE 0 END) AS s4xx,
-             SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN 1 ELSE 0 END) AS s5xx,
-             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN 1 ELSE 0 END) AS other
+             SUM(CASE WHEN pageview_flag = 1 THEN sample_rate ELSE 0 END) AS pv,
+             SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent * sample_rate ELSE 0 END) AS traffic,
+             SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN sample_rate ELSE 0 END) AS s2xx,
+             SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN sample_rate ELSE 0 END) AS s3xx,
+             SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN sample_rate ELSE 0 END) AS s4xx,
+             SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN sample_rate ELSE 0 END) AS s5xx,
+             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN sample_rate ELSE 0 END) AS other
          FROM "%s"
          GROUP BY day`, aggDaily, logTable,
 	)); err != nil {
@@ -253,8 +254,42 @@ func (r *Repository) backfillSessions(websiteID string) error {
 		return err
 	}
 
+	if _, err = tx.Exec(sessionInsertSQL(sessionTable, fmt.Sprintf(
+		`pv AS (
+            SELECT id, ip_id, ua_id, location_id, url_id, timestamp
+            FROM "%s"
+            WHERE pageview_flag = 1
+        )`, logTable,
+	))); err != nil {
+		return err
+	}
+
 	if _, err = tx.Exec(fmt.Sprintf(
-		`WITH ordered AS (
+		`INSERT INTO "%s" (ip_id, ua_id, session_id, last_ts)
+         SELECT ip_id, ua_id, id, end_ts
+         FROM "%s"
+         ORDER BY end_ts
+         ON CONFLICT(ip_id, ua_id) DO UPDATE SET
+             session_id = excluded.session_id,
+             last_ts = excluded.last_ts`,
+		stateTable, sessionTable,
+	)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logrus.WithField("website", websiteID).Info("会话数据回填完成")
+	return nil
+}
+
+// sessionInsertSQL 按 30 分钟间隔把 pvCTE 中名为 pv 的 PV 日志切分为会话并写入 sessionTable
+func sessionInsertSQL(sessionTable, pvCTE string) string {
+	return fmt.Sprintf(
+		`WITH %s,
+        ordered AS (
             SELECT id, ip_id, ua_id, location_id, url_id, timestamp,
                    CASE
                        WHEN LAG(timestamp) OVER (
@@ -265,8 +300,7 @@ func (r *Repository) backfillSessions(websiteID string) error {
                        ) > %d THEN 1
                        ELSE 0
                    END AS new_session
-            FROM "%s"
-            WHERE pageview_flag = 1
+            FROM pv
         ),
         sessions AS (
             SELECT *,
@@ -298,30 +332,93 @@ func (r *Repository) backfillSessions(websiteID string) error {
             COUNT(*) AS page_count
         FROM ranked
         GROUP BY ip_id, ua_id, session_no`,
-		sessionGapSeconds, logTable, sessionTable,
-	)); err != nil {
+		pvCTE, sessionGapSeconds, sessionTable,
+	)
+}
+
+// rebuildSessionsForRange 在 [start, end) 内的 pageview_flag 变化后只重建受影响的会话：
+// 与该范围相距不超过会话间隔的旧会话连同范围内的 PV 一起按访客重新切分，
+// 范围外更远的会话不会因此合并或拆分，保持不变
+func (r *Repository) rebuildSessionsForRange(websiteID string, start, end time.Time) error {
+	logTable := fmt.Sprintf("%s_nginx_logs", websiteID)
+	sessionTable := fmt.Sprintf("%s_sessions", websiteID)
+	stateTable := fmt.Sprintf("%s_session_state", websiteID)
+
+	touchStart := start.Unix() - sessionGapSeconds
+	touchEnd := end.Unix() + sessionGapSeconds
+
+	tx, err := r.db.Begin()
+	if err != nil {
 		return err
 	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
 
-	if _, err = tx.Exec(fmt.Sprintf(
-		`INSERT INTO "%s" (ip_id, ua_id, session_id, last_ts)
+	var maxOldID int64
+	if err = tx.QueryRow(fmt.Sprintf(`SELECT COALESCE(MAX(id), 0) FROM "%s"`, sessionTable)).Scan(&maxOldID); err != nil {
+		return err
+	}
+
+	// 每个访客的重建区间取受影响旧会话与范围内 PV 的并集；区间外的 PV 与区间相隔超过会话间隔，不影响切分
+	if _, err = tx.Exec(sqlutil.ReplacePlaceholders(sessionInsertSQL(sessionTable, fmt.Sprintf(
+		`touched AS (
+            SELECT ip_id, ua_id, start_ts AS lo, end_ts AS hi
+            FROM "%[1]s"
+            WHERE end_ts >= ? AND start_ts < ?
+            UNION ALL
+            SELECT ip_id, ua_id, CAST(? AS BIGINT) AS lo, CAST(? AS BIGINT) AS hi
+            FROM "%[2]s"
+            WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ?
+            GROUP BY ip_id, ua_id
+        ),
+        bounds AS (
+            SELECT ip_id, ua_id, MIN(lo) AS lo, MAX(hi) AS hi
+            FROM touched
+            GROUP BY ip_id, ua_id
+        ),
+        pv AS (
+            SELECT l.id, l.ip_id, l.ua_id, l.location_id, l.url_id, l.timestamp
+            FROM "%[2]s" l
+            JOIN bounds b ON b.ip_id = l.ip_id AND b.ua_id = l.ua_id
+            WHERE l.pageview_flag = 1 AND l.timestamp >= b.lo AND l.timestamp <= b.hi
+        )`, sessionTable, logTable,
+	))), touchStart, touchEnd, start.Unix(), end.Unix()-1, start.Unix(), end.Unix()); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`DELETE FROM "%s" WHERE session_id IN (
+             SELECT id FROM "%s" WHERE id <= ? AND end_ts >= ? AND start_ts < ?
+         )`, stateTable, sessionTable,
+	)), maxOldID, touchStart, touchEnd); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`DELETE FROM "%s" WHERE id <= ? AND end_ts >= ? AND start_ts < ?`, sessionTable,
+	)), maxOldID, touchStart, touchEnd); err != nil {
+		return err
+	}
+
+	// 访客在范围之后已有更新的会话时保留其状态
+	if _, err = tx.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`INSERT INTO "%[1]s" (ip_id, ua_id, session_id, last_ts)
          SELECT ip_id, ua_id, id, end_ts
-         FROM "%s"
+         FROM "%[2]s"
+         WHERE id > ?
          ORDER BY end_ts
          ON CONFLICT(ip_id, ua_id) DO UPDATE SET
              session_id = excluded.session_id,
-             last_ts = excluded.last_ts`,
+             last_ts = excluded.last_ts
+         WHERE "%[1]s".last_ts <= excluded.last_ts`,
 		stateTable, sessionTable,
-	)); err != nil {
+	)), maxOldID); err != nil {
 		return err
 	}
 
-	if err := tx.Commit(); err != nil {
-		return err
-	}
-
-	logrus.WithField("website", websiteID).Info("会话数据回填完成")
-	return nil
+	return tx.Commit()
 }
 
 func (r *Repository) backfillSessionAggregates(websiteID string) error {
@@ -379,7 +476,31 @@ func (r *Repository) backfillSessionAggregates(websiteID string) error {
 	return nil
 }
 
-func (r *Repository) cleanupAggregates(websiteID string, cutoff time.Time) error {
+// retentionCutoffs 为分层保留的截止时间：原始日志/会话明细、小时聚合、日聚合（含会话聚合）与精确 UV 的 IP 集合
+type retentionCutoffs struct {
+	raw     time.Time
+	hourly  time.Time
+	daily   time.Time
+	uvExact time.Time
+}
+
+func newRetentionCutoffs(now time.Time, policy config.RetentionPolicy) retentionCutoffs {
+	return retentionCutoffs{
+		raw:     now.AddDate(0, 0, -policy.RawDays),
+		hourly:  now.AddDate(0, 0, -policy.HourlyDays),
+		daily:   now.AddDate(0, 0, -policy.DailyDays),
+		uvExact: now.AddDate(0, 0, -policy.UVExactDays),
+	}
+}
+
+// ipSetsCoverDaily 判断 IP 集合是否与日聚合保留同样久（exact 口径）
+func (c retentionCutoffs) ipSetsCoverDaily() bool {
+	return !c.uvExact.After(c.daily)
+}
+
+// cleanupAggregates 按各层保留天数清理聚合表，返回是否删除了数据。
+// 与原始日志同时过期的层级需按剩余日志重算边界桶；保留更久的层级整桶保留，不能再用日志重算。
+func (r *Repository) cleanupAggregates(websiteID string, cutoffs retentionCutoffs, rawDeleted bool) (bool, error) {
 	aggHourly := fmt.Sprintf("%s_agg_hourly", websiteID)
 	aggHourlyIP := fmt.Sprintf("%s_agg_hourly_ip", websiteID)
 	aggDaily := fmt.Sprintf("%s_agg_daily", websiteID)
@@ -387,44 +508,120 @@ func (r *Repository) cleanupAggregates(websiteID string, cutoff time.Time) error
 
 	hasAgg, err := r.tableExists(aggHourly)
 	if err != nil || !hasAgg {
-		return err
+		return false, err
 	}
 
-	cutoffHour := hourBucket(cutoff)
-	cutoffDay := dayBucket(cutoff)
+	cutoffHour := hourBucket(cutoffs.hourly)
+	cutoffDay := dayBucket(cutoffs.daily)
+	// approx 口径下 IP 集合只保留到原始日志的截止时间，UV 由草图保留到聚合的截止时间
+	ipCutoffHour := max(cutoffHour, hourBucket(cutoffs.uvExact))
+	ipCutoffDay := max(cutoffDay, dayBucket(cutoffs.uvExact))
 
-	if _, err := r.db.Exec(
-		sqlutil.ReplacePlaceholders(fmt.Sprintf(`DELETE FROM "%s" WHERE bucket < ?`, aggHourly)),
-		cutoffHour,
-	); err != nil {
-		return err
+	type aggDelete struct {
+		query string
+		arg   interface{}
 	}
-	if _, err := r.db.Exec(
-		sqlutil.ReplacePlaceholders(fmt.Sprintf(`DELETE FROM "%s" WHERE bucket < ?`, aggHourlyIP)),
-		cutoffHour,
-	); err != nil {
-		return err
+	var deleted int64
+	deletes := []aggDelete{
+		{query: fmt.Sprintf(`DELETE FROM "%s" WHERE bucket < ?`, aggHourly), arg: cutoffHour},
+		{query: fmt.Sprintf(`DELETE FROM "%s" WHERE bucket < ?`, aggHourlyIP), arg: ipCutoffHour},
+		{query: fmt.Sprintf(`DELETE FROM "%s" WHERE day < ?`, aggDaily), arg: cutoffDay},
+		{query: fmt.Sprintf(`DELETE FROM "%s" WHERE day < ?`, aggDailyIP), arg: ipCutoffDay},
 	}
-	if _, err := r.db.Exec(
-		sqlutil.ReplacePlaceholders(fmt.Sprintf(`DELETE FROM "%s" WHERE day < ?`, aggDaily)),
-		cutoffDay,
-	); err != nil {
-		return err
+	hasSketches, err := r.tableExists(fmt.Sprintf("%s_agg_daily_hll", websiteID))
+	if err != nil {
+		return false, err
 	}
-	if _, err := r.db.Exec(
-		sqlutil.ReplacePlaceholders(fmt.Sprintf(`DELETE FROM "%s" WHERE day < ?`, aggDailyIP)),
-		cutoffDay,
-	); err != nil {
-		return err
+	if hasSketches {
+		deletes = append(deletes,
+			aggDelete{query: fmt.Sprintf(`DELETE FROM "%s_agg_hourly_hll" WHERE bucket < ?`, websiteID), arg: cutoffHour},
+			aggDelete{query: fmt.Sprintf(`DELETE FROM "%s_agg_daily_hll" WHERE day < ?`, websiteID), arg: cutoffDay},
+		)
+	}
+	hasDimAgg, err := r.tableExists(fmt.Sprintf("%s_agg_dim_daily", websiteID))
+	if err != nil {
+		return false, err
+	}
+	if hasDimAgg {
+		// 按 kind 逐个删除，以便走 (kind, day, ...) 主键
+		for _, item := range dimAggKinds {
+			for _, table := range []string{"agg_dim_daily", "agg_dim_daily_ip", "agg_dim_daily_hll"} {
+				if table == "agg_dim_daily_hll" && !hasSketches {
+					continue
+				}
+				arg := cutoffDay
+				if table == "agg_dim_daily_ip" {
+					arg = ipCutoffDay
+				}
+				deletes = append(deletes, aggDelete{
+					query: fmt.Sprintf(`DELETE FROM "%s_%s" WHERE kind = %d AND day < ?`, websiteID, table, item.kind),
+					arg:   arg,
+				})
+			}
+		}
+	}
+	for _, item := range deletes {
+		result, err := r.db.Exec(sqlutil.ReplacePlaceholders(item.query), item.arg)
+		if err != nil {
+			return false, err
+		}
+		count, _ := result.RowsAffected()
+		deleted += count
+	}
+
+	dailyWithRaw := cutoffs.daily.Equal(cutoffs.raw)
+	if rawDeleted && cutoffs.hourly.Equal(cutoffs.raw) {
+		if err := r.rebuildHourlyAggregate(websiteID, cutoffHour); err != nil {
+			return false, err
+		}
+	}
+	if rawDeleted && dailyWithRaw {
+		if err := r.rebuildDailyAggregate(websiteID, cutoffDay); err != nil {
+			return false, err
+		}
 	}
 
-	if err := r.rebuildHourlyAggregate(websiteID, cutoffHour); err != nil {
+	sessionDeleted, err := r.cleanupSessionAggregates(websiteID, cutoffs.daily, rawDeleted && dailyWithRaw)
+	if err != nil {
+		return false, err
+	}
+
+	// 首次访问时间需覆盖日聚合的整个保留期，日聚合保留更久时只剔除已完全过期的 IP
+	if dailyWithRaw {
+		if rawDeleted {
+			if err := r.rebuildFirstSeen(websiteID); err != nil {
+				return false, err
+			}
+		}
+	} else if deleted > 0 {
+		if err := r.pruneFirstSeen(websiteID, cutoffs); err != nil {
+			return false, err
+		}
+	}
+	return deleted > 0 || sessionDeleted, nil
+}
+
+// pruneFirstSeen 剔除已不在日聚合保留期内出现的 IP。
+// IP 集合比日聚合保留得短时（approx 口径）无法判断 IP 最后出现的日期，只剔除首次访问早于日聚合截止时间、
+// 且近期没有再出现的 IP
+func (r *Repository) pruneFirstSeen(websiteID string, cutoffs retentionCutoffs) error {
+	table := fmt.Sprintf("%s_first_seen", websiteID)
+	exists, err := r.tableExists(table)
+	if err != nil || !exists {
 		return err
 	}
-	if err := r.rebuildDailyAggregate(websiteID, cutoffDay); err != nil {
+	if cutoffs.ipSetsCoverDaily() {
+		_, err = r.db.Exec(fmt.Sprintf(
+			`DELETE FROM "%s" WHERE ip_id NOT IN (SELECT ip_id FROM "%s_agg_daily_ip")`,
+			table, websiteID,
+		))
 		return err
 	}
-	return r.rebuildFirstSeen(websiteID)
+	_, err = r.db.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`DELETE FROM "%s" WHERE first_ts < ? AND ip_id NOT IN (SELECT ip_id FROM "%s_agg_daily_ip")`,
+		table, websiteID,
+	)), cutoffs.daily.Unix())
+	return err
 }
 
 func (r *Repository) cleanupSessions(websiteID string, cutoff time.Time) error {
@@ -451,7 +648,7 @@ func (r *Repository) cleanupSessions(websiteID string, cutoff time.Time) error {
 	if _, err := r.db.Exec(fmt.Sprintf(`DELETE FROM "%s"`, stateTable)); err != nil {
 		return err
 	}
-	if _, err := r.db.Exec(fmt.Sprintf(
+	_, err = r.db.Exec(fmt.Sprintf(
 		`INSERT INTO "%s" (ip_id, ua_id, session_id, last_ts)
          SELECT ip_id, ua_id, id, end_ts
          FROM "%s"
@@ -460,45 +657,53 @@ func (r *Repository) cleanupSessions(websiteID string, cutoff time.Time) error {
              session_id = excluded.session_id,
              last_ts = excluded.last_ts`,
 		stateTable, sessionTable,
-	)); err != nil {
-		return err
-	}
-
-	return r.cleanupSessionAggregates(websiteID, cutoff)
+	))
+	return err
 }
 
-func (r *Repository) cleanupSessionAggregates(websiteID string, cutoff time.Time) error {
+// cleanupSessionAggregates 清理会话聚合；rebuild 为 true 时按剩余的会话明细重算边界日。
+func (r *Repository) cleanupSessionAggregates(websiteID string, cutoff time.Time, rebuild bool) (bool, error) {
 	dailyTable := fmt.Sprintf("%s_agg_session_daily", websiteID)
 	entryTable := fmt.Sprintf("%s_agg_entry_daily", websiteID)
 
 	hasDaily, err := r.tableExists(dailyTable)
 	if err != nil || !hasDaily {
-		return err
+		return false, err
 	}
 
 	cutoffDay := dayBucket(cutoff)
 
-	if _, err := r.db.Exec(
+	result, err := r.db.Exec(
 		sqlutil.ReplacePlaceholders(fmt.Sprintf(`DELETE FROM "%s" WHERE day < ?`, dailyTable)),
 		cutoffDay,
-	); err != nil {
-		return err
+	)
+	if err != nil {
+		return false, err
 	}
+	deleted, _ := result.RowsAffected()
 
 	hasEntry, err := r.tableExists(entryTable)
 	if err != nil {
-		return err
+		return false, err
 	}
 	if hasEntry {
-		if _, err := r.db.Exec(
+		result, err := r.db.Exec(
 			sqlutil.ReplacePlaceholders(fmt.Sprintf(`DELETE FROM "%s" WHERE day < ?`, entryTable)),
 			cutoffDay,
-		); err != nil {
-			return err
+		)
+		if err != nil {
+			return false, err
 		}
+		count, _ := result.RowsAffected()
+		deleted += count
 	}
 
-	return r.rebuildSessionAggregatesForDay(websiteID, cutoffDay)
+	if rebuild {
+		if err := r.rebuildSessionAggregatesForDay(websiteID, cutoffDay); err != nil {
+			return false, err
+		}
+	}
+	return deleted > 0, nil
 }
 
 func (r *Repository) rebuildSessionAggregatesForDay(websiteID, day string) error {
@@ -599,13 +804,13 @@ func (r *Repository) rebuildHourlyAggregate(websiteID string, bucket int64) erro
 		`INSERT INTO "%s" (bucket, pv, traffic, s2xx, s3xx, s4xx, s5xx, other)
          SELECT
              (timestamp / 3600) * 3600 AS bucket,
-             SUM(CASE WHEN pageview_flag = 1 THEN 1 ELSE 0 END) AS pv,
-             SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent ELSE 0 END) AS traffic,
-             SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN 1 ELSE 0 END) AS s2xx,
-             SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN 1 ELSE 0 END) AS s3xx,
-             SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN 1 ELSE 0 END) AS s4xx,
-             SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN 1 ELSE 0 END) AS s5xx,
-             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN 1 ELSE 0 END) AS other
+             SUM(CASE WHEN pageview_flag = 1 THEN sample_rate ELSE 0 END) AS pv,
+             SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent * sample_rate ELSE 0 END) AS traffic,
+             SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN sample_rate ELSE 0 END) AS s2xx,
+             SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN sample_rate ELSE 0 END) AS s3xx,
+             SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN sample_rate ELSE 0 END) AS s4xx,
+             SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN sample_rate ELSE 0 END) AS s5xx,
+             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN sample_rate ELSE 0 END) AS other
          FROM "%s"
          WHERE timestamp >= ? AND timestamp < ?
          GROUP BY bucket`, aggHourly, logTable,
@@ -626,6 +831,16 @@ func (r *Repository) rebuildHourlyAggregate(websiteID string, bucket int64) erro
 		return err
 	}
 
+	hasSketches, err := r.tableExists(fmt.Sprintf("%s_agg_hourly_hll", websiteID))
+	if err != nil {
+		return err
+	}
+	if hasSketches {
+		if err = rebuildSketches(tx, websiteID, hourlyHLLTable, "bucket = ?", bucket); err != nil {
+			return err
+		}
+	}
+
 	return tx.Commit()
 }
 
@@ -667,13 +882,13 @@ func (r *Repository) rebuildDailyAggregate(websiteID string, day string) error {
 		`INSERT INTO "%s" (day, pv, traffic, s2xx, s3xx, s4xx, s5xx, other)
          SELECT
              date(to_timestamp(timestamp)) AS day,
-             SUM(CASE WHEN pageview_flag = 1 THEN 1 ELSE 0 END) AS pv,
-             SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent ELSE 0 END) AS traffic,
-             SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN 1 ELSE 0 END) AS s2xx,
-             SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN 1 ELSE 0 END) AS s3xx,
-             SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN 1 ELSE 0 END) AS s4xx,
-             SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN 1 ELSE 0 END) AS s5xx,
-             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN 1 ELSE 0 END) AS other
+             SUM(CASE WHEN pageview_flag = 1 THEN sample_rate ELSE 0 END) AS pv,
+             SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent * sample_rate ELSE 0 END) AS traffic,
+             SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN sample_rate ELSE 0 END) AS s2xx,
+             SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN sample_rate ELSE 0 END) AS s3xx,
+             SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN sample_rate ELSE 0 END) AS s4xx,
+             SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN sample_rate ELSE 0 END) AS s5xx,
+             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN sample_rate ELSE 0 END) AS other
          FROM "%s"
          WHERE timestamp >= ? AND timestamp < ?
          GROUP BY day`, aggDaily, logTable,
@@ -694,6 +909,31 @@ func (r *Repository) rebuildDailyAggregate(websiteID string, day string) error {
 		return err
 	}
 
+	hasDimAgg, err := r.tableExists(fmt.Sprintf("%s_agg_dim_daily", websiteID))
+	if err != nil {
+		return err
+	}
+	if hasDimAgg {
+		if err = rebuildDimAggregatesForDay(tx, websiteID, day, start, end); err != nil {
+			return err
+		}
+	}
+
+	hasSketches, err := r.tableExists(fmt.Sprintf("%s_agg_daily_hll", websiteID))
+	if err != nil {
+		return err
+	}
+	if hasSketches {
+		if err = rebuildSketches(tx, websiteID, dailyHLLTable, "day = ?", day); err != nil {
+			return err
+		}
+		for _, item := range dimAggKinds {
+			if err = rebuildSketches(tx, websiteID, dimDailyHLLTable, "kind = ? AND day = ?", item.kind, day); err != nil {
+				return err
+			}
+		}
+	}
+
 	return tx.Commit()
 }
 
@@ -703,6 +943,11 @@ func (r *Repository) clearAggregateTablesForWebsite(websiteID string) error {
 		fmt.Sprintf("%s_agg_hourly_ip", websiteID),
 		fmt.Sprintf("%s_agg_daily", websiteID),
 		fmt.Sprintf("%s_agg_daily_ip", websiteID),
+		fmt.Sprintf("%s_agg_dim_daily", websiteID),
+		fmt.Sprintf("%s_agg_dim_daily_ip", websiteID),
+		fmt.Sprintf("%s_agg_hourly_hll", websiteID),
+		fmt.Sprintf("%s_agg_daily_hll", websiteID),
+		fmt.Sprintf("%s_agg_dim_daily_hll", websiteID),
 	}
 	for _, table := range aggTables {
 		exists, err := r.tableExists(table)
@@ -0,0 +1,641 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+	"github.com/sirupsen/logrus"
+)
+
+// 备份列的值类型，决定导出时的 JSON 表示与恢复时的参数类型
+const (
+	BackupKindInt       = "int"
+	BackupKindText      = "text"
+	BackupKindBytes     = "bytes"     // base64
+	BackupKindDate      = "date"      // 2006-01-02
+	BackupKindTimestamp = "timestamp" // RFC3339Nano（UTC）
+)
+
+// backupSerialTables 为带自增 id 的站点表，恢复后需把序列推进到最大 id
+var backupSerialTables = map[string]bool{
+	"dim_ip": true, "dim_url": true, "dim_referer": true, "dim_ua": true, "dim_location": true,
+	"nginx_logs": true, "sessions": true,
+}
+
+const backupMaxParams = 30000
+
+type BackupColumn struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// BackupTable 为备份中的一张表；WebsiteID 为空表示全局表，Name 为去掉站点前缀后的表名
+type BackupTable struct {
+	WebsiteID string         `json:"website_id,omitempty"`
+	Name      string         `json:"name"`
+	Columns   []BackupColumn `json:"columns"`
+	Rows      int64          `json:"rows"`
+}
+
+// BackupRows 逐行读取一张表，Values 返回可直接 JSON 编码的值
+type BackupRows struct {
+	rows    *sql.Rows
+	kinds   []string
+	values  []interface{}
+	targets []interface{}
+	err     error
+}
+
+// BackupRowReader 逐行返回备份中一张表的数据（json.Decoder 以 UseNumber 解出的值），读完返回 io.EOF
+type BackupRowReader interface {
+	Next() ([]interface{}, error)
+	Close() error
+}
+
+// RestoreResult 为恢复备份的统计
+type RestoreResult struct {
+	Tables        int   `json:"tables"`
+	Rows          int64 `json:"rows"`
+	IPGeoCache    int64 `json:"ip_geo_cache"`
+	Notifications int64 `json:"notifications"`
+}
+
+// SchemaVersion 返回当前程序的站点表结构版本（最新一条站点迁移的版本号）
+func SchemaVersion() int {
+	return websiteMigrations[len(websiteMigrations)-1].version
+}
+
+// DumpTables 在同一个只读事务内依次导出站点表、IP 归属地缓存与系统通知，保证各表之间一致；
+// 待查询 IP 与归属地失败记录是临时数据，不导出。
+// full 为 true 时导出全部 IP 归属地缓存与系统通知，否则只导出与所选站点相关的部分。
+func (r *Repository) DumpTables(websiteIDs []string, full bool, fn func(table BackupTable, rows *BackupRows) error) error {
+	opts := &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+	if sqlutil.IsSQLite() {
+		// SQLite 事务本身即为快照，不支持指定隔离级别
+		opts = nil
+	}
+	tx, err := r.db.BeginTx(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, websiteID := range websiteIDs {
+		for _, name := range websiteTables {
+			tableName := fmt.Sprintf("%s_%s", websiteID, name)
+			exists, err := txTableExists(tx, tableName)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+			query := fmt.Sprintf(`SELECT * FROM "%s"`, tableName)
+			if backupSerialTables[name] {
+				query += " ORDER BY id"
+			}
+			if err := dumpTable(tx, BackupTable{WebsiteID: websiteID, Name: name}, query, nil, fn); err != nil {
+				return fmt.Errorf("导出表 %s 失败: %w", tableName, err)
+			}
+		}
+	}
+
+	cacheQuery := `SELECT * FROM "ip_geo_cache"`
+	notificationQuery := `SELECT * FROM "system_notifications" ORDER BY id`
+	var args []interface{}
+	if !full {
+		if len(websiteIDs) == 0 {
+			return tx.Commit()
+		}
+		ipScopes := make([]string, 0, len(websiteIDs))
+		for _, websiteID := range websiteIDs {
+			ipTable := fmt.Sprintf("%s_dim_ip", websiteID)
+			if exists, err := txTableExists(tx, ipTable); err != nil {
+				return err
+			} else if exists {
+				ipScopes = append(ipScopes,
+					fmt.Sprintf(`EXISTS (SELECT 1 FROM "%s" AS ip WHERE ip.ip = c.ip)`, ipTable))
+			}
+		}
+		if len(ipScopes) == 0 {
+			ipScopes = append(ipScopes, "1 = 0")
+		}
+		cacheQuery = `SELECT c.* FROM "ip_geo_cache" AS c WHERE ` + strings.Join(ipScopes, " OR ")
+
+		placeholders := make([]string, len(websiteIDs))
+		for i, websiteID := range websiteIDs {
+			placeholders[i] = "?"
+			args = append(args, websiteID)
+		}
+		notificationQuery = sqlutil.ReplacePlaceholders(fmt.Sprintf(
+			`SELECT * FROM "system_notifications" WHERE %s IN (%s) ORDER BY id`,
+			sqlutil.JSONTextField("metadata", "website_id"), strings.Join(placeholders, ", "),
+		))
+	}
+	if err := dumpTable(tx, BackupTable{Name: "ip_geo_cache"}, cacheQuery, nil, fn); err != nil {
+		return fmt.Errorf("导出 IP 归属地缓存失败: %w", err)
+	}
+	if err := dumpTable(tx, BackupTable{Name: "system_notifications"}, notificationQuery, args, fn); err != nil {
+		return fmt.Errorf("导出系统通知失败: %w", err)
+	}
+	return tx.Commit()
+}
+
+func dumpTable(
+	tx *sql.Tx,
+	table BackupTable,
+	query string,
+	args []interface{},
+	fn func(table BackupTable, rows *BackupRows) error,
+) error {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	table.Columns = make([]BackupColumn, len(columnTypes))
+	reader := &BackupRows{
+		rows:    rows,
+		kinds:   make([]string, len(columnTypes)),
+		values:  make([]interface{}, len(columnTypes)),
+		targets: make([]interface{}, len(columnTypes)),
+	}
+	for i, columnType := range columnTypes {
+		kind := backupColumnKind(columnType.DatabaseTypeName())
+		table.Columns[i] = BackupColumn{Name: columnType.Name(), Kind: kind}
+		reader.kinds[i] = kind
+		reader.targets[i] = &reader.values[i]
+	}
+	if err := fn(table, reader); err != nil {
+		return err
+	}
+	if reader.err != nil {
+		return reader.err
+	}
+	return rows.Err()
+}
+
+// backupColumnKind 按数据库类型名归类，兼容 PostgreSQL（INT8/TIMESTAMPTZ/BYTEA/JSONB）与 SQLite 的声明类型
+func backupColumnKind(typeName string) string {
+	typeName = strings.ToUpper(typeName)
+	switch {
+	case strings.Contains(typeName, "INT"):
+		return BackupKindInt
+	case strings.Contains(typeName, "TIMESTAMP"):
+		return BackupKindTimestamp
+	case typeName == "DATE":
+		return BackupKindDate
+	case typeName == "BYTEA" || typeName == "BLOB":
+		return BackupKindBytes
+	default:
+		return BackupKindText
+	}
+}
+
+func (b *BackupRows) Next() bool {
+	if b.err != nil || !b.rows.Next() {
+		return false
+	}
+	if b.err = b.rows.Scan(b.targets...); b.err != nil {
+		return false
+	}
+	return true
+}
+
+// Values 返回当前行，时间统一为 UTC 文本，二进制由 encoding/json 编码为 base64
+func (b *BackupRows) Values() []interface{} {
+	out := make([]interface{}, len(b.values))
+	for i, value := range b.values {
+		if value == nil {
+			continue
+		}
+		switch b.kinds[i] {
+		case BackupKindDate:
+			switch v := value.(type) {
+			case time.Time:
+				out[i] = v.Format("2006-01-02")
+			default:
+				text := backupText(v)
+				if len(text) > 10 {
+					text = text[:10]
+				}
+				out[i] = text
+			}
+		case BackupKindTimestamp:
+			switch v := value.(type) {
+			case time.Time:
+				out[i] = v.UTC().Format(time.RFC3339Nano)
+			default:
+				out[i] = backupText(v)
+			}
+		case BackupKindBytes:
+			switch v := value.(type) {
+			case []byte:
+				out[i] = v
+			default:
+				out[i] = []byte(backupText(v))
+			}
+		case BackupKindText:
+			out[i] = backupText(value)
+		default:
+			out[i] = value
+		}
+	}
+	return out
+}
+
+func backupText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// RestoreWebsite 把备份中的站点表写入配置中的站点 websiteID（可与备份时的 ID 不同）。
+// 目标站点的表必须为空；全部表在同一个事务内写入，任何一张失败都会整体回滚。
+func (r *Repository) RestoreWebsite(
+	websiteID string,
+	tables []BackupTable,
+	open func(BackupTable) (BackupRowReader, error),
+) (RestoreResult, error) {
+	var result RestoreResult
+	if _, ok := config.GetWebsiteByID(websiteID); !ok {
+		return result, fmt.Errorf("配置中没有 ID 为 %s 的站点", websiteID)
+	}
+	// 新实例首次使用时站点表可能尚未创建；目标站点必须为空，不涉及回填
+	if _, err := r.MigrateWebsites([]string{websiteID}, false); err != nil {
+		return result, err
+	}
+
+	known := make(map[string]bool, len(websiteTables))
+	for _, name := range websiteTables {
+		known[name] = true
+	}
+	for _, table := range tables {
+		if !known[table.Name] {
+			return result, fmt.Errorf("备份中包含未知的站点表 %s", table.Name)
+		}
+		tableName := fmt.Sprintf("%s_%s", websiteID, table.Name)
+		exists, err := r.tableExists(tableName)
+		if err != nil {
+			return result, err
+		}
+		if !exists {
+			return result, fmt.Errorf("目标表 %s 不存在", tableName)
+		}
+		hasRows, err := r.tableHasRows(tableName)
+		if err != nil {
+			return result, err
+		}
+		if hasRows {
+			return result, fmt.Errorf("站点 %s 已有数据（%s），无法恢复", websiteID, tableName)
+		}
+		if table.Name == "nginx_logs" {
+			if err := r.ensureRestorePartitions(websiteID, table, open); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, table := range tables {
+		tableName := fmt.Sprintf("%s_%s", websiteID, table.Name)
+		var rows int64
+		rows, err = restoreTable(tx, tableName, table, open, "", false, nil)
+		if err != nil {
+			err = fmt.Errorf("恢复表 %s 失败: %w", tableName, err)
+			return result, err
+		}
+		if backupSerialTables[table.Name] && !sqlutil.IsSQLite() {
+			// SQLite 的 AUTOINCREMENT 会随显式写入的 id 自动推进
+			if _, err = tx.Exec(fmt.Sprintf(
+				`SELECT setval(pg_get_serial_sequence('"%[1]s"', 'id'), MAX(id)) FROM "%[1]s" HAVING MAX(id) IS NOT NULL`,
+				tableName,
+			)); err != nil {
+				err = fmt.Errorf("更新表 %s 的序列失败: %w", tableName, err)
+				return result, err
+			}
+		}
+		result.Tables++
+		result.Rows += rows
+	}
+
+	if err = tx.Commit(); err != nil {
+		return result, err
+	}
+	logrus.Infof("已恢复站点 %s 的 %d 张表，共 %d 行", websiteID, result.Tables, result.Rows)
+	return result, nil
+}
+
+// ensureRestorePartitions 在事务外预先为备份日志涉及的时间段创建分区，避免写入事务与建分区互相等待
+func (r *Repository) ensureRestorePartitions(
+	websiteID string,
+	table BackupTable,
+	open func(BackupTable) (BackupRowReader, error),
+) error {
+	if !sqlutil.SupportsPartitions() {
+		return nil
+	}
+	tsIndex := -1
+	for i, column := range table.Columns {
+		if column.Name == "timestamp" {
+			tsIndex = i
+		}
+	}
+	if tsIndex < 0 {
+		return errors.New("备份的日志表缺少 timestamp 列")
+	}
+	reader, err := open(table)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	times := make([]time.Time, 0)
+	seen := make(map[int64]struct{})
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		value, err := restoreValue(BackupKindInt, row[tsIndex])
+		if err != nil {
+			return err
+		}
+		ts, ok := value.(int64)
+		if !ok {
+			continue
+		}
+		day, _ := logPartitionRange(time.Unix(ts, 0), config.LogPartitionDaily)
+		if _, ok := seen[day.Unix()]; ok {
+			continue
+		}
+		seen[day.Unix()] = struct{}{}
+		times = append(times, time.Unix(ts, 0))
+	}
+	return r.ensureLogPartitions(fmt.Sprintf("%s_nginx_logs", websiteID), times)
+}
+
+// RestoreGlobal 合并备份中的 IP 归属地缓存与系统通知：已有的缓存与相同指纹的通知保留本机数据，
+// 通知中的站点 ID 按 idMap 改写为恢复后的站点 ID。
+func (r *Repository) RestoreGlobal(
+	tables []BackupTable,
+	open func(BackupTable) (BackupRowReader, error),
+	idMap map[string]string,
+) (result RestoreResult, err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, table := range tables {
+		var rows int64
+		switch table.Name {
+		case "ip_geo_cache":
+			rows, err = restoreTable(tx, "ip_geo_cache", table, open, "ON CONFLICT (ip) DO NOTHING", false, nil)
+			result.IPGeoCache += rows
+		case "system_notifications":
+			rows, err = restoreTable(tx, "system_notifications", table, open, "ON CONFLICT DO NOTHING", true,
+				func(columns []BackupColumn, row []interface{}) []interface{} {
+					return remapNotification(columns, row, idMap)
+				})
+			result.Notifications += rows
+		default:
+			err = fmt.Errorf("备份中包含未知的全局表 %s", table.Name)
+		}
+		if err != nil {
+			return result, err
+		}
+		result.Tables++
+		result.Rows += rows
+	}
+	if err = tx.Commit(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// remapNotification 改写通知指纹与 metadata 中的站点 ID（通知 id 由本机重新分配）
+func remapNotification(columns []BackupColumn, row []interface{}, idMap map[string]string) []interface{} {
+	var oldID, newID string
+	for i, column := range columns {
+		if column.Name != "metadata" {
+			continue
+		}
+		text, ok := row[i].(string)
+		if !ok || text == "" {
+			break
+		}
+		var metadata map[string]interface{}
+		if json.Unmarshal([]byte(text), &metadata) != nil {
+			break
+		}
+		oldID, _ = metadata["website_id"].(string)
+		if mapped, ok := idMap[oldID]; ok && mapped != oldID {
+			newID = mapped
+			metadata["website_id"] = newID
+			if data, err := json.Marshal(metadata); err == nil {
+				row[i] = string(data)
+			}
+		}
+	}
+	if newID == "" {
+		return row
+	}
+	for i, column := range columns {
+		if text, ok := row[i].(string); ok && column.Name == "fingerprint" {
+			row[i] = strings.Replace(text, ":"+oldID+":", ":"+newID+":", 1)
+		}
+	}
+	return row
+}
+
+// restoreTable 按批次写入一张表，返回写入的行数；omitID 时不写入 id 列，transform 可改写每一行
+func restoreTable(
+	tx *sql.Tx,
+	tableName string,
+	table BackupTable,
+	open func(BackupTable) (BackupRowReader, error),
+	conflict string,
+	omitID bool,
+	transform func(columns []BackupColumn, row []interface{}) []interface{},
+) (int64, error) {
+	reader, err := open(table)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	columns := table.Columns
+	skipID := -1
+	for i, column := range columns {
+		if omitID && column.Name == "id" {
+			skipID = i
+		}
+	}
+	names := make([]string, 0, len(columns))
+	for i, column := range columns {
+		if i != skipID {
+			names = append(names, fmt.Sprintf(`"%s"`, column.Name))
+		}
+	}
+	if len(names) == 0 {
+		return 0, nil
+	}
+	rowPlaceholder := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ") + ")"
+	batchSize := max(1, min(500, backupMaxParams/len(names)))
+
+	var (
+		inserted int64
+		batch    = make([]interface{}, 0, batchSize*len(names))
+		count    int
+	)
+	flush := func() error {
+		if count == 0 {
+			return nil
+		}
+		query := fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES %s`,
+			tableName, strings.Join(names, ", "),
+			strings.TrimSuffix(strings.Repeat(rowPlaceholder+", ", count), ", "))
+		if conflict != "" {
+			query += " " + conflict
+		}
+		result, err := tx.Exec(sqlutil.ReplacePlaceholders(query), batch...)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		inserted += affected
+		batch = batch[:0]
+		count = 0
+		return nil
+	}
+
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return inserted, err
+		}
+		if len(row) != len(columns) {
+			return inserted, fmt.Errorf("行的列数 %d 与表头 %d 不一致", len(row), len(columns))
+		}
+		if transform != nil {
+			row = transform(columns, row)
+		}
+		for i, column := range columns {
+			if i == skipID {
+				continue
+			}
+			value, err := restoreValue(column.Kind, row[i])
+			if err != nil {
+				return inserted, fmt.Errorf("列 %s: %w", column.Name, err)
+			}
+			batch = append(batch, value)
+		}
+		count++
+		if count == batchSize {
+			if err := flush(); err != nil {
+				return inserted, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return inserted, err
+	}
+	return inserted, nil
+}
+
+// restoreValue 把备份中的 JSON 值还原为写入参数；SQLite 的时间列与 CURRENT_TIMESTAMP 保持相同的文本格式
+func restoreValue(kind string, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch kind {
+	case BackupKindInt:
+		switch v := value.(type) {
+		case json.Number:
+			return v.Int64()
+		case float64:
+			return int64(v), nil
+		case bool:
+			if v {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		}
+	case BackupKindBytes:
+		if text, ok := value.(string); ok {
+			return base64.StdEncoding.DecodeString(text)
+		}
+	case BackupKindTimestamp:
+		if text, ok := value.(string); ok {
+			parsed, err := time.Parse(time.RFC3339Nano, text)
+			if err != nil {
+				// 旧版 SQLite 写入的文本时间原样保留
+				return text, nil
+			}
+			if sqlutil.IsSQLite() {
+				return parsed.UTC().Format("2006-01-02 15:04:05"), nil
+			}
+			return parsed, nil
+		}
+	default:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case json.Number:
+			return v.String(), nil
+		}
+	}
+	return nil, fmt.Errorf("无法识别的 %s 值 %v", kind, value)
+}
+
+func txTableExists(tx *sql.Tx, tableName string) (bool, error) {
+	var value int
+	err := tx.QueryRow(sqlutil.TableExistsQuery(), tableName).Scan(&value)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
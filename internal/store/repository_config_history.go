@@ -0,0 +1,196 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+)
+
+// 配置历史的来源
+const (
+	ConfigSourceBaseline = "baseline" // 首次保存前的原有配置
+	ConfigSourceSave     = "save"     // 页面保存
+	ConfigSourceRollback = "rollback" // 回滚到历史版本
+	ConfigSourceReload   = "reload"   // 手动修改文件后通过 SIGHUP 加载
+)
+
+// ConfigVersion 为一个已保存的配置版本；列表接口不返回 Config 内容
+type ConfigVersion struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Source     string          `json:"source"`
+	Actor      string          `json:"actor"`
+	RemoteIP   string          `json:"remote_ip"`
+	UserAgent  string          `json:"user_agent"`
+	RollbackOf int64           `json:"rollback_of,omitempty"` // 回滚时为目标版本
+	Checksum   string          `json:"checksum"`
+	Config     json.RawMessage `json:"config,omitempty"`
+}
+
+func (r *Repository) ensureConfigHistoryTable() error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS "config_history" (
+            id BIGSERIAL PRIMARY KEY,
+            source TEXT NOT NULL,
+            actor TEXT NOT NULL DEFAULT '',
+            remote_ip TEXT NOT NULL DEFAULT '',
+            user_agent TEXT NOT NULL DEFAULT '',
+            rollback_of BIGINT NOT NULL DEFAULT 0,
+            checksum TEXT NOT NULL,
+            config TEXT NOT NULL,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_config_history_created_at ON "config_history"(created_at)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := r.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordConfig 把 cfg 记为新的配置版本（来源与操作者取自 version）；
+// 内容与最新版本相同时不重复记录，返回最新版本号与是否新增了版本
+func (r *Repository) RecordConfig(cfg *config.Config, version ConfigVersion) (int64, bool, error) {
+	payload, err := json.Marshal(cfg)
+	if err != nil {
+		return 0, false, err
+	}
+	sum := sha256.Sum256(payload)
+	version.Checksum = hex.EncodeToString(sum[:])
+	version.Config = payload
+
+	latest, err := r.LatestConfigVersion()
+	if err != nil {
+		return 0, false, err
+	}
+	if latest != nil && latest.Checksum == version.Checksum {
+		return latest.ID, false, nil
+	}
+	id, err := r.SaveConfigVersion(version)
+	return id, err == nil, err
+}
+
+// RecordConfigBaseline 在还没有任何历史时，把 cfg（保存前的配置）记为基线版本，
+// 保证第一次修改后也能对比或回滚到修改前的内容
+func (r *Repository) RecordConfigBaseline(cfg *config.Config) error {
+	latest, err := r.LatestConfigVersion()
+	if err != nil || latest != nil {
+		return err
+	}
+	_, _, err = r.RecordConfig(cfg, ConfigVersion{Source: ConfigSourceBaseline})
+	return err
+}
+
+// SaveConfigVersion 追加一个配置版本，返回版本号
+func (r *Repository) SaveConfigVersion(version ConfigVersion) (int64, error) {
+	var id int64
+	err := r.db.QueryRow(sqlutil.ReplacePlaceholders(
+		`INSERT INTO "config_history" (source, actor, remote_ip, user_agent, rollback_of, checksum, config)
+         VALUES (?, ?, ?, ?, ?, ?, ?)
+         RETURNING id`,
+	), version.Source, version.Actor, version.RemoteIP, version.UserAgent,
+		version.RollbackOf, version.Checksum, string(version.Config)).Scan(&id)
+	return id, err
+}
+
+// ListConfigVersions 按时间倒序分页列出配置版本（不含配置内容）
+func (r *Repository) ListConfigVersions(page, pageSize int) ([]ConfigVersion, bool, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+	if pageSize > 200 {
+		pageSize = 200
+	}
+	offset := (page - 1) * pageSize
+
+	rows, err := r.db.Query(sqlutil.ReplacePlaceholders(
+		`SELECT id, source, actor, remote_ip, user_agent, rollback_of, checksum, created_at
+         FROM "config_history"
+         ORDER BY id DESC
+         LIMIT ? OFFSET ?`,
+	), pageSize+1, offset)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	versions := make([]ConfigVersion, 0, pageSize)
+	hasMore := false
+	for rows.Next() {
+		var version ConfigVersion
+		if err := rows.Scan(
+			&version.ID,
+			&version.Source,
+			&version.Actor,
+			&version.RemoteIP,
+			&version.UserAgent,
+			&version.RollbackOf,
+			&version.Checksum,
+			&version.CreatedAt,
+		); err != nil {
+			return nil, false, err
+		}
+		if len(versions) < pageSize {
+			versions = append(versions, version)
+		} else {
+			hasMore = true
+		}
+	}
+	return versions, hasMore, rows.Err()
+}
+
+// GetConfigVersion 读取指定版本（含配置内容），不存在时返回 nil
+func (r *Repository) GetConfigVersion(id int64) (*ConfigVersion, error) {
+	return r.queryConfigVersion(`WHERE id = ?`, id)
+}
+
+// LatestConfigVersion 读取最新的版本（含配置内容），没有历史时返回 nil
+func (r *Repository) LatestConfigVersion() (*ConfigVersion, error) {
+	return r.queryConfigVersion(`ORDER BY id DESC LIMIT 1`)
+}
+
+func (r *Repository) queryConfigVersion(clause string, args ...interface{}) (*ConfigVersion, error) {
+	var version ConfigVersion
+	var payload string
+	err := r.db.QueryRow(sqlutil.ReplacePlaceholders(
+		`SELECT id, source, actor, remote_ip, user_agent, rollback_of, checksum, config, created_at
+         FROM "config_history" `+clause,
+	), args...).Scan(
+		&version.ID,
+		&version.Source,
+		&version.Actor,
+		&version.RemoteIP,
+		&version.UserAgent,
+		&version.RollbackOf,
+		&version.Checksum,
+		&payload,
+		&version.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	version.Config = json.RawMessage(payload)
+	return &version, nil
+}
+
+// DecodeConfig 解析版本中保存的配置，未出现的字段取默认值
+func (v *ConfigVersion) DecodeConfig() (*config.Config, error) {
+	cfg := config.DefaultConfig()
+	if err := json.Unmarshal(v.Config, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,453 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+)
+
+// COPY 写入路径（system.logInsertMode = copy，仅 PostgreSQL）：
+// 维度值、日志、聚合与首次访问时间先 COPY 进会话级临时表，再以集合 SQL 解析维表 ID 并合并到站点表；
+// 会话与 UV 草图依赖逐条处理，沿用与 INSERT 路径相同的逻辑。
+
+func useCopyIngest() bool {
+	return !sqlutil.IsSQLite() &&
+		strings.TrimSpace(config.ReadConfig().System.LogInsertMode) == config.LogInsertModeCopy
+}
+
+// copyWriter 在 database/sql 事务所在的连接上执行 pgx CopyFrom，数据随事务一起提交或回滚
+type copyWriter struct {
+	ctx  context.Context
+	conn *sql.Conn
+}
+
+func (w copyWriter) copy(table string, columns []string, rows [][]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	return w.conn.Raw(func(driverConn any) error {
+		pgConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("COPY 写入仅支持 PostgreSQL")
+		}
+		_, err := pgConn.Conn().CopyFrom(w.ctx, pgx.Identifier{table}, columns, pgx.CopyFromRows(rows))
+		return err
+	})
+}
+
+// stageTable 创建与站点表结构一致的临时表（提交时清空），返回临时表名
+func stageTable(tx *sql.Tx, websiteID, table string) (string, error) {
+	stage := "ingest_stage_" + table
+	if _, err := tx.Exec(fmt.Sprintf(
+		`CREATE TEMP TABLE IF NOT EXISTS "%s" (LIKE "%s_%s") ON COMMIT DELETE ROWS`,
+		stage, websiteID, table,
+	)); err != nil {
+		return "", err
+	}
+	return stage, nil
+}
+
+// stageMerge 描述一次“COPY 到临时表 + INSERT ... SELECT 合并”：update 为空时冲突行忽略
+type stageMerge struct {
+	table    string
+	columns  []string
+	conflict []string
+	update   string
+	rows     [][]any
+}
+
+func mergeThroughStage(tx *sql.Tx, writer copyWriter, websiteID string, merge stageMerge) error {
+	if len(merge.rows) == 0 {
+		return nil
+	}
+	stage, err := stageTable(tx, websiteID, merge.table)
+	if err != nil {
+		return err
+	}
+	if err := writer.copy(stage, merge.columns, merge.rows); err != nil {
+		return err
+	}
+
+	target := fmt.Sprintf("%s_%s", websiteID, merge.table)
+	columns := strings.Join(merge.columns, ", ")
+	onConflict := "DO NOTHING"
+	if merge.update != "" {
+		onConflict = "DO UPDATE SET " + strings.ReplaceAll(merge.update, "{target}", `"`+target+`"`)
+	}
+	// 按冲突键排序写入，保持与逐条写入相同的加锁顺序
+	_, err = tx.Exec(fmt.Sprintf(
+		`INSERT INTO "%s" (%s)
+         SELECT %s FROM "%s" ORDER BY %s
+         ON CONFLICT (%s) %s`,
+		target, columns, columns, stage,
+		strings.Join(merge.conflict, ", "), strings.Join(merge.conflict, ", "), onConflict,
+	))
+	return err
+}
+
+const (
+	dimStageIP int16 = iota + 1
+	dimStageURL
+	dimStageReferer
+	dimStageUA
+	dimStageLocation
+)
+
+var dimStageSpecs = []struct {
+	kind    int16
+	table   string
+	columns []string
+}{
+	{kind: dimStageIP, table: "dim_ip", columns: []string{"ip"}},
+	{kind: dimStageURL, table: "dim_url", columns: []string{"url"}},
+	{kind: dimStageReferer, table: "dim_referer", columns: []string{"referer"}},
+	{kind: dimStageUA, table: "dim_ua", columns: []string{"browser", "os", "device"}},
+	{kind: dimStageLocation, table: "dim_location", columns: []string{"domestic", "global"}},
+}
+
+// resolveDimsWithCopy 把本批次的维度值 COPY 进临时表，集合插入缺失值后一次性取回 ID。
+// 返回的缓存 key 与 uaCacheKey/locationCacheKey 一致（多列以 \x1f 连接）。
+func resolveDimsWithCopy(tx *sql.Tx, writer copyWriter, websiteID string, logs []NginxLogRecord) (map[int16]map[string]int64, error) {
+	values := make(map[int16]map[string][]string, len(dimStageSpecs))
+	for _, spec := range dimStageSpecs {
+		values[spec.kind] = make(map[string][]string)
+	}
+	add := func(kind int16, parts ...string) {
+		values[kind][strings.Join(parts, "\x1f")] = parts
+	}
+	for _, log := range logs {
+		add(dimStageIP, log.IP)
+		add(dimStageURL, log.Url)
+		add(dimStageReferer, log.Referer)
+		add(dimStageUA, log.UserBrowser, log.UserOs, log.UserDevice)
+		add(dimStageLocation, log.DomesticLocation, log.GlobalLocation)
+	}
+
+	if _, err := tx.Exec(`CREATE TEMP TABLE IF NOT EXISTS ingest_stage_dims (
+            kind SMALLINT NOT NULL,
+            v1 TEXT NOT NULL,
+            v2 TEXT NOT NULL DEFAULT '',
+            v3 TEXT NOT NULL DEFAULT ''
+        ) ON COMMIT DELETE ROWS`); err != nil {
+		return nil, err
+	}
+	rows := make([][]any, 0)
+	for _, spec := range dimStageSpecs {
+		for _, parts := range values[spec.kind] {
+			row := []any{spec.kind, "", "", ""}
+			for i, part := range parts {
+				row[i+1] = part
+			}
+			rows = append(rows, row)
+		}
+	}
+	if err := writer.copy("ingest_stage_dims", []string{"kind", "v1", "v2", "v3"}, rows); err != nil {
+		return nil, err
+	}
+
+	ids := make(map[int16]map[string]int64, len(dimStageSpecs))
+	for _, spec := range dimStageSpecs {
+		table := fmt.Sprintf("%s_%s", websiteID, spec.table)
+		stageColumns := []string{"v1", "v2", "v3"}[:len(spec.columns)]
+		joins := make([]string, 0, len(spec.columns))
+		dimColumns := make([]string, 0, len(spec.columns))
+		for i, column := range spec.columns {
+			joins = append(joins, fmt.Sprintf("d.%s = s.%s", column, stageColumns[i]))
+			dimColumns = append(dimColumns, "d."+column)
+		}
+
+		if _, err := tx.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+			`INSERT INTO "%s" (%s)
+             SELECT DISTINCT %s FROM ingest_stage_dims WHERE kind = ? ORDER BY %s
+             ON CONFLICT DO NOTHING`,
+			table, strings.Join(spec.columns, ", "),
+			strings.Join(stageColumns, ", "), strings.Join(stageColumns, ", "),
+		)), spec.kind); err != nil {
+			return nil, err
+		}
+
+		result, err := tx.Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+			`SELECT d.id, %s FROM "%s" d JOIN ingest_stage_dims s ON s.kind = ? AND %s`,
+			strings.Join(dimColumns, ", "), table, strings.Join(joins, " AND "),
+		)), spec.kind)
+		if err != nil {
+			return nil, err
+		}
+		resolved := make(map[string]int64, len(values[spec.kind]))
+		for result.Next() {
+			var id int64
+			parts := make([]string, len(spec.columns))
+			targets := []any{&id}
+			for i := range parts {
+				targets = append(targets, &parts[i])
+			}
+			if err := result.Scan(targets...); err != nil {
+				result.Close()
+				return nil, err
+			}
+			resolved[strings.Join(parts, "\x1f")] = id
+		}
+		if err := result.Err(); err != nil {
+			result.Close()
+			return nil, err
+		}
+		result.Close()
+		ids[spec.kind] = resolved
+	}
+	return ids, nil
+}
+
+func (r *Repository) batchInsertLogsWithCopyOnce(
+	websiteID string,
+	logs []NginxLogRecord,
+	states []ScanStateRow,
+) (err error) {
+	ctx := context.Background()
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	writer := copyWriter{ctx: ctx, conn: conn}
+
+	sanitized := make([]NginxLogRecord, len(logs))
+	for i, log := range logs {
+		sanitized[i] = sanitizeLogRecord(log)
+	}
+	dimIDs, err := resolveDimsWithCopy(tx, writer, websiteID, sanitized)
+	if err != nil {
+		return err
+	}
+	lookup := func(kind int16, parts ...string) (int64, error) {
+		key := strings.Join(parts, "\x1f")
+		id, ok := dimIDs[kind][key]
+		if !ok {
+			return 0, fmt.Errorf("维表 ID 解析失败: %q", key)
+		}
+		return id, nil
+	}
+
+	sessions, err := prepareSessionStatements(tx, websiteID)
+	if err != nil {
+		return err
+	}
+	defer sessions.Close()
+
+	batch := newAggBatch()
+	sessionCache := make(map[string]sessionState)
+	sessionAggDaily := make(map[string]int64)
+	sessionAggEntry := make(map[string]map[int64]int64)
+	sessionUpdates := make(map[int64]*pendingSessionUpdate)
+	sessionStateUpserts := make(map[string]pendingSessionStateUpsert)
+	lockedSessionKeys := make(map[string]struct{})
+	firstSeenMinTs := make(map[int64]int64)
+	logRows := make([][]any, 0, len(sanitized))
+
+	for _, log := range sanitized {
+		ipID, err := lookup(dimStageIP, log.IP)
+		if err != nil {
+			return err
+		}
+		urlID, err := lookup(dimStageURL, log.Url)
+		if err != nil {
+			return err
+		}
+		refererID, err := lookup(dimStageReferer, log.Referer)
+		if err != nil {
+			return err
+		}
+		uaID, err := lookup(dimStageUA, log.UserBrowser, log.UserOs, log.UserDevice)
+		if err != nil {
+			return err
+		}
+		locationID, err := lookup(dimStageLocation, log.DomesticLocation, log.GlobalLocation)
+		if err != nil {
+			return err
+		}
+
+		ts := log.Timestamp.Unix()
+		logRows = append(logRows, []any{
+			ipID, int16(log.PageviewFlag), ts, log.Method, urlID,
+			int32(log.Status), int64(log.BytesSent), refererID, uaID, locationID, int32(log.SampleRate),
+		})
+
+		if log.PageviewFlag == 1 {
+			if prev, ok := firstSeenMinTs[ipID]; !ok || ts < prev {
+				firstSeenMinTs[ipID] = ts
+			}
+			if err := updateSessionFromLog(
+				sessions,
+				sessionCache,
+				sessionAggDaily,
+				sessionAggEntry,
+				sessionUpdates,
+				sessionStateUpserts,
+				lockedSessionKeys,
+				ipID,
+				uaID,
+				locationID,
+				urlID,
+				ts,
+			); err != nil {
+				return err
+			}
+		}
+
+		batch.add(log, ipID)
+		batch.addDims(log, ipID, urlID, refererID, uaID, locationID)
+	}
+
+	if err := writer.copy(fmt.Sprintf("%s_nginx_logs", websiteID), []string{
+		"ip_id", "pageview_flag", "timestamp", "method", "url_id",
+		"status_code", "bytes_sent", "referer_id", "ua_id", "location_id", "sample_rate",
+	}, logRows); err != nil {
+		return err
+	}
+
+	for _, merge := range aggStageMerges(batch, firstSeenMinTs) {
+		if err := mergeThroughStage(tx, writer, websiteID, merge); err != nil {
+			return err
+		}
+	}
+
+	sketches := &aggStatements{}
+	defer sketches.Close()
+	for _, item := range []struct {
+		target **hllStatements
+		table  hllTable
+	}{
+		{target: &sketches.hourlyHLL, table: hourlyHLLTable},
+		{target: &sketches.dailyHLL, table: dailyHLLTable},
+		{target: &sketches.dimDailyHLL, table: dimDailyHLLTable},
+	} {
+		if *item.target, err = prepareHLLStatements(tx, websiteID, item.table); err != nil {
+			return err
+		}
+	}
+	if err := applySketchUpdates(sketches, batch); err != nil {
+		return err
+	}
+
+	if err := applySessionAggUpdatesWithLocks(sessions, sessionAggDaily, sessionAggEntry); err != nil {
+		return err
+	}
+	if err := applySessionUpdates(sessions, sessionUpdates); err != nil {
+		return err
+	}
+	if err := applySessionStateUpserts(sessions, sessionStateUpserts); err != nil {
+		return err
+	}
+	if err := saveScanStateTx(tx, states); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// aggStageMerges 把批次内的聚合增量转换为临时表行，计数类按主键累加、集合类忽略已存在的行
+func aggStageMerges(batch *aggBatch, firstSeenMinTs map[int64]int64) []stageMerge {
+	countColumns := []string{"pv", "traffic", "s2xx", "s3xx", "s4xx", "s5xx", "other"}
+	countUpdates := make([]string, 0, len(countColumns))
+	for _, column := range countColumns {
+		countUpdates = append(countUpdates, fmt.Sprintf("%s = {target}.%s + excluded.%s", column, column, column))
+	}
+	countRow := func(key any, counts *aggCounts) []any {
+		return []any{key, counts.pv, counts.traffic, counts.s2xx, counts.s3xx, counts.s4xx, counts.s5xx, counts.other}
+	}
+
+	hourly := stageMerge{
+		table:    "agg_hourly",
+		columns:  append([]string{"bucket"}, countColumns...),
+		conflict: []string{"bucket"},
+		update:   strings.Join(countUpdates, ", "),
+	}
+	for bucket, counts := range batch.hourly {
+		if counts != nil {
+			hourly.rows = append(hourly.rows, countRow(bucket, counts))
+		}
+	}
+	daily := stageMerge{
+		table:    "agg_daily",
+		columns:  append([]string{"day"}, countColumns...),
+		conflict: []string{"day"},
+		update:   strings.Join(countUpdates, ", "),
+	}
+	for day, counts := range batch.daily {
+		if counts != nil {
+			daily.rows = append(daily.rows, countRow(copyDate(day), counts))
+		}
+	}
+
+	hourlyIPs := stageMerge{table: "agg_hourly_ip", columns: []string{"bucket", "ip_id"}, conflict: []string{"bucket", "ip_id"}}
+	for bucket, ips := range batch.hourlyIPs {
+		for ipID := range ips {
+			hourlyIPs.rows = append(hourlyIPs.rows, []any{bucket, ipID})
+		}
+	}
+	dailyIPs := stageMerge{table: "agg_daily_ip", columns: []string{"day", "ip_id"}, conflict: []string{"day", "ip_id"}}
+	for day, ips := range batch.dailyIPs {
+		for ipID := range ips {
+			dailyIPs.rows = append(dailyIPs.rows, []any{copyDate(day), ipID})
+		}
+	}
+
+	dimDaily := stageMerge{
+		table:    "agg_dim_daily",
+		columns:  []string{"kind", "day", "dim_id", "pv"},
+		conflict: []string{"kind", "day", "dim_id"},
+		update:   "pv = {target}.pv + excluded.pv",
+	}
+	for _, key := range sortedDimAggKeys(batch.dimDaily) {
+		dimDaily.rows = append(dimDaily.rows, []any{int16(key.kind), copyDate(key.day), key.dimID, batch.dimDaily[key]})
+	}
+	dimDailyIPs := stageMerge{
+		table:    "agg_dim_daily_ip",
+		columns:  []string{"kind", "day", "dim_id", "ip_id"},
+		conflict: []string{"kind", "day", "dim_id", "ip_id"},
+	}
+	for _, key := range sortedDimAggKeys(batch.dimDailyIPs) {
+		for ipID := range batch.dimDailyIPs[key] {
+			dimDailyIPs.rows = append(dimDailyIPs.rows, []any{int16(key.kind), copyDate(key.day), key.dimID, ipID})
+		}
+	}
+
+	firstSeen := stageMerge{
+		table:    "first_seen",
+		columns:  []string{"ip_id", "first_ts"},
+		conflict: []string{"ip_id"},
+		update:   "first_ts = LEAST({target}.first_ts, excluded.first_ts)",
+	}
+	ipIDs := make([]int64, 0, len(firstSeenMinTs))
+	for ipID := range firstSeenMinTs {
+		ipIDs = append(ipIDs, ipID)
+	}
+	sort.Slice(ipIDs, func(i, j int) bool { return ipIDs[i] < ipIDs[j] })
+	for _, ipID := range ipIDs {
+		firstSeen.rows = append(firstSeen.rows, []any{ipID, firstSeenMinTs[ipID]})
+	}
+
+	return []stageMerge{hourly, daily, hourlyIPs, dailyIPs, dimDaily, dimDailyIPs, firstSeen}
+}
+
+// copyDate 把 dayBucket 的日期转换为 COPY 二进制格式可编码的 DATE 值
+func copyDate(day string) time.Time {
+	parsed, _ := time.Parse("2006-01-02", day)
+	return parsed
+}
@@ -0,0 +1,341 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+	"github.com/sirupsen/logrus"
+)
+
+// DimAggKind 为按日维度聚合（<id>_agg_dim_daily / <id>_agg_dim_daily_ip）中的维度类型
+type DimAggKind int
+
+const (
+	DimAggURL      DimAggKind = 1
+	DimAggReferer  DimAggKind = 2
+	DimAggUA       DimAggKind = 3
+	DimAggLocation DimAggKind = 4
+)
+
+// dimAggKinds 为各维度类型对应的日志表列
+var dimAggKinds = []struct {
+	kind   DimAggKind
+	column string
+}{
+	{kind: DimAggURL, column: "url_id"},
+	{kind: DimAggReferer, column: "referer_id"},
+	{kind: DimAggUA, column: "ua_id"},
+	{kind: DimAggLocation, column: "location_id"},
+}
+
+type dimAggKey struct {
+	kind  DimAggKind
+	day   string
+	dimID int64
+}
+
+// addDims 累加 PV 日志的按日维度聚合；PV 与 agg_hourly/agg_daily 口径一致，边缘采样的日志按倍率还原
+func (b *aggBatch) addDims(log NginxLogRecord, ipID, urlID, refererID, uaID, locationID int64) {
+	if b == nil || log.PageviewFlag != 1 {
+		return
+	}
+	day := dayBucket(log.Timestamp)
+	weight := sampleWeight(log)
+	for _, item := range []struct {
+		kind  DimAggKind
+		dimID int64
+	}{
+		{kind: DimAggURL, dimID: urlID},
+		{kind: DimAggReferer, dimID: refererID},
+		{kind: DimAggUA, dimID: uaID},
+		{kind: DimAggLocation, dimID: locationID},
+	} {
+		key := dimAggKey{kind: item.kind, day: day, dimID: item.dimID}
+		b.dimDaily[key] += weight
+		if b.dimDailyIPs[key] == nil {
+			b.dimDailyIPs[key] = make(map[int64]struct{})
+		}
+		b.dimDailyIPs[key][ipID] = struct{}{}
+	}
+}
+
+func sortedDimAggKeys[V any](items map[dimAggKey]V) []dimAggKey {
+	keys := make([]dimAggKey, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].kind != keys[j].kind {
+			return keys[i].kind < keys[j].kind
+		}
+		if keys[i].day != keys[j].day {
+			return keys[i].day < keys[j].day
+		}
+		return keys[i].dimID < keys[j].dimID
+	})
+	return keys
+}
+
+func (r *Repository) backfillDimAggregatesIfEmpty(websiteID string) error {
+	hasAgg, err := r.tableHasRows(fmt.Sprintf("%s_agg_dim_daily", websiteID))
+	if err != nil {
+		return err
+	}
+	if hasAgg {
+		return nil
+	}
+
+	hasLogs, err := r.tableHasRows(fmt.Sprintf("%s_nginx_logs", websiteID))
+	if err != nil || !hasLogs {
+		return err
+	}
+
+	return r.backfillDimAggregates(websiteID)
+}
+
+// backfillDimAggregates 用保留期内的原始日志重建按日维度聚合
+func (r *Repository) backfillDimAggregates(websiteID string) error {
+	logrus.WithField("website", websiteID).Info("开始回填维度聚合数据")
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(fmt.Sprintf(`DELETE FROM "%s_agg_dim_daily"`, websiteID)); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(fmt.Sprintf(`DELETE FROM "%s_agg_dim_daily_ip"`, websiteID)); err != nil {
+		return err
+	}
+	if err = insertDimAggregates(tx, websiteID, "", nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logrus.WithField("website", websiteID).Info("维度聚合数据回填完成")
+	return nil
+}
+
+// rebuildDimAggregatesForDay 在事务内按原始日志重算某一天的维度聚合
+func rebuildDimAggregatesForDay(tx *sql.Tx, websiteID, day string, start, end time.Time) error {
+	for _, item := range dimAggKinds {
+		for _, table := range []string{"agg_dim_daily", "agg_dim_daily_ip"} {
+			if _, err := tx.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+				`DELETE FROM "%s_%s" WHERE kind = ? AND day = ?`, websiteID, table,
+			)), item.kind, day); err != nil {
+				return err
+			}
+		}
+	}
+	return insertDimAggregates(tx, websiteID, "AND timestamp >= ? AND timestamp < ?", []interface{}{start.Unix(), end.Unix()})
+}
+
+func insertDimAggregates(tx *sql.Tx, websiteID, rangeCondition string, rangeArgs []interface{}) error {
+	logTable := fmt.Sprintf("%s_nginx_logs", websiteID)
+	for _, item := range dimAggKinds {
+		args := append([]interface{}{item.kind}, rangeArgs...)
+		if _, err := tx.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+			`INSERT INTO "%s_agg_dim_daily" (kind, day, dim_id, pv)
+             SELECT ?, date(to_timestamp(timestamp)) AS day, %s, SUM(sample_rate)
+             FROM "%s"
+             WHERE pageview_flag = 1 %s
+             GROUP BY day, %s`, websiteID, item.column, logTable, rangeCondition, item.column,
+		)), args...); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+			`INSERT INTO "%s_agg_dim_daily_ip" (kind, day, dim_id, ip_id)
+             SELECT ?, date(to_timestamp(timestamp)) AS day, %s, ip_id
+             FROM "%s"
+             WHERE pageview_flag = 1 %s
+             GROUP BY day, %s, ip_id
+             ON CONFLICT DO NOTHING`, websiteID, item.column, logTable, rangeCondition, item.column,
+		)), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// locationAggMover 在 IP 归属地变化（待解析 <-> 已解析）时，把该 IP 的地域维度聚合迁移到新的 location_id。
+// 需在更新日志的 location_id 之前调用，迁移量按仍在保留期内的日志计算。
+type locationAggMover struct {
+	tx             *sql.Tx
+	websiteID      string
+	hasSketches    bool
+	dirty          map[dimAggKey]struct{}
+	selectCounts   *sql.Stmt
+	upsertTarget   *sql.Stmt
+	decreaseFrom   *sql.Stmt
+	deleteEmpty    *sql.Stmt
+	deleteFromIP   *sql.Stmt
+	insertTargetIP *sql.Stmt
+}
+
+// prepareLocationAggMover 准备迁移语句；exceptFrom 为 true 时迁移该 IP 所有不等于目标地域的日志，否则只迁移 from 地域的日志。
+// 站点尚无维度聚合表时返回 nil。
+func (r *Repository) prepareLocationAggMover(tx *sql.Tx, websiteID string, exceptFrom bool) (*locationAggMover, error) {
+	exists, err := r.tableExists(fmt.Sprintf("%s_agg_dim_daily", websiteID))
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	locationCondition := "location_id = ?"
+	if exceptFrom {
+		locationCondition = "location_id <> ?"
+	}
+	hasSketches, err := r.tableExists(fmt.Sprintf("%s_agg_dim_daily_hll", websiteID))
+	if err != nil {
+		return nil, err
+	}
+	mover := &locationAggMover{
+		tx:          tx,
+		websiteID:   websiteID,
+		hasSketches: hasSketches,
+		dirty:       make(map[dimAggKey]struct{}),
+	}
+	statements := []struct {
+		target **sql.Stmt
+		query  string
+	}{
+		// 按 15 分钟分桶再在 Go 侧归到本地日期，兼容非整点时区
+		{target: &mover.selectCounts, query: fmt.Sprintf(
+			`SELECT (timestamp / 900) * 900 AS bucket, location_id, SUM(sample_rate)
+             FROM "%s_nginx_logs"
+             WHERE ip_id = ? AND %s AND pageview_flag = 1
+             GROUP BY bucket, location_id`, websiteID, locationCondition,
+		)},
+		{target: &mover.upsertTarget, query: fmt.Sprintf(
+			`INSERT INTO "%[1]s_agg_dim_daily" (kind, day, dim_id, pv)
+             VALUES (?, ?, ?, ?)
+             ON CONFLICT(kind, day, dim_id) DO UPDATE SET pv = "%[1]s_agg_dim_daily".pv + excluded.pv`, websiteID,
+		)},
+		{target: &mover.decreaseFrom, query: fmt.Sprintf(
+			`UPDATE "%s_agg_dim_daily" SET pv = pv - ? WHERE kind = ? AND day = ? AND dim_id = ?`, websiteID,
+		)},
+		{target: &mover.deleteEmpty, query: fmt.Sprintf(
+			`DELETE FROM "%s_agg_dim_daily" WHERE kind = ? AND day = ? AND dim_id = ? AND pv <= 0`, websiteID,
+		)},
+		{target: &mover.deleteFromIP, query: fmt.Sprintf(
+			`DELETE FROM "%s_agg_dim_daily_ip" WHERE kind = ? AND day = ? AND dim_id = ? AND ip_id = ?`, websiteID,
+		)},
+		{target: &mover.insertTargetIP, query: fmt.Sprintf(
+			`INSERT INTO "%s_agg_dim_daily_ip" (kind, day, dim_id, ip_id) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`, websiteID,
+		)},
+	}
+	for _, item := range statements {
+		stmt, err := tx.Prepare(sqlutil.ReplacePlaceholders(item.query))
+		if err != nil {
+			mover.Close()
+			return nil, err
+		}
+		*item.target = stmt
+	}
+	return mover, nil
+}
+
+func (m *locationAggMover) Close() {
+	if m == nil {
+		return
+	}
+	for _, stmt := range []*sql.Stmt{
+		m.selectCounts, m.upsertTarget, m.decreaseFrom, m.deleteEmpty, m.deleteFromIP, m.insertTargetIP,
+	} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// Move 把 ipID 在 location 条件下的 PV 从原地域迁移到 toID
+func (m *locationAggMover) Move(ipID, locationArg, toID int64) error {
+	if m == nil {
+		return nil
+	}
+	type moveKey struct {
+		day    string
+		fromID int64
+	}
+	counts := make(map[moveKey]int64)
+	rows, err := m.selectCounts.Query(ipID, locationArg)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var bucket, fromID, count int64
+		if err := rows.Scan(&bucket, &fromID, &count); err != nil {
+			rows.Close()
+			return err
+		}
+		if fromID == toID {
+			continue
+		}
+		counts[moveKey{day: dayBucket(time.Unix(bucket, 0)), fromID: fromID}] += count
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	keys := make([]moveKey, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].day != keys[j].day {
+			return keys[i].day < keys[j].day
+		}
+		return keys[i].fromID < keys[j].fromID
+	})
+	for _, key := range keys {
+		count := counts[key]
+		if _, err := m.upsertTarget.Exec(DimAggLocation, key.day, toID, count); err != nil {
+			return err
+		}
+		if _, err := m.decreaseFrom.Exec(count, DimAggLocation, key.day, key.fromID); err != nil {
+			return err
+		}
+		if _, err := m.deleteEmpty.Exec(DimAggLocation, key.day, key.fromID); err != nil {
+			return err
+		}
+		if _, err := m.deleteFromIP.Exec(DimAggLocation, key.day, key.fromID, ipID); err != nil {
+			return err
+		}
+		if _, err := m.insertTargetIP.Exec(DimAggLocation, key.day, toID, ipID); err != nil {
+			return err
+		}
+		m.dirty[dimAggKey{kind: DimAggLocation, day: key.day, dimID: key.fromID}] = struct{}{}
+		m.dirty[dimAggKey{kind: DimAggLocation, day: key.day, dimID: toID}] = struct{}{}
+	}
+	return nil
+}
+
+// Flush 按迁移后的访客集合重建受影响的地域 UV 草图，需在提交事务前调用
+func (m *locationAggMover) Flush() error {
+	if m == nil || !m.hasSketches {
+		return nil
+	}
+	for _, key := range sortedDimAggKeys(m.dirty) {
+		if err := rebuildSketches(
+			m.tx, m.websiteID, dimDailyHLLTable,
+			"kind = ? AND day = ? AND dim_id = ?", key.kind, key.day, key.dimID,
+		); err != nil {
+			return err
+		}
+	}
+	m.dirty = make(map[dimAggKey]struct{})
+	return nil
+}
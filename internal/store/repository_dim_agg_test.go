@@ -0,0 +1,151 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+)
+
+const dimAggTestWebsite = "shop"
+
+func newDimAggTestRepository(t *testing.T) *Repository {
+	t.Helper()
+	raw, err := json.Marshal(map[string]interface{}{
+		"websites": []map[string]interface{}{
+			{"id": dimAggTestWebsite, "name": "Shop", "logPath": filepath.Join(t.TempDir(), "access.log")},
+		},
+		"system": map[string]interface{}{
+			"logRetentionDays": 30,
+			"parseBatchSize":   100,
+			"ipGeoCacheLimit":  1000,
+		},
+		"database": map[string]interface{}{
+			"driver": "sqlite",
+			"dsn":    filepath.Join(t.TempDir(), "nginxpulse.db"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_JSON", string(raw))
+	if _, err := config.ReloadConfig(nil); err != nil {
+		t.Fatalf("加载配置失败: %v", err)
+	}
+	repo, err := NewRepository()
+	if err != nil {
+		t.Fatalf("创建仓库失败: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	if err := repo.Init(); err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	return repo
+}
+
+// readDimPV 读取维度聚合的 PV，键为 kind/day/dim_id
+func readDimPV(t *testing.T, repo *Repository) map[string]int64 {
+	t.Helper()
+	rows, err := repo.db.Query(fmt.Sprintf(`SELECT kind, day, dim_id, pv FROM "%s_agg_dim_daily"`, dimAggTestWebsite))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	result := make(map[string]int64)
+	for rows.Next() {
+		var kind, dimID, pv int64
+		var day string
+		if err := rows.Scan(&kind, &day, &dimID, &pv); err != nil {
+			t.Fatal(err)
+		}
+		result[fmt.Sprintf("%d/%s/%d", kind, day[:10], dimID)] = pv
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// TestDimAggregatesWeightSampleRate 校验采样日志在 INSERT 写入、COPY 暂存与按原始日志重建三条路径上得到相同的维度聚合
+func TestDimAggregatesWeightSampleRate(t *testing.T) {
+	repo := newDimAggTestRepository(t)
+
+	ts := time.Now().Add(-time.Hour)
+	sampled := func(ip, url string, rate int, offset time.Duration) NginxLogRecord {
+		return NginxLogRecord{
+			IP: ip, PageviewFlag: 1, Timestamp: ts.Add(offset), Method: "GET", Url: url, Status: 200,
+			BytesSent: 100, Referer: "-", UserBrowser: "Chrome", UserOs: "Linux", UserDevice: "Desktop",
+			DomesticLocation: "本地", GlobalLocation: "本地", SampleRate: rate,
+		}
+	}
+	logs := []NginxLogRecord{
+		sampled("10.0.0.1", "/", 10, 0),
+		sampled("10.0.0.2", "/", 10, time.Second),
+		sampled("10.0.0.3", "/cart", 1, 2*time.Second),
+	}
+	if err := repo.BatchInsertLogsForWebsite(dimAggTestWebsite, logs); err != nil {
+		t.Fatalf("写入日志失败: %v", err)
+	}
+
+	inserted := readDimPV(t, repo)
+	var dailyPV int64
+	if err := repo.db.QueryRow(fmt.Sprintf(`SELECT SUM(pv) FROM "%s_agg_daily"`, dimAggTestWebsite)).Scan(&dailyPV); err != nil {
+		t.Fatal(err)
+	}
+	if dailyPV != 21 {
+		t.Fatalf("agg_daily PV = %d, want 21", dailyPV)
+	}
+	var urlPV int64
+	for key, pv := range inserted {
+		if key[0] == byte('0'+DimAggURL) {
+			urlPV += pv
+		}
+	}
+	if urlPV != dailyPV {
+		t.Fatalf("URL 维度 PV 合计 = %d, agg_daily PV = %d", urlPV, dailyPV)
+	}
+
+	// COPY 路径：用已写入日志的维表 ID 重放批次，暂存行应与 INSERT 结果一致
+	rows, err := repo.db.Query(fmt.Sprintf(
+		`SELECT ip_id, url_id, referer_id, ua_id, location_id, timestamp, status_code, bytes_sent, sample_rate
+         FROM "%s_nginx_logs" WHERE pageview_flag = 1`, dimAggTestWebsite,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := newAggBatch()
+	for rows.Next() {
+		var ipID, urlID, refererID, uaID, locationID, unix int64
+		log := NginxLogRecord{PageviewFlag: 1}
+		if err := rows.Scan(&ipID, &urlID, &refererID, &uaID, &locationID, &unix, &log.Status, &log.BytesSent, &log.SampleRate); err != nil {
+			rows.Close()
+			t.Fatal(err)
+		}
+		log.Timestamp = time.Unix(unix, 0)
+		batch.add(log, ipID)
+		batch.addDims(log, ipID, urlID, refererID, uaID, locationID)
+	}
+	rows.Close()
+	staged := make(map[string]int64)
+	for _, merge := range aggStageMerges(batch, nil) {
+		if merge.table != "agg_dim_daily" {
+			continue
+		}
+		for _, row := range merge.rows {
+			staged[fmt.Sprintf("%d/%s/%d", row[0], row[1].(time.Time).Format("2006-01-02"), row[2])] = row[3].(int64)
+		}
+	}
+	if fmt.Sprint(staged) != fmt.Sprint(inserted) {
+		t.Fatalf("COPY 暂存的维度聚合 = %v, INSERT 写入 = %v", staged, inserted)
+	}
+
+	if err := repo.backfillDimAggregates(dimAggTestWebsite); err != nil {
+		t.Fatalf("重建维度聚合失败: %v", err)
+	}
+	if rebuilt := readDimPV(t, repo); fmt.Sprint(rebuilt) != fmt.Sprint(inserted) {
+		t.Fatalf("按原始日志重建的维度聚合 = %v, INSERT 写入 = %v", rebuilt, inserted)
+	}
+}
@@ -0,0 +1,349 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/hll"
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+	"github.com/sirupsen/logrus"
+)
+
+// hllTable 描述一张 UV 近似计数表：keyColumns 为主键列，ipTable 为列名相同的精确 IP 集合表。
+// 草图的保留期与对应聚合一致；approx 口径下 IP 集合只保留到原始日志的截止时间，更早的 UV 只能由草图得到。
+type hllTable struct {
+	name       string
+	ipTable    string
+	keyColumns []string
+}
+
+var (
+	hourlyHLLTable   = hllTable{name: "agg_hourly_hll", ipTable: "agg_hourly_ip", keyColumns: []string{"bucket"}}
+	dailyHLLTable    = hllTable{name: "agg_daily_hll", ipTable: "agg_daily_ip", keyColumns: []string{"day"}}
+	dimDailyHLLTable = hllTable{name: "agg_dim_daily_hll", ipTable: "agg_dim_daily_ip", keyColumns: []string{"kind", "day", "dim_id"}}
+)
+
+func createHLLTables(execer sqlExecer, websiteID string) error {
+	stmts := []string{
+		fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS "%s_agg_hourly_hll" (
+                bucket BIGINT PRIMARY KEY,
+                sketch BYTEA NOT NULL
+            )`, websiteID,
+		),
+		fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS "%s_agg_daily_hll" (
+                day DATE PRIMARY KEY,
+                sketch BYTEA NOT NULL
+            )`, websiteID,
+		),
+		fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS "%s_agg_dim_daily_hll" (
+                kind SMALLINT NOT NULL,
+                day DATE NOT NULL,
+                dim_id BIGINT NOT NULL,
+                sketch BYTEA NOT NULL,
+                PRIMARY KEY(kind, day, dim_id)
+            )`, websiteID,
+		),
+	}
+	for _, stmt := range stmts {
+		if _, err := execer.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hllStatements 合并写入草图：先尝试插入，已存在时加行锁读出、合并后写回
+type hllStatements struct {
+	insert *sql.Stmt
+	lock   *sql.Stmt
+	update *sql.Stmt
+}
+
+func prepareHLLStatements(tx *sql.Tx, websiteID string, table hllTable) (*hllStatements, error) {
+	tableName := fmt.Sprintf("%s_%s", websiteID, table.name)
+	conditions := make([]string, 0, len(table.keyColumns))
+	for _, column := range table.keyColumns {
+		conditions = append(conditions, column+" = ?")
+	}
+	where := strings.Join(conditions, " AND ")
+	placeholders := strings.Repeat("?, ", len(table.keyColumns))
+
+	stmts := &hllStatements{}
+	var err error
+	if stmts.insert, err = tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`INSERT INTO "%s" (%s, sketch) VALUES (%s?) ON CONFLICT DO NOTHING`,
+		tableName, strings.Join(table.keyColumns, ", "), placeholders,
+	))); err != nil {
+		return nil, err
+	}
+	if stmts.lock, err = tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`SELECT sketch FROM "%s" WHERE %s FOR UPDATE`, tableName, where,
+	))); err != nil {
+		stmts.Close()
+		return nil, err
+	}
+	if stmts.update, err = tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`UPDATE "%s" SET sketch = ? WHERE %s`, tableName, where,
+	))); err != nil {
+		stmts.Close()
+		return nil, err
+	}
+	return stmts, nil
+}
+
+func (s *hllStatements) Close() {
+	if s == nil {
+		return
+	}
+	for _, stmt := range []*sql.Stmt{s.insert, s.lock, s.update} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// merge 把 ipIDs 合并进 keys 对应的草图
+func (s *hllStatements) merge(keys []interface{}, ipIDs map[int64]struct{}) error {
+	if s == nil || len(ipIDs) == 0 {
+		return nil
+	}
+	sketch := hll.New()
+	for ipID := range ipIDs {
+		sketch.AddID(ipID)
+	}
+	data, err := sketch.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	result, err := s.insert.Exec(append(append([]interface{}{}, keys...), data)...)
+	if err != nil {
+		return err
+	}
+	if inserted, _ := result.RowsAffected(); inserted > 0 {
+		return nil
+	}
+
+	var existing []byte
+	if err := s.lock.QueryRow(keys...).Scan(&existing); err != nil {
+		return err
+	}
+	current, err := hll.Unmarshal(existing)
+	if err != nil {
+		return err
+	}
+	current.Merge(sketch)
+	if data, err = current.MarshalBinary(); err != nil {
+		return err
+	}
+	_, err = s.update.Exec(append([]interface{}{data}, keys...)...)
+	return err
+}
+
+// rebuildSketches 按精确 IP 集合重建满足 condition 的草图（condition 只引用主键列）
+func rebuildSketches(tx *sql.Tx, websiteID string, table hllTable, condition string, args ...interface{}) error {
+	tableName := fmt.Sprintf("%s_%s", websiteID, table.name)
+	ipTableName := fmt.Sprintf("%s_%s", websiteID, table.ipTable)
+	keyList := strings.Join(table.keyColumns, ", ")
+
+	if _, err := tx.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`DELETE FROM "%s" WHERE %s`, tableName, condition,
+	)), args...); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`SELECT %s, ip_id FROM "%s" WHERE %s`, keyList, ipTableName, condition,
+	)), args...)
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		keys   []interface{}
+		sketch *hll.Sketch
+	}
+	entries := make(map[string]*entry)
+	order := make([]string, 0)
+	for rows.Next() {
+		values := make([]interface{}, len(table.keyColumns)+1)
+		targets := make([]interface{}, len(values))
+		for i := range values {
+			targets[i] = &values[i]
+		}
+		if err := rows.Scan(targets...); err != nil {
+			rows.Close()
+			return err
+		}
+		keys := values[:len(table.keyColumns)]
+		for i, value := range keys {
+			// DATE 列可能以 time.Time 或文本返回，统一成 "2006-01-02" 以便作为 map key 与写回
+			switch v := value.(type) {
+			case time.Time:
+				keys[i] = v.Format("2006-01-02")
+			case []byte:
+				keys[i] = string(v)
+			}
+		}
+		mapKey := fmt.Sprint(keys...)
+		item, ok := entries[mapKey]
+		if !ok {
+			item = &entry{keys: keys, sketch: hll.New()}
+			entries[mapKey] = item
+			order = append(order, mapKey)
+		}
+		ipID, ok := values[len(values)-1].(int64)
+		if !ok {
+			rows.Close()
+			return fmt.Errorf("无法解析 %s 的 ip_id: %v", ipTableName, values[len(values)-1])
+		}
+		item.sketch.AddID(ipID)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	if len(order) == 0 {
+		return nil
+	}
+	insert, err := tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`INSERT INTO "%s" (%s, sketch) VALUES (%s?)`,
+		tableName, keyList, strings.Repeat("?, ", len(table.keyColumns)),
+	)))
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, mapKey := range order {
+		item := entries[mapKey]
+		data, err := item.sketch.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if _, err := insert.Exec(append(append([]interface{}{}, item.keys...), data)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) backfillHLLIfEmpty(websiteID string) error {
+	hasSketches, err := r.tableHasRows(fmt.Sprintf("%s_agg_daily_hll", websiteID))
+	if err != nil || hasSketches {
+		return err
+	}
+	hasIPs, err := r.tableHasRows(fmt.Sprintf("%s_agg_daily_ip", websiteID))
+	if err != nil || !hasIPs {
+		return err
+	}
+	return r.backfillHLL(websiteID)
+}
+
+// backfillHLL 由精确 IP 集合逐日重建全部草图，覆盖聚合表的整个保留期
+func (r *Repository) backfillHLL(websiteID string) error {
+	logrus.WithField("website", websiteID).Info("开始回填 UV 草图")
+
+	rows, err := r.db.Query(fmt.Sprintf(
+		`SELECT DISTINCT day FROM "%s_agg_daily_ip" ORDER BY day`, websiteID,
+	))
+	if err != nil {
+		return err
+	}
+	var days []time.Time
+	for rows.Next() {
+		var day time.Time
+		if err := rows.Scan(&day); err != nil {
+			rows.Close()
+			return err
+		}
+		days = append(days, day)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	// 小时草图覆盖 agg_hourly_ip 的全部桶（其保留期不长于日聚合）
+	if err := r.withTx(func(tx *sql.Tx) error {
+		return rebuildSketches(tx, websiteID, hourlyHLLTable, "bucket >= ?", int64(0))
+	}); err != nil {
+		return err
+	}
+	hasDim, err := r.tableExists(fmt.Sprintf("%s_agg_dim_daily_ip", websiteID))
+	if err != nil {
+		return err
+	}
+	for _, day := range days {
+		dayKey := day.Format("2006-01-02")
+		if err := r.withTx(func(tx *sql.Tx) error {
+			if err := rebuildSketches(tx, websiteID, dailyHLLTable, "day = ?", dayKey); err != nil {
+				return err
+			}
+			if !hasDim {
+				return nil
+			}
+			for _, item := range dimAggKinds {
+				if err := rebuildSketches(tx, websiteID, dimDailyHLLTable, "kind = ? AND day = ?", item.kind, dayKey); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	logrus.WithField("website", websiteID).Info("UV 草图回填完成")
+	return nil
+}
+
+func (r *Repository) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// applySketchUpdates 把批次内的访客集合合并进小时/日/维度草图，key 同样排序以保持锁顺序稳定
+func applySketchUpdates(aggs *aggStatements, batch *aggBatch) error {
+	buckets := make([]int64, 0, len(batch.hourlyIPs))
+	for bucket := range batch.hourlyIPs {
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+	for _, bucket := range buckets {
+		if err := aggs.hourlyHLL.merge([]interface{}{bucket}, batch.hourlyIPs[bucket]); err != nil {
+			return err
+		}
+	}
+
+	days := make([]string, 0, len(batch.dailyIPs))
+	for day := range batch.dailyIPs {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	for _, day := range days {
+		if err := aggs.dailyHLL.merge([]interface{}{day}, batch.dailyIPs[day]); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range sortedDimAggKeys(batch.dimDailyIPs) {
+		if err := aggs.dimDailyHLL.merge([]interface{}{key.kind, key.day, key.dimID}, batch.dimDailyIPs[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -124,25 +124,34 @@ func (b *aggBatch) add(log NginxLogRecord, ipID int64) {
 	}
 }
 
+// sampleWeight 为边缘采样日志的还原倍率，未采样的日志记为 1
+func sampleWeight(log NginxLogRecord) int64 {
+	if log.SampleRate < 1 {
+		return 1
+	}
+	return int64(log.SampleRate)
+}
+
 func addCounts(counts *aggCounts, log NginxLogRecord) {
 	if counts == nil {
 		return
 	}
+	weight := sampleWeight(log)
 	if log.PageviewFlag == 1 {
-		counts.pv++
-		counts.traffic += int64(log.BytesSent)
+		counts.pv += weight
+		counts.traffic += int64(log.BytesSent) * weight
 	}
 	switch {
 	case log.Status >= 200 && log.Status < 300:
-		counts.s2xx++
+		counts.s2xx += weight
 	case log.Status >= 300 && log.Status < 400:
-		counts.s3xx++
+		counts.s3xx += weight
 	case log.Status >= 400 && log.Status < 500:
-		counts.s4xx++
+		counts.s4xx += weight
 	case log.Status >= 500 && log.Status < 600:
-		counts.s5xx++
+		counts.s5xx += weight
 	default:
-		counts.other++
+		counts.other += weight
 	}
 }
 
@@ -39,6 +39,11 @@ type aggStatements struct {
 	upsertDaily    *sql.Stmt
 	insertHourlyIP *sql.Stmt
 	insertDailyIP  *sql.Stmt
+	upsertDimDaily *sql.Stmt
+	insertDimIP    *sql.Stmt
+	hourlyHLL      *hllStatements
+	dailyHLL       *hllStatements
+	dimDailyHLL    *hllStatements
 }
 
 type sessionStatements struct {
@@ -67,6 +72,9 @@ type aggBatch struct {
 	daily     map[string]*aggCounts
 	hourlyIPs map[int64]map[int64]struct{}
 	dailyIPs  map[string]map[int64]struct{}
+	// 按日维度聚合：PV 计数与访客 IP 集合
+	dimDaily    map[dimAggKey]int64
+	dimDailyIPs map[dimAggKey]map[int64]struct{}
 }
 
 type sessionState struct {
@@ -98,6 +106,7 @@ type logInsertRow struct {
 	refererID    int64
 	uaID         int64
 	locationID   int64
+	sampleRate   int
 }
 
 const sessionGapSeconds = int64(1800)
@@ -114,10 +123,12 @@ func newDimCaches() dimCaches {
 
 func newAggBatch() *aggBatch {
 	return &aggBatch{
-		hourly:    make(map[int64]*aggCounts),
-		daily:     make(map[string]*aggCounts),
-		hourlyIPs: make(map[int64]map[int64]struct{}),
-		dailyIPs:  make(map[string]map[int64]struct{}),
+		hourly:      make(map[int64]*aggCounts),
+		daily:       make(map[string]*aggCounts),
+		hourlyIPs:   make(map[int64]map[int64]struct{}),
+		dailyIPs:    make(map[string]map[int64]struct{}),
+		dimDaily:    make(map[dimAggKey]int64),
+		dimDailyIPs: make(map[dimAggKey]map[int64]struct{}),
 	}
 }
 
@@ -149,6 +160,11 @@ func (a *aggStatements) Close() {
 	closeStmt(a.upsertDaily)
 	closeStmt(a.insertHourlyIP)
 	closeStmt(a.insertDailyIP)
+	closeStmt(a.upsertDimDaily)
+	closeStmt(a.insertDimIP)
+	a.hourlyHLL.Close()
+	a.dailyHLL.Close()
+	a.dimDailyHLL.Close()
 }
 
 func (s *sessionStatements) Close() {
@@ -303,6 +319,8 @@ func prepareAggStatements(tx *sql.Tx, websiteID string) (*aggStatements, error)
 	dailyTable := fmt.Sprintf("%s_agg_daily", websiteID)
 	hourlyIPTable := fmt.Sprintf("%s_agg_hourly_ip", websiteID)
 	dailyIPTable := fmt.Sprintf("%s_agg_daily_ip", websiteID)
+	dimDailyTable := fmt.Sprintf("%s_agg_dim_daily", websiteID)
+	dimDailyIPTable := fmt.Sprintf("%s_agg_dim_daily_ip", websiteID)
 
 	upsertHourly, err := tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
 		`INSERT INTO "%s" (bucket, pv, traffic, s2xx, s3xx, s4xx, s5xx, other)
@@ -356,12 +374,55 @@ func prepareAggStatements(tx *sql.Tx, websiteID string) (*aggStatements, error)
 		return nil, err
 	}
 
-	return &aggStatements{
+	upsertDimDaily, err := tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`INSERT INTO "%s" (kind, day, dim_id, pv)
+         VALUES (?, ?, ?, ?)
+         ON CONFLICT(kind, day, dim_id) DO UPDATE SET pv = "%s".pv + excluded.pv`, dimDailyTable, dimDailyTable,
+	)))
+	if err != nil {
+		insertDailyIP.Close()
+		insertHourlyIP.Close()
+		upsertDaily.Close()
+		upsertHourly.Close()
+		return nil, err
+	}
+
+	insertDimIP, err := tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`INSERT INTO "%s" (kind, day, dim_id, ip_id) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`, dimDailyIPTable,
+	)))
+	if err != nil {
+		upsertDimDaily.Close()
+		insertDailyIP.Close()
+		insertHourlyIP.Close()
+		upsertDaily.Close()
+		upsertHourly.Close()
+		return nil, err
+	}
+
+	aggs := &aggStatements{
 		upsertHourly:   upsertHourly,
 		upsertDaily:    upsertDaily,
 		insertHourlyIP: insertHourlyIP,
 		insertDailyIP:  insertDailyIP,
-	}, nil
+		upsertDimDaily: upsertDimDaily,
+		insertDimIP:    insertDimIP,
+	}
+	for _, item := range []struct {
+		target **hllStatements
+		table  hllTable
+	}{
+		{target: &aggs.hourlyHLL, table: hourlyHLLTable},
+		{target: &aggs.dailyHLL, table: dailyHLLTable},
+		{target: &aggs.dimDailyHLL, table: dimDailyHLLTable},
+	} {
+		stmts, err := prepareHLLStatements(tx, websiteID, item.table)
+		if err != nil {
+			aggs.Close()
+			return nil, err
+		}
+		*item.target = stmts
+	}
+	return aggs, nil
 }
 
 func prepareFirstSeenStatement(tx *sql.Tx, websiteID string) (*sql.Stmt, error) {
@@ -423,10 +484,10 @@ func prepareSessionStatements(tx *sql.Tx, websiteID string) (*sessionStatements,
 		return nil, err
 	}
 
-	lockSessionKey, err := tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+	lockSessionKey, err := prepareAdvisoryLock(tx, fmt.Sprintf(
 		`SELECT pg_advisory_xact_lock(hashtext('%s:session'), (hashint8(?) # hashint8(?)))`,
 		websiteID,
-	)))
+	))
 	if err != nil {
 		updateSession.Close()
 		insertSession.Close()
@@ -435,12 +496,12 @@ func prepareSessionStatements(tx *sql.Tx, websiteID string) (*sessionStatements,
 		return nil, err
 	}
 
-	lockAggSessionDaily, err := tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+	lockAggSessionDaily, err := prepareAdvisoryLock(tx, fmt.Sprintf(
 		`SELECT pg_advisory_xact_lock(hashtext('%s:agg_session_daily'), hashtext(?))`,
 		websiteID,
-	)))
+	))
 	if err != nil {
-		lockSessionKey.Close()
+		closeAdvisoryLock(lockSessionKey)
 		updateSession.Close()
 		insertSession.Close()
 		upsertState.Close()
@@ -455,8 +516,8 @@ func prepareSessionStatements(tx *sql.Tx, websiteID string) (*sessionStatements,
              sessions = "%s".sessions + excluded.sessions`, dailyTable, dailyTable,
 	)))
 	if err != nil {
-		lockAggSessionDaily.Close()
-		lockSessionKey.Close()
+		closeAdvisoryLock(lockAggSessionDaily)
+		closeAdvisoryLock(lockSessionKey)
 		updateSession.Close()
 		insertSession.Close()
 		upsertState.Close()
@@ -472,8 +533,8 @@ func prepareSessionStatements(tx *sql.Tx, websiteID string) (*sessionStatements,
 	)))
 	if err != nil {
 		upsertDaily.Close()
-		lockAggSessionDaily.Close()
-		lockSessionKey.Close()
+		closeAdvisoryLock(lockAggSessionDaily)
+		closeAdvisoryLock(lockSessionKey)
 		updateSession.Close()
 		insertSession.Close()
 		upsertState.Close()
@@ -493,6 +554,20 @@ func prepareSessionStatements(tx *sql.Tx, websiteID string) (*sessionStatements,
 	}, nil
 }
 
+// prepareAdvisoryLock 预编译 advisory lock 语句；数据库不支持时（SQLite 单写者）返回 nil，调用方跳过加锁。
+func prepareAdvisoryLock(tx *sql.Tx, query string) (*sql.Stmt, error) {
+	if !sqlutil.SupportsAdvisoryLocks() {
+		return nil, nil
+	}
+	return tx.Prepare(sqlutil.ReplacePlaceholders(query))
+}
+
+func closeAdvisoryLock(stmt *sql.Stmt) {
+	if stmt != nil {
+		stmt.Close()
+	}
+}
+
 func applySessionAggUpdatesWithLocks(
 	stmts *sessionStatements,
 	sessionAggDaily map[string]int64,
@@ -713,7 +788,26 @@ func applyAggUpdates(aggs *aggStatements, batch *aggBatch) error {
 		}
 	}
 
-	return nil
+	for _, key := range sortedDimAggKeys(batch.dimDaily) {
+		if _, err := aggs.upsertDimDaily.Exec(key.kind, key.day, key.dimID, batch.dimDaily[key]); err != nil {
+			return err
+		}
+	}
+	for _, key := range sortedDimAggKeys(batch.dimDailyIPs) {
+		ips := batch.dimDailyIPs[key]
+		ipIDs := make([]int64, 0, len(ips))
+		for ipID := range ips {
+			ipIDs = append(ipIDs, ipID)
+		}
+		sort.Slice(ipIDs, func(i, j int) bool { return ipIDs[i] < ipIDs[j] })
+		for _, ipID := range ipIDs {
+			if _, err := aggs.insertDimIP.Exec(key.kind, key.day, key.dimID, ipID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return applySketchUpdates(aggs, batch)
 
 	// 旧实现（保留注释，便于回溯）：
 	/*
@@ -779,7 +873,7 @@ func bulkInsertLogRows(tx *sql.Tx, logTable string, rows []logInsertRow) error {
 	}
 
 	const (
-		columnCount = 10
+		columnCount = 11
 		// PostgreSQL 参数上限是 65535，预留余量避免触边界。
 		maxParams = 60000
 	)
@@ -811,15 +905,15 @@ func bulkInsertLogRowsChunk(tx *sql.Tx, logTable string, rows []logInsertRow) er
 	query.WriteString(logTable)
 	query.WriteString(`" (
         ip_id, pageview_flag, timestamp, method, url_id,
-        status_code, bytes_sent, referer_id, ua_id, location_id
+        status_code, bytes_sent, referer_id, ua_id, location_id, sample_rate
     ) VALUES `)
 
-	args := make([]interface{}, 0, len(rows)*10)
+	args := make([]interface{}, 0, len(rows)*11)
 	for i, row := range rows {
 		if i > 0 {
 			query.WriteString(",")
 		}
-		query.WriteString("(?,?,?,?,?,?,?,?,?,?)")
+		query.WriteString("(?,?,?,?,?,?,?,?,?,?,?)")
 		args = append(
 			args,
 			row.ipID,
@@ -832,6 +926,7 @@ func bulkInsertLogRowsChunk(tx *sql.Tx, logTable string, rows []logInsertRow) er
 			row.refererID,
 			row.uaID,
 			row.locationID,
+			row.sampleRate,
 		)
 	}
 
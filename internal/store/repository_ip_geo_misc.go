@@ -307,6 +307,17 @@ func (r *Repository) BatchInsertLogsForWebsite(websiteID string, logs []NginxLog
 	if len(logs) == 0 {
 		return nil
 	}
+	return r.batchInsertLogs(websiteID, logs, nil)
+}
+
+// batchInsertLogs 写入一批日志，states 不为空时在同一个事务内写入扫描状态
+func (r *Repository) batchInsertLogs(websiteID string, logs []NginxLogRecord, states []ScanStateRow) error {
+	if pending, _ := r.RefreshMigrationPending(websiteID); pending {
+		return fmt.Errorf("站点 %s: %w", websiteID, ErrMigrationPending)
+	}
+	if err := r.ensureLogPartitionsForLogs(websiteID, logs); err != nil {
+		return err
+	}
 
 	// 不修改调用方的 slice，避免潜在副作用
 	logsCopy := append([]NginxLogRecord(nil), logs...)
@@ -318,10 +329,14 @@ func (r *Repository) BatchInsertLogsForWebsite(websiteID string, logs []NginxLog
 		maxDelay    = 2 * time.Second
 	)
 	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	insertOnce := r.batchInsertLogsForWebsiteOnce
+	if useCopyIngest() {
+		insertOnce = r.batchInsertLogsWithCopyOnce
+	}
 
 	var lastErr error
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		err := r.batchInsertLogsForWebsiteOnce(websiteID, logsCopy)
+		err := insertOnce(websiteID, logsCopy, states)
 		if err == nil {
 			return nil
 		}
@@ -350,7 +365,11 @@ func (r *Repository) BatchInsertLogsForWebsite(websiteID string, logs []NginxLog
 	return lastErr
 }
 
-func (r *Repository) batchInsertLogsForWebsiteOnce(websiteID string, logs []NginxLogRecord) (err error) {
+func (r *Repository) batchInsertLogsForWebsiteOnce(
+	websiteID string,
+	logs []NginxLogRecord,
+	states []ScanStateRow,
+) (err error) {
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
@@ -378,14 +397,14 @@ func (r *Repository) batchInsertLogsForWebsiteOnce(websiteID string, logs []Ngin
 		return err
 	}
 	defer firstSeenStmt.Close()
-	lockFirstSeenStmt, err := tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+	lockFirstSeenStmt, err := prepareAdvisoryLock(tx, fmt.Sprintf(
 		`SELECT pg_advisory_xact_lock(hashtext('%s:first_seen'), hashint8(?))`,
 		websiteID,
-	)))
+	))
 	if err != nil {
 		return err
 	}
-	defer lockFirstSeenStmt.Close()
+	defer closeAdvisoryLock(lockFirstSeenStmt)
 	sessions, err := prepareSessionStatements(tx, websiteID)
 	if err != nil {
 		return err
@@ -461,6 +480,7 @@ func (r *Repository) batchInsertLogsForWebsiteOnce(websiteID string, logs []Ngin
 			refererID:    refererID,
 			uaID:         uaID,
 			locationID:   locationID,
+			sampleRate:   log.SampleRate,
 		})
 
 		if log.PageviewFlag == 1 {
@@ -486,6 +506,7 @@ func (r *Repository) batchInsertLogsForWebsiteOnce(websiteID string, logs []Ngin
 		}
 
 		aggBatch.add(log, ipID)
+		aggBatch.addDims(log, ipID, urlID, refererID, uaID, locationID)
 	}
 
 	if err := bulkInsertLogRows(tx, logTable, logRows); err != nil {
@@ -500,8 +521,10 @@ func (r *Repository) batchInsertLogsForWebsiteOnce(websiteID string, logs []Ngin
 		}
 		sort.Slice(ipIDs, func(i, j int) bool { return ipIDs[i] < ipIDs[j] })
 		for _, ipID := range ipIDs {
-			if _, err := lockFirstSeenStmt.Exec(ipID); err != nil {
-				return err
+			if lockFirstSeenStmt != nil {
+				if _, err := lockFirstSeenStmt.Exec(ipID); err != nil {
+					return err
+				}
 			}
 			if _, err := firstSeenStmt.Exec(ipID, firstSeenMinTs[ipID]); err != nil {
 				return err
@@ -525,30 +548,25 @@ func (r *Repository) batchInsertLogsForWebsiteOnce(websiteID string, logs []Ngin
 	if err := applySessionStateUpserts(sessions, sessionStateUpserts); err != nil {
 		return err
 	}
+	if err := saveScanStateTx(tx, states); err != nil {
+		return err
+	}
 
 	return tx.Commit()
 }
 
-// CleanOldLogs 清理保留天数之前的日志数据
-func (r *Repository) CleanOldLogs() error {
-	retentionDays := config.ReadConfig().System.LogRetentionDays
-	if retentionDays <= 0 {
-		retentionDays = 30
-	}
-	cutoffTime := time.Now().AddDate(0, 0, -retentionDays).Unix()
-	cutoff := time.Unix(cutoffTime, 0)
-
+// CleanOldLogs 清理保留天数之前的日志数据；archive 不为空时先归档再删除原始日志
+func (r *Repository) CleanOldLogs(archive LogArchiver) error {
+	now := time.Now()
 	deletedCount := 0
+	partitionsDropped := 0
+	rawDeleted := make(map[string]bool)
+	// 站点可以覆盖全局的保留天数，按站点分别计算各层截止时间
+	cutoffsFor := func(websiteID string) retentionCutoffs {
+		return newRetentionCutoffs(now, config.GetRetentionPolicyForWebsite(websiteID))
+	}
 
-	rows, err := r.db.Query(`
-        SELECT c.relname
-        FROM pg_class c
-        JOIN pg_namespace n ON n.oid = c.relnamespace
-        WHERE n.nspname = 'public'
-          AND c.relkind IN ('r', 'p')
-          AND c.relispartition = false
-          AND c.relname LIKE '%\_nginx_logs' ESCAPE '\'
-    `)
+	rows, err := r.db.Query(sqlutil.LogTablesQuery())
 	if err != nil {
 		return fmt.Errorf("查询表名失败: %v", err)
 	}
@@ -565,45 +583,80 @@ func (r *Repository) CleanOldLogs() error {
 	}
 
 	for _, tableName := range tableNames {
-		result, err := r.db.Exec(
-			sqlutil.ReplacePlaceholders(fmt.Sprintf(`DELETE FROM "%s" WHERE timestamp < ?`, tableName)),
-			cutoffTime,
-		)
+		websiteID := strings.TrimSuffix(tableName, "_nginx_logs")
+		cutoff := cutoffsFor(websiteID).raw
+		if archive != nil {
+			if err := archive(websiteID, cutoff); err != nil {
+				logrus.WithError(err).Errorf("归档站点 %s 的过期日志失败，本轮不删除其原始日志", websiteID)
+				continue
+			}
+		}
+		cutoffTime := cutoff.Unix()
+		// 分区表先整体删除过期分区，只有边界分区/默认分区中仍有过期数据时才逐行删除
+		dropped, err := r.maintainLogPartitions(tableName, cutoffTime)
+		if err != nil {
+			logrus.WithError(err).Warnf("维护表 %s 的日志分区失败", tableName)
+		}
+		partitionsDropped += dropped
+
+		expiredTables, err := r.expiredLogTables(tableName, cutoffTime)
 		if err != nil {
-			logrus.WithError(err).Errorf("清理表 %s 的旧日志失败", tableName)
+			logrus.WithError(err).Errorf("检查表 %s 的过期日志失败", tableName)
 			continue
 		}
+		count := int64(0)
+		for _, table := range expiredTables {
+			result, err := r.db.Exec(
+				sqlutil.ReplacePlaceholders(fmt.Sprintf(`DELETE FROM "%s" WHERE timestamp < ?`, table)),
+				cutoffTime,
+			)
+			if err != nil {
+				logrus.WithError(err).Errorf("清理表 %s 的旧日志失败", table)
+				continue
+			}
+			deleted, _ := result.RowsAffected()
+			count += deleted
+		}
 
-		count, _ := result.RowsAffected()
 		deletedCount += int(count)
+		if count > 0 || dropped > 0 {
+			rawDeleted[strings.TrimSuffix(tableName, "_nginx_logs")] = true
+		}
 	}
 
-	if deletedCount > 0 {
-		visited := make(map[string]struct{})
-		for _, tableName := range tableNames {
-			if !strings.HasSuffix(tableName, "_nginx_logs") {
-				continue
-			}
-			websiteID := strings.TrimSuffix(tableName, "_nginx_logs")
-			if websiteID == "" {
-				continue
-			}
-			if _, ok := visited[websiteID]; ok {
-				continue
+	// 聚合表按各自的保留天数过期，即使本轮没有删除原始日志也需要检查
+	visited := make(map[string]struct{})
+	for _, tableName := range tableNames {
+		if !strings.HasSuffix(tableName, "_nginx_logs") {
+			continue
+		}
+		websiteID := strings.TrimSuffix(tableName, "_nginx_logs")
+		if websiteID == "" {
+			continue
+		}
+		if _, ok := visited[websiteID]; ok {
+			continue
+		}
+		visited[websiteID] = struct{}{}
+		cutoffs := cutoffsFor(websiteID)
+		if rawDeleted[websiteID] {
+			if err := r.cleanupSessions(websiteID, cutoffs.raw); err != nil {
+				logrus.WithError(err).Warnf("清理网站 %s 的会话数据失败", websiteID)
 			}
-			visited[websiteID] = struct{}{}
+		}
+		aggDeleted, err := r.cleanupAggregates(websiteID, cutoffs, rawDeleted[websiteID])
+		if err != nil {
+			logrus.WithError(err).Warnf("清理网站 %s 的聚合数据失败", websiteID)
+		}
+		if rawDeleted[websiteID] || aggDeleted {
 			if err := r.cleanupOrphanDims(websiteID); err != nil {
 				logrus.WithError(err).Warnf("清理网站 %s 的维表孤儿数据失败", websiteID)
 			}
-			if err := r.cleanupAggregates(websiteID, cutoff); err != nil {
-				logrus.WithError(err).Warnf("清理网站 %s 的聚合数据失败", websiteID)
-			}
-			if err := r.cleanupSessions(websiteID, cutoff); err != nil {
-				logrus.WithError(err).Warnf("清理网站 %s 的会话数据失败", websiteID)
-			}
 		}
+	}
 
-		logrus.Infof("删除了 %d 条 %d 天前的日志记录", deletedCount, retentionDays)
+	if deletedCount > 0 || partitionsDropped > 0 {
+		logrus.Infof("删除了 %d 个分区和 %d 条超过保留天数的日志记录", partitionsDropped, deletedCount)
 	}
 
 	return nil
@@ -643,27 +696,6 @@ func (r *Repository) ClearAllLogs() error {
 	return nil
 }
 
-func (r *Repository) createTables() error {
-	if err := r.ensureIPGeoCacheTable(); err != nil {
-		return err
-	}
-	if err := r.ensureIPGeoPendingTable(); err != nil {
-		return err
-	}
-	if err := r.ensureIPGeoAPIFailureTable(); err != nil {
-		return err
-	}
-	if err := r.ensureSystemNotificationTable(); err != nil {
-		return err
-	}
-	for _, id := range config.GetAllWebsiteIDs() {
-		if err := r.ensureWebsiteSchema(id); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 func (r *Repository) ensureIPGeoCacheTable() error {
 	stmts := []string{
 		`CREATE TABLE IF NOT EXISTS "ip_geo_cache" (
@@ -124,11 +124,21 @@ func (r *Repository) MarkIPGeoPendingForWebsite(
 		defer updateSessionsStmt.Close()
 	}
 
+	// 该 IP 的全部日志改为待解析，地域维度聚合同步迁移到待解析地域
+	locationAggs, err := r.prepareLocationAggMover(tx, websiteID, true)
+	if err != nil {
+		return err
+	}
+	defer locationAggs.Close()
+
 	for _, ip := range unique {
 		ipID, ok := ipIDs[ip]
 		if !ok {
 			continue
 		}
+		if err := locationAggs.Move(ipID, pendingID, pendingID); err != nil {
+			return err
+		}
 		if _, err := updateLogsStmt.Exec(pendingID, ipID); err != nil {
 			return err
 		}
@@ -139,6 +149,9 @@ func (r *Repository) MarkIPGeoPendingForWebsite(
 		}
 	}
 
+	if err := locationAggs.Flush(); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
@@ -278,6 +291,12 @@ func (r *Repository) updateIPGeoLocationsForWebsiteOnce(
 		defer updateSessionsStmt.Close()
 	}
 
+	locationAggs, err := r.prepareLocationAggMover(tx, websiteID, false)
+	if err != nil {
+		return err
+	}
+	defer locationAggs.Close()
+
 	for _, ip := range ips {
 		ipID, ok := ipIDs[ip]
 		if !ok {
@@ -305,6 +324,9 @@ func (r *Repository) updateIPGeoLocationsForWebsiteOnce(
 		if err != nil {
 			return err
 		}
+		if err := locationAggs.Move(ipID, pendingID, locationID); err != nil {
+			return err
+		}
 		if _, err := updateLogsStmt.Exec(locationID, ipID, pendingID); err != nil {
 			return err
 		}
@@ -315,5 +337,8 @@ func (r *Repository) updateIPGeoLocationsForWebsiteOnce(
 		}
 	}
 
+	if err := locationAggs.Flush(); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
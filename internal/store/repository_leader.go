@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+)
+
+// migrationLockKey 为多实例同时启动时串行执行结构迁移的 PostgreSQL advisory lock 键
+const migrationLockKey = 7310642815
+
+func (r *Repository) ensureLeaderLeaseTable() error {
+	_, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS "leader_lease" (
+            name TEXT PRIMARY KEY,
+            holder TEXT NOT NULL,
+            expires_at TIMESTAMPTZ NOT NULL,
+            acquired_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+        )`)
+	return err
+}
+
+// AcquireLease 尝试以 holder 身份获取或续期名为 name 的租约，有效期为 ttl。
+// 租约未被占用、已过期或本就由 holder 持有时成功；过期时间以数据库时间计算，不受各实例时钟偏差影响。
+func (r *Repository) AcquireLease(name, holder string, ttl time.Duration) (bool, error) {
+	var current string
+	err := r.db.QueryRow(sqlutil.ReplacePlaceholders(`INSERT INTO "leader_lease" (name, holder, expires_at)
+        VALUES (?, ?, NOW() + make_interval(secs => ?))
+        ON CONFLICT (name) DO UPDATE SET
+            holder = excluded.holder,
+            expires_at = excluded.expires_at,
+            acquired_at = CASE WHEN "leader_lease".holder = excluded.holder
+                THEN "leader_lease".acquired_at ELSE NOW() END
+        WHERE "leader_lease".holder = excluded.holder OR "leader_lease".expires_at < NOW()
+        RETURNING holder`), name, holder, ttl.Seconds()).Scan(&current)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return current == holder, nil
+}
+
+// ReleaseLease 释放 holder 持有的租约，便于其他实例立即接管；租约已被他人持有时不做任何修改
+func (r *Repository) ReleaseLease(name, holder string) error {
+	_, err := r.db.Exec(sqlutil.ReplacePlaceholders(
+		`DELETE FROM "leader_lease" WHERE name = ? AND holder = ?`,
+	), name, holder)
+	return err
+}
+
+// LeaseHolder 返回租约当前的有效持有者，无人持有时返回空字符串
+func (r *Repository) LeaseHolder(name string) (string, error) {
+	var holder string
+	err := r.db.QueryRow(sqlutil.ReplacePlaceholders(
+		`SELECT holder FROM "leader_lease" WHERE name = ? AND expires_at >= NOW()`,
+	), name).Scan(&holder)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return holder, err
+}
+
+// lockMigrations 在 PostgreSQL 上获取结构迁移的 advisory lock，避免滚动升级时多个实例同时迁移；
+// 返回的函数释放锁。SQLite 只允许单实例使用，直接返回空操作。
+func (r *Repository) lockMigrations() (func(), error) {
+	if sqlutil.IsSQLite() {
+		return func() {}, nil
+	}
+	ctx := context.Background()
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return func() {
+		conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+		conn.Close()
+	}, nil
+}
@@ -0,0 +1,531 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrMigrationRequired 表示存在需要回填历史数据的待执行迁移，启动时不会自动执行
+var ErrMigrationRequired = errors.New("存在需要回填历史数据的待执行迁移")
+
+// ErrMigrationPending 表示站点的回填迁移尚未执行，执行完成前不写入该站点的日志
+var ErrMigrationPending = errors.New("站点存在待执行的回填迁移，暂停写入日志")
+
+// schemaMigration 为一条带版本号的结构迁移，同一作用域（全局或单个站点）内按版本号顺序执行。
+// apply 需保持幂等：迁移记录在 apply 成功后才写入，中途失败时下次会从头重试。
+type schemaMigration struct {
+	version int
+	name    string
+	// backfill 表示迁移会扫描已有日志回填数据，耗时随数据量增长
+	backfill bool
+	// present 判断引入迁移记录之前的旧库是否已具备该结构，首次迁移时据此只记录基线；为 nil 时总是执行
+	present func(r *Repository, websiteID string) (bool, error)
+	apply   func(r *Repository, websiteID string) error
+}
+
+// MigrationInfo 为一条待执行的迁移，WebsiteID 为空表示全局迁移
+type MigrationInfo struct {
+	WebsiteID string `json:"website_id,omitempty"`
+	Version   int    `json:"version"`
+	Name      string `json:"name"`
+	Backfill  bool   `json:"backfill"`
+}
+
+func (m MigrationInfo) String() string {
+	scope := "全局"
+	if m.WebsiteID != "" {
+		scope = "站点 " + m.WebsiteID
+	}
+	return fmt.Sprintf("%s v%d %s", scope, m.Version, m.Name)
+}
+
+// 新增迁移只能追加到列表末尾，已发布的版本号与内容不可修改
+var globalMigrations = []schemaMigration{
+	{
+		version: 1,
+		name:    "ip_geo_tables",
+		present: func(r *Repository, _ string) (bool, error) {
+			return r.tableExists("ip_geo_api_failures")
+		},
+		apply: func(r *Repository, _ string) error {
+			if err := r.ensureIPGeoCacheTable(); err != nil {
+				return err
+			}
+			if err := r.ensureIPGeoPendingTable(); err != nil {
+				return err
+			}
+			return r.ensureIPGeoAPIFailureTable()
+		},
+	},
+	{
+		version: 2,
+		name:    "system_notifications",
+		present: func(r *Repository, _ string) (bool, error) {
+			return r.tableExists("system_notifications")
+		},
+		apply: func(r *Repository, _ string) error {
+			return r.ensureSystemNotificationTable()
+		},
+	},
+	{
+		version: 3,
+		name:    "scan_state",
+		present: func(r *Repository, _ string) (bool, error) {
+			return r.tableExists("scan_state")
+		},
+		apply: func(r *Repository, _ string) error {
+			return r.ensureScanStateTable()
+		},
+	},
+	{
+		version: 4,
+		name:    "leader_lease",
+		present: func(r *Repository, _ string) (bool, error) {
+			return r.tableExists("leader_lease")
+		},
+		apply: func(r *Repository, _ string) error {
+			return r.ensureLeaderLeaseTable()
+		},
+	},
+	{
+		version: 5,
+		name:    "config_history",
+		present: func(r *Repository, _ string) (bool, error) {
+			return r.tableExists("config_history")
+		},
+		apply: func(r *Repository, _ string) error {
+			return r.ensureConfigHistoryTable()
+		},
+	},
+}
+
+var websiteMigrations = []schemaMigration{
+	{
+		version:  1,
+		name:     "log_tables",
+		backfill: true,
+		present: func(r *Repository, websiteID string) (bool, error) {
+			return r.tableHasColumn(fmt.Sprintf("%s_nginx_logs", websiteID), "ip_id")
+		},
+		apply: (*Repository).migrateLogTables,
+	},
+	{
+		version: 2,
+		name:    "log_sample_rate",
+		present: func(r *Repository, websiteID string) (bool, error) {
+			return r.tableHasColumn(fmt.Sprintf("%s_nginx_logs", websiteID), "sample_rate")
+		},
+		apply: func(r *Repository, websiteID string) error {
+			return r.ensureLogSampleRateColumn(fmt.Sprintf("%s_nginx_logs", websiteID))
+		},
+	},
+	{
+		version:  3,
+		name:     "aggregates",
+		backfill: true,
+		present:  websiteTablePresent("agg_daily"),
+		apply: func(r *Repository, websiteID string) error {
+			if err := createAggTables(r.db, websiteID); err != nil {
+				return err
+			}
+			return r.backfillAggregatesIfEmpty(websiteID)
+		},
+	},
+	{
+		version:  4,
+		name:     "dim_aggregates",
+		backfill: true,
+		present:  websiteTablePresent("agg_dim_daily"),
+		apply: func(r *Repository, websiteID string) error {
+			if err := createDimAggTables(r.db, websiteID); err != nil {
+				return err
+			}
+			return r.backfillDimAggregatesIfEmpty(websiteID)
+		},
+	},
+	{
+		version:  5,
+		name:     "uv_sketches",
+		backfill: true,
+		present:  websiteTablePresent("agg_daily_hll"),
+		apply: func(r *Repository, websiteID string) error {
+			if err := createHLLTables(r.db, websiteID); err != nil {
+				return err
+			}
+			return r.backfillHLLIfEmpty(websiteID)
+		},
+	},
+	{
+		version:  6,
+		name:     "first_seen",
+		backfill: true,
+		present:  websiteTablePresent("first_seen"),
+		apply: func(r *Repository, websiteID string) error {
+			if err := createFirstSeenTable(r.db, websiteID); err != nil {
+				return err
+			}
+			return r.backfillFirstSeenIfEmpty(websiteID)
+		},
+	},
+	{
+		version:  7,
+		name:     "sessions",
+		backfill: true,
+		present:  websiteTablePresent("sessions"),
+		apply: func(r *Repository, websiteID string) error {
+			if err := createSessionTables(r.db, websiteID); err != nil {
+				return err
+			}
+			return r.backfillSessionsIfEmpty(websiteID)
+		},
+	},
+	{
+		version:  8,
+		name:     "session_aggregates",
+		backfill: true,
+		present:  websiteTablePresent("agg_session_daily"),
+		apply: func(r *Repository, websiteID string) error {
+			if err := createSessionAggTables(r.db, websiteID); err != nil {
+				return err
+			}
+			return r.backfillSessionAggregatesIfEmpty(websiteID)
+		},
+	},
+}
+
+func websiteTablePresent(suffix string) func(r *Repository, websiteID string) (bool, error) {
+	return func(r *Repository, websiteID string) (bool, error) {
+		return r.tableExists(fmt.Sprintf("%s_%s", websiteID, suffix))
+	}
+}
+
+// migrateLogTables 创建日志表与维度表；旧版（无 ip_id 列）的日志表整体迁移为维度化结构
+func (r *Repository) migrateLogTables(websiteID string) error {
+	logTable := fmt.Sprintf("%s_nginx_logs", websiteID)
+	exists, err := r.tableExists(logTable)
+	if err != nil {
+		return err
+	}
+	if exists {
+		hasIPID, err := r.tableHasColumn(logTable, "ip_id")
+		if err != nil {
+			return err
+		}
+		if !hasIPID {
+			return r.migrateLegacyLogs(websiteID)
+		}
+	}
+	if err := createDimTables(r.db, websiteID); err != nil {
+		return err
+	}
+	if err := createLogTable(r.db, logTable); err != nil {
+		return err
+	}
+	return createLogIndexes(r.db, websiteID)
+}
+
+// migrationScope 为一个作用域的迁移计划：baseline 只需记录，pending 需要执行
+type migrationScope struct {
+	websiteID string
+	baseline  []schemaMigration
+	pending   []schemaMigration
+}
+
+// PendingMigrations 列出全局与各站点尚未执行的迁移（只读，不会记录基线）
+func (r *Repository) PendingMigrations() ([]MigrationInfo, error) {
+	scopes, err := r.planMigrations(config.GetAllWebsiteIDs())
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]MigrationInfo, 0)
+	for _, scope := range scopes {
+		for _, m := range scope.pending {
+			pending = append(pending, scope.info(m))
+		}
+	}
+	return pending, nil
+}
+
+// Migrate 按版本顺序执行全部待执行的迁移，返回已执行的迁移。
+// allowBackfill 为 false 时，已有日志的站点只执行到第一条需要回填的迁移之前，其余迁移保持待执行，
+// 这些站点记为待迁移（见 MigrationPending）并返回 ErrMigrationRequired；尚无日志的站点回填为空操作，总是直接执行。
+func (r *Repository) Migrate(allowBackfill bool) ([]MigrationInfo, error) {
+	return r.MigrateWebsites(config.GetAllWebsiteIDs(), allowBackfill)
+}
+
+// MigrateWebsites 与 Migrate 相同，但只处理全局与 websiteIDs 中的站点；
+// 配置热加载时用于在新配置生效前为新增站点建表。
+func (r *Repository) MigrateWebsites(websiteIDs []string, allowBackfill bool) ([]MigrationInfo, error) {
+	scopes, err := r.planMigrations(websiteIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocked []string
+	blockedSites := make(map[string]bool)
+	if !allowBackfill {
+		for i, scope := range scopes {
+			if scope.websiteID == "" {
+				continue
+			}
+			hasLogs, err := r.tableHasRows(fmt.Sprintf("%s_nginx_logs", scope.websiteID))
+			if err != nil {
+				return nil, err
+			}
+			if !hasLogs {
+				continue
+			}
+			for j, m := range scope.pending {
+				if !m.backfill {
+					continue
+				}
+				for _, rest := range scope.pending[j:] {
+					blocked = append(blocked, scope.info(rest).String())
+				}
+				scopes[i].pending = scope.pending[:j]
+				blockedSites[scope.websiteID] = true
+				break
+			}
+		}
+	}
+
+	if err := r.ensureMigrationTable(); err != nil {
+		return nil, err
+	}
+	applied := make([]MigrationInfo, 0)
+	for _, scope := range scopes {
+		for _, m := range scope.baseline {
+			if err := r.recordMigration(scope.websiteID, m); err != nil {
+				return applied, err
+			}
+		}
+		if len(scope.baseline) > 0 {
+			logrus.WithField("website", scope.websiteID).Infof("已记录 %d 条迁移基线", len(scope.baseline))
+		}
+		for _, m := range scope.pending {
+			info := scope.info(m)
+			logrus.Infof("执行结构迁移: %s", info)
+			start := time.Now()
+			if err := m.apply(r, scope.websiteID); err != nil {
+				return applied, fmt.Errorf("结构迁移 %s 失败: %w", info, err)
+			}
+			if err := r.recordMigration(scope.websiteID, m); err != nil {
+				return applied, err
+			}
+			logrus.Infof("结构迁移 %s 完成，耗时 %s", info, time.Since(start).Round(time.Millisecond))
+			applied = append(applied, info)
+		}
+		if scope.websiteID != "" {
+			r.setMigrationPending(scope.websiteID, blockedSites[scope.websiteID])
+		}
+	}
+	if len(blocked) > 0 {
+		return applied, fmt.Errorf(
+			"%w: %s；请执行 nginxpulse -migrate（可用 -migrate-dry-run 预览），或设置 system.autoMigrate=true 在启动后自动执行",
+			ErrMigrationRequired, strings.Join(blocked, ", "),
+		)
+	}
+	return applied, nil
+}
+
+// MigrationPending 返回站点是否有尚未执行的回填迁移；为 true 时不扫描、不写入该站点的日志
+func (r *Repository) MigrationPending(websiteID string) bool {
+	r.migrationMu.RLock()
+	defer r.migrationMu.RUnlock()
+	return r.migrationPending[websiteID]
+}
+
+// PendingMigrationSites 返回有尚未执行的回填迁移的站点
+func (r *Repository) PendingMigrationSites() []string {
+	r.migrationMu.RLock()
+	defer r.migrationMu.RUnlock()
+	sites := make([]string, 0, len(r.migrationPending))
+	for websiteID := range r.migrationPending {
+		sites = append(sites, websiteID)
+	}
+	sort.Strings(sites)
+	return sites
+}
+
+// RefreshMigrationPending 重新读取待迁移站点的迁移记录，其他进程（nginxpulse -migrate）执行完成后恢复写入
+func (r *Repository) RefreshMigrationPending(websiteID string) (bool, error) {
+	if !r.MigrationPending(websiteID) {
+		return false, nil
+	}
+	scope, err := r.planMigrationScope(websiteID, websiteMigrations)
+	if err != nil {
+		return true, err
+	}
+	for _, m := range scope.pending {
+		if m.backfill {
+			return true, nil
+		}
+	}
+	r.setMigrationPending(websiteID, false)
+	return false, nil
+}
+
+func (r *Repository) setMigrationPending(websiteID string, pending bool) {
+	r.migrationMu.Lock()
+	defer r.migrationMu.Unlock()
+	if !pending {
+		delete(r.migrationPending, websiteID)
+		return
+	}
+	if r.migrationPending == nil {
+		r.migrationPending = make(map[string]bool)
+	}
+	r.migrationPending[websiteID] = true
+}
+
+// RunPendingMigrations 执行启动时跳过的回填迁移（system.autoMigrate 开启时在服务开始监听后调用），
+// 按站点依次执行，每个站点完成后即恢复写入
+func (r *Repository) RunPendingMigrations() {
+	r.migrationRunMu.Lock()
+	defer r.migrationRunMu.Unlock()
+	for _, websiteID := range r.PendingMigrationSites() {
+		if err := r.InitWebsites([]string{websiteID}, true); err != nil {
+			logrus.WithError(err).WithField("website", websiteID).Error("执行回填迁移失败，站点保持暂停写入")
+			continue
+		}
+		logrus.WithField("website", websiteID).Info("回填迁移完成，恢复写入日志")
+	}
+}
+
+func (r *Repository) planMigrations(websiteIDs []string) ([]migrationScope, error) {
+	global, err := r.planMigrationScope("", globalMigrations)
+	if err != nil {
+		return nil, err
+	}
+	scopes := []migrationScope{global}
+	for _, id := range websiteIDs {
+		scope, err := r.planMigrationScope(id, websiteMigrations)
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+// planMigrationScope 对比迁移记录得到待执行列表；作用域没有任何记录、但标志表（全局为 ip_geo_cache，
+// 站点为日志表）已存在时，说明数据来自引入迁移记录之前的版本，已具备结构的迁移只记录基线。
+func (r *Repository) planMigrationScope(websiteID string, migrations []schemaMigration) (migrationScope, error) {
+	scope := migrationScope{websiteID: websiteID}
+	applied, err := r.appliedMigrations(websiteID)
+	if err != nil {
+		return scope, err
+	}
+
+	legacy := false
+	if len(applied) == 0 {
+		marker := "ip_geo_cache"
+		if websiteID != "" {
+			marker = fmt.Sprintf("%s_nginx_logs", websiteID)
+		}
+		legacy, err = r.tableExists(marker)
+		if err != nil {
+			return scope, err
+		}
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if legacy && m.present != nil {
+			present, err := m.present(r, websiteID)
+			if err != nil {
+				return scope, err
+			}
+			if present {
+				scope.baseline = append(scope.baseline, m)
+				continue
+			}
+		}
+		scope.pending = append(scope.pending, m)
+	}
+	return scope, nil
+}
+
+func (s migrationScope) info(m schemaMigration) MigrationInfo {
+	return MigrationInfo{WebsiteID: s.websiteID, Version: m.version, Name: m.name, Backfill: m.backfill}
+}
+
+func (r *Repository) ensureMigrationTable() error {
+	_, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS "schema_migrations" (
+            website_id TEXT NOT NULL,
+            version INT NOT NULL,
+            name TEXT NOT NULL,
+            applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            PRIMARY KEY(website_id, version)
+        )`)
+	return err
+}
+
+// appliedMigrations 返回作用域内已记录的迁移版本，迁移表不存在时视为没有记录
+func (r *Repository) appliedMigrations(websiteID string) (map[int]bool, error) {
+	applied := make(map[int]bool)
+	exists, err := r.tableExists("schema_migrations")
+	if err != nil || !exists {
+		return applied, err
+	}
+	rows, err := r.db.Query(sqlutil.ReplacePlaceholders(
+		`SELECT version FROM "schema_migrations" WHERE website_id = ?`,
+	), websiteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func (r *Repository) recordMigration(websiteID string, m schemaMigration) error {
+	_, err := r.db.Exec(sqlutil.ReplacePlaceholders(
+		`INSERT INTO "schema_migrations" (website_id, version, name) VALUES (?, ?, ?)
+         ON CONFLICT DO NOTHING`,
+	), websiteID, m.version, m.name)
+	return err
+}
+
+// moveMigrationRecords 在站点改名时迁移其迁移记录；newID 为空时直接删除（站点数据被清除）
+func moveMigrationRecords(tx *sql.Tx, oldID, newID string) error {
+	var exists int
+	err := tx.QueryRow(sqlutil.TableExistsQuery(), "schema_migrations").Scan(&exists)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if newID == "" {
+		_, err = tx.Exec(sqlutil.ReplacePlaceholders(
+			`DELETE FROM "schema_migrations" WHERE website_id = ?`,
+		), oldID)
+		return err
+	}
+	if _, err := tx.Exec(sqlutil.ReplacePlaceholders(
+		`DELETE FROM "schema_migrations" WHERE website_id = ?`,
+	), newID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(sqlutil.ReplacePlaceholders(
+		`UPDATE "schema_migrations" SET website_id = ? WHERE website_id = ?`,
+	), newID, oldID)
+	return err
+}
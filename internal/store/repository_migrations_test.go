@@ -0,0 +1,53 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestInitDefersBackfillMigrations 模拟升级后出现需要回填的迁移：启动不失败，站点暂停写入，执行后恢复
+func TestInitDefersBackfillMigrations(t *testing.T) {
+	repo := newDimAggTestRepository(t)
+	logs := []NginxLogRecord{{
+		IP: "10.0.0.1", PageviewFlag: 1, Timestamp: time.Now().Add(-time.Hour), Method: "GET", Url: "/",
+		Status: 200, Referer: "-", UserBrowser: "Chrome", UserOs: "Linux", UserDevice: "Desktop",
+		DomesticLocation: "本地", GlobalLocation: "本地",
+	}}
+	if err := repo.BatchInsertLogsForWebsite(dimAggTestWebsite, logs); err != nil {
+		t.Fatalf("写入日志失败: %v", err)
+	}
+
+	// 回到引入维度聚合之前的结构
+	for _, query := range []string{
+		fmt.Sprintf(`DROP TABLE "%s_agg_dim_daily"`, dimAggTestWebsite),
+		fmt.Sprintf(`DROP TABLE "%s_agg_dim_daily_ip"`, dimAggTestWebsite),
+		fmt.Sprintf(`DELETE FROM "schema_migrations" WHERE website_id = '%s' AND version >= 4`, dimAggTestWebsite),
+	} {
+		if _, err := repo.db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := repo.Init(); err != nil {
+		t.Fatalf("存在待执行的回填迁移时启动失败: %v", err)
+	}
+	if !repo.MigrationPending(dimAggTestWebsite) {
+		t.Fatal("站点应标记为待迁移")
+	}
+	if err := repo.BatchInsertLogsForWebsite(dimAggTestWebsite, logs); !errors.Is(err, ErrMigrationPending) {
+		t.Fatalf("待迁移站点写入日志 err = %v, want ErrMigrationPending", err)
+	}
+
+	repo.RunPendingMigrations()
+	if repo.MigrationPending(dimAggTestWebsite) {
+		t.Fatal("回填迁移执行后站点仍为待迁移")
+	}
+	if pv := readDimPV(t, repo); len(pv) == 0 {
+		t.Fatal("回填迁移未重建维度聚合")
+	}
+	if err := repo.BatchInsertLogsForWebsite(dimAggTestWebsite, logs); err != nil {
+		t.Fatalf("迁移完成后写入日志失败: %v", err)
+	}
+}
@@ -0,0 +1,639 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// 提前创建的分区数量：按天分区提前 7 天，按月分区提前 2 个月
+	logPartitionAheadDays   = 7
+	logPartitionAheadMonths = 2
+	// 迁移旧默认分区时，范围上界至少覆盖到当前时间之后的这段时长，避免迁移期间新日志越界
+	legacyPartitionMargin = 24 * time.Hour
+	logPartitionColumns   = `id, ip_id, pageview_flag, timestamp, method, url_id,
+            status_code, bytes_sent, referer_id, ua_id, location_id, sample_rate`
+)
+
+var logPartitionBoundPattern = regexp.MustCompile(`FROM \(([^)]*)\) TO \(([^)]*)\)`)
+
+// parseLogPartitionBound 解析 pg_get_expr 输出的分区边界，MINVALUE/MAXVALUE 映射为 int64 的极值。
+func parseLogPartitionBound(raw string) (int64, bool) {
+	value := strings.Trim(strings.TrimSpace(raw), "'")
+	switch strings.ToUpper(value) {
+	case "MINVALUE":
+		return math.MinInt64, true
+	case "MAXVALUE":
+		return math.MaxInt64, true
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	return parsed, err == nil
+}
+
+// logPartition 为日志表的一个时间范围分区，范围为 [from, to)（unix 秒）。
+// partitioned 表示该分区本身也按时间分区（旧数据所在的 <表>_legacy）。
+type logPartition struct {
+	name        string
+	from        int64
+	to          int64
+	partitioned bool
+}
+
+// logPartitionSet 缓存单个日志表的分区布局。
+// legacy 表示所有数据仍在默认分区中（旧版本建表方式），需要先由维护任务迁移。
+type logPartitionSet struct {
+	parts       []logPartition
+	defaultName string
+	legacy      bool
+}
+
+// unsplitLegacy 返回早期版本迁移出的、下界为 MINVALUE 的普通分区，需要改挂到 <表>_legacy 下再逐段拆分
+func (s *logPartitionSet) unsplitLegacy() (logPartition, bool) {
+	for _, part := range s.parts {
+		if part.from == math.MinInt64 && !part.partitioned {
+			return part, true
+		}
+	}
+	return logPartition{}, false
+}
+
+func (s *logPartitionSet) covering(ts int64) (logPartition, bool) {
+	for _, part := range s.parts {
+		if ts >= part.from && ts < part.to {
+			return part, true
+		}
+	}
+	return logPartition{}, false
+}
+
+func (s *logPartitionSet) overlaps(from, to int64) bool {
+	for _, part := range s.parts {
+		if from < part.to && part.from < to {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *logPartitionSet) add(part logPartition) {
+	s.parts = append(s.parts, part)
+	sort.Slice(s.parts, func(i, j int) bool { return s.parts[i].from < s.parts[j].from })
+}
+
+func logPartitionInterval() string {
+	if strings.TrimSpace(config.ReadConfig().System.LogPartitionInterval) == config.LogPartitionMonthly {
+		return config.LogPartitionMonthly
+	}
+	return config.LogPartitionDaily
+}
+
+// logPartitionRange 返回 ts 所在分区的起止时间（本地时区，与聚合表的 day 口径一致）。
+func logPartitionRange(ts time.Time, interval string) (time.Time, time.Time) {
+	local := ts.In(time.Local)
+	if interval == config.LogPartitionMonthly {
+		start := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, time.Local)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.Local)
+	return start, start.AddDate(0, 0, 1)
+}
+
+func logPartitionName(logTable string, start time.Time, interval string) string {
+	if interval == config.LogPartitionMonthly {
+		return fmt.Sprintf("%s_p%s", logTable, start.Format("200601"))
+	}
+	return fmt.Sprintf("%s_p%s", logTable, start.Format("20060102"))
+}
+
+// loadLogPartitions 从系统表读取日志表的分区及其范围。
+func (r *Repository) loadLogPartitions(logTable string) (*logPartitionSet, error) {
+	rows, err := r.db.Query(sqlutil.ReplacePlaceholders(
+		`SELECT c.relname, pg_get_expr(c.relpartbound, c.oid), c.relkind = 'p'
+         FROM pg_inherits i
+         JOIN pg_class c ON c.oid = i.inhrelid
+         JOIN pg_class p ON p.oid = i.inhparent
+         JOIN pg_namespace n ON n.oid = p.relnamespace
+         WHERE n.nspname = 'public' AND p.relname = ?`,
+	), logTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	set := &logPartitionSet{}
+	for rows.Next() {
+		var name, bound string
+		var partitioned bool
+		if err := rows.Scan(&name, &bound, &partitioned); err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(strings.TrimSpace(bound), "DEFAULT") {
+			set.defaultName = name
+			continue
+		}
+		match := logPartitionBoundPattern.FindStringSubmatch(bound)
+		if match == nil {
+			continue
+		}
+		from, okFrom := parseLogPartitionBound(match[1])
+		to, okTo := parseLogPartitionBound(match[2])
+		if !okFrom || !okTo {
+			continue
+		}
+		set.add(logPartition{name: name, from: from, to: to, partitioned: partitioned})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(set.parts) == 0 && set.defaultName != "" {
+		hasRows, err := r.tableHasRows(set.defaultName)
+		if err != nil {
+			return nil, err
+		}
+		set.legacy = hasRows
+	}
+	return set, nil
+}
+
+// logPartitionsLocked 返回缓存的分区布局，调用方需持有 partitionMu。
+func (r *Repository) logPartitionsLocked(logTable string) (*logPartitionSet, error) {
+	if set, ok := r.partitionCache[logTable]; ok {
+		return set, nil
+	}
+	set, err := r.loadLogPartitions(logTable)
+	if err != nil {
+		return nil, err
+	}
+	r.partitionCache[logTable] = set
+	return set, nil
+}
+
+func (r *Repository) forgetLogPartitions(logTable string) {
+	r.partitionMu.Lock()
+	delete(r.partitionCache, logTable)
+	r.partitionMu.Unlock()
+}
+
+// ensureLogPartitionsForLogs 在写入前为批次涉及的时间段创建分区，使回填的历史日志也能落入正确分区。
+func (r *Repository) ensureLogPartitionsForLogs(websiteID string, logs []NginxLogRecord) error {
+	if !sqlutil.SupportsPartitions() || len(logs) == 0 {
+		return nil
+	}
+	times := make([]time.Time, 0, 4)
+	seen := make(map[int64]struct{})
+	for _, log := range logs {
+		day, _ := logPartitionRange(log.Timestamp, config.LogPartitionDaily)
+		if _, ok := seen[day.Unix()]; ok {
+			continue
+		}
+		seen[day.Unix()] = struct{}{}
+		times = append(times, log.Timestamp)
+	}
+	return r.ensureLogPartitions(fmt.Sprintf("%s_nginx_logs", websiteID), times)
+}
+
+func (r *Repository) ensureLogPartitions(logTable string, times []time.Time) error {
+	if !sqlutil.SupportsPartitions() || len(times) == 0 {
+		return nil
+	}
+	r.partitionMu.Lock()
+	defer r.partitionMu.Unlock()
+
+	set, err := r.logPartitionsLocked(logTable)
+	if err != nil {
+		return err
+	}
+	// 旧表的数据还全部在默认分区中，此时建分区需要扫描整个默认分区；等维护任务完成迁移后再按时间分区写入
+	if set.legacy {
+		return nil
+	}
+
+	interval := logPartitionInterval()
+	for _, ts := range times {
+		if _, ok := set.covering(ts.Unix()); ok {
+			continue
+		}
+		start, end := logPartitionRange(ts, interval)
+		name := logPartitionName(logTable, start, interval)
+		// 切换过分区粒度时，按月范围可能与已有的按天分区重叠，退回按天创建
+		if set.overlaps(start.Unix(), end.Unix()) {
+			start, end = logPartitionRange(ts, config.LogPartitionDaily)
+			name = logPartitionName(logTable, start, config.LogPartitionDaily)
+			if set.overlaps(start.Unix(), end.Unix()) {
+				logrus.Warnf("日志表 %s 的分区范围与已有分区重叠，%s 的数据将写入默认分区", logTable, start.Format("2006-01-02"))
+				continue
+			}
+		}
+		part := logPartition{name: name, from: start.Unix(), to: end.Unix()}
+		if err := r.createLogPartition(logTable, set.defaultName, part); err != nil {
+			delete(r.partitionCache, logTable)
+			return fmt.Errorf("创建日志分区 %s 失败: %w", name, err)
+		}
+		set.add(part)
+	}
+	return nil
+}
+
+// createLogPartition 创建一个范围分区。默认分区中已有该范围的数据时，
+// 先建独立表并把数据搬过去，再 ATTACH 为分区（PostgreSQL 不允许直接创建与默认分区数据冲突的分区）。
+func (r *Repository) createLogPartition(logTable, defaultName string, part logPartition) (err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// 多实例同时建分区时串行化
+	if _, err = tx.Exec(sqlutil.ReplacePlaceholders(`SELECT pg_advisory_xact_lock(hashtext(?))`), logTable+":partition"); err != nil {
+		return err
+	}
+	var exists int
+	err = tx.QueryRow(sqlutil.TableExistsQuery(), part.name).Scan(&exists)
+	if err == nil {
+		return tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	hasDefaultRows := false
+	if defaultName != "" {
+		// 阻止并发写入默认分区，保证搬迁后 ATTACH 的校验不会失败
+		if _, err = tx.Exec(fmt.Sprintf(`LOCK TABLE "%s" IN SHARE ROW EXCLUSIVE MODE`, defaultName)); err != nil {
+			return err
+		}
+		if err = tx.QueryRow(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+			`SELECT EXISTS (SELECT 1 FROM "%s" WHERE timestamp >= ? AND timestamp < ?)`, defaultName,
+		)), part.from, part.to).Scan(&hasDefaultRows); err != nil {
+			return err
+		}
+	}
+
+	if !hasDefaultRows {
+		if _, err = tx.Exec(fmt.Sprintf(
+			`CREATE TABLE "%s" PARTITION OF "%s" FOR VALUES FROM (%d) TO (%d)`,
+			part.name, logTable, part.from, part.to,
+		)); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if _, err = tx.Exec(fmt.Sprintf(
+		`CREATE TABLE "%s" (LIKE "%s" INCLUDING DEFAULTS INCLUDING CONSTRAINTS)`, part.name, logTable,
+	)); err != nil {
+		return err
+	}
+	result, err := tx.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`WITH moved AS (
+            DELETE FROM "%s" WHERE timestamp >= ? AND timestamp < ?
+            RETURNING %s
+        )
+        INSERT INTO "%s" (%s) SELECT %s FROM moved`,
+		defaultName, logPartitionColumns, part.name, logPartitionColumns, logPartitionColumns,
+	)), part.from, part.to)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(fmt.Sprintf(
+		`ALTER TABLE "%s" ATTACH PARTITION "%s" FOR VALUES FROM (%d) TO (%d)`,
+		logTable, part.name, part.from, part.to,
+	)); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	moved, _ := result.RowsAffected()
+	logrus.Infof("日志分区 %s 已创建，从默认分区迁移 %d 条记录", part.name, moved)
+	return nil
+}
+
+// legacyLogTable 为旧数据所在的二级分区表名：其默认分区保存迁移前的全部日志，维护任务再逐段拆出按时间的子分区
+func legacyLogTable(logTable string) string {
+	return logTable + "_legacy"
+}
+
+// migrateLegacyLogPartition 把旧版本留在默认分区中（fromDefault）或早期迁移挂成 [MINVALUE, 上界) 的普通分区 source
+// 改挂到新建的二级分区表 <表>_legacy 下，作为它的默认分区，不搬迁数据：
+// 1. 加 NOT VALID 的 CHECK 约束后在线校验（只加 SHARE UPDATE EXCLUSIVE 锁，不阻塞读写）；
+// 2. 短事务内 DETACH source，建 [MINVALUE, 上界) 的 <表>_legacy 并把 source 作为其默认分区 ATTACH（已有约束可跳过全表扫描）。
+// 之后 splitLegacyLogPartition 每轮从中拆出一段按时间的子分区，过期后即可整体删除。
+func (r *Repository) migrateLegacyLogPartition(logTable, source string, fromDefault bool, upperBound int64) error {
+	to := upperBound
+	if fromDefault {
+		var maxTs sql.NullInt64
+		if err := r.db.QueryRow(fmt.Sprintf(`SELECT MAX(timestamp) FROM "%s"`, source)).Scan(&maxTs); err != nil {
+			return err
+		}
+		if !maxTs.Valid {
+			return nil
+		}
+		// 上界至少覆盖到当前时间之后，避免迁移期间新日志越界
+		upper := time.Unix(maxTs.Int64, 0)
+		if margin := time.Now().Add(legacyPartitionMargin); upper.Before(margin) {
+			upper = margin
+		}
+		_, end := logPartitionRange(upper, logPartitionInterval())
+		to = end.Unix()
+	}
+	legacyTable := legacyLogTable(logTable)
+	legacyDefault := legacyTable + "_default"
+	constraint := fmt.Sprintf("%s_range", legacyTable)
+
+	logrus.Infof("开始迁移日志表 %s 的旧数据分区 %s", logTable, source)
+	if _, err := r.db.Exec(fmt.Sprintf(
+		`ALTER TABLE "%s" DROP CONSTRAINT IF EXISTS "%s"`, source, constraint,
+	)); err != nil {
+		return err
+	}
+	if _, err := r.db.Exec(fmt.Sprintf(
+		`ALTER TABLE "%s" ADD CONSTRAINT "%s" CHECK (timestamp IS NOT NULL AND timestamp < %d) NOT VALID`,
+		source, constraint, to,
+	)); err != nil {
+		return err
+	}
+	if _, err := r.db.Exec(fmt.Sprintf(
+		`ALTER TABLE "%s" VALIDATE CONSTRAINT "%s"`, source, constraint,
+	)); err != nil {
+		return err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	stmts := []string{
+		fmt.Sprintf(`ALTER TABLE "%s" DETACH PARTITION "%s"`, logTable, source),
+		fmt.Sprintf(`ALTER TABLE "%s" RENAME TO "%s"`, source, legacyDefault),
+		fmt.Sprintf(
+			`CREATE TABLE "%s" PARTITION OF "%s" FOR VALUES FROM (MINVALUE) TO (%d) PARTITION BY RANGE (timestamp)`,
+			legacyTable, logTable, to,
+		),
+		fmt.Sprintf(`ALTER TABLE "%s" ATTACH PARTITION "%s" DEFAULT`, legacyTable, legacyDefault),
+		fmt.Sprintf(`ALTER TABLE "%s" DROP CONSTRAINT "%s"`, legacyDefault, constraint),
+	}
+	if fromDefault {
+		stmts = append(stmts, fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS "%s_default" PARTITION OF "%s" DEFAULT`, logTable, logTable,
+		))
+	}
+	for _, stmt := range stmts {
+		if _, err = tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	logrus.Infof("日志表 %s 的旧数据已改挂到 %s，将逐段拆分为按时间的分区", logTable, legacyTable)
+	return nil
+}
+
+// splitLegacyLogPartition 从 <表>_legacy 的默认分区中拆出最早一段未过期的数据，作为按时间的子分区（每轮一段）。
+// 早于 cutoff 的数据不搬迁，由保留期清理直接删除；拆分完成后该表在上界过期时整体删除。
+func (r *Repository) splitLegacyLogPartition(logTable string, legacy logPartition, cutoff int64) error {
+	r.partitionMu.Lock()
+	defer r.partitionMu.Unlock()
+
+	set, err := r.logPartitionsLocked(legacy.name)
+	if err != nil {
+		return err
+	}
+	if set.defaultName == "" {
+		return nil
+	}
+	var minTs sql.NullInt64
+	if err := r.db.QueryRow(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`SELECT MIN(timestamp) FROM "%s" WHERE timestamp >= ?`, set.defaultName,
+	)), cutoff).Scan(&minTs); err != nil {
+		return err
+	}
+	if !minTs.Valid {
+		return nil
+	}
+
+	ts := time.Unix(minTs.Int64, 0)
+	interval := logPartitionInterval()
+	start, end := logPartitionRange(ts, interval)
+	// 切换过分区粒度时，按月范围可能与已拆出的按天子分区重叠，退回按天拆分
+	if set.overlaps(start.Unix(), end.Unix()) {
+		interval = config.LogPartitionDaily
+		start, end = logPartitionRange(ts, interval)
+	}
+	part := logPartition{
+		name: logPartitionName(legacy.name, start, interval),
+		from: start.Unix(),
+		to:   min(end.Unix(), legacy.to),
+	}
+	if set.overlaps(part.from, part.to) {
+		return fmt.Errorf("%s 的分区范围与已有子分区重叠", start.Format("2006-01-02"))
+	}
+	if err := r.createLogPartition(legacy.name, set.defaultName, part); err != nil {
+		delete(r.partitionCache, legacy.name)
+		return err
+	}
+	set.add(part)
+	return nil
+}
+
+// expiredLogTables 返回仍需逐行删除早于 cutoff 的日志的表：不分区时为日志表本身；
+// 分区时只检查默认分区、旧数据的默认分区与跨越 cutoff 的分区，都没有过期数据时返回空，跳过 DELETE
+func (r *Repository) expiredLogTables(logTable string, cutoff int64) ([]string, error) {
+	if !sqlutil.SupportsPartitions() {
+		return []string{logTable}, nil
+	}
+
+	r.partitionMu.Lock()
+	var candidates []string
+	set, err := r.logPartitionsLocked(logTable)
+	if err == nil {
+		if set.defaultName != "" {
+			candidates = append(candidates, set.defaultName)
+		}
+		for _, part := range set.parts {
+			if part.from >= cutoff {
+				continue
+			}
+			if !part.partitioned {
+				candidates = append(candidates, part.name)
+				continue
+			}
+			var legacySet *logPartitionSet
+			if legacySet, err = r.logPartitionsLocked(part.name); err != nil {
+				break
+			}
+			if legacySet.defaultName != "" {
+				candidates = append(candidates, legacySet.defaultName)
+			}
+			for _, sub := range legacySet.parts {
+				if sub.from < cutoff {
+					candidates = append(candidates, sub.name)
+				}
+			}
+		}
+	}
+	r.partitionMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make([]string, 0, len(candidates))
+	for _, table := range candidates {
+		var expired bool
+		if err := r.db.QueryRow(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+			`SELECT EXISTS (SELECT 1 FROM "%s" WHERE timestamp < ?)`, table,
+		)), cutoff).Scan(&expired); err != nil {
+			return nil, err
+		}
+		if expired {
+			tables = append(tables, table)
+		}
+	}
+	return tables, nil
+}
+
+// maintainLogPartitions 为日志表执行分区维护：迁移旧默认分区、拆分一段旧数据、预建未来分区、删除过期分区。
+// 返回被删除的分区数量。
+func (r *Repository) maintainLogPartitions(logTable string, cutoff int64) (int, error) {
+	if !sqlutil.SupportsPartitions() {
+		return 0, nil
+	}
+
+	r.partitionMu.Lock()
+	set, err := r.logPartitionsLocked(logTable)
+	r.partitionMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	migrated := false
+	if set.legacy {
+		err = r.migrateLegacyLogPartition(logTable, set.defaultName, true, 0)
+		migrated = true
+	} else if part, ok := set.unsplitLegacy(); ok {
+		err = r.migrateLegacyLogPartition(logTable, part.name, false, part.to)
+		migrated = true
+	}
+	if migrated {
+		r.forgetLogPartitions(logTable)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("迁移旧数据分区失败: %w", err)
+	}
+
+	now := time.Now()
+	ahead := make([]time.Time, 0, logPartitionAheadDays+1)
+	if logPartitionInterval() == config.LogPartitionMonthly {
+		for i := 0; i <= logPartitionAheadMonths; i++ {
+			ahead = append(ahead, now.AddDate(0, i, 0))
+		}
+	} else {
+		for i := 0; i <= logPartitionAheadDays; i++ {
+			ahead = append(ahead, now.AddDate(0, 0, i))
+		}
+	}
+	if err := r.ensureLogPartitions(logTable, ahead); err != nil {
+		return 0, err
+	}
+
+	dropped, err := r.dropExpiredLogPartitions(logTable, cutoff)
+	if err != nil {
+		return dropped, err
+	}
+
+	// 旧数据表未整体过期时，先拆出一段，再删除已过期的子分区
+	r.partitionMu.Lock()
+	set, err = r.logPartitionsLocked(logTable)
+	r.partitionMu.Unlock()
+	if err != nil {
+		return dropped, err
+	}
+	for _, part := range set.parts {
+		if !part.partitioned {
+			continue
+		}
+		if err := r.splitLegacyLogPartition(logTable, part, cutoff); err != nil {
+			logrus.WithError(err).Warnf("拆分日志表 %s 的旧数据分区失败", logTable)
+		}
+		subDropped, err := r.dropExpiredLogPartitions(part.name, cutoff)
+		dropped += subDropped
+		if err != nil {
+			return dropped, err
+		}
+	}
+	return dropped, nil
+}
+
+// dropExpiredLogPartitions 整体删除范围上界不晚于 cutoff 的分区，代替逐行 DELETE。
+func (r *Repository) dropExpiredLogPartitions(logTable string, cutoff int64) (int, error) {
+	r.partitionMu.Lock()
+	defer r.partitionMu.Unlock()
+
+	set, err := r.logPartitionsLocked(logTable)
+	if err != nil {
+		return 0, err
+	}
+
+	dropped := 0
+	kept := set.parts[:0]
+	var dropErr error
+	for _, part := range set.parts {
+		if dropErr != nil || part.to > cutoff {
+			kept = append(kept, part)
+			continue
+		}
+		if err := r.dropLogPartition(logTable, part.name); err != nil {
+			dropErr = fmt.Errorf("删除日志分区 %s 失败: %w", part.name, err)
+			kept = append(kept, part)
+			continue
+		}
+		dropped++
+	}
+	set.parts = kept
+	if dropped > 0 {
+		logrus.Infof("日志表 %s 删除了 %d 个过期分区", logTable, dropped)
+	}
+	return dropped, dropErr
+}
+
+func (r *Repository) dropLogPartition(logTable, name string) (err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	if _, err = tx.Exec(sqlutil.ReplacePlaceholders(`SELECT pg_advisory_xact_lock(hashtext(?))`), logTable+":partition"); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(fmt.Sprintf(`ALTER TABLE "%s" DETACH PARTITION "%s"`, logTable, name)); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(fmt.Sprintf(`DROP TABLE "%s"`, name)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
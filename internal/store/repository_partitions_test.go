@@ -0,0 +1,44 @@
+package store
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+)
+
+func TestLogPartitionRange(t *testing.T) {
+	ts := time.Date(2024, 2, 29, 23, 30, 0, 0, time.Local)
+
+	start, end := logPartitionRange(ts, config.LogPartitionDaily)
+	if !start.Equal(time.Date(2024, 2, 29, 0, 0, 0, 0, time.Local)) || !end.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)) {
+		t.Fatalf("daily range = [%v, %v)", start, end)
+	}
+	if name := logPartitionName("abcd_nginx_logs", start, config.LogPartitionDaily); name != "abcd_nginx_logs_p20240229" {
+		t.Fatalf("daily name = %q", name)
+	}
+
+	start, end = logPartitionRange(ts, config.LogPartitionMonthly)
+	if !start.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local)) || !end.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)) {
+		t.Fatalf("monthly range = [%v, %v)", start, end)
+	}
+	if name := logPartitionName("abcd_nginx_logs", start, config.LogPartitionMonthly); name != "abcd_nginx_logs_p202402" {
+		t.Fatalf("monthly name = %q", name)
+	}
+}
+
+func TestParseLogPartitionBound(t *testing.T) {
+	match := logPartitionBoundPattern.FindStringSubmatch("FOR VALUES FROM (MINVALUE) TO ('1700000000')")
+	if match == nil {
+		t.Fatal("bound pattern did not match")
+	}
+	from, ok := parseLogPartitionBound(match[1])
+	if !ok || from != math.MinInt64 {
+		t.Fatalf("from = %d, %v", from, ok)
+	}
+	to, ok := parseLogPartitionBound(match[2])
+	if !ok || to != 1700000000 {
+		t.Fatalf("to = %d, %v", to, ok)
+	}
+}
@@ -0,0 +1,221 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+	"github.com/sirupsen/logrus"
+)
+
+// OrphanWebsite 为数据库中仍有数据表、但已不在配置中的站点
+type OrphanWebsite struct {
+	ID        string     `json:"id"`
+	Tables    []string   `json:"tables"`
+	LastLogAt *time.Time `json:"last_log_at,omitempty"`
+}
+
+// PurgeResult 为清除站点数据的统计
+type PurgeResult struct {
+	Tables        int   `json:"tables"`
+	IPGeoFailures int64 `json:"ip_geo_failures"`
+	IPGeoPending  int64 `json:"ip_geo_pending"`
+	Notifications int64 `json:"notifications"`
+}
+
+// ListOrphanWebsites 列出数据库中存在日志表、但配置里已没有对应站点的站点 ID
+func (r *Repository) ListOrphanWebsites() ([]OrphanWebsite, error) {
+	ids, err := r.storedWebsiteIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := make([]OrphanWebsite, 0)
+	for _, id := range ids {
+		if _, ok := config.GetWebsiteByID(id); ok {
+			continue
+		}
+		relations, err := r.websiteRelations(id)
+		if err != nil {
+			return nil, err
+		}
+		orphan := OrphanWebsite{ID: id, Tables: make([]string, 0, len(relations))}
+		for _, rel := range relations {
+			if rel.kind == "table" {
+				orphan.Tables = append(orphan.Tables, rel.name)
+			}
+		}
+
+		var lastTs sql.NullInt64
+		if err := r.db.QueryRow(
+			fmt.Sprintf(`SELECT MAX(timestamp) FROM "%s_nginx_logs"`, id),
+		).Scan(&lastTs); err != nil {
+			return nil, err
+		}
+		if lastTs.Valid {
+			lastLogAt := time.Unix(lastTs.Int64, 0)
+			orphan.LastLogAt = &lastLogAt
+		}
+		orphans = append(orphans, orphan)
+	}
+	return orphans, nil
+}
+
+// PurgeWebsite 在同一个事务内删除已从配置中移除的站点的全部数据：
+// 站点表（见 websiteTables，含分区、索引与序列）、只属于该站点 IP 的归属地失败记录与待查询记录、关联该站点的系统通知，以及扫描状态。
+func (r *Repository) PurgeWebsite(websiteID string) (PurgeResult, error) {
+	if websiteID == "" {
+		return PurgeResult{}, errors.New("站点 ID 不能为空")
+	}
+	if _, ok := config.GetWebsiteByID(websiteID); ok {
+		return PurgeResult{}, fmt.Errorf("站点 %s 仍在配置中，请先从配置中移除", websiteID)
+	}
+
+	// 只接受数据库中确实存在日志表的站点 ID，避免把 ip、system 等全局表的前缀当作站点
+	stored, err := r.isStoredWebsite(websiteID)
+	if err != nil {
+		return PurgeResult{}, err
+	}
+	if !stored {
+		return PurgeResult{}, fmt.Errorf("数据库中没有站点 %s 的数据", websiteID)
+	}
+	relations, err := r.websiteRelations(websiteID)
+	if err != nil {
+		return PurgeResult{}, err
+	}
+
+	ipScope, err := r.exclusiveIPScope(websiteID)
+	if err != nil {
+		return PurgeResult{}, err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return PurgeResult{}, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var result PurgeResult
+	if ipScope != "" {
+		deleted, execErr := execRowsAffected(tx, fmt.Sprintf(
+			`DELETE FROM "ip_geo_api_failures" AS f WHERE %s`, strings.ReplaceAll(ipScope, "{ip}", "f.ip"),
+		))
+		if execErr != nil {
+			err = execErr
+			return PurgeResult{}, err
+		}
+		result.IPGeoFailures = deleted
+
+		deleted, execErr = execRowsAffected(tx, fmt.Sprintf(
+			`DELETE FROM "ip_geo_pending" AS p WHERE %s`, strings.ReplaceAll(ipScope, "{ip}", "p.ip"),
+		))
+		if execErr != nil {
+			err = execErr
+			return PurgeResult{}, err
+		}
+		result.IPGeoPending = deleted
+	}
+
+	result.Notifications, err = execRowsAffected(tx, sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`DELETE FROM "system_notifications" WHERE %s = ?`, sqlutil.JSONTextField("metadata", "website_id"),
+	)), websiteID)
+	if err != nil {
+		return PurgeResult{}, err
+	}
+
+	// 分区与索引随父表一起删除，因此使用 IF EXISTS
+	for _, rel := range relations {
+		if rel.kind != "table" {
+			continue
+		}
+		if _, err = tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, rel.name)); err != nil {
+			return PurgeResult{}, fmt.Errorf("删除表 %s 失败: %w", rel.name, err)
+		}
+		result.Tables++
+	}
+	if err = moveMigrationRecords(tx, websiteID, ""); err != nil {
+		return PurgeResult{}, fmt.Errorf("删除结构迁移记录失败: %w", err)
+	}
+	if err = moveScanStateRecords(tx, websiteID, ""); err != nil {
+		return PurgeResult{}, fmt.Errorf("删除扫描状态失败: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return PurgeResult{}, err
+	}
+	r.forgetLogPartitions(fmt.Sprintf("%s_nginx_logs", websiteID))
+
+	logrus.Infof("已清除站点 %s 的数据: %d 张表, %d 条归属地失败记录, %d 条系统通知",
+		websiteID, result.Tables, result.IPGeoFailures, result.Notifications)
+	return result, nil
+}
+
+// storedWebsiteIDs 返回数据库中存在日志表的站点 ID
+func (r *Repository) storedWebsiteIDs() ([]string, error) {
+	rows, err := r.db.Query(sqlutil.LogTablesQuery())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			return nil, err
+		}
+		ids = append(ids, strings.TrimSuffix(tableName, "_nginx_logs"))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// exclusiveIPScope 返回“IP 只出现在该站点”的过滤条件，{ip} 为待替换的列名；站点没有 IP 维表时返回空串
+func (r *Repository) exclusiveIPScope(websiteID string) (string, error) {
+	ipTable := fmt.Sprintf("%s_dim_ip", websiteID)
+	exists, err := r.tableExists(ipTable)
+	if err != nil || !exists {
+		return "", err
+	}
+
+	ids, err := r.storedWebsiteIDs()
+	if err != nil {
+		return "", err
+	}
+	conditions := []string{fmt.Sprintf(`EXISTS (SELECT 1 FROM "%s" AS ip WHERE ip.ip = {ip})`, ipTable)}
+	for _, id := range ids {
+		if id == websiteID {
+			continue
+		}
+		otherTable := fmt.Sprintf("%s_dim_ip", id)
+		exists, err := r.tableExists(otherTable)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			conditions = append(conditions,
+				fmt.Sprintf(`NOT EXISTS (SELECT 1 FROM "%s" AS other WHERE other.ip = {ip})`, otherTable))
+		}
+	}
+	return strings.Join(conditions, " AND "), nil
+}
+
+func execRowsAffected(tx *sql.Tx, query string, args ...interface{}) (int64, error) {
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
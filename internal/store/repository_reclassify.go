@@ -0,0 +1,306 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+)
+
+const (
+	reclassifyUpdateBatchSize = 500
+	reclassifyPageSize        = 10000
+)
+
+// ErrReclassifyCanceled 表示重新判定 PV 的任务被取消（已处理的日期保持一致，未处理的日期不变）
+var ErrReclassifyCanceled = errors.New("重新判定 PV 已取消")
+
+// PageviewClassifier 按当前规则判定一条日志是否计入 PV（返回 0/1）
+type PageviewClassifier func(statusCode int, path string, ip string) int
+
+// ReclassifyProgress 为重新判定 PV 的进度，按天推进
+type ReclassifyProgress struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	TotalDays   int       `json:"total_days"`
+	DoneDays    int       `json:"done_days"`
+	Scanned     int64     `json:"scanned"`
+	Promoted    int64     `json:"promoted"`
+	Demoted     int64     `json:"demoted"`
+	ChangedDays int       `json:"changed_days"`
+}
+
+// ReclassifyPageviews 使用 classify 重新判定 [start, end) 内已入库日志的 pageview_flag，
+// 并重建受影响的小时/日聚合、会话与首次访问数据。
+// 起始时间会被收敛到原始日志保留期内的第一个完整自然日，避免用残缺的日志重算保留更久的聚合。
+// progress 在每处理完一天后回调，返回 false 时中止。
+func (r *Repository) ReclassifyPageviews(
+	websiteID string,
+	start, end time.Time,
+	classify PageviewClassifier,
+	progress func(ReclassifyProgress) bool,
+) (ReclassifyProgress, error) {
+	logTable := fmt.Sprintf("%s_nginx_logs", websiteID)
+	exists, err := r.tableExists(logTable)
+	if err != nil {
+		return ReclassifyProgress{}, err
+	}
+	if !exists {
+		return ReclassifyProgress{}, fmt.Errorf("网站 %s 没有日志数据", websiteID)
+	}
+
+	now := time.Now()
+	rawCutoff := newRetentionCutoffs(now, config.GetRetentionPolicyForWebsite(websiteID)).raw
+	if start.Before(rawCutoff) {
+		start = startOfDay(rawCutoff).AddDate(0, 0, 1)
+	}
+	start = startOfDay(start)
+	if end.IsZero() || end.After(now) {
+		end = now
+	}
+
+	state := ReclassifyProgress{Start: start, End: end}
+	if !start.Before(end) {
+		return state, nil
+	}
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		state.TotalDays++
+	}
+
+	var changedDays []string
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		dayEnd := day.AddDate(0, 0, 1)
+		if dayEnd.After(end) {
+			dayEnd = end
+		}
+		result, err := r.reclassifyRange(websiteID, day, dayEnd, classify)
+		if err != nil {
+			return state, err
+		}
+		state.Scanned += result.scanned
+		state.Promoted += result.promoted
+		state.Demoted += result.demoted
+
+		if len(result.buckets) > 0 {
+			for _, bucket := range result.buckets {
+				if err := r.rebuildHourlyAggregate(websiteID, bucket); err != nil {
+					return state, err
+				}
+			}
+			dayKey := dayBucket(day)
+			if err := r.rebuildDailyAggregate(websiteID, dayKey); err != nil {
+				return state, err
+			}
+			changedDays = append(changedDays, dayKey)
+			state.ChangedDays++
+		}
+
+		state.DoneDays++
+		if progress != nil && !progress(state) {
+			// 已更新的日期需要同步会话与首次访问数据后再退出
+			if err := r.refreshPageviewDerivedData(websiteID, changedDays); err != nil {
+				return state, err
+			}
+			return state, ErrReclassifyCanceled
+		}
+	}
+
+	if err := r.refreshPageviewDerivedData(websiteID, changedDays); err != nil {
+		return state, err
+	}
+	return state, nil
+}
+
+type reclassifyResult struct {
+	scanned  int64
+	promoted int64
+	demoted  int64
+	buckets  []int64
+}
+
+// reclassifyRange 按 (timestamp, id) 分页判定 [start, end) 内的日志并逐页写回发生变化的 pageview_flag，
+// 返回受影响的小时桶
+func (r *Repository) reclassifyRange(
+	websiteID string,
+	start, end time.Time,
+	classify PageviewClassifier,
+) (reclassifyResult, error) {
+	logTable := fmt.Sprintf("%s_nginx_logs", websiteID)
+	query := sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`SELECT l.id, l.timestamp, l.status_code, l.pageview_flag, u.url, ip.ip
+         FROM "%s" l
+         JOIN "%s_dim_url" u ON u.id = l.url_id
+         JOIN "%s_dim_ip" ip ON ip.id = l.ip_id
+         WHERE l.timestamp >= ? AND l.timestamp < ?
+           AND (l.timestamp > ? OR (l.timestamp = ? AND l.id > ?))
+         ORDER BY l.timestamp, l.id
+         LIMIT ?`, logTable, websiteID, websiteID,
+	))
+
+	var result reclassifyResult
+	buckets := make(map[int64]struct{})
+	lastTS, lastID := start.Unix()-1, int64(0)
+	for {
+		rows, err := r.db.Query(query, start.Unix(), end.Unix(), lastTS, lastTS, lastID, reclassifyPageSize)
+		if err != nil {
+			return reclassifyResult{}, err
+		}
+
+		var promoted, demoted []int64
+		pageRows := 0
+		for rows.Next() {
+			var (
+				id, timestamp    int64
+				statusCode, flag int
+				path, ip         string
+			)
+			if err := rows.Scan(&id, &timestamp, &statusCode, &flag, &path, &ip); err != nil {
+				rows.Close()
+				return reclassifyResult{}, err
+			}
+			pageRows++
+			lastTS, lastID = timestamp, id
+			next := classify(statusCode, path, ip)
+			if next == flag {
+				continue
+			}
+			if next == 1 {
+				promoted = append(promoted, id)
+			} else {
+				demoted = append(demoted, id)
+			}
+			buckets[(timestamp/3600)*3600] = struct{}{}
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return reclassifyResult{}, err
+		}
+		rows.Close()
+
+		if err := r.updatePageviewFlags(logTable, start, end, promoted, 1); err != nil {
+			return reclassifyResult{}, err
+		}
+		if err := r.updatePageviewFlags(logTable, start, end, demoted, 0); err != nil {
+			return reclassifyResult{}, err
+		}
+		result.scanned += int64(pageRows)
+		result.promoted += int64(len(promoted))
+		result.demoted += int64(len(demoted))
+
+		if pageRows < reclassifyPageSize {
+			break
+		}
+	}
+
+	for bucket := range buckets {
+		result.buckets = append(result.buckets, bucket)
+	}
+	sort.Slice(result.buckets, func(i, j int) bool { return result.buckets[i] < result.buckets[j] })
+	return result, nil
+}
+
+// updatePageviewFlags 分批更新 pageview_flag；带上时间范围以便 PostgreSQL 只扫描对应分区
+func (r *Repository) updatePageviewFlags(logTable string, start, end time.Time, ids []int64, flag int) error {
+	for offset := 0; offset < len(ids); offset += reclassifyUpdateBatchSize {
+		batch := ids[offset:min(offset+reclassifyUpdateBatchSize, len(ids))]
+		args := make([]interface{}, 0, len(batch)+3)
+		args = append(args, flag, start.Unix(), end.Unix())
+		for _, id := range batch {
+			args = append(args, id)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		if _, err := r.db.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+			`UPDATE "%s" SET pageview_flag = ?
+             WHERE timestamp >= ? AND timestamp < ? AND id IN (%s)`, logTable, placeholders,
+		)), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshPageviewDerivedData 在 pageview_flag 变化后重建受影响日期附近的会话、
+// 受影响日期及其次日的会话聚合（跨零点的会话可能合并或拆分），以及首次访问时间
+func (r *Repository) refreshPageviewDerivedData(websiteID string, changedDays []string) error {
+	if len(changedDays) == 0 {
+		return nil
+	}
+
+	hasSessions, err := r.tableExists(fmt.Sprintf("%s_sessions", websiteID))
+	if err != nil {
+		return err
+	}
+	if hasSessions {
+		days := make(map[string]struct{}, len(changedDays)*2)
+		for _, day := range changedDays {
+			days[day] = struct{}{}
+			parsed, err := time.ParseInLocation("2006-01-02", day, time.Local)
+			if err != nil {
+				return err
+			}
+			next := parsed.AddDate(0, 0, 1)
+			if err := r.rebuildSessionsForRange(websiteID, parsed, next); err != nil {
+				return err
+			}
+			days[dayBucket(next)] = struct{}{}
+		}
+		for day := range days {
+			if err := r.rebuildSessionAggregatesForDay(websiteID, day); err != nil {
+				return err
+			}
+		}
+	}
+
+	first, err := time.ParseInLocation("2006-01-02", changedDays[0], time.Local)
+	if err != nil {
+		return err
+	}
+	return r.refreshFirstSeenSince(websiteID, first)
+}
+
+// refreshFirstSeenSince 重算首次访问时间不早于 since 的 IP：
+// 更早的首次访问不受 since 之后的 PV 变化影响，因此不需要（也无法）用已过期的日志重算
+func (r *Repository) refreshFirstSeenSince(websiteID string, since time.Time) error {
+	firstSeenTable := fmt.Sprintf("%s_first_seen", websiteID)
+	exists, err := r.tableExists(firstSeenTable)
+	if err != nil || !exists {
+		return err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(
+		sqlutil.ReplacePlaceholders(fmt.Sprintf(`DELETE FROM "%s" WHERE first_ts >= ?`, firstSeenTable)),
+		since.Unix(),
+	); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`INSERT INTO "%s" (ip_id, first_ts)
+         SELECT ip_id, MIN(timestamp)
+         FROM "%s_nginx_logs"
+         WHERE pageview_flag = 1 AND timestamp >= ?
+         GROUP BY ip_id
+         ON CONFLICT DO NOTHING`, firstSeenTable, websiteID,
+	)), since.Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func startOfDay(ts time.Time) time.Time {
+	local := ts.In(time.Local)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.Local)
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func readSessionSnapshot(t *testing.T, repo *Repository) string {
+	t.Helper()
+	var snapshot string
+	for _, query := range []string{
+		`SELECT ip_id, ua_id, start_ts, end_ts, entry_url_id, exit_url_id, page_count
+         FROM "%s_sessions" ORDER BY ip_id, ua_id, start_ts`,
+		`SELECT s.ip_id, s.ua_id, s.last_ts, se.start_ts, 0, 0, 0
+         FROM "%[1]s_session_state" s JOIN "%[1]s_sessions" se ON se.id = s.session_id
+         ORDER BY s.ip_id, s.ua_id`,
+		`SELECT 0, 0, 0, 0, 0, 0, sessions FROM "%s_agg_session_daily" ORDER BY day`,
+	} {
+		rows, err := repo.db.Query(fmt.Sprintf(query, dimAggTestWebsite))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var cols [7]int64
+			if err := rows.Scan(&cols[0], &cols[1], &cols[2], &cols[3], &cols[4], &cols[5], &cols[6]); err != nil {
+				rows.Close()
+				t.Fatal(err)
+			}
+			snapshot += fmt.Sprintln(cols)
+		}
+		rows.Close()
+		snapshot += "--\n"
+	}
+	return snapshot
+}
+
+// TestReclassifyRebuildsSessionsForRange 校验按天重建的会话与全量回填结果一致
+func TestReclassifyRebuildsSessionsForRange(t *testing.T) {
+	repo := newDimAggTestRepository(t)
+
+	day := startOfDay(time.Now()).AddDate(0, 0, -2)
+	pv := func(ip, url string, ts time.Time) NginxLogRecord {
+		return NginxLogRecord{
+			IP: ip, PageviewFlag: 1, Timestamp: ts, Method: "GET", Url: url, Status: 200,
+			BytesSent: 100, Referer: "-", UserBrowser: "Chrome", UserOs: "Linux", UserDevice: "Desktop",
+			DomesticLocation: "本地", GlobalLocation: "本地",
+		}
+	}
+	logs := []NginxLogRecord{
+		pv("10.0.0.1", "/", day.Add(-10*time.Minute)),
+		pv("10.0.0.1", "/skip", day.Add(10*time.Minute)),
+		pv("10.0.0.1", "/a", day.Add(35*time.Minute)),
+		pv("10.0.0.1", "/b", day.Add(50*time.Minute)),
+		pv("10.0.0.2", "/", day.Add(3*time.Hour)),
+		pv("10.0.0.2", "/skip", day.Add(3*time.Hour+20*time.Minute)),
+		pv("10.0.0.1", "/c", day.Add(30*time.Hour)),
+	}
+	if err := repo.BatchInsertLogsForWebsite(dimAggTestWebsite, logs); err != nil {
+		t.Fatalf("写入日志失败: %v", err)
+	}
+
+	classify := func(statusCode int, path string, ip string) int {
+		if path == "/skip" {
+			return 0
+		}
+		return 1
+	}
+	state, err := repo.ReclassifyPageviews(dimAggTestWebsite, day, day.AddDate(0, 0, 1), classify, nil)
+	if err != nil {
+		t.Fatalf("重新判定 PV 失败: %v", err)
+	}
+	if state.Demoted != 2 || state.ChangedDays != 1 {
+		t.Fatalf("progress = %+v, want demoted=2 changed_days=1", state)
+	}
+	rebuilt := readSessionSnapshot(t, repo)
+
+	if err := repo.backfillSessions(dimAggTestWebsite); err != nil {
+		t.Fatalf("回填会话失败: %v", err)
+	}
+	if err := repo.backfillSessionAggregates(dimAggTestWebsite); err != nil {
+		t.Fatalf("回填会话聚合失败: %v", err)
+	}
+	if full := readSessionSnapshot(t, repo); rebuilt != full {
+		t.Fatalf("按范围重建的会话:\n%s全量回填的会话:\n%s", rebuilt, full)
+	}
+}
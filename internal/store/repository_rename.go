@@ -0,0 +1,196 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+	"github.com/sirupsen/logrus"
+)
+
+type websiteRelation struct {
+	name string
+	kind string // table / index / sequence
+}
+
+// RenameWebsite 把站点 oldID 的全部表、分区、索引与序列在同一个事务内重命名为 newID 前缀，迁移记录与扫描状态随之迁移。
+// 目标 ID 已有表时，仅当这些表都为空（例如修改配置后服务已启动过一次）才会先删除再迁移。
+func (r *Repository) RenameWebsite(oldID, newID string) error {
+	if oldID == "" || newID == "" {
+		return errors.New("站点 ID 不能为空")
+	}
+	if oldID == newID {
+		return errors.New("新旧站点 ID 相同")
+	}
+	if err := config.CheckWebsiteID(newID); err != nil {
+		return err
+	}
+	stored, err := r.isStoredWebsite(oldID)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return fmt.Errorf("数据库中没有站点 %s 的数据", oldID)
+	}
+
+	sources, err := r.websiteRelations(oldID)
+	if err != nil {
+		return err
+	}
+	targets, err := r.websiteRelations(newID)
+	if err != nil {
+		return err
+	}
+	for _, rel := range targets {
+		if rel.kind != "table" {
+			continue
+		}
+		hasRows, err := r.tableHasRows(rel.name)
+		if err != nil {
+			return err
+		}
+		if hasRows {
+			return fmt.Errorf("站点 %s 已有数据（%s），无法覆盖", newID, rel.name)
+		}
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// 先删除目标 ID 的空表（分区、索引与序列随表一起删除）
+	for _, rel := range targets {
+		if rel.kind != "table" {
+			continue
+		}
+		if _, err = tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, rel.name)); err != nil {
+			return fmt.Errorf("删除空表 %s 失败: %w", rel.name, err)
+		}
+	}
+
+	// SQLite 不支持重命名索引，表重命名后按原定义以新名称重建
+	var sqliteIndexes []websiteRelation
+	for _, rel := range sources {
+		newName, ok := renameWebsiteRelation(rel.name, oldID, newID)
+		if !ok {
+			continue
+		}
+		switch {
+		case rel.kind == "index" && sqlutil.IsSQLite():
+			sqliteIndexes = append(sqliteIndexes, rel)
+		case rel.kind == "index":
+			_, err = tx.Exec(fmt.Sprintf(`ALTER INDEX "%s" RENAME TO "%s"`, rel.name, newName))
+		case rel.kind == "sequence":
+			_, err = tx.Exec(fmt.Sprintf(`ALTER SEQUENCE "%s" RENAME TO "%s"`, rel.name, newName))
+		default:
+			_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE "%s" RENAME TO "%s"`, rel.name, newName))
+		}
+		if err != nil {
+			return fmt.Errorf("重命名 %s 失败: %w", rel.name, err)
+		}
+	}
+	for _, rel := range sqliteIndexes {
+		newName, _ := renameWebsiteRelation(rel.name, oldID, newID)
+		var definition string
+		if err = tx.QueryRow(
+			`SELECT sql FROM sqlite_master WHERE type = 'index' AND name = ?`, rel.name,
+		).Scan(&definition); err != nil {
+			return fmt.Errorf("读取索引 %s 定义失败: %w", rel.name, err)
+		}
+		if _, err = tx.Exec(fmt.Sprintf(`DROP INDEX "%s"`, rel.name)); err != nil {
+			return err
+		}
+		if _, err = tx.Exec(strings.Replace(definition, rel.name, newName, 1)); err != nil {
+			return fmt.Errorf("重建索引 %s 失败: %w", newName, err)
+		}
+	}
+
+	if err = moveMigrationRecords(tx, oldID, newID); err != nil {
+		return fmt.Errorf("迁移结构迁移记录失败: %w", err)
+	}
+	if err = moveScanStateRecords(tx, oldID, newID); err != nil {
+		return fmt.Errorf("迁移扫描状态失败: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	r.forgetLogPartitions(fmt.Sprintf("%s_nginx_logs", oldID))
+	r.forgetLogPartitions(fmt.Sprintf("%s_nginx_logs", newID))
+
+	logrus.Infof("站点 %s 的 %d 个数据库对象已迁移到 %s", oldID, len(sources), newID)
+	return nil
+}
+
+// websiteTables 为每个站点的数据表（不含 "<id>_" 前缀），按依赖顺序排列；
+// 站点的数据库对象只按这份清单确定，不按名称前缀匹配，避免误伤 ip_geo_cache 等全局表或其他站点的表
+var websiteTables = []string{
+	"dim_ip", "dim_url", "dim_referer", "dim_ua", "dim_location",
+	"nginx_logs",
+	"agg_hourly", "agg_hourly_ip", "agg_daily", "agg_daily_ip",
+	"agg_dim_daily", "agg_dim_daily_ip",
+	"agg_hourly_hll", "agg_daily_hll", "agg_dim_daily_hll",
+	"first_seen",
+	"sessions", "session_state",
+	"agg_session_daily", "agg_entry_daily",
+}
+
+// websiteRelations 列出属于站点的数据库对象：websiteTables 中的表（含旧版迁移残留的 nginx_logs_new）、
+// 日志表的分区，以及这些表上的索引与序列
+func (r *Repository) websiteRelations(websiteID string) ([]websiteRelation, error) {
+	tables := make([]interface{}, 0, len(websiteTables)+1)
+	for _, name := range websiteTables {
+		tables = append(tables, fmt.Sprintf("%s_%s", websiteID, name))
+	}
+	tables = append(tables, fmt.Sprintf("%s_nginx_logs_new", websiteID))
+
+	rows, err := r.db.Query(sqlutil.WebsiteRelationsQuery(len(tables)), tables...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var relations []websiteRelation
+	for rows.Next() {
+		var rel websiteRelation
+		if err := rows.Scan(&rel.name, &rel.kind); err != nil {
+			return nil, err
+		}
+		relations = append(relations, rel)
+	}
+	return relations, rows.Err()
+}
+
+// isStoredWebsite 判断数据库中是否存在该站点的日志表
+func (r *Repository) isStoredWebsite(websiteID string) (bool, error) {
+	ids, err := r.storedWebsiteIDs()
+	if err != nil {
+		return false, err
+	}
+	for _, id := range ids {
+		if id == websiteID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// renameWebsiteRelation 返回对象在新站点 ID 下的名称；名称不以站点前缀开头的对象（例如由数据库生成的名称）保持不变
+func renameWebsiteRelation(name, oldID, newID string) (string, bool) {
+	if strings.HasPrefix(name, "idx_"+oldID+"_") {
+		return "idx_" + newID + strings.TrimPrefix(name, "idx_"+oldID), true
+	}
+	if strings.HasPrefix(name, oldID+"_") {
+		return newID + strings.TrimPrefix(name, oldID), true
+	}
+	return name, false
+}
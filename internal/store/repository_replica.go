@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	replicaHealthInterval = 15 * time.Second
+	replicaPingTimeout    = 3 * time.Second
+)
+
+// readReplica 为一个只读副本连接池，healthy 由后台健康检查维护
+type readReplica struct {
+	name    string // host:port/database，不含账号密码，用于日志
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// replicaPool 把统计查询轮询分发到健康的只读副本；写入始终使用主库
+type replicaPool struct {
+	replicas []*readReplica
+	next     atomic.Uint64
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// openReplicaPool 按 database.readDSN 打开只读副本。副本暂时不可用不影响启动，统计查询先回退到主库，
+// 健康检查发现其恢复后再切回。
+func openReplicaPool(cfg config.DatabaseConfig) (*replicaPool, error) {
+	pool := &replicaPool{stop: make(chan struct{})}
+	for i, dsn := range cfg.ReadDSN {
+		dsn = strings.TrimSpace(dsn)
+		if dsn == "" {
+			continue
+		}
+		pgConfig, err := pgx.ParseConfig(dsn)
+		if err != nil {
+			pool.close()
+			return nil, fmt.Errorf("解析只读副本 DSN（第 %d 个）失败: %w", i+1, err)
+		}
+		replica := &readReplica{
+			name: fmt.Sprintf("%s:%d/%s", pgConfig.Host, pgConfig.Port, pgConfig.Database),
+			db:   openPostgresPool(pgConfig, cfg),
+		}
+		pool.replicas = append(pool.replicas, replica)
+	}
+	if len(pool.replicas) == 0 {
+		return nil, nil
+	}
+
+	for _, replica := range pool.replicas {
+		if err := replica.ping(); err != nil {
+			logrus.WithError(err).Warnf("只读副本 %s 不可用，统计查询暂时使用主库", replica.name)
+			continue
+		}
+		replica.healthy.Store(true)
+		logrus.Infof("统计查询使用只读副本 %s", replica.name)
+	}
+
+	pool.wg.Add(1)
+	go pool.healthLoop()
+	return pool, nil
+}
+
+// pick 轮询返回一个健康的副本，全部不可用时返回 nil
+func (p *replicaPool) pick() *sql.DB {
+	count := uint64(len(p.replicas))
+	start := p.next.Add(1)
+	for i := uint64(0); i < count; i++ {
+		replica := p.replicas[(start+i)%count]
+		if replica.healthy.Load() {
+			return replica.db
+		}
+	}
+	return nil
+}
+
+func (p *replicaPool) healthLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(replicaHealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			for _, replica := range p.replicas {
+				replica.check()
+			}
+		}
+	}
+}
+
+func (p *replicaPool) close() {
+	close(p.stop)
+	p.wg.Wait()
+	for _, replica := range p.replicas {
+		replica.db.Close()
+	}
+}
+
+// check 更新副本的健康状态，仅在状态变化时记录日志
+func (r *readReplica) check() {
+	err := r.ping()
+	wasHealthy := r.healthy.Swap(err == nil)
+	switch {
+	case err != nil && wasHealthy:
+		logrus.WithError(err).Warnf("只读副本 %s 不可用，统计查询回退到主库", r.name)
+	case err == nil && !wasHealthy:
+		logrus.Infof("只读副本 %s 已恢复，统计查询切回副本", r.name)
+	}
+}
+
+func (r *readReplica) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaPingTimeout)
+	defer cancel()
+	return r.db.PingContext(ctx)
+}
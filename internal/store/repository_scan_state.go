@@ -0,0 +1,172 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+)
+
+// ScanStateRow 为一条日志扫描状态记录。Kind 区分站点汇总、本地日志文件与远端/流式目标，
+// Key 为文件路径或目标键（站点汇总为空）；State 为状态的 JSON，为空表示删除该记录。
+type ScanStateRow struct {
+	WebsiteID string
+	Kind      string
+	Key       string
+	State     json.RawMessage
+}
+
+// EnsureScanStateTable 创建扫描状态表；命令行工具不执行结构迁移，在读写扫描状态前调用
+func (r *Repository) EnsureScanStateTable() error {
+	return r.ensureScanStateTable()
+}
+
+func (r *Repository) ensureScanStateTable() error {
+	_, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS "scan_state" (
+            website_id TEXT NOT NULL,
+            kind TEXT NOT NULL,
+            state_key TEXT NOT NULL,
+            state TEXT NOT NULL,
+            updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            PRIMARY KEY(website_id, kind, state_key)
+        )`)
+	return err
+}
+
+// LoadScanState 读取全部扫描状态记录
+func (r *Repository) LoadScanState() ([]ScanStateRow, error) {
+	return r.queryScanState(`SELECT website_id, kind, state_key, state FROM "scan_state"`)
+}
+
+func (r *Repository) queryScanState(query string, args ...interface{}) ([]ScanStateRow, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states []ScanStateRow
+	for rows.Next() {
+		var row ScanStateRow
+		var state string
+		if err := rows.Scan(&row.WebsiteID, &row.Kind, &row.Key, &state); err != nil {
+			return nil, err
+		}
+		row.State = json.RawMessage(state)
+		states = append(states, row)
+	}
+	return states, rows.Err()
+}
+
+// SaveScanState 在一个事务内写入（或删除）扫描状态记录
+func (r *Repository) SaveScanState(states []ScanStateRow) (err error) {
+	if len(states) == 0 {
+		return nil
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	if err = saveScanStateTx(tx, states); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceScanState 用 states 替换站点的全部扫描状态记录；websiteID 为空时清空所有站点
+func (r *Repository) ReplaceScanState(websiteID string, states []ScanStateRow) (err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	if websiteID == "" {
+		_, err = tx.Exec(`DELETE FROM "scan_state"`)
+	} else {
+		_, err = tx.Exec(sqlutil.ReplacePlaceholders(`DELETE FROM "scan_state" WHERE website_id = ?`), websiteID)
+	}
+	if err != nil {
+		return err
+	}
+	if err = saveScanStateTx(tx, states); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// BatchInsertLogsWithScanState 与 BatchInsertLogsForWebsite 相同，但扫描状态与日志在同一个事务内提交，
+// 保证记录的读取位置与已写入的数据一致
+func (r *Repository) BatchInsertLogsWithScanState(websiteID string, logs []NginxLogRecord, states []ScanStateRow) error {
+	if len(logs) == 0 {
+		return r.SaveScanState(states)
+	}
+	return r.batchInsertLogs(websiteID, logs, states)
+}
+
+func saveScanStateTx(tx *sql.Tx, states []ScanStateRow) error {
+	if len(states) == 0 {
+		return nil
+	}
+	upsert, err := tx.Prepare(sqlutil.ReplacePlaceholders(`INSERT INTO "scan_state" (website_id, kind, state_key, state)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT (website_id, kind, state_key) DO UPDATE SET
+            state = excluded.state,
+            updated_at = NOW()`))
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+	remove, err := tx.Prepare(sqlutil.ReplacePlaceholders(
+		`DELETE FROM "scan_state" WHERE website_id = ? AND kind = ? AND state_key = ?`,
+	))
+	if err != nil {
+		return err
+	}
+	defer remove.Close()
+
+	for _, state := range states {
+		if len(state.State) == 0 {
+			_, err = remove.Exec(state.WebsiteID, state.Kind, state.Key)
+		} else {
+			_, err = upsert.Exec(state.WebsiteID, state.Kind, state.Key, string(state.State))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveScanStateRecords 在站点改名时迁移其扫描状态；newID 为空时直接删除（站点数据被清除）
+func moveScanStateRecords(tx *sql.Tx, oldID, newID string) error {
+	var exists int
+	err := tx.QueryRow(sqlutil.TableExistsQuery(), "scan_state").Scan(&exists)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if newID == "" {
+		_, err = tx.Exec(sqlutil.ReplacePlaceholders(`DELETE FROM "scan_state" WHERE website_id = ?`), oldID)
+		return err
+	}
+	if _, err := tx.Exec(sqlutil.ReplacePlaceholders(
+		`DELETE FROM "scan_state" WHERE website_id = ?`,
+	), newID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(sqlutil.ReplacePlaceholders(
+		`UPDATE "scan_state" SET website_id = ? WHERE website_id = ?`,
+	), newID, oldID)
+	return err
+}
@@ -3,6 +3,7 @@ package store
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 
 	"github.com/likaia/nginxpulse/internal/sqlutil"
 	"github.com/sirupsen/logrus"
@@ -15,16 +16,44 @@ func (r *Repository) cleanupOrphanDims(websiteID string) error {
 		return err
 	}
 
-	type dimSpec struct {
+	type dimRef struct {
 		table  string
 		column string
+		filter string
+	}
+	type dimSpec struct {
+		table string
+		refs  []dimRef
 	}
+	dimAggTable := fmt.Sprintf("%s_agg_dim_daily", websiteID)
+	dimAggIPTable := fmt.Sprintf("%s_agg_dim_daily_ip", websiteID)
+	// 聚合表可能比原始日志保留更久，仍被聚合引用的维度值不能删除。
+	// UV 草图按 ip_id 计数，仍在 first_seen 中的 IP 保留原 id，避免重新出现时在草图中被重复计数
 	dims := []dimSpec{
-		{table: fmt.Sprintf("%s_dim_ip", websiteID), column: "ip_id"},
-		{table: fmt.Sprintf("%s_dim_url", websiteID), column: "url_id"},
-		{table: fmt.Sprintf("%s_dim_referer", websiteID), column: "referer_id"},
-		{table: fmt.Sprintf("%s_dim_ua", websiteID), column: "ua_id"},
-		{table: fmt.Sprintf("%s_dim_location", websiteID), column: "location_id"},
+		{table: fmt.Sprintf("%s_dim_ip", websiteID), refs: []dimRef{
+			{table: logTable, column: "ip_id"},
+			{table: fmt.Sprintf("%s_first_seen", websiteID), column: "ip_id"},
+			{table: fmt.Sprintf("%s_agg_hourly_ip", websiteID), column: "ip_id"},
+			{table: fmt.Sprintf("%s_agg_daily_ip", websiteID), column: "ip_id"},
+			{table: dimAggIPTable, column: "ip_id"},
+		}},
+		{table: fmt.Sprintf("%s_dim_url", websiteID), refs: []dimRef{
+			{table: logTable, column: "url_id"},
+			{table: fmt.Sprintf("%s_agg_entry_daily", websiteID), column: "entry_url_id"},
+			{table: dimAggTable, column: "dim_id", filter: fmt.Sprintf("kind = %d", DimAggURL)},
+		}},
+		{table: fmt.Sprintf("%s_dim_referer", websiteID), refs: []dimRef{
+			{table: logTable, column: "referer_id"},
+			{table: dimAggTable, column: "dim_id", filter: fmt.Sprintf("kind = %d", DimAggReferer)},
+		}},
+		{table: fmt.Sprintf("%s_dim_ua", websiteID), refs: []dimRef{
+			{table: logTable, column: "ua_id"},
+			{table: dimAggTable, column: "dim_id", filter: fmt.Sprintf("kind = %d", DimAggUA)},
+		}},
+		{table: fmt.Sprintf("%s_dim_location", websiteID), refs: []dimRef{
+			{table: logTable, column: "location_id"},
+			{table: dimAggTable, column: "dim_id", filter: fmt.Sprintf("kind = %d", DimAggLocation)},
+		}},
 	}
 
 	for _, dim := range dims {
@@ -35,9 +64,25 @@ func (r *Repository) cleanupOrphanDims(websiteID string) error {
 		if !exists {
 			continue
 		}
+		conditions := make([]string, 0, len(dim.refs))
+		for _, ref := range dim.refs {
+			refExists, err := r.tableExists(ref.table)
+			if err != nil {
+				return err
+			}
+			if !refExists {
+				continue
+			}
+			if ref.filter != "" {
+				conditions = append(conditions, fmt.Sprintf(
+					`id NOT IN (SELECT %s FROM "%s" WHERE %s)`, ref.column, ref.table, ref.filter,
+				))
+			} else {
+				conditions = append(conditions, fmt.Sprintf(`id NOT IN (SELECT %s FROM "%s")`, ref.column, ref.table))
+			}
+		}
 		if _, err := r.db.Exec(fmt.Sprintf(
-			`DELETE FROM "%s" WHERE id NOT IN (SELECT %s FROM "%s")`,
-			dim.table, dim.column, logTable,
+			`DELETE FROM "%s" WHERE %s`, dim.table, strings.Join(conditions, " AND "),
 		)); err != nil {
 			return err
 		}
@@ -123,84 +168,17 @@ func (r *Repository) clearSessionAggTablesForWebsite(websiteID string) error {
 	return nil
 }
 
-func (r *Repository) ensureWebsiteSchema(websiteID string) error {
-	logTable := fmt.Sprintf("%s_nginx_logs", websiteID)
-	exists, err := r.tableExists(logTable)
-	if err != nil {
-		return err
-	}
-
-	if !exists {
-		if err := createDimTables(r.db, websiteID); err != nil {
-			return err
-		}
-		if err := createLogTable(r.db, logTable); err != nil {
-			return err
-		}
-		if err := createLogIndexes(r.db, websiteID); err != nil {
-			return err
-		}
-		if err := createAggTables(r.db, websiteID); err != nil {
-			return err
-		}
-		if err := createFirstSeenTable(r.db, websiteID); err != nil {
-			return err
-		}
-		if err := createSessionTables(r.db, websiteID); err != nil {
-			return err
-		}
-		if err := createSessionAggTables(r.db, websiteID); err != nil {
-			return err
-		}
-		if err := r.backfillAggregatesIfEmpty(websiteID); err != nil {
-			return err
-		}
-		if err := r.backfillFirstSeenIfEmpty(websiteID); err != nil {
-			return err
-		}
-		if err := r.backfillSessionsIfEmpty(websiteID); err != nil {
-			return err
-		}
-		return r.backfillSessionAggregatesIfEmpty(websiteID)
-	}
-
-	hasIPID, err := r.tableHasColumn(logTable, "ip_id")
-	if err != nil {
-		return err
-	}
-
-	if !hasIPID {
-		return r.migrateLegacyLogs(websiteID)
-	}
-
-	if err := createDimTables(r.db, websiteID); err != nil {
-		return err
-	}
-	if err := createLogIndexes(r.db, websiteID); err != nil {
-		return err
-	}
-	if err := createAggTables(r.db, websiteID); err != nil {
-		return err
-	}
-	if err := createFirstSeenTable(r.db, websiteID); err != nil {
-		return err
-	}
-	if err := createSessionTables(r.db, websiteID); err != nil {
-		return err
-	}
-	if err := createSessionAggTables(r.db, websiteID); err != nil {
-		return err
-	}
-	if err := r.backfillAggregatesIfEmpty(websiteID); err != nil {
-		return err
-	}
-	if err := r.backfillFirstSeenIfEmpty(websiteID); err != nil {
-		return err
-	}
-	if err := r.backfillSessionsIfEmpty(websiteID); err != nil {
+// ensureLogSampleRateColumn 为旧版日志表补齐 sample_rate 列（agent 边缘采样倍率，未采样为 1）。
+func (r *Repository) ensureLogSampleRateColumn(logTable string) error {
+	hasColumn, err := r.tableHasColumn(logTable, "sample_rate")
+	if err != nil || hasColumn {
 		return err
 	}
-	return r.backfillSessionAggregatesIfEmpty(websiteID)
+	_, err = r.db.Exec(fmt.Sprintf(
+		`ALTER TABLE "%s" ADD COLUMN IF NOT EXISTS sample_rate INT NOT NULL DEFAULT 1`,
+		logTable,
+	))
+	return err
 }
 
 func (r *Repository) migrateLegacyLogs(websiteID string) error {
@@ -316,6 +294,18 @@ func (r *Repository) migrateLegacyLogs(websiteID string) error {
 	if err := r.backfillAggregates(websiteID); err != nil {
 		return err
 	}
+	if err := createDimAggTables(r.db, websiteID); err != nil {
+		return err
+	}
+	if err := r.backfillDimAggregates(websiteID); err != nil {
+		return err
+	}
+	if err := createHLLTables(r.db, websiteID); err != nil {
+		return err
+	}
+	if err := r.backfillHLL(websiteID); err != nil {
+		return err
+	}
 	if err := r.backfillFirstSeen(websiteID); err != nil {
 		return err
 	}
@@ -331,14 +321,7 @@ func (r *Repository) migrateLegacyLogs(websiteID string) error {
 }
 
 func (r *Repository) tableExists(tableName string) (bool, error) {
-	row := r.db.QueryRow(sqlutil.ReplacePlaceholders(
-		`SELECT 1
-         FROM pg_class c
-         JOIN pg_namespace n ON n.oid = c.relnamespace
-         WHERE n.nspname = 'public'
-           AND c.relkind IN ('r', 'p')
-           AND c.relname = ?`,
-	), tableName)
+	row := r.db.QueryRow(sqlutil.TableExistsQuery(), tableName)
 	var exists int
 	if err := row.Scan(&exists); err != nil {
 		if err == sql.ErrNoRows {
@@ -366,12 +349,7 @@ func (r *Repository) tableHasRows(tableName string) (bool, error) {
 }
 
 func (r *Repository) tableHasColumn(tableName, columnName string) (bool, error) {
-	rows, err := r.db.Query(sqlutil.ReplacePlaceholders(
-		`SELECT 1
-         FROM information_schema.columns
-         WHERE table_schema = 'public' AND table_name = ? AND column_name = ?
-         LIMIT 1`,
-	), tableName, columnName)
+	rows, err := r.db.Query(sqlutil.ColumnExistsQuery(), tableName, columnName)
 	if err != nil {
 		return false, err
 	}
@@ -434,9 +412,16 @@ func createDimTables(execer sqlExecer, websiteID string) error {
 }
 
 func createLogTable(execer sqlExecer, tableName string) error {
+	// PostgreSQL 按 timestamp 分区，主键必须包含分区键；SQLite 不支持分区，使用自增主键即可。
+	idColumn := "id BIGSERIAL NOT NULL"
+	primaryKey := ",\n            PRIMARY KEY (id, timestamp)"
+	if !sqlutil.SupportsPartitions() {
+		idColumn = "id INTEGER PRIMARY KEY AUTOINCREMENT"
+		primaryKey = ""
+	}
 	stmt := fmt.Sprintf(
 		`CREATE TABLE IF NOT EXISTS "%s" (
-            id BIGSERIAL NOT NULL,
+            %s,
             ip_id BIGINT NOT NULL,
             pageview_flag SMALLINT NOT NULL DEFAULT 0,
             timestamp BIGINT NOT NULL,
@@ -447,11 +432,11 @@ func createLogTable(execer sqlExecer, tableName string) error {
             referer_id BIGINT NOT NULL,
             ua_id BIGINT NOT NULL,
             location_id BIGINT NOT NULL,
-            PRIMARY KEY (id, timestamp)
-        ) PARTITION BY RANGE (timestamp)`, tableName,
+            sample_rate INT NOT NULL DEFAULT 1%s
+        )%s`, tableName, idColumn, primaryKey, sqlutil.PartitionByRange("timestamp"),
 	)
 	_, err := execer.Exec(stmt)
-	if err != nil {
+	if err != nil || !sqlutil.SupportsPartitions() {
 		return err
 	}
 	partition := fmt.Sprintf(
@@ -536,6 +521,37 @@ func createAggTables(execer sqlExecer, websiteID string) error {
 	return nil
 }
 
+// createDimAggTables 创建按日维度聚合表：kind 区分 URL/来源/UA/地域，dim_id 为对应维表 ID
+func createDimAggTables(execer sqlExecer, websiteID string) error {
+	stmts := []string{
+		fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS "%s_agg_dim_daily" (
+                kind SMALLINT NOT NULL,
+                day DATE NOT NULL,
+                dim_id BIGINT NOT NULL,
+                pv BIGINT NOT NULL DEFAULT 0,
+                PRIMARY KEY(kind, day, dim_id)
+            )`, websiteID,
+		),
+		fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS "%s_agg_dim_daily_ip" (
+                kind SMALLINT NOT NULL,
+                day DATE NOT NULL,
+                dim_id BIGINT NOT NULL,
+                ip_id BIGINT NOT NULL,
+                PRIMARY KEY(kind, day, dim_id, ip_id)
+            )`, websiteID,
+		),
+	}
+
+	for _, stmt := range stmts {
+		if _, err := execer.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func createFirstSeenTable(execer sqlExecer, websiteID string) error {
 	stmt := fmt.Sprintf(
 		`CREATE TABLE IF NOT EXISTS "%s_first_seen" (
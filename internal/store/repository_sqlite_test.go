@@ -0,0 +1,229 @@
+package store_test
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/store"
+)
+
+const sqliteTestWebsite = "blog"
+
+// newSQLiteRepository 以临时 SQLite 文件初始化仓库：原始日志保留 7 天，日聚合保留 30 天
+func newSQLiteRepository(t *testing.T) *store.Repository {
+	t.Helper()
+	cfg := map[string]interface{}{
+		"websites": []map[string]interface{}{
+			{"id": sqliteTestWebsite, "name": "Blog", "logPath": filepath.Join(t.TempDir(), "access.log")},
+		},
+		"system": map[string]interface{}{
+			"logRetentionDays":   7,
+			"dailyRetentionDays": 30,
+			"parseBatchSize":     100,
+			"ipGeoCacheLimit":    1000,
+		},
+		"database": map[string]interface{}{
+			"driver": "sqlite",
+			"dsn":    filepath.Join(t.TempDir(), "nginxpulse.db"),
+		},
+	}
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_JSON", string(raw))
+	if _, err := config.ReloadConfig(nil); err != nil {
+		t.Fatalf("加载配置失败: %v", err)
+	}
+
+	repo, err := store.NewRepository()
+	if err != nil {
+		t.Fatalf("创建仓库失败: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	if err := repo.Init(); err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	return repo
+}
+
+func pageview(ip, url string, ts time.Time) store.NginxLogRecord {
+	return store.NginxLogRecord{
+		IP:               ip,
+		PageviewFlag:     1,
+		Timestamp:        ts,
+		Method:           "GET",
+		Url:              url,
+		Status:           200,
+		BytesSent:        512,
+		Referer:          "-",
+		UserBrowser:      "Chrome",
+		UserOs:           "Linux",
+		UserDevice:       "Desktop",
+		DomesticLocation: "本地",
+		GlobalLocation:   "本地",
+	}
+}
+
+func queryStats[T analytics.StatsResult](t *testing.T, manager analytics.StatsManager, extra map[string]interface{}) T {
+	t.Helper()
+	result, err := manager.Query(context.Background(), analytics.StatsQuery{
+		WebsiteID:  sqliteTestWebsite,
+		ExtraParam: extra,
+	})
+	if err != nil {
+		t.Fatalf("查询统计失败: %v", err)
+	}
+	typed, ok := result.(T)
+	if !ok {
+		t.Fatalf("统计结果类型为 %T", result)
+	}
+	return typed
+}
+
+func TestSQLiteIngestRetentionAndStats(t *testing.T) {
+	repo := newSQLiteRepository(t)
+
+	now := time.Now()
+	// 当天零点（刚过零点时取前一天），保证三条近期日志落在同一天且不晚于当前时间
+	day := now.Add(-3 * time.Minute)
+	recent := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+	// 早于原始日志保留期、仍在日聚合保留期内
+	old := now.AddDate(0, 0, -10)
+	// 早于日聚合保留期
+	expired := now.AddDate(0, 0, -40)
+	logs := []store.NginxLogRecord{
+		pageview("10.0.0.1", "/", recent),
+		pageview("10.0.0.1", "/about", recent.Add(time.Minute)),
+		pageview("10.0.0.2", "/", recent.Add(2*time.Minute)),
+		pageview("10.0.0.3", "/archive", old),
+		pageview("10.0.0.3", "/archive", old.Add(time.Minute)),
+		pageview("10.0.0.4", "/legacy", expired),
+	}
+	if err := repo.BatchInsertLogsForWebsite(sqliteTestWebsite, logs); err != nil {
+		t.Fatalf("写入日志失败: %v", err)
+	}
+
+	countLogs := func() int {
+		t.Helper()
+		var count int
+		if err := repo.GetDB().QueryRow(`SELECT COUNT(*) FROM "blog_nginx_logs"`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+	if got := countLogs(); got != len(logs) {
+		t.Fatalf("写入后日志行数 = %d, want %d", got, len(logs))
+	}
+
+	if err := repo.CleanOldLogs(nil); err != nil {
+		t.Fatalf("清理过期日志失败: %v", err)
+	}
+	if got := countLogs(); got != 3 {
+		t.Fatalf("清理后日志行数 = %d, want 3", got)
+	}
+
+	overall := queryStats[analytics.OverallStats](t, analytics.NewOverallStatsManager(repo), map[string]interface{}{
+		"timeRange": "last30days",
+	})
+	if overall.PV != 5 || overall.UV != 3 {
+		t.Fatalf("近 30 天 PV/UV = %d/%d, want 5/3", overall.PV, overall.UV)
+	}
+	if overall.TruncatedBefore != "" {
+		t.Fatalf("近 30 天仍在日聚合保留期内，truncatedBefore = %q", overall.TruncatedBefore)
+	}
+
+	today := queryStats[analytics.OverallStats](t, analytics.NewOverallStatsManager(repo), map[string]interface{}{
+		"timeRange": recent.Format("2006-01-02"),
+	})
+	if today.PV != 3 || today.UV != 2 {
+		t.Fatalf("%s PV/UV = %d/%d, want 3/2", recent.Format("2006-01-02"), today.PV, today.UV)
+	}
+
+	longRange := queryStats[analytics.OverallStats](t, analytics.NewOverallStatsManager(repo), map[string]interface{}{
+		"timeRange": "last90days",
+	})
+	if longRange.PV != 5 {
+		t.Fatalf("近 90 天 PV = %d, want 5（过期的日聚合已删除）", longRange.PV)
+	}
+	if longRange.TruncatedBefore == "" {
+		t.Fatal("近 90 天超出日聚合保留期，应返回 truncatedBefore")
+	}
+
+	series := queryStats[analytics.TimeSeriesStats](t, analytics.NewTimeSeriesStatsManager(repo), map[string]interface{}{
+		"timeRange": "last30days",
+		"viewType":  "daily",
+	})
+	seriesPV := 0
+	for _, pv := range series.Pageviews {
+		seriesPV += pv
+	}
+	if seriesPV != 5 {
+		t.Fatalf("近 30 天趋势 PV 合计 = %d, want 5", seriesPV)
+	}
+
+	// 整天范围读取按日维度聚合，原始日志已删除的 /archive 仍在排行中
+	urls := queryStats[analytics.ClientStats](t, analytics.NewURLStatsManager(repo), map[string]interface{}{
+		"timeRange": "last30days",
+		"limit":     10,
+		"uvMode":    config.UVModeExact,
+	})
+	urlPV := make(map[string]int, len(urls.Key))
+	for i, key := range urls.Key {
+		urlPV[key] = urls.PV[i]
+	}
+	if urlPV["/"] != 2 || urlPV["/about"] != 1 || urlPV["/archive"] != 2 {
+		t.Fatalf("近 30 天 URL 排行 = %v", urlPV)
+	}
+	if _, ok := urlPV["/legacy"]; ok {
+		t.Fatalf("过期的 /legacy 不应出现在排行中: %v", urlPV)
+	}
+
+	// 会话摘要只能读原始日志，超出保留期的部分需要告知调用方
+	summary := queryStats[analytics.SessionSummary](t, analytics.NewSessionSummaryStatsManager(repo), map[string]interface{}{
+		"timeRange": "last30days",
+	})
+	if summary.SessionCount != 2 {
+		t.Fatalf("近 30 天会话数 = %d, want 2", summary.SessionCount)
+	}
+	if summary.TruncatedBefore == "" {
+		t.Fatal("会话摘要超出原始日志保留期，应返回 truncatedBefore")
+	}
+}
+
+// TestSQLiteSampledPVMatchesAcrossSources 校验采样日志的 PV 在按日维度聚合（来源排行）与原始日志（来源 IP 排行）两种读取方式下一致
+func TestSQLiteSampledPVMatchesAcrossSources(t *testing.T) {
+	repo := newSQLiteRepository(t)
+
+	day := time.Now().Add(-3 * time.Minute)
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+	sampled := pageview("10.0.0.1", "/", start)
+	sampled.SampleRate = 10
+	logs := []store.NginxLogRecord{sampled, pageview("10.0.0.2", "/", start.Add(time.Minute))}
+	if err := repo.BatchInsertLogsForWebsite(sqliteTestWebsite, logs); err != nil {
+		t.Fatalf("写入日志失败: %v", err)
+	}
+
+	for name, manager := range map[string]analytics.StatsManager{
+		"referer":    analytics.NewrefererStatsManager(repo),
+		"referer_ip": analytics.NewRefererIPStatsManager(repo),
+	} {
+		stats := queryStats[analytics.ClientStats](t, manager, map[string]interface{}{
+			"timeRange": "today",
+			"limit":     10,
+			"uvMode":    config.UVModeExact,
+		})
+		pv := 0
+		for _, value := range stats.PV {
+			pv += value
+		}
+		if pv != 11 {
+			t.Fatalf("%s PV 合计 = %d, want 11", name, pv)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+	"modernc.org/sqlite"
+)
+
+const (
+	sqliteDriverName = "nginxpulse-sqlite"
+	sqliteDataFile   = "nginxpulse_sqlite.db"
+)
+
+var registerSQLiteOnce sync.Once
+
+// openSQLite 打开内嵌的 SQLite 数据库（纯 Go 实现，无需外部服务）。
+// DSN 为空时使用数据目录下的 nginxpulse_sqlite.db；注意不能使用 nginxpulse.db，
+// 该文件名保留给旧版本迁移到 PostgreSQL 的流程。
+func openSQLite(cfg config.DatabaseConfig) (*sql.DB, error) {
+	registerSQLiteOnce.Do(func() {
+		sql.Register(sqliteDriverName, &sqliteDriver{base: &sqlite.Driver{}})
+	})
+
+	path := strings.TrimSpace(cfg.DSN)
+	if path == "" {
+		path = filepath.Join(config.DataDir, sqliteDataFile)
+	}
+	if file := sqliteFilePath(path); file != "" && file != ":memory:" {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return nil, fmt.Errorf("创建 SQLite 数据目录失败: %w", err)
+		}
+	}
+
+	db, err := sql.Open(sqliteDriverName, buildSQLiteDSN(path))
+	if err != nil {
+		return nil, fmt.Errorf("打开 SQLite 数据库失败: %w", err)
+	}
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// buildSQLiteDSN 在用户 DSN 基础上补齐默认参数：
+// WAL 允许读写并发；busy_timeout 让并发写入排队等待而不是立即报错；
+// _txlock=immediate 使事务开始时即获取写锁，避免读锁升级导致的 SQLITE_BUSY。
+func buildSQLiteDSN(path string) string {
+	defaults := []struct {
+		key   string
+		param string
+	}{
+		{key: "journal_mode", param: "_pragma=journal_mode(WAL)"},
+		{key: "busy_timeout", param: "_pragma=busy_timeout(60000)"},
+		{key: "synchronous", param: "_pragma=synchronous(NORMAL)"},
+		{key: "_txlock", param: "_txlock=immediate"},
+		{key: "_time_format", param: "_time_format=sqlite"},
+	}
+	params := make([]string, 0, len(defaults))
+	for _, item := range defaults {
+		if !strings.Contains(path, item.key) {
+			params = append(params, item.param)
+		}
+	}
+	if len(params) == 0 {
+		return path
+	}
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + strings.Join(params, "&")
+}
+
+func sqliteFilePath(dsn string) string {
+	path := strings.TrimPrefix(dsn, "file:")
+	if idx := strings.Index(path, "?"); idx >= 0 {
+		path = path[:idx]
+	}
+	return path
+}
+
+// sqliteDriver 包装 modernc 的驱动：执行前把 PostgreSQL 方言翻译为 SQLite，
+// 并将时间参数统一转换为 UTC，保证与 CURRENT_TIMESTAMP 写入的值可以按字符串比较。
+type sqliteDriver struct {
+	base driver.Driver
+}
+
+func (d *sqliteDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.base.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteConn{Conn: conn}, nil
+}
+
+type sqliteConn struct {
+	driver.Conn
+}
+
+func (c *sqliteConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(sqlutil.TranslateSQLite(query))
+}
+
+func (c *sqliteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, sqlutil.TranslateSQLite(query))
+	}
+	return c.Prepare(query)
+}
+
+func (c *sqliteConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *sqliteConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return execer.ExecContext(ctx, sqlutil.TranslateSQLite(query), args)
+}
+
+func (c *sqliteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return queryer.QueryContext(ctx, sqlutil.TranslateSQLite(query), args)
+}
+
+func (c *sqliteConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *sqliteConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *sqliteConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// CheckNamedValue 统一把 time.Time 参数转换为 UTC，其余类型交给默认转换。
+func (c *sqliteConn) CheckNamedValue(value *driver.NamedValue) error {
+	if ts, ok := value.Value.(time.Time); ok {
+		value.Value = ts.UTC()
+		return nil
+	}
+	return driver.ErrSkip
+}
@@ -31,6 +31,10 @@ func TimePeriod(timeRange string) (time.Time, time.Time, error) {
 		startTime, endTime = monthBounds(now)
 	case "last30days":
 		startTime = setTime(now.AddDate(0, 0, -29), 0, 0, 0)
+	case "last90days":
+		startTime = setTime(now.AddDate(0, 0, -89), 0, 0, 0)
+	case "last365days":
+		startTime = setTime(now.AddDate(0, 0, -364), 0, 0, 0)
 	default:
 		startTime = setTime(now, 0, 0, 0)
 	}
@@ -86,6 +90,12 @@ func TimePointsAndLabels(
 	case "last30days":
 		startDay = setTime(now.AddDate(0, 0, -29), 0, 0, 0)
 		endDay = setTime(now, 23, 0, 0)
+	case "last90days":
+		startDay = setTime(now.AddDate(0, 0, -89), 0, 0, 0)
+		endDay = setTime(now, 23, 0, 0)
+	case "last365days":
+		startDay = setTime(now.AddDate(0, 0, -364), 0, 0, 0)
+		endDay = setTime(now, 23, 0, 0)
 	}
 
 	includeWeekday := (viewType == "daily" && timeRangeType == "last7days") ||
@@ -0,0 +1,140 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// configActor 标识修改配置的调用方：启用访问密钥时为密钥 sha256 的前 8 位（不保存密钥本身），否则为空
+func configActor(c *gin.Context) string {
+	key := strings.TrimSpace(c.GetHeader("X-NginxPulse-Key"))
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:4])
+}
+
+// applyConfig 写入已校验的配置并记录历史版本，然后热加载；保存与回滚共用。
+// 初始化模式下没有数据库，不记录历史，保存后需要重启。
+func applyConfig(
+	c *gin.Context,
+	cfg *config.Config,
+	version store.ConfigVersion,
+	statsFactory *analytics.StatsFactory,
+	logParser *ingest.LogParser,
+) {
+	var repo *store.Repository
+	if statsFactory != nil {
+		repo = statsFactory.Repo()
+	}
+
+	if repo != nil {
+		// 首次保存前把原有配置记为基线，之后才能对比或回滚到修改前的内容
+		if previous, err := config.ReadRawConfig(); err == nil {
+			if err := repo.RecordConfigBaseline(previous); err != nil {
+				logrus.WithError(err).Warn("记录配置基线失败")
+			}
+		}
+	}
+
+	if err := config.WriteConfigFile(cfg); err != nil {
+		if errors.Is(err, config.ErrManagedWebsite) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		logrus.WithError(err).Error("保存配置失败")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("保存配置失败: %v", err),
+		})
+		return
+	}
+
+	response := gin.H{
+		"success": true,
+	}
+	if repo != nil {
+		version.Actor = configActor(c)
+		version.RemoteIP = c.ClientIP()
+		version.UserAgent = c.Request.UserAgent()
+		// 历史写入失败不影响已写入的配置
+		if id, _, err := repo.RecordConfig(cfg, version); err != nil {
+			logrus.WithError(err).Warn("记录配置历史失败")
+		} else {
+			response["version"] = id
+		}
+	}
+
+	if logParser == nil {
+		// 初始化模式下尚未创建解析器，保存后需要重启进入正常模式
+		response["restart_required"] = true
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	reload, err := logParser.ReloadConfig()
+	if err != nil {
+		logrus.WithError(err).Error("重新加载配置失败")
+		response["restart_required"] = true
+		response["reload_error"] = err.Error()
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	if statsFactory != nil {
+		statsFactory.ClearCache()
+	}
+	response["restart_required"] = reload.RestartRequired()
+	response["reload"] = reload
+	c.JSON(http.StatusOK, response)
+}
+
+// loadConfigVersion 按查询参数中的版本号读取历史版本，出错时已写入响应并返回 nil
+func loadConfigVersion(c *gin.Context, repo *store.Repository, value string) *store.ConfigVersion {
+	id, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "版本号无效",
+		})
+		return nil
+	}
+	version, err := repo.GetConfigVersion(id)
+	if err != nil {
+		logrus.WithError(err).Error("读取配置历史失败")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("读取配置历史失败: %v", err),
+		})
+		return nil
+	}
+	if version == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "配置版本不存在",
+		})
+		return nil
+	}
+	return version
+}
+
+func decodeConfigVersion(c *gin.Context, version *store.ConfigVersion) *config.Config {
+	cfg, err := version.DecodeConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("解析配置版本 %d 失败: %v", version.ID, err),
+		})
+		return nil
+	}
+	return cfg
+}
@@ -1,6 +1,7 @@
 package web
 
 import (
+	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
@@ -14,8 +15,10 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/backup"
 	"github.com/likaia/nginxpulse/internal/config"
 	"github.com/likaia/nginxpulse/internal/ingest"
+	"github.com/likaia/nginxpulse/internal/store"
 	"github.com/likaia/nginxpulse/internal/version"
 	"github.com/sirupsen/logrus"
 )
@@ -26,6 +29,11 @@ func SetupRoutes(
 	statsFactory *analytics.StatsFactory,
 	logParser *ingest.LogParser) {
 
+	var ingestQueue *ingest.IngestQueue
+	if logParser != nil {
+		ingestQueue = ingest.NewIngestQueue(logParser)
+	}
+
 	// 获取所有网站列表
 	router.GET("/api/websites", func(c *gin.Context) {
 		websiteIDs := config.GetAllWebsiteIDs()
@@ -48,12 +56,174 @@ func SetupRoutes(
 		})
 	})
 
+	// 列出数据库中仍有数据、但已从配置中移除的站点
+	router.GET("/api/websites/orphans", func(c *gin.Context) {
+		if statsFactory == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持站点数据管理",
+			})
+			return
+		}
+		orphans, err := statsFactory.Repo().ListOrphanWebsites()
+		if err != nil {
+			logrus.WithError(err).Error("读取残留站点失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "读取残留站点失败",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"websites": orphans,
+		})
+	})
+
+	// 清除已从配置中移除的站点的全部数据；confirm 需与 id 一致，防止误删
+	router.POST("/api/websites/purge", func(c *gin.Context) {
+		if logParser == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持站点数据管理",
+			})
+			return
+		}
+		type purgeRequest struct {
+			ID      string `json:"id"`
+			Confirm string `json:"confirm"`
+		}
+		var req purgeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+		websiteID := strings.TrimSpace(req.ID)
+		if websiteID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "站点 ID 不能为空",
+			})
+			return
+		}
+		if strings.TrimSpace(req.Confirm) != websiteID {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请在 confirm 中再次输入站点 ID 以确认清除",
+			})
+			return
+		}
+		result, err := logParser.PurgeWebsite(websiteID)
+		if err != nil {
+			status := parserErrorStatus(err, http.StatusBadRequest)
+			c.JSON(status, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if statsFactory != nil {
+			statsFactory.ClearCache()
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"id":     websiteID,
+			"result": result,
+		})
+	})
+
+	// 导出站点数据的完整备份（tar.gz）；id 为逗号分隔的站点 ID，留空导出全部站点
+	router.GET("/api/websites/backup", func(c *gin.Context) {
+		if logParser == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持站点数据管理",
+			})
+			return
+		}
+		var websiteIDs []string
+		for _, id := range strings.Split(c.Query("id"), ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				websiteIDs = append(websiteIDs, id)
+			}
+		}
+
+		// 先写入临时文件，导出失败时仍能返回错误而不是半个文件
+		file, err := os.CreateTemp("", "nginxpulse-backup-*.tar.gz")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "创建备份文件失败",
+			})
+			return
+		}
+		defer os.Remove(file.Name())
+		_, err = logParser.Backup(file, websiteIDs)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			status := parserErrorStatus(err, http.StatusBadRequest)
+			c.JSON(status, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		filename := fmt.Sprintf("nginxpulse_backup_%s.tar.gz", time.Now().Format("20060102_150405"))
+		c.Header("Content-Type", "application/gzip")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+		c.Header("Cache-Control", "no-store")
+		c.File(file.Name())
+	})
+
+	// 上传备份文件（file）恢复站点数据；map 为可选的站点 ID 映射，格式 旧ID:新ID,旧ID:新ID
+	router.POST("/api/websites/restore", func(c *gin.Context) {
+		if logParser == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持站点数据管理",
+			})
+			return
+		}
+		idMap, err := backup.ParseIDMap(c.PostForm("map"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请使用 multipart/form-data 上传备份文件",
+			})
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "读取备份文件失败",
+			})
+			return
+		}
+		defer file.Close()
+
+		result, err := logParser.Restore(file, idMap)
+		if err != nil {
+			status := parserErrorStatus(err, http.StatusBadRequest)
+			c.JSON(status, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if statsFactory != nil {
+			statsFactory.ClearCache()
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"result": result,
+		})
+	})
+
 	router.GET("/api/status", func(c *gin.Context) {
 		cfg := config.ReadConfig()
 		migrationRequired := needsPGMigration()
 		ipGeoPendingCount := int64(0)
+		leader := true
 		if logParser != nil {
 			ipGeoPendingCount = logParser.GetIPGeoPendingCount()
+			leader = logParser.IsLeader()
 		}
 		c.JSON(http.StatusOK, gin.H{
 			"log_parsing":                             ingest.IsIPParsing(),
@@ -73,6 +243,7 @@ func SetupRoutes(
 			"migration_required":                      migrationRequired,
 			"setup_required":                          config.IsSetupMode(),
 			"config_readonly":                         config.ConfigReadOnly(),
+			"leader":                                  leader,
 		})
 	})
 
@@ -217,6 +388,7 @@ func SetupRoutes(
 			"readonly":         config.ConfigReadOnly(),
 			"setup_required":   config.IsSetupMode(),
 			"default_log_path": defaultLogPath,
+			"managed_websites": cfg.ManagedWebsites(),
 		})
 	})
 
@@ -258,20 +430,149 @@ func SetupRoutes(
 			return
 		}
 
-		if err := config.WriteConfigFile(cfg); err != nil {
-			logrus.WithError(err).Error("保存配置失败")
+		applyConfig(c, cfg, store.ConfigVersion{Source: store.ConfigSourceSave}, statsFactory, logParser)
+	})
+
+	router.GET("/api/config/history", func(c *gin.Context) {
+		if statsFactory == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持配置历史",
+			})
+			return
+		}
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+
+		versions, hasMore, err := statsFactory.Repo().ListConfigVersions(page, pageSize)
+		if err != nil {
+			logrus.WithError(err).Error("读取配置历史失败")
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": fmt.Sprintf("保存配置失败: %v", err),
+				"error": fmt.Sprintf("读取配置历史失败: %v", err),
 			})
 			return
 		}
+		c.JSON(http.StatusOK, gin.H{
+			"versions": versions,
+			"has_more": hasMore,
+		})
+	})
 
+	router.GET("/api/config/history/detail", func(c *gin.Context) {
+		if statsFactory == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持配置历史",
+			})
+			return
+		}
+		entry := loadConfigVersion(c, statsFactory.Repo(), c.Query("id"))
+		if entry == nil {
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{
-			"success":          true,
-			"restart_required": true,
+			"version": entry,
 		})
 	})
 
+	// 对比两个版本；省略 to 时与当前配置对比
+	router.GET("/api/config/history/diff", func(c *gin.Context) {
+		if statsFactory == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持配置历史",
+			})
+			return
+		}
+		repo := statsFactory.Repo()
+		fromEntry := loadConfigVersion(c, repo, c.Query("from"))
+		if fromEntry == nil {
+			return
+		}
+		from := decodeConfigVersion(c, fromEntry)
+		if from == nil {
+			return
+		}
+
+		var to *config.Config
+		toID := int64(0)
+		if value := strings.TrimSpace(c.Query("to")); value != "" {
+			toEntry := loadConfigVersion(c, repo, value)
+			if toEntry == nil {
+				return
+			}
+			if to = decodeConfigVersion(c, toEntry); to == nil {
+				return
+			}
+			toID = toEntry.ID
+		} else {
+			current, err := config.ReadRawConfig()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": fmt.Sprintf("读取配置失败: %v", err),
+				})
+				return
+			}
+			to = current
+		}
+
+		changes, err := config.DiffConfigs(from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("对比配置失败: %v", err),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"from":    fromEntry.ID,
+			"to":      toID,
+			"changes": changes,
+		})
+	})
+
+	// 回滚到历史版本：与保存相同，先校验再写入并热加载，回滚本身也记为新版本
+	router.POST("/api/config/rollback", func(c *gin.Context) {
+		if config.ConfigReadOnly() {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "配置来自环境变量，无法保存",
+			})
+			return
+		}
+		if statsFactory == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持配置历史",
+			})
+			return
+		}
+		var req struct {
+			ID int64 `json:"id"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+
+		entry := loadConfigVersion(c, statsFactory.Repo(), strconv.FormatInt(req.ID, 10))
+		if entry == nil {
+			return
+		}
+		cfg := decodeConfigVersion(c, entry)
+		if cfg == nil {
+			return
+		}
+		result := config.ValidateConfig(cfg, config.ValidateOptions{
+			CheckPaths: true,
+		})
+		if len(result.Errors) > 0 {
+			c.JSON(http.StatusBadRequest, result)
+			return
+		}
+
+		applyConfig(c, cfg, store.ConfigVersion{
+			Source:     store.ConfigSourceRollback,
+			RollbackOf: entry.ID,
+		}, statsFactory, logParser)
+	})
+
 	router.POST("/api/system/restart", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -323,8 +624,8 @@ func SetupRoutes(
 		}
 
 		if err := logParser.TriggerReparse(websiteID); err != nil {
-			if errors.Is(err, ingest.ErrParsingInProgress) {
-				c.JSON(http.StatusConflict, gin.H{
+			if status := parserErrorStatus(err, 0); status != 0 {
+				c.JSON(status, gin.H{
 					"error": err.Error(),
 				})
 				return
@@ -528,7 +829,7 @@ func SetupRoutes(
 		c.Header("Cache-Control", "no-store")
 		c.Status(http.StatusOK)
 
-		if err := exportLogsXLSX(c.Writer, statsFactory, query, c.Query("lang")); err != nil {
+		if err := exportLogsXLSX(c.Request.Context(), c.Writer, statsFactory, query, c.Query("lang")); err != nil {
 			logrus.WithError(err).Error("导出日志失败")
 		}
 	})
@@ -802,6 +1103,8 @@ func SetupRoutes(
 			WebsiteID string   `json:"website_id"`
 			SourceID  string   `json:"source_id"`
 			Lines     []string `json:"lines"`
+			// SampleRates 与 Lines 一一对应，记录 agent 边缘采样倍率（可选）。
+			SampleRates []int `json:"sample_rates"`
 		}
 
 		var req ingestRequest
@@ -831,21 +1134,316 @@ func SetupRoutes(
 			})
 			return
 		}
+		if len(req.SampleRates) > 0 && len(req.SampleRates) != len(req.Lines) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "采样倍率数量与日志行数不一致",
+			})
+			return
+		}
 
-		accepted, deduped, err := logParser.IngestLines(websiteID, strings.TrimSpace(req.SourceID), req.Lines)
+		done, err := ingestQueue.Submit(
+			websiteID, strings.TrimSpace(req.SourceID), req.Lines, req.SampleRates,
+		)
+		if errors.Is(err, ingest.ErrIngestQueueFull) {
+			// 带随机抖动的重试时间，避免大量 agent 在同一时刻重新推送
+			c.Header("Retry-After", strconv.Itoa(ingestRetryAfterSeconds()))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": "推送队列已满，请稍后重试",
+			})
+			return
+		}
+
+		var result ingest.IngestResult
+		select {
+		case result = <-done:
+		case <-c.Request.Context().Done():
+			// 客户端已断开，批次仍会在队列中处理完成
+			return
+		}
+		if result.Err != nil {
+			logrus.WithError(result.Err).Error("日志推送解析失败")
+			c.JSON(parserErrorStatus(result.Err, http.StatusInternalServerError), gin.H{
+				"error": fmt.Sprintf("解析失败: %v", result.Err),
+			})
+			return
+		}
+
+		if result.Accepted > 0 {
+			statsFactory.ClearCacheDebounced()
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"accepted": result.Accepted,
+			"deduped":  result.Deduped,
+		})
+	})
+
+	// 上传 .log/.gz 文件导入指定站点，后台解析并可查询进度
+	router.POST("/api/logs/import", func(c *gin.Context) {
+		if logParser == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持日志解析",
+			})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, logImportMaxBytes())
+		reader, err := c.Request.MultipartReader()
 		if err != nil {
-			logrus.WithError(err).Error("日志推送解析失败")
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": fmt.Sprintf("解析失败: %v", err),
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请使用 multipart/form-data 上传日志文件",
+			})
+			return
+		}
+		websiteID := strings.TrimSpace(c.Query("website_id"))
+		job, err := importJobs.Create(reader, websiteID, statsFactory, ingestQueue)
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"job_id": job.ID,
+			"status": job.Status,
+			"files":  job.Files,
+			"total":  job.Total,
+		})
+	})
+
+	router.GET("/api/logs/import/status", func(c *gin.Context) {
+		jobID := strings.TrimSpace(c.Query("id"))
+		if jobID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "任务 ID 不能为空",
+			})
+			return
+		}
+		job, ok := importJobs.Get(jobID)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "任务不存在",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, job)
+	})
+
+	router.GET("/api/logs/import/list", func(c *gin.Context) {
+		websiteID := strings.TrimSpace(c.Query("website_id"))
+		c.JSON(http.StatusOK, gin.H{
+			"jobs": importJobs.List(websiteID),
+		})
+	})
+
+	router.POST("/api/logs/import/cancel", func(c *gin.Context) {
+		type cancelRequest struct {
+			ID string `json:"id"`
+		}
+		var req cancelRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+		jobID := strings.TrimSpace(req.ID)
+		if jobID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "任务 ID 不能为空",
+			})
+			return
+		}
+		job, err := importJobs.Cancel(jobID)
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"id":     job.ID,
+			"status": job.Status,
+		})
+	})
+
+	// 按当前 PV 过滤规则重新判定已入库日志的 pageview_flag，并重建聚合、会话与首次访问数据
+	router.POST("/api/logs/reclassify", func(c *gin.Context) {
+		if logParser == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持日志解析",
+			})
+			return
+		}
+		type reclassifyRequest struct {
+			ID    string `json:"id"`
+			Start string `json:"start"`
+			End   string `json:"end"`
+		}
+		var req reclassifyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+		start, end, err := parseReclassifyRange(strings.TrimSpace(req.Start), strings.TrimSpace(req.End))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		job, err := reclassifyJobs.Create(strings.TrimSpace(req.ID), start, end, statsFactory, logParser)
+		if err != nil {
+			status := parserErrorStatus(err, http.StatusBadRequest)
+			c.JSON(status, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"job_id": job.ID,
+			"status": job.Status,
+		})
+	})
+
+	router.GET("/api/logs/reclassify/status", func(c *gin.Context) {
+		jobID := strings.TrimSpace(c.Query("id"))
+		if jobID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "任务 ID 不能为空",
+			})
+			return
+		}
+		job, ok := reclassifyJobs.Get(jobID)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "任务不存在",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, job)
+	})
+
+	router.GET("/api/logs/reclassify/list", func(c *gin.Context) {
+		websiteID := strings.TrimSpace(c.Query("website_id"))
+		c.JSON(http.StatusOK, gin.H{
+			"jobs": reclassifyJobs.List(websiteID),
+		})
+	})
+
+	router.POST("/api/logs/reclassify/cancel", func(c *gin.Context) {
+		type cancelRequest struct {
+			ID string `json:"id"`
+		}
+		var req cancelRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+		jobID := strings.TrimSpace(req.ID)
+		if jobID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "任务 ID 不能为空",
+			})
+			return
+		}
+		job, err := reclassifyJobs.Cancel(jobID)
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"id":     job.ID,
+			"status": job.Status,
+		})
+	})
+
+	// 流式推送：请求体为逐行日志（text/plain 或 application/x-ndjson，可 gzip 压缩）
+	router.POST("/api/ingest/stream", func(c *gin.Context) {
+		if logParser == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持日志解析",
+			})
+			return
+		}
+
+		websiteID := strings.TrimSpace(c.Query("website_id"))
+		if websiteID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "缺少站点ID",
+			})
+			return
+		}
+		if _, ok := config.GetWebsiteByID(websiteID); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "站点不存在",
+			})
+			return
+		}
+		ndjson, err := ingestStreamFormat(c.ContentType())
+		if err != nil {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{
+				"error": "仅支持 text/plain 或 application/x-ndjson",
+			})
+			return
+		}
+		body, err := openIngestStreamBody(c.Request.Body, c.GetHeader("Content-Encoding"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("请求体解码失败: %v", err),
+			})
+			return
+		}
+		defer body.Close()
+
+		submit := queueIngestBatch(c.Request.Context(), ingestQueue, websiteID, strings.TrimSpace(c.Query("source_id")))
+		result, err := ingestLogStream(submit, body, ndjson, nil)
+		if result.Accepted > 0 {
+			statsFactory.ClearCacheDebounced()
+		}
+		if errors.Is(err, ingest.ErrIngestQueueFull) {
+			// 与批量推送一致返回 429；已写入的行重推时会被去重
+			c.Header("Retry-After", strconv.Itoa(ingestRetryAfterSeconds()))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":    "推送队列已满，请稍后重试",
+				"accepted": result.Accepted,
+				"deduped":  result.Deduped,
+				"failed":   result.Failed,
+			})
+			return
+		}
+		if err != nil && c.Request.Context().Err() != nil {
+			// 客户端已断开，已投递的批次仍会在队列中处理完成
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).Error("流式日志推送解析失败")
+			c.JSON(parserErrorStatus(err, http.StatusInternalServerError), gin.H{
+				"error":    fmt.Sprintf("解析失败: %v", err),
+				"accepted": result.Accepted,
+				"deduped":  result.Deduped,
+				"failed":   result.Failed,
 			})
 			return
 		}
 
-		statsFactory.ClearCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success":  true,
-			"accepted": accepted,
-			"deduped":  deduped,
+			"accepted": result.Accepted,
+			"deduped":  result.Deduped,
+			"failed":   result.Failed,
 		})
 	})
 
@@ -875,8 +1473,18 @@ func SetupRoutes(
 		}
 
 		// 执行查询
-		result, err := statsFactory.QueryStats(statsType, query)
+		result, err := statsFactory.QueryStats(c.Request.Context(), statsType, query)
 		if err != nil {
+			if errors.Is(c.Request.Context().Err(), context.Canceled) {
+				// 客户端已断开（如关闭页面），查询已随请求取消
+				return
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				c.JSON(http.StatusGatewayTimeout, gin.H{
+					"error": "查询超时，请缩小时间范围后重试",
+				})
+				return
+			}
 			logrus.WithError(err).Errorf("查询统计数据[%s]失败", statsType)
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": fmt.Sprintf("查询失败: %v", err),
@@ -889,6 +1497,19 @@ func SetupRoutes(
 
 }
 
+// parserErrorStatus 把解析器返回的错误映射为 HTTP 状态码：解析进行中为 409，
+// 当前实例不是主节点（负载均衡可重试其他实例）或站点回填迁移尚未执行为 503，其他错误返回 fallback
+func parserErrorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, ingest.ErrParsingInProgress):
+		return http.StatusConflict
+	case errors.Is(err, ingest.ErrNotLeader), errors.Is(err, store.ErrMigrationPending):
+		return http.StatusServiceUnavailable
+	default:
+		return fallback
+	}
+}
+
 func bindConfigPayload(c *gin.Context) (*config.Config, error) {
 	payload := struct {
 		Config config.Config `json:"config"`
@@ -911,6 +1532,10 @@ func sqliteDataPath() string {
 }
 
 func needsPGMigration() bool {
+	// 使用内嵌 SQLite 时无需迁移到 PostgreSQL
+	if strings.TrimSpace(config.ReadConfig().Database.Driver) == "sqlite" {
+		return false
+	}
 	if _, err := os.Stat(migrationMarkerPath()); err == nil {
 		return false
 	}
@@ -0,0 +1,169 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"mime"
+	"strings"
+
+	"github.com/likaia/nginxpulse/internal/ingest"
+)
+
+const (
+	// 流式推送每攒够多少行投递一次推送队列
+	ingestStreamBatchLines = 1000
+	// 单行上限，超出的行计为失败并丢弃（与 agent 的 maxLineBytes 默认值一致）
+	ingestStreamMaxLineBytes = 256 * 1024
+	// 推送队列满时 Retry-After 的基础秒数与随机抖动范围
+	ingestRetryAfterBase   = 2
+	ingestRetryAfterJitter = 8
+)
+
+var (
+	errUnsupportedIngestContentType = errors.New("unsupported content type")
+	errIngestStreamStopped          = errors.New("ingest stream stopped")
+)
+
+type ingestStreamResult struct {
+	Accepted int `json:"accepted"`
+	Deduped  int `json:"deduped"`
+	Failed   int `json:"failed"`
+}
+
+// ingestRetryAfterSeconds 返回 429 响应的 Retry-After 秒数，加入抖动以打散重试。
+func ingestRetryAfterSeconds() int {
+	return ingestRetryAfterBase + rand.Intn(ingestRetryAfterJitter+1)
+}
+
+// ingestStreamFormat 根据 Content-Type 判断请求体格式，返回是否为 NDJSON。
+func ingestStreamFormat(contentType string) (bool, error) {
+	if strings.TrimSpace(contentType) == "" {
+		return false, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false, errUnsupportedIngestContentType
+	}
+	switch mediaType {
+	case "text/plain":
+		return false, nil
+	case "application/x-ndjson", "application/ndjson":
+		return true, nil
+	default:
+		return false, errUnsupportedIngestContentType
+	}
+}
+
+// openIngestStreamBody 按 Content-Encoding 包装请求体，目前支持 gzip。
+func openIngestStreamBody(body io.Reader, contentEncoding string) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "", "identity":
+		return io.NopCloser(body), nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	default:
+		return nil, errors.New("unsupported content encoding")
+	}
+}
+
+// ingestBatchFunc 写入一批日志行，由调用方决定如何经过站点推送队列。
+type ingestBatchFunc func(lines []string) (ingest.IngestResult, error)
+
+// queueIngestBatch 把批次投递到站点推送队列并等待处理结果；队列已满时返回 ingest.ErrIngestQueueFull。
+// ctx 结束（客户端断开）时不再等待，批次仍会在队列中处理完成。
+func queueIngestBatch(ctx context.Context, queue *ingest.IngestQueue, websiteID, sourceID string) ingestBatchFunc {
+	return func(lines []string) (ingest.IngestResult, error) {
+		done, err := queue.Submit(websiteID, sourceID, lines, nil)
+		if err != nil {
+			return ingest.IngestResult{}, err
+		}
+		select {
+		case result := <-done:
+			return result, result.Err
+		case <-ctx.Done():
+			return ingest.IngestResult{}, ctx.Err()
+		}
+	}
+}
+
+// ingestLogStream 逐行读取请求体并分批写入，不会把整个请求体读入内存。
+// NDJSON 模式下无法通过 JSON 校验的行计为失败；解析失败的行同样计入 failed。
+// afterBatch 在每批写入后调用（可为 nil），返回 false 时中止并返回 errIngestStreamStopped。
+func ingestLogStream(
+	submit ingestBatchFunc,
+	body io.Reader,
+	ndjson bool,
+	afterBatch func(ingestStreamResult) bool,
+) (ingestStreamResult, error) {
+	result := ingestStreamResult{}
+	reader := bufio.NewReaderSize(body, 64*1024)
+	batch := make([]string, 0, ingestStreamBatchLines)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		ingested, err := submit(batch)
+		result.Accepted += ingested.Accepted
+		result.Deduped += ingested.Deduped
+		if err != nil {
+			return err
+		}
+		result.Failed += len(batch) - ingested.Accepted - ingested.Deduped
+		// 批次已交给队列 worker，不复用底层数组
+		batch = make([]string, 0, ingestStreamBatchLines)
+		if afterBatch != nil && !afterBatch(result) {
+			return errIngestStreamStopped
+		}
+		return nil
+	}
+
+	var line bytes.Buffer
+	overlong := false
+	for {
+		frag, err := reader.ReadSlice('\n')
+		if len(frag) > 0 && !overlong {
+			if line.Len()+len(frag) > ingestStreamMaxLineBytes+2 {
+				overlong = true
+				line.Reset()
+			} else {
+				line.Write(frag)
+			}
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return result, err
+		}
+
+		if overlong {
+			result.Failed++
+		} else if text := strings.TrimRight(line.String(), "\r\n"); strings.TrimSpace(text) != "" {
+			if ndjson && !json.Valid([]byte(text)) {
+				result.Failed++
+			} else {
+				batch = append(batch, text)
+			}
+		}
+		line.Reset()
+		overlong = false
+
+		if len(batch) >= ingestStreamBatchLines {
+			if flushErr := flush(); flushErr != nil {
+				return result, flushErr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	return result, flush()
+}
@@ -1,6 +1,7 @@
 package web
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"strconv"
@@ -38,15 +39,17 @@ type logsExportStyles struct {
 }
 
 func exportLogsXLSX(
+	ctx context.Context,
 	writer io.Writer,
 	statsFactory *analytics.StatsFactory,
 	query analytics.StatsQuery,
 	lang string,
 ) error {
-	return exportLogsXLSXWithProgress(writer, statsFactory, query, lang, nil, nil)
+	return exportLogsXLSXWithProgress(ctx, writer, statsFactory, query, lang, nil, nil)
 }
 
 func exportLogsXLSXWithProgress(
+	ctx context.Context,
 	writer io.Writer,
 	statsFactory *analytics.StatsFactory,
 	query analytics.StatsQuery,
@@ -91,7 +94,9 @@ func exportLogsXLSXWithProgress(
 		query.ExtraParam["page"] = page
 		query.ExtraParam["pageSize"] = exportBatchSize
 
-		result, err := manager.Query(query)
+		pageCtx, cancel := statsFactory.Repo().WithStatementTimeout(ctx)
+		result, err := manager.Query(pageCtx, query)
+		cancel()
 		if err != nil {
 			return err
 		}
@@ -2,6 +2,7 @@ package web
 
 import (
 	"bufio"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
@@ -205,6 +206,7 @@ func (m *logsExportManager) run(jobID string, statsFactory *analytics.StatsFacto
 
 	buffered := bufio.NewWriter(file)
 	err = exportLogsXLSXWithProgress(
+		context.Background(),
 		buffered,
 		statsFactory,
 		query,
@@ -0,0 +1,428 @@
+package web
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	logsImportJobTTL   = 24 * time.Hour
+	logsImportSourceID = "upload"
+	// 未配置 system.logImportMaxMB 时单次上传的大小上限
+	defaultLogImportMaxMB = 1024
+)
+
+type LogsImportJobStatus string
+
+const (
+	logsImportPending  LogsImportJobStatus = "pending"
+	logsImportRunning  LogsImportJobStatus = "running"
+	logsImportSuccess  LogsImportJobStatus = "success"
+	logsImportFailed   LogsImportJobStatus = "failed"
+	logsImportCanceled LogsImportJobStatus = "canceled"
+)
+
+type LogsImportJob struct {
+	ID        string              `json:"id"`
+	Status    LogsImportJobStatus `json:"status"`
+	CreatedAt time.Time           `json:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at"`
+	WebsiteID string              `json:"website_id"`
+	Files     []string            `json:"files"`
+	Error     string              `json:"error,omitempty"`
+	// Processed/Total 为已读取/上传文件的字节数（gzip 文件按压缩后大小计算）
+	Processed int64  `json:"processed"`
+	Total     int64  `json:"total"`
+	Accepted  int    `json:"accepted"`
+	Deduped   int    `json:"deduped"`
+	Failed    int    `json:"failed"`
+	Canceled  bool   `json:"-"`
+	Dir       string `json:"-"`
+}
+
+type logsImportManager struct {
+	mu   sync.Mutex
+	jobs map[string]*LogsImportJob
+}
+
+var importJobs = &logsImportManager{
+	jobs: make(map[string]*LogsImportJob),
+}
+
+// isImportableLogFile 按最后一个扩展名判断，仅接受 .log、.gz 与数字轮转后缀（如 access.log.1）。
+func isImportableLogFile(name string) bool {
+	lower := strings.ToLower(name)
+	ext := filepath.Ext(lower)
+	switch ext {
+	case ".gz", ".log":
+		return true
+	case "", ".":
+		return false
+	}
+	if strings.Trim(ext[1:], "0123456789") != "" {
+		return false
+	}
+	return filepath.Ext(strings.TrimSuffix(lower, ext)) == ".log"
+}
+
+// logImportMaxBytes 返回上传导入单次请求的大小上限。
+func logImportMaxBytes() int64 {
+	maxMB := config.ReadConfig().System.LogImportMaxMB
+	if maxMB <= 0 {
+		maxMB = defaultLogImportMaxMB
+	}
+	return int64(maxMB) << 20
+}
+
+// Create 从 multipart 请求体中读取站点 ID 与日志文件，落盘后在后台解析。
+// 文件按 part 顺序直接写入磁盘，不会整体读入内存。
+func (m *logsImportManager) Create(
+	reader *multipart.Reader,
+	websiteID string,
+	statsFactory *analytics.StatsFactory,
+	ingestQueue *ingest.IngestQueue,
+) (*LogsImportJob, error) {
+	jobID, err := newExportJobID()
+	if err != nil {
+		return nil, err
+	}
+	importRoot := filepath.Join(config.DataDir, "imports")
+	m.removeOrphanDirs(importRoot)
+	dir := filepath.Join(importRoot, jobID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	job := &LogsImportJob{
+		ID:        jobID,
+		Status:    logsImportPending,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		WebsiteID: websiteID,
+		Dir:       dir,
+	}
+	if err := m.receiveFiles(reader, job); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	if job.WebsiteID == "" {
+		_ = os.RemoveAll(dir)
+		return nil, errors.New("缺少站点ID")
+	}
+	if _, ok := config.GetWebsiteByID(job.WebsiteID); !ok {
+		_ = os.RemoveAll(dir)
+		return nil, errors.New("站点不存在")
+	}
+	if len(job.Files) == 0 {
+		_ = os.RemoveAll(dir)
+		return nil, errors.New("未上传日志文件")
+	}
+
+	m.mu.Lock()
+	m.cleanupLocked(time.Now())
+	m.jobs[jobID] = job
+	m.mu.Unlock()
+
+	go m.run(jobID, statsFactory, ingestQueue)
+	snapshot := *job
+	return &snapshot, nil
+}
+
+func (m *logsImportManager) receiveFiles(reader *multipart.Reader, job *LogsImportJob) error {
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return uploadReadError("读取上传内容失败", err)
+		}
+
+		if part.FileName() == "" {
+			if part.FormName() == "website_id" && job.WebsiteID == "" {
+				value, _ := io.ReadAll(io.LimitReader(part, 256))
+				job.WebsiteID = strings.TrimSpace(string(value))
+			}
+			part.Close()
+			continue
+		}
+
+		name := filepath.Base(part.FileName())
+		if !isImportableLogFile(name) {
+			part.Close()
+			return fmt.Errorf("不支持的文件类型: %s", name)
+		}
+		// 加序号前缀，避免同名文件互相覆盖，同时保留原始文件名用于展示
+		target := filepath.Join(job.Dir, fmt.Sprintf("%03d_%s", len(job.Files), name))
+		file, err := os.Create(target)
+		if err != nil {
+			part.Close()
+			return err
+		}
+		written, err := io.Copy(file, part)
+		closeErr := file.Close()
+		part.Close()
+		if err != nil {
+			return uploadReadError("保存上传文件失败", err)
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+		job.Files = append(job.Files, name)
+		job.Total += written
+	}
+}
+
+// uploadReadError 包装读取上传内容时的错误，超过大小上限时给出明确提示（仍可用 errors.As 取到 *http.MaxBytesError）。
+func uploadReadError(action string, err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return fmt.Errorf("上传内容超过大小上限 %d MB: %w", maxErr.Limit>>20, err)
+	}
+	return fmt.Errorf("%s: %w", action, err)
+}
+
+func (m *logsImportManager) Get(id string) (*LogsImportJob, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cleanupLocked(time.Now())
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	snapshot := *job
+	return &snapshot, true
+}
+
+func (m *logsImportManager) List(websiteID string) []LogsImportJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cleanupLocked(time.Now())
+	items := make([]LogsImportJob, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		if websiteID != "" && job.WebsiteID != websiteID {
+			continue
+		}
+		items = append(items, *job)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].CreatedAt.After(items[j].CreatedAt)
+	})
+	return items
+}
+
+func (m *logsImportManager) Cancel(id string) (*LogsImportJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("任务不存在")
+	}
+	if job.Status == logsImportSuccess || job.Status == logsImportFailed || job.Status == logsImportCanceled {
+		snapshot := *job
+		return &snapshot, fmt.Errorf("任务无法取消")
+	}
+	job.Canceled = true
+	snapshot := *job
+	return &snapshot, nil
+}
+
+func (m *logsImportManager) run(jobID string, statsFactory *analytics.StatsFactory, ingestQueue *ingest.IngestQueue) {
+	job, ok := m.Get(jobID)
+	if !ok {
+		return
+	}
+	// 无论成功与否，解析结束后都删除上传的临时文件
+	defer func() {
+		if err := os.RemoveAll(job.Dir); err != nil {
+			logrus.WithError(err).Warnf("清理上传日志目录失败: %s", job.Dir)
+		}
+	}()
+
+	m.update(jobID, func(job *LogsImportJob) {
+		job.Status = logsImportRunning
+		job.UpdatedAt = time.Now()
+	})
+
+	var (
+		processedBefore int64
+		total           ingestStreamResult
+	)
+	for i, name := range job.Files {
+		path := filepath.Join(job.Dir, fmt.Sprintf("%03d_%s", i, name))
+		result, read, err := m.importFile(jobID, path, job.WebsiteID, ingestQueue, processedBefore, total)
+		processedBefore += read
+		total.Accepted += result.Accepted
+		total.Deduped += result.Deduped
+		total.Failed += result.Failed
+		if total.Accepted > 0 && statsFactory != nil {
+			statsFactory.ClearCacheDebounced()
+		}
+		if errors.Is(err, errIngestStreamStopped) {
+			m.update(jobID, func(job *LogsImportJob) {
+				job.Status = logsImportCanceled
+				job.UpdatedAt = time.Now()
+			})
+			return
+		}
+		if err != nil {
+			m.fail(jobID, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+	}
+
+	m.update(jobID, func(job *LogsImportJob) {
+		job.Status = logsImportSuccess
+		job.Processed = job.Total
+		job.UpdatedAt = time.Now()
+	})
+}
+
+// importFile 解析单个上传文件，返回本文件的统计结果与已读取的（压缩后）字节数。
+func (m *logsImportManager) importFile(
+	jobID, path, websiteID string,
+	ingestQueue *ingest.IngestQueue,
+	processedBefore int64,
+	previous ingestStreamResult,
+) (ingestStreamResult, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ingestStreamResult{}, 0, err
+	}
+	defer file.Close()
+
+	counter := &countingReader{reader: file}
+	var body io.Reader = counter
+	if strings.HasSuffix(strings.ToLower(path), ".gz") {
+		gz, err := gzip.NewReader(counter)
+		if err != nil {
+			return ingestStreamResult{}, counter.n.Load(), err
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	// 与推送共用站点队列；队列已满时等待重试而不是失败，任务取消时停止等待
+	submit := func(lines []string) (ingest.IngestResult, error) {
+		done, err := ingestQueue.SubmitWait(websiteID, logsImportSourceID, lines,
+			ingestRetryAfterBase*time.Second, func() bool { return m.isCanceled(jobID) })
+		if errors.Is(err, ingest.ErrIngestQueueFull) {
+			return ingest.IngestResult{}, errIngestStreamStopped
+		}
+		if err != nil {
+			return ingest.IngestResult{}, err
+		}
+		result := <-done
+		return result, result.Err
+	}
+	result, err := ingestLogStream(submit, body, false, func(current ingestStreamResult) bool {
+		m.update(jobID, func(job *LogsImportJob) {
+			job.Processed = processedBefore + counter.n.Load()
+			job.Accepted = previous.Accepted + current.Accepted
+			job.Deduped = previous.Deduped + current.Deduped
+			job.Failed = previous.Failed + current.Failed
+			job.UpdatedAt = time.Now()
+		})
+		return !m.isCanceled(jobID)
+	})
+	m.update(jobID, func(job *LogsImportJob) {
+		job.Processed = processedBefore + counter.n.Load()
+		job.Accepted = previous.Accepted + result.Accepted
+		job.Deduped = previous.Deduped + result.Deduped
+		job.Failed = previous.Failed + result.Failed
+		job.UpdatedAt = time.Now()
+	})
+	return result, counter.n.Load(), err
+}
+
+func (m *logsImportManager) isCanceled(jobID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[jobID]
+	if !ok {
+		return true
+	}
+	return job.Canceled
+}
+
+func (m *logsImportManager) update(jobID string, updater func(job *LogsImportJob)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[jobID]
+	if !ok {
+		return
+	}
+	updater(job)
+}
+
+func (m *logsImportManager) fail(jobID string, err error) {
+	m.update(jobID, func(job *LogsImportJob) {
+		job.Status = logsImportFailed
+		job.Error = err.Error()
+		job.UpdatedAt = time.Now()
+	})
+}
+
+func (m *logsImportManager) cleanupLocked(now time.Time) {
+	for id, job := range m.jobs {
+		if job.Status == logsImportPending || job.Status == logsImportRunning {
+			continue
+		}
+		if now.Sub(job.UpdatedAt) <= logsImportJobTTL {
+			continue
+		}
+		delete(m.jobs, id)
+	}
+}
+
+// removeOrphanDirs 删除进程重启等原因遗留的上传目录（不属于任何内存中的任务）。
+// 只处理一小时前的目录，避免误删其它请求正在写入的目录。
+func (m *logsImportManager) removeOrphanDirs(root string) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, ok := m.jobs[entry.Name()]; ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < time.Hour {
+			continue
+		}
+		_ = os.RemoveAll(filepath.Join(root, entry.Name()))
+	}
+}
+
+// countingReader 统计已读取的字节数，用于计算导入进度。
+type countingReader struct {
+	reader io.Reader
+	n      atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,233 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+const logsReclassifyJobTTL = 24 * time.Hour
+
+type LogsReclassifyJobStatus string
+
+const (
+	logsReclassifyRunning  LogsReclassifyJobStatus = "running"
+	logsReclassifySuccess  LogsReclassifyJobStatus = "success"
+	logsReclassifyFailed   LogsReclassifyJobStatus = "failed"
+	logsReclassifyCanceled LogsReclassifyJobStatus = "canceled"
+)
+
+// LogsReclassifyJob 为按当前 PV 过滤规则重新判定历史日志的后台任务
+type LogsReclassifyJob struct {
+	ID        string                  `json:"id"`
+	Status    LogsReclassifyJobStatus `json:"status"`
+	CreatedAt time.Time               `json:"created_at"`
+	UpdatedAt time.Time               `json:"updated_at"`
+	WebsiteID string                  `json:"website_id"`
+	Error     string                  `json:"error,omitempty"`
+	store.ReclassifyProgress
+	Canceled bool `json:"-"`
+}
+
+type logsReclassifyManager struct {
+	mu   sync.Mutex
+	jobs map[string]*LogsReclassifyJob
+}
+
+var reclassifyJobs = &logsReclassifyManager{
+	jobs: make(map[string]*LogsReclassifyJob),
+}
+
+// parseReclassifyRange 解析 YYYY-MM-DD 格式的起止日期（均包含），结束日期留空表示截至当前
+func parseReclassifyRange(startValue, endValue string) (time.Time, time.Time, error) {
+	if startValue == "" {
+		return time.Time{}, time.Time{}, errors.New("开始日期不能为空")
+	}
+	start, err := time.ParseInLocation("2006-01-02", startValue, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("开始日期格式错误: %s", startValue)
+	}
+	if endValue == "" {
+		return start, time.Now(), nil
+	}
+	end, err := time.ParseInLocation("2006-01-02", endValue, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("结束日期格式错误: %s", endValue)
+	}
+	end = end.AddDate(0, 0, 1)
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, errors.New("结束日期不能早于开始日期")
+	}
+	return start, end, nil
+}
+
+func (m *logsReclassifyManager) Create(
+	websiteID string,
+	start, end time.Time,
+	statsFactory *analytics.StatsFactory,
+	logParser *ingest.LogParser,
+) (*LogsReclassifyJob, error) {
+	if _, ok := config.GetWebsiteByID(websiteID); !ok {
+		return nil, errors.New("站点不存在")
+	}
+	if err := logParser.RequireLeader(); err != nil {
+		return nil, err
+	}
+	if ingest.IsIPParsing() {
+		return nil, ingest.ErrParsingInProgress
+	}
+	jobID, err := newExportJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	job := &LogsReclassifyJob{
+		ID:        jobID,
+		Status:    logsReclassifyRunning,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		WebsiteID: websiteID,
+	}
+	job.Start = start
+	job.End = end
+
+	m.mu.Lock()
+	m.cleanupLocked(time.Now())
+	for _, existing := range m.jobs {
+		if existing.Status == logsReclassifyRunning {
+			m.mu.Unlock()
+			return nil, errors.New("已有重新判定任务在执行，请稍后重试")
+		}
+	}
+	m.jobs[jobID] = job
+	m.mu.Unlock()
+
+	go m.run(jobID, websiteID, start, end, statsFactory, logParser)
+	snapshot := *job
+	return &snapshot, nil
+}
+
+func (m *logsReclassifyManager) Get(id string) (*LogsReclassifyJob, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cleanupLocked(time.Now())
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	snapshot := *job
+	return &snapshot, true
+}
+
+func (m *logsReclassifyManager) List(websiteID string) []LogsReclassifyJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cleanupLocked(time.Now())
+	items := make([]LogsReclassifyJob, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		if websiteID != "" && job.WebsiteID != websiteID {
+			continue
+		}
+		items = append(items, *job)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].CreatedAt.After(items[j].CreatedAt)
+	})
+	return items
+}
+
+func (m *logsReclassifyManager) Cancel(id string) (*LogsReclassifyJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("任务不存在")
+	}
+	if job.Status != logsReclassifyRunning {
+		snapshot := *job
+		return &snapshot, fmt.Errorf("任务无法取消")
+	}
+	job.Canceled = true
+	snapshot := *job
+	return &snapshot, nil
+}
+
+func (m *logsReclassifyManager) run(
+	jobID, websiteID string,
+	start, end time.Time,
+	statsFactory *analytics.StatsFactory,
+	logParser *ingest.LogParser,
+) {
+	result, err := logParser.ReclassifyPageviews(websiteID, start, end, func(current store.ReclassifyProgress) bool {
+		m.update(jobID, func(job *LogsReclassifyJob) {
+			job.ReclassifyProgress = current
+			job.UpdatedAt = time.Now()
+		})
+		return !m.isCanceled(jobID)
+	})
+	if result.ChangedDays > 0 && statsFactory != nil {
+		statsFactory.ClearCache()
+	}
+
+	m.update(jobID, func(job *LogsReclassifyJob) {
+		if result.TotalDays > 0 {
+			job.ReclassifyProgress = result
+		}
+		job.UpdatedAt = time.Now()
+		switch {
+		case errors.Is(err, store.ErrReclassifyCanceled):
+			job.Status = logsReclassifyCanceled
+		case err != nil:
+			job.Status = logsReclassifyFailed
+			job.Error = err.Error()
+		default:
+			job.Status = logsReclassifySuccess
+		}
+	})
+	if err != nil && !errors.Is(err, store.ErrReclassifyCanceled) {
+		logrus.WithError(err).Errorf("重新判定网站 %s 的 PV 失败", websiteID)
+		return
+	}
+	logrus.Infof("网站 %s 重新判定 PV 完成: 扫描 %d 条, 新增 %d 条, 移除 %d 条",
+		websiteID, result.Scanned, result.Promoted, result.Demoted)
+}
+
+func (m *logsReclassifyManager) isCanceled(jobID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[jobID]
+	if !ok {
+		return true
+	}
+	return job.Canceled
+}
+
+func (m *logsReclassifyManager) update(jobID string, updater func(job *LogsReclassifyJob)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[jobID]
+	if !ok {
+		return
+	}
+	updater(job)
+}
+
+func (m *logsReclassifyManager) cleanupLocked(now time.Time) {
+	for id, job := range m.jobs {
+		if job.Status == logsReclassifyRunning {
+			continue
+		}
+		if now.Sub(job.UpdatedAt) <= logsReclassifyJobTTL {
+			continue
+		}
+		delete(m.jobs, id)
+	}
+}
@@ -4,30 +4,41 @@ import (
 	"context"
 	"time"
 
+	"github.com/likaia/nginxpulse/internal/config"
 	"github.com/likaia/nginxpulse/internal/ingest"
 	"github.com/likaia/nginxpulse/internal/logging"
 	"github.com/sirupsen/logrus"
 )
 
 // InitialScan performs an initial log scan after startup.
-func InitialScan(parser *ingest.LogParser, interval time.Duration) {
+func InitialScan(ctx context.Context, parser *ingest.LogParser, interval time.Duration) {
 	logrus.Info("****** 2 初始扫描 ******")
-	ExecutePeriodicTasks(parser, interval)
+	ExecutePeriodicTasks(ctx, parser, interval)
 }
 
 // RunScheduler executes periodic tasks on a ticker until ctx is canceled.
+// The ticker picks up a new system.taskInterval after each config reload.
 func RunScheduler(ctx context.Context, parser *ingest.LogParser, interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	iteration := 0
+	changed := config.Changed()
 
 	for {
 		select {
 		case <-ticker.C:
 			iteration++
 			logrus.WithFields(logrus.Fields{"iteration": iteration}).Info("定期任务开始")
-			ExecutePeriodicTasks(parser, interval)
+			ExecutePeriodicTasks(ctx, parser, interval)
+		case <-changed:
+			changed = config.Changed()
+			next := config.ParseInterval(config.ReadConfig().System.TaskInterval, 5*time.Minute)
+			if next != interval {
+				logrus.Infof("定期任务间隔已更新: %s -> %s", interval, next)
+				interval = next
+				ticker.Reset(interval)
+			}
 		case <-ctx.Done():
 			return
 		}
@@ -35,13 +46,28 @@ func RunScheduler(ctx context.Context, parser *ingest.LogParser, interval time.D
 }
 
 // ExecutePeriodicTasks runs log rotation, cleanup, and log scanning.
-func ExecutePeriodicTasks(parser *ingest.LogParser, interval time.Duration) {
+// With leader election enabled, only the leader runs anything past log rotation, and losing
+// leadership mid-run cancels ctx like a shutdown does.
+// Once ctx is canceled the running step stops at its next batch boundary and the remaining steps are skipped.
+func ExecutePeriodicTasks(ctx context.Context, parser *ingest.LogParser, interval time.Duration) {
 	{ // 1 日志轮转
 		if err := logging.RotateLogFile(); err != nil {
 			logrus.WithError(err).Warn("日志轮转失败")
 		}
 	}
 
+	if !parser.IsLeader() {
+		logrus.Debug("当前实例不是主节点，跳过扫描、回填与 IP 归属地解析")
+		return
+	}
+	// 运行中失去主节点身份时取消，扫描与回填在下一个批次边界停止
+	ctx, cancel := parser.LeaderContext(ctx)
+	defer cancel()
+
+	if ctx.Err() != nil {
+		return
+	}
+
 	{ // 2 清理旧数据
 		if err := parser.CleanOldLogs(); err != nil {
 			logrus.WithError(err).Warn("清理数据库中过期日志数据失败")
@@ -50,7 +76,7 @@ func ExecutePeriodicTasks(parser *ingest.LogParser, interval time.Duration) {
 
 	{ // 3 Nginx日志扫描
 		startTime := time.Now()
-		results := parser.ScanNginxLogs()
+		results := parser.ScanNginxLogs(ctx)
 		totalDuration := time.Since(startTime)
 
 		totalEntries := 0
@@ -81,9 +107,13 @@ func ExecutePeriodicTasks(parser *ingest.LogParser, interval time.Duration) {
 		}
 	}
 
+	if ctx.Err() != nil {
+		return
+	}
+
 	{ // 4 历史日志回填
 		backfillDuration, backfillBytes := backfillBudget(interval)
-		backfillResult := parser.BackfillHistory(backfillDuration, backfillBytes)
+		backfillResult := parser.BackfillHistory(ctx, backfillDuration, backfillBytes)
 		if backfillResult.ProcessedBytes > 0 {
 			logrus.Infof("历史日志回填完成: %d 条记录, %.2f MB",
 				backfillResult.ProcessedEntries,
@@ -91,6 +121,10 @@ func ExecutePeriodicTasks(parser *ingest.LogParser, interval time.Duration) {
 		}
 	}
 
+	if ctx.Err() != nil {
+		return
+	}
+
 	{ // 5 IP 归属地回填
 		processed := parser.ProcessPendingIPGeo(0)
 		if processed > 0 {
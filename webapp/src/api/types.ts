@@ -39,6 +39,7 @@ export interface WhitelistConfig {
 }
 
 export interface WebsiteConfig {
+  id?: string;
   name: string;
   logPath?: string;
   domains?: string[];
@@ -48,6 +49,8 @@ export interface WebsiteConfig {
   timeLayout?: string;
   sources?: SourceConfig[];
   whitelist?: WhitelistConfig;
+  retentionDays?: number;
+  pvFilter?: Partial<PVFilterConfig>;
 }
 
 export interface SystemConfig {
@@ -55,6 +58,8 @@ export interface SystemConfig {
   taskInterval?: string;
   httpSourceTimeout?: string;
   logRetentionDays?: number;
+  hourlyRetentionDays?: number;
+  dailyRetentionDays?: number;
   parseBatchSize?: number;
   ipGeoCacheLimit?: number;
   demoMode?: boolean;
@@ -74,6 +79,7 @@ export interface DatabaseConfig {
   maxOpenConns?: number;
   maxIdleConns?: number;
   connMaxLifetime?: string;
+  readDSN?: string[];
 }
 
 export interface PVFilterConfig {
@@ -105,11 +111,20 @@ export interface ConfigResponse {
   readonly: boolean;
   setup_required: boolean;
   default_log_path?: string;
+  managed_websites?: Record<string, string>;
+}
+
+export interface ConfigReloadResult {
+  added: string[] | null;
+  removed: string[] | null;
+  restart_fields: string[];
 }
 
 export interface ConfigSaveResponse {
   success: boolean;
   restart_required?: boolean;
+  reload?: ConfigReloadResult;
+  reload_error?: string;
 }
 
 export interface TimeSeriesStats {
@@ -46,6 +46,8 @@ const enUS = {
     week: 'This week',
     last7Days: 'Last 7 days',
     last30Days: 'Last 30 days',
+    last90Days: 'Last 90 days',
+    last365Days: 'Last 365 days',
     date: 'Date',
     to: 'to',
     time: 'Time',
@@ -199,6 +201,7 @@ const enUS = {
     },
     fields: {
       websiteName: 'Website name',
+      websiteId: 'Website ID',
       domains: 'Domains',
       logPath: 'Log path',
       logType: 'Log type',
@@ -206,15 +209,20 @@ const enUS = {
       logRegex: 'Log regex',
       timeLayout: 'Time layout',
       sourcesJson: 'Advanced sources (sources JSON)',
+      siteRetentionDays: 'Site log retention days',
+      sitePvFilterJson: 'Site PV filter (pvFilter JSON)',
       databaseDsn: 'Database DSN',
       dbMaxOpen: 'Max open conns',
       dbMaxIdle: 'Max idle conns',
       dbConnLifetime: 'Conn max lifetime',
+      dbReadDsn: 'Read replica DSNs (optional)',
       serverPort: 'Server port',
       webBasePath: 'Frontend base path',
       taskInterval: 'Task interval',
       httpSourceTimeout: 'HTTP log read timeout',
       logRetentionDays: 'Log retention (days)',
+      hourlyRetentionDays: 'Hourly aggregate retention (days)',
+      dailyRetentionDays: 'Daily aggregate retention (days)',
       parseBatchSize: 'Parse batch size',
       ipGeoCacheLimit: 'IP cache limit',
       language: 'Language',
@@ -238,11 +246,14 @@ const enUS = {
       logRegex: '^(?<ip>\\S+) - (?<user>\\S+) \\[(?<time>[^\\]]+)\\] "(?<request>[^"]+)" (?<status>\\d{3}) (?<bytes>\\d+) "(?<referer>[^"]*)" "(?<ua>[^"]*)"$',
       timeLayout: '02/Jan/2006:15:04:05 -0700',
       sourcesJson: "['{' \"id\": \"sftp-1\", \"type\": \"sftp\" '}']",
+      websiteId: 'Leave empty to derive from the name',
+      sitePvFilterJson: "'{' \"excludePatterns\": [\"^/healthz$\"] '}'",
       databaseDsn: 'postgres://user:pass{at}host:5432/db?sslmode=disable',
       serverPort: '8089 or :8089',
       webBasePath: 'e.g. nginxpulse (path becomes /nginxpulse/)',
       httpSourceTimeout: '2m',
       accessKeys: 'Separate multiple keys with commas',
+      dbReadDsn: 'One per line. Stats and log queries use replicas, writes stay on the primary; falls back to the primary when replicas are down',
       statusCodeInclude: '200, 204, 206',
       whitelistIps: '1.1.1.1, 10.0.0.0/8, 1.1.1.1-1.1.1.255',
       whitelistCities: 'Shanghai, Hangzhou, Tokyo',
@@ -250,9 +261,13 @@ const enUS = {
     hints: {
       logPath: 'Use full path or glob patterns, recommended under /share/logs/, must be accessible in container',
       sourcesJson: 'Provide sources JSON for SFTP/HTTP/S3 advanced sources',
+      websiteId: 'Lowercase letters and digits. Data is stored under the site ID; without it the ID is derived from the name, so renaming the site starts a new one. Fill in the current ID before renaming.',
+      siteRetentionDays: 'Overrides the global log retention days for this site; leave empty to use the global value',
+      sitePvFilterJson: 'Fields set here replace the global PV filter fields of the same name; leave empty to use the global rules',
       accessKeys: 'Separate multiple keys with commas',
       webBasePath: 'Single segment only; restart required; root path will be disabled',
       httpSourceTimeout: 'Timeout for remote HTTP log reads, supports Go duration (e.g. 30s, 2m)',
+      aggregateRetention: 'Leave empty to follow the previous tier; must not be shorter than raw log retention',
       demoMode: 'Show simulated data for demos',
       mobilePwaEnabled: 'Enable Add-to-Home-Screen (PWA) for mobile. Default is off',
       whitelistIps: 'Supports single IP, CIDR, and ranges (use -)',
@@ -276,6 +291,7 @@ const enUS = {
       invalidJson: 'Invalid JSON format',
       parseJson: 'JSON parse failed: {message}',
       sourcesArray: 'sources must be an array',
+      pvFilterObject: 'pvFilter must be an object',
       whitelistEmpty: 'Whitelist is enabled but no rules are set',
     },
     logValidation: {
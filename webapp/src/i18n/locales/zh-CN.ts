@@ -46,6 +46,8 @@ const zhCN = {
     week: '本周',
     last7Days: '最近7天',
     last30Days: '最近30天',
+    last90Days: '最近90天',
+    last365Days: '最近365天',
     date: '日期',
     to: '至',
     time: '时间',
@@ -199,6 +201,7 @@ const zhCN = {
     },
     fields: {
       websiteName: '站点名称',
+      websiteId: '站点 ID',
       domains: '域名列表',
       logPath: '日志路径',
       logType: '日志类型',
@@ -206,15 +209,20 @@ const zhCN = {
       logRegex: '日志正则',
       timeLayout: '时间格式',
       sourcesJson: '高级来源 (sources JSON)',
+      siteRetentionDays: '站点日志保留天数',
+      sitePvFilterJson: '站点 PV 过滤 (pvFilter JSON)',
       databaseDsn: '数据库 DSN',
       dbMaxOpen: '最大连接数',
       dbMaxIdle: '最大空闲连接',
       dbConnLifetime: '连接最大生命周期',
+      dbReadDsn: '只读副本 DSN（可选）',
       serverPort: '服务端口',
       webBasePath: '前端访问前缀',
       taskInterval: '任务间隔',
       httpSourceTimeout: 'HTTP 日志读取超时',
       logRetentionDays: '日志保留天数',
+      hourlyRetentionDays: '小时聚合保留天数',
+      dailyRetentionDays: '日聚合保留天数',
       parseBatchSize: '解析批次大小',
       ipGeoCacheLimit: 'IP 缓存上限',
       language: '语言',
@@ -238,11 +246,14 @@ const zhCN = {
       logRegex: '^(?<ip>\\S+) - (?<user>\\S+) \\[(?<time>[^\\]]+)\\] "(?<request>[^"]+)" (?<status>\\d{3}) (?<bytes>\\d+) "(?<referer>[^"]*)" "(?<ua>[^"]*)"$',
       timeLayout: '02/Jan/2006:15:04:05 -0700',
       sourcesJson: "['{' \"id\": \"sftp-1\", \"type\": \"sftp\" '}']",
+      websiteId: '留空则由站点名称生成',
+      sitePvFilterJson: "'{' \"excludePatterns\": [\"^/healthz$\"] '}'",
       databaseDsn: 'postgres://user:pass{at}host:5432/db?sslmode=disable',
       serverPort: '8089 或 :8089',
       webBasePath: '如 nginxpulse（访问路径 /nginxpulse/）',
       httpSourceTimeout: '2m',
       accessKeys: '多个密钥用逗号分隔',
+      dbReadDsn: '每行一个，统计与日志查询使用副本，写入仍走主库；副本不可用时自动回退主库',
       statusCodeInclude: '200, 204, 206',
       whitelistIps: '1.1.1.1, 10.0.0.0/8, 1.1.1.1-1.1.1.255',
       whitelistCities: '上海, 杭州, Tokyo',
@@ -250,9 +261,13 @@ const zhCN = {
     hints: {
       logPath: '支持完整路径或通配符，建议放在 /share/logs/，需在容器内可访问',
       sourcesJson: '填写 sources 数组 JSON，用于 SFTP/HTTP/S3 等高级来源',
+      websiteId: '仅限小写字母与数字。数据按站点 ID 存储，未填写时由名称生成，改名会变成新站点；改名前请先填入当前 ID。',
+      siteRetentionDays: '覆盖全局的日志保留天数，留空沿用全局配置',
+      sitePvFilterJson: '填写的字段覆盖全局 PV 过滤中的同名字段，留空沿用全局规则',
       accessKeys: '多个密钥用逗号分隔',
       webBasePath: '仅支持单段路径，修改后需重启服务，根路径将不可访问',
       httpSourceTimeout: '远程 HTTP 日志读取超时，支持 Go duration（如 30s、2m）',
+      aggregateRetention: '留空表示沿用上一级；不能小于日志保留天数',
       demoMode: '启用后展示模拟数据，适用于演示环境',
       mobilePwaEnabled: '开启后移动端支持安装到桌面（PWA），默认关闭',
       whitelistIps: '支持单 IP、CIDR 与 IP 段（使用 -）',
@@ -276,6 +291,7 @@ const zhCN = {
       invalidJson: 'JSON 格式错误',
       parseJson: 'JSON 解析失败: {message}',
       sourcesArray: 'sources 必须是数组',
+      pvFilterObject: 'pvFilter 必须是对象',
       whitelistEmpty: '白名单已启用但未配置规则',
     },
     logValidation: {